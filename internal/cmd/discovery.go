@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/discovery"
+	"github.com/ripplego/ripplego/internal/index"
+	"github.com/ripplego/ripplego/internal/transfer"
+)
+
+// 可选的发现后端（--discovery）
+const (
+	backendBroadcast = "broadcast" // UDP 广播 + IPv6 组播
+	backendMDNS      = "mdns"      // mDNS/DNS-SD
+	backendDHT       = "dht"       // Kademlia DHT
+	backendStatic    = "static"    // 引导节点（--peer）与节点缓存
+	backendPEX       = "pex"       // 节点交换
+	backendTracker   = "tracker"   // tracker 服务（--tracker）
+)
+
+var allBackends = []string{backendBroadcast, backendMDNS, backendDHT, backendStatic, backendPEX, backendTracker}
+
+// discoveryFlags list/get/serve 共用的发现参数
+type discoveryFlags struct {
+	backends     []string
+	port         int
+	peers        []string
+	dhtPort      int
+	dhtBootstrap []string
+	dhtTable     string
+	trackers     []string
+}
+
+// register 注册发现相关参数；serve 为 true 时注册仅节点使用的参数
+func (d *discoveryFlags) register(c *cobra.Command, defaults []string, serve bool) {
+	c.Flags().StringSliceVar(&d.backends, "discovery", defaults, "启用的发现方式，逗号分隔："+strings.Join(allBackends, ",")+"，或 all")
+	c.Flags().IntVarP(&d.port, "port", "p", 7788, "UDP 广播端口")
+	c.Flags().StringSliceVar(&d.peers, "peer", nil, "引导节点的传输服务地址（host:port），启动时主动连接，可重复指定")
+	c.Flags().StringSliceVar(&d.dhtBootstrap, "dht-bootstrap", nil, "DHT 引导节点地址（host:port），可重复指定")
+	c.Flags().StringSliceVar(&d.trackers, "tracker", nil, "tracker 地址（如 http://10.0.0.5:7070），可重复指定")
+	if serve {
+		c.Flags().IntVar(&d.dhtPort, "dht-port", 0, "DHT UDP 端口，0 表示不加入 DHT")
+		c.Flags().StringVar(&d.dhtTable, "dht-table", ".ripplego/dht.json", "DHT 路由表持久化文件，为空表示不持久化")
+	} else {
+		c.Flags().StringVar(&d.dhtTable, "dht-table", ".ripplego/dht.json", "用于引导的 DHT 路由表文件（只读）")
+	}
+}
+
+// enabled 解析 --discovery
+func (d *discoveryFlags) enabled() (map[string]bool, error) {
+	out := make(map[string]bool)
+	for _, b := range d.backends {
+		b = strings.ToLower(strings.TrimSpace(b))
+		switch {
+		case b == "all":
+			for _, x := range allBackends { out[x] = true }
+		case slices.Contains(allBackends, b):
+			out[b] = true
+		case b != "":
+			return nil, fmt.Errorf("未知的发现方式：%s（可选 %s）", b, strings.Join(allBackends, ","))
+		}
+	}
+	return out, nil
+}
+
+// dhtUDPPort 返回启用 DHT 时本节点的 DHT UDP 端口，否则为 0
+func (d *discoveryFlags) dhtUDPPort() int {
+	if on, err := d.enabled(); err != nil || !on[backendDHT] { return 0 }
+	return d.dhtPort
+}
+
+// nodeInfo 节点（serve）对外宣告的信息
+type nodeInfo struct {
+	ID          core.NodeID
+	Name        string
+	ServicePort int
+	Advertise   []string
+}
+
+// nodeFinder 创建 serve 使用的组合发现：宣告本节点并应答查询；启用 pex 时设置 tr.PEX
+func (d *discoveryFlags) nodeFinder(self nodeInfo, bs *index.BadgerStore, tr *transfer.TCPTransport) (*discovery.MultiFinder, error) {
+	on, err := d.enabled()
+	if err != nil { return nil, err }
+	hasFile := func(id core.FileID) bool {
+		fi, err := bs.GetFile(id)
+		return err == nil && !fi.Withdrawn && fi.Path != ""
+	}
+	files := func() []core.FileID { return servedFiles(bs) }
+
+	multi := discovery.NewMultiFinder()
+	if on[backendBroadcast] {
+		f := discovery.NewUDPFinder(self.Name, d.port)
+		f.SetID(self.ID)
+		f.ServicePort, f.Addrs, f.HasFile = self.ServicePort, self.Advertise, hasFile
+		multi.Add(f)
+	}
+	if on[backendMDNS] {
+		f := discovery.NewMDNSFinder(self.Name, self.ServicePort)
+		f.SetID(self.ID)
+		multi.Add(f)
+	}
+	if on[backendStatic] {
+		multi.Add(newPeerFinder(tr, bs, self.ID, d.peers))
+	}
+	if on[backendDHT] && d.dhtPort > 0 {
+		f := discovery.NewDHTFinder(self.Name, d.dhtPort, self.ServicePort)
+		f.Bootstrap, f.TablePath, f.Files = d.dhtBootstrap, d.dhtTable, files
+		multi.Add(f)
+	}
+	if on[backendTracker] && len(d.trackers) > 0 {
+		f := discovery.NewTrackerFinder(d.trackers, self.ID, self.ServicePort)
+		f.Addrs, f.Files = self.Advertise, files
+		multi.Add(f)
+	}
+	if on[backendPEX] {
+		// 候选节点来自其他发现方式
+		others := append([]discovery.Finder(nil), multi.Finders()...)
+		f := discovery.NewPEXFinder(tr, self.ID, self.ServicePort)
+		f.Files, f.Candidates = files, finderNodes(others)
+		tr.PEX = f
+		multi.Add(f)
+	}
+	return multi, nil
+}
+
+// queryFinder 创建 list/get 使用的组合发现：只查询，不宣告自身；watch 为 true 时持续接收广播公告
+func (d *discoveryFlags) queryFinder(ps index.PeerStore, tr *transfer.TCPTransport, watch bool) (*discovery.MultiFinder, error) {
+	on, err := d.enabled()
+	if err != nil { return nil, err }
+	multi := discovery.NewMultiFinder()
+	if on[backendBroadcast] {
+		f := discovery.NewUDPFinderQuery(d.port)
+		f.Watch = watch
+		multi.Add(f)
+	}
+	if on[backendMDNS] {
+		multi.Add(discovery.NewMDNSFinderQuery())
+	}
+	if on[backendStatic] {
+		multi.Add(newPeerFinder(tr, ps, "", d.peers))
+	}
+	if on[backendDHT] {
+		f := discovery.NewDHTFinderQuery()
+		f.Bootstrap, f.TablePath = d.dhtBootstrap, d.dhtTable
+		multi.Add(f)
+	}
+	if on[backendTracker] && len(d.trackers) > 0 {
+		multi.Add(discovery.NewTrackerFinderQuery(d.trackers))
+	}
+	if on[backendPEX] {
+		others := append([]discovery.Finder(nil), multi.Finders()...)
+		f := discovery.NewPEXFinder(tr, "", 0)
+		f.Candidates = finderNodes(others)
+		multi.Add(f)
+	}
+	return multi, nil
+}
+
+func finderNodes(finders []discovery.Finder) func() []core.Node {
+	return func() []core.Node {
+		var out []core.Node
+		for _, f := range finders { out = append(out, f.Nodes()...) }
+		return discovery.MergeNodes(out)
+	}
+}
+
+// backendNames 返回组合发现中各后端的名称，用于启动提示
+func backendNames(m *discovery.MultiFinder) string {
+	var names []string
+	for _, f := range m.Finders() {
+		switch f.(type) {
+		case *discovery.UDPFinder:
+			names = append(names, backendBroadcast)
+		case *discovery.MDNSFinder:
+			names = append(names, backendMDNS)
+		case *discovery.DHTFinder:
+			names = append(names, backendDHT)
+		case *discovery.StaticFinder:
+			names = append(names, backendStatic)
+		case *discovery.PEXFinder:
+			names = append(names, backendPEX)
+		case *discovery.TrackerFinder:
+			names = append(names, backendTracker)
+		}
+	}
+	if len(names) == 0 { return "无" }
+	return strings.Join(names, ",")
+}
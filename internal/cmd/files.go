@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/index"
+)
+
+func newFilesCmd() *cobra.Command {
+	var (
+		storeDir string
+		asJSON   bool
+	)
+
+	c := &cobra.Command{
+		Use:   "files",
+		Short: "列出已分享的文件",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bs, _, closeStore, err := openStore(storeDir)
+			if err != nil { return err }
+			defer closeStore()
+
+			files := bs.ListFiles()
+			sort.Slice(files, func(i, j int) bool { return files[i].CreatedAt.Before(files[j].CreatedAt) })
+			if asJSON {
+				if files == nil { files = []core.FileInfo{} }
+				return printJSON(files)
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tNAME\tSIZE\tCHUNKS\tHASH\tSHARED AT\tSTATUS")
+			for _, fi := range files {
+				status := "shared"
+				if fi.Withdrawn { status = "withdrawn" } else if fi.Path == "" { status = "imported" }
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%s\n",
+					shortID(fi.ID), fi.Name, fi.Size, fi.ChunkCount, fi.HashAlgo.OrDefault(),
+					fi.CreatedAt.Format("2006-01-02 15:04:05"), status)
+			}
+			return tw.Flush()
+		},
+	}
+	c.PersistentFlags().StringVar(&storeDir, "store", ".ripplego/index", "索引持久化目录")
+	c.PersistentFlags().BoolVar(&asJSON, "json", false, "以 JSON 输出")
+
+	show := &cobra.Command{
+		Use:   "show <fileID>",
+		Short: "显示文件详情及分片列表（fileID 可使用唯一前缀）",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bs, _, closeStore, err := openStore(storeDir)
+			if err != nil { return err }
+			defer closeStore()
+
+			fi, err := lookupFile(bs, args[0])
+			if err != nil { return err }
+			chunks, err := bs.GetChunks(fi.ID)
+			if err != nil { return err }
+			sort.Slice(chunks, func(i, j int) bool { return chunks[i].Index < chunks[j].Index })
+
+			if asJSON {
+				return printJSON(struct {
+					File   core.FileInfo    `json:"file"`
+					Chunks []core.ChunkInfo `json:"chunks"`
+				}{fi, chunks})
+			}
+
+			fmt.Printf("文件ID: %s\n名称: %s\n路径: %s\n大小: %d bytes\n哈希: %s %s\n分片: %d 个 (chunkSize=%d)\n分享时间: %s\n",
+				fi.ID, fi.Name, fi.Path, fi.Size, fi.HashAlgo.OrDefault(), fi.Hash,
+				fi.ChunkCount, fi.ChunkSize, fi.CreatedAt.Format("2006-01-02 15:04:05"))
+			if fi.Withdrawn { fmt.Println("状态: 已撤回（文件已变更或缺失）") }
+			if fi.Path == "" { fmt.Println("状态: 仅有导入的索引，本地未持有文件") }
+			fmt.Println()
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "INDEX\tCHUNK ID\tOFFSET\tSIZE\tHASH")
+			for _, ch := range chunks {
+				fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%s\n", ch.Index, ch.ID, ch.Offset, ch.Size, ch.Hash)
+			}
+			return tw.Flush()
+		},
+	}
+	c.AddCommand(show)
+	return c
+}
+
+func newUnshareCmd() *cobra.Command {
+	var (
+		storeDir string
+		asJSON   bool
+	)
+
+	c := &cobra.Command{
+		Use:   "unshare <fileID>...",
+		Short: "取消分享：从索引中删除文件及其分片记录（fileID 可使用唯一前缀）",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bs, _, closeStore, err := openStore(storeDir)
+			if err != nil { return err }
+			defer closeStore()
+
+			// 先全部解析，避免部分删除后才发现参数错误
+			targets := make([]core.FileInfo, 0, len(args))
+			for _, a := range args {
+				fi, err := lookupFile(bs, a)
+				if err != nil { return err }
+				targets = append(targets, fi)
+			}
+
+			removed := make([]core.FileID, 0, len(targets))
+			for _, fi := range targets {
+				if err := index.RemoveFile(bs, fi.ID); err != nil { return err }
+				removed = append(removed, fi.ID)
+				if !asJSON { fmt.Printf("已取消分享：%s (%s)\n", fi.Name, fi.ID) }
+			}
+			if asJSON {
+				return printJSON(struct {
+					Removed []core.FileID `json:"removed"`
+				}{removed})
+			}
+			return nil
+		},
+	}
+	c.Flags().StringVar(&storeDir, "store", ".ripplego/index", "索引持久化目录")
+	c.Flags().BoolVar(&asJSON, "json", false, "以 JSON 输出")
+	return c
+}
+
+// lookupFile 按完整 FileID 或唯一前缀查找文件
+func lookupFile(s index.IndexStore, id string) (core.FileInfo, error) {
+	if fi, err := s.GetFile(core.FileID(id)); err == nil {
+		return fi, nil
+	}
+	var matches []core.FileInfo
+	for _, fi := range s.ListFiles() {
+		if strings.HasPrefix(string(fi.ID), id) {
+			matches = append(matches, fi)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return core.FileInfo{}, fmt.Errorf("未找到文件：%s", id)
+	case 1:
+		return matches[0], nil
+	default:
+		return core.FileInfo{}, fmt.Errorf("文件ID前缀不唯一：%s（匹配 %d 个）", id, len(matches))
+	}
+}
+
+func shortID(id core.FileID) string {
+	if len(id) > 12 { return string(id[:12]) }
+	return string(id)
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
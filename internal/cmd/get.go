@@ -1,17 +1,20 @@
 package cmd
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
-	"sync"
+	"sort"
+	"time"
 
 	"github.com/schollz/progressbar/v3"
 	"github.com/spf13/cobra"
 
 	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/discovery"
 	"github.com/ripplego/ripplego/internal/index"
+	"github.com/ripplego/ripplego/internal/link"
 	"github.com/ripplego/ripplego/internal/transfer"
 )
 
@@ -19,27 +22,95 @@ func newGetCmd() *cobra.Command {
 	var (
 		fileID   string
 		outPath  string
-		addr     string
+		addrs    []string
 		storeDir string
-		workers  int
+		workers    int
+		rendezvous []string
+		relay      relayFlags
+		quic       quicFlags
+		ledbat     ledbatFlags
+		rate       rateFlags
+		compress   bool
+		node       string
+		disc       discoveryFlags
 	)
 
 	c := &cobra.Command{
-		Use:   "get",
+		Use:   "get [ripplego://链接]",
 		Short: "从远端节点下载文件(并发/断点续传-简化)",
+		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if fileID == "" || addr == "" {
-				return fmt.Errorf("请提供 --file-id 与 --addr")
+			var lk link.Link
+			if len(args) == 1 {
+				l, err := link.Parse(args[0])
+				if err != nil { return err }
+				if fileID != "" && core.FileID(fileID) != l.FileID {
+					return fmt.Errorf("--file-id 与链接中的文件ID不一致")
+				}
+				lk, fileID = l, string(l.FileID)
+				addrs = append(addrs, l.Peers...)
 			}
+			if fileID == "" {
+				return fmt.Errorf("请提供 ripplego:// 链接或 --file-id")
+			}
+			bs, storeNode, closeStore, err := openStore(storeDir)
+			if err != nil {
+				// 与 list 相同，索引不可用时仍可下载，只是不读取也不记录本地索引
+				fmt.Fprintf(os.Stderr, "索引不可用，下载结果不会记录到索引：%v\n", err)
+				bs, closeStore = index.NewMemoryStore(), func() {}
+			}
+			defer closeStore()
+			// 经运行中的节点访问索引时，下载的数据默认计入该节点的回报
+			if node == "" { node = storeNode }
 
-			bs, err := index.NewBadgerStore(storeDir)
+			on, err := disc.enabled()
 			if err != nil { return err }
-			defer bs.Close()
+			ctx, cancel := context.WithCancel(cmd.Context())
+			defer cancel()
+			tr := transfer.NewTCPTransport("", "")
+			tr.Compress = compress
+			if len(rendezvous) > 0 {
+				// 源节点无法直连时经会合节点打洞
+				if _, err := startPunch(ctx, tr, "", rendezvous); err != nil { return err }
+			}
+			// 直连与打洞都失败时经中继下载
+			dlTr := relay.transport(tr, nil)
+			// 启用 QUIC 的源节点优先经 QUIC 下载，失败时回退到上面的 TCP 链路
+			dlTr = quic.transport(tr, dlTr)
+			if qt, ok := dlTr.(*transfer.QUICTransport); ok { defer qt.Close() }
+			// 启用 LEDBAT 的源节点优先经 UDP 下载，其余节点与失败时走上面的链路
+			dlTr = ledbat.transport(tr, dlTr)
+			if ut, ok := dlTr.(*transfer.UDPTransport); ok { defer ut.Close() }
+			sources := make([]core.Node, 0, len(addrs))
+			for _, a := range addrs { sources = append(sources, core.Node{Address: a}) }
+			var newSources <-chan core.Node
+			if len(sources) == 0 {
+				// 发现在下载期间继续运行，新加入且持有该文件的节点会被加入下载源
+				finder, err := disc.queryFinder(bs, tr, true)
+				if err != nil { return err }
+				if err := finder.Start(ctx); err != nil { return err }
+				defer finder.Stop()
+				found, err := findSources(ctx, finder, core.FileID(fileID))
+				if err != nil { return fmt.Errorf("未指定 --addr，且未找到持有该文件的节点：%w", err) }
+				sources = found
+				newSources = watchSources(ctx, finder, tr, core.FileID(fileID))
+			}
+			if on[backendPEX] {
+				// 向已知源节点交换节点列表，找到更多持有该文件的节点
+				more := discovery.NewPEXFinder(tr, "", 0).Exchange(ctx, core.FileID(fileID), sources)
+				if len(more) > maxPEXSources { more = more[:maxPEXSources] }
+				if len(more) > 0 { fmt.Printf("通过节点交换新增 %d 个源节点\n", len(more)) }
+				sources = append(sources, more...)
+			}
 
-			fi, err := bs.GetFile(core.FileID(fileID))
-			if err != nil { return err }
-			chunks, err := bs.GetChunks(fi.ID)
+			fi, chunks, err := loadManifest(ctx, bs, tr, core.FileID(fileID), sources)
 			if err != nil { return err }
+			if lk.Hash != "" && (lk.Hash != fi.Hash || lk.HashAlgo != fi.HashAlgo.OrDefault()) {
+				return fmt.Errorf("文件索引的哈希与链接不一致")
+			}
+			if lk.Size > 0 && lk.Size != fi.Size {
+				return fmt.Errorf("文件索引的大小与链接不一致")
+			}
 
 			if outPath == "" { outPath = filepath.Base(fi.Name) }
 			tmpPath := outPath + ".part"
@@ -51,41 +122,116 @@ func newGetCmd() *cobra.Command {
 			}
 
 			bar := progressbar.DefaultBytes(fi.Size, "downloading")
-			tr := transfer.NewTCPTransport("", "")
-			node := core.Node{Address: addr}
-
-			sem := make(chan struct{}, workers)
-			var wg sync.WaitGroup
-			var firstErr error
-			var mu sync.Mutex
-			for _, ch := range chunks {
-				ch := ch
-				sem <- struct{}{}
-				wg.Add(1)
-				go func(){
-					defer func(){ <-sem; wg.Done() }()
-					buf := bytes.NewBuffer(make([]byte, 0, ch.Size))
-					if err := tr.Download(cmd.Context(), node, fi.ID, ch, buf); err != nil {
-						mu.Lock(); if firstErr == nil { firstErr = err }; mu.Unlock(); return
-					}
-					// 写入目标文件指定偏移
-					mu.Lock()
-					if _, err := f.WriteAt(buf.Bytes(), ch.Offset); err != nil && firstErr==nil { firstErr = err }
-					_ = bar.Add64(ch.Size)
-					mu.Unlock()
+			dl := transfer.NewDownloader(dlTr, workers)
+			dl.OnChunk = func(ch core.ChunkInfo) { _ = bar.Add64(ch.Size) }
+			dl.OnSource = func(n core.Node, err error) { _ = index.RecordPeerResult(bs, n, err) }
+			dl.NewSources = newSources
+			if dl.Limiter, err = rate.limiter(ctx, false); err != nil { return err }
+			if node != "" {
+				// 下载的数据计入本机 serve 节点的上传调度，使这些源节点优先获得本机的上传槽位
+				rep := transfer.NewReceivedReporter(node)
+				go rep.Run(ctx)
+				defer func() {
+					if err := rep.Flush(context.Background()); err != nil { fmt.Fprintf(os.Stderr, "向本机节点报告回报失败: %v\n", err) }
 				}()
+				dl.Scheduler = rep
 			}
-			wg.Wait()
-			if firstErr != nil { return firstErr }
+			if err := dl.Download(ctx, fi, chunks, sources, f); err != nil { return err }
 			_ = f.Close()
-			return os.Rename(tmpPath, outPath)
+
+			// 分片均已校验，这里再校验完整文件，确保元数据本身可信
+			sum, _, err := index.ComputeFileHash(fi.HashAlgo, tmpPath)
+			if err != nil { return err }
+			if sum != fi.Hash { return fmt.Errorf("文件哈希校验失败 (%s)", fi.HashAlgo.OrDefault()) }
+			if err := os.Rename(tmpPath, outPath); err != nil { return err }
+
+			// 远端获取或导入的索引（无本地路径）记录到本地并指向下载结果，serve 时可继续作为源提供
+			if fi.Path == "" {
+				if fi, err = index.StampFile(fi, outPath); err != nil { return err }
+				if err := bs.SaveChunks(fi.ID, chunks); err != nil { return err }
+				if err := bs.SaveFile(fi); err != nil { return err }
+			}
+			return nil
 		},
 	}
 
 	c.Flags().StringVar(&fileID, "file-id", "", "目标文件ID")
 	c.Flags().StringVar(&outPath, "out", "", "输出文件路径")
-	c.Flags().StringVar(&addr, "addr", "", "源节点地址，例如 127.0.0.1:9001")
+	c.Flags().StringSliceVar(&addrs, "addr", nil, "源节点地址，例如 127.0.0.1:9001，可重复指定多个源")
 	c.Flags().StringVar(&storeDir, "store", ".ripplego/index", "索引持久化目录")
 	c.Flags().IntVar(&workers, "workers", 4, "并发下载的工作协程数")
+	c.Flags().StringSliceVar(&rendezvous, "rendezvous", nil, "会合节点地址（host:port），源节点无法直连（如位于 NAT 之后）时经其打洞，可重复指定")
+	relay.register(c, false)
+	quic.register(c, false)
+	ledbat.register(c, false)
+	rate.register(c, false)
+	c.Flags().StringVar(&node, "node", "", "本机运行中节点（serve --upload-slots）的传输服务地址，如 127.0.0.1:9001：从各源节点下载的数据计入其回报，使其优先获得该节点的上传槽位；索引目录正被本机节点使用时默认为该节点")
+	c.Flags().BoolVar(&compress, "compress", false, "请求源节点以 zstd 压缩传输分片（需源节点启用 --compress），不支持的节点自动改用不压缩的请求")
+	disc.register(c, []string{backendBroadcast, backendStatic, backendDHT, backendPEX, backendTracker}, false)
 	return c
-}
\ No newline at end of file
+}
+
+// loadManifest 优先读取本地索引，缺失时依次向源节点获取并校验（远端索引不含本地路径）
+func loadManifest(ctx context.Context, s index.IndexStore, mf transfer.ManifestFetcher, id core.FileID, sources []core.Node) (fi core.FileInfo, chunks []core.ChunkInfo, err error) {
+	if fi, err = s.GetFile(id); err == nil {
+		if chunks, err = s.GetChunks(id); err == nil {
+			return fi, chunks, nil
+		}
+	}
+	for _, n := range sources {
+		fi, chunks, err = mf.FetchManifest(ctx, n, id)
+		if err != nil { continue }
+		sort.Slice(chunks, func(i, j int) bool { return chunks[i].Index < chunks[j].Index })
+		if err = index.ValidateChunks(fi, chunks); err != nil { continue }
+		fi.Path = ""
+		return fi, chunks, nil
+	}
+	return core.FileInfo{}, nil, fmt.Errorf("无法获取文件索引：%w", err)
+}
+
+// findSources 未指定源节点时通过 finder 的各发现方式并发查找持有 fileID 的节点
+func findSources(ctx context.Context, finder *discovery.MultiFinder, id core.FileID) ([]core.Node, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	nodes, err := finder.Providers(ctx, id)
+	if err != nil { return nil, err }
+	fmt.Printf("通过 %s 找到 %d 个源节点\n", backendNames(finder), len(nodes))
+	return nodes, nil
+}
+
+// watchSources 订阅 finder 的节点事件，将新加入且持有 fileID 的节点送出，直到 ctx 结束
+// 每个节点只询问一次；返回的通道不会被关闭
+func watchSources(ctx context.Context, finder discovery.Subscriber, fc discovery.FileChecker, id core.FileID) <-chan core.Node {
+	out := make(chan core.Node)
+	events, unsubscribe := finder.Subscribe(0)
+	go func() {
+		defer unsubscribe()
+		checked := make(map[core.NodeID]bool)
+		for {
+			var ev discovery.Event
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok = <-events:
+				if !ok { return }
+			}
+			n := ev.Node
+			if ev.Type == discovery.PeerLost || checked[n.ID] || len(n.ServiceAddrs()) == 0 { continue }
+			checked[n.ID] = true
+			go func() {
+				cctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+				defer cancel()
+				if has, err := fc.HasFile(cctx, n, id); err != nil || !has { return }
+				select {
+				case out <- n:
+				case <-ctx.Done():
+				}
+			}()
+		}
+	}()
+	return out
+}
+
+// maxPEXSources 通过节点交换额外加入的源节点数上限
+const maxPEXSources = 16
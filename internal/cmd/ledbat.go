@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/ripplego/ripplego/internal/transfer"
+)
+
+// ledbatFlags UDP（LEDBAT）传输相关的命令行参数（serve 与 get 共用）
+type ledbatFlags struct {
+	enabled bool
+	peers   []string
+}
+
+// register 注册 --ledbat；get 还注册 --ledbat-peer
+func (f *ledbatFlags) register(c *cobra.Command, serve bool) {
+	if serve {
+		c.Flags().BoolVar(&f.enabled, "ledbat", false, "同时在传输服务端口（UDP）上提供 LEDBAT 拥塞控制的传输：排队延迟升高时主动降速，让出带宽给交互流量")
+		return
+	}
+	c.Flags().BoolVar(&f.enabled, "ledbat", false, "对全部源节点优先使用 UDP（LEDBAT）传输下载，连接失败时回退到 TCP")
+	c.Flags().StringSliceVar(&f.peers, "ledbat-peer", nil, "只对这些源节点（节点ID或服务地址）使用 UDP（LEDBAT）传输，可重复指定")
+}
+
+// transport 启用时返回以 fallback 为回退的 UDP 传输，否则返回 fallback 本身
+func (f *ledbatFlags) transport(tr *transfer.TCPTransport, fallback transfer.Transport) transfer.Transport {
+	if !f.enabled && len(f.peers) == 0 { return fallback }
+	ut := transfer.NewUDPTransport(tr, fallback)
+	if len(f.peers) > 0 && !f.enabled { ut.Select = peerSelector(f.peers) }
+	return ut
+}
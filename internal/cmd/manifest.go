@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ripplego/ripplego/internal/manifest"
+)
+
+func newManifestCmd() *cobra.Command {
+	var storeDir string
+
+	c := &cobra.Command{
+		Use:   "manifest",
+		Short: "导出/导入可移植的文件索引（.rgm）",
+	}
+	c.PersistentFlags().StringVar(&storeDir, "store", ".ripplego/index", "索引持久化目录")
+
+	var (
+		format  string
+		outPath string
+	)
+	export := &cobra.Command{
+		Use:   "export <fileID>",
+		Short: "导出文件索引，默认写到标准输出（fileID 可使用唯一前缀）",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "json" && format != "binary" {
+				return fmt.Errorf("不支持的格式：%s（可选 json | binary）", format)
+			}
+			bs, _, closeStore, err := openStore(storeDir)
+			if err != nil { return err }
+			defer closeStore()
+
+			fi, err := lookupFile(bs, args[0])
+			if err != nil { return err }
+			m, err := manifest.Export(bs, fi.ID)
+			if err != nil { return err }
+
+			var w io.Writer = os.Stdout
+			if outPath != "" && outPath != "-" {
+				f, err := os.Create(outPath)
+				if err != nil { return err }
+				defer f.Close()
+				w = f
+			}
+			if format == "binary" {
+				return m.WriteBinary(w)
+			}
+			return m.WriteJSON(w)
+		},
+	}
+	export.Flags().StringVar(&format, "format", "json", "输出格式：json | binary")
+	export.Flags().StringVarP(&outPath, "out", "o", "", "输出文件路径，默认标准输出")
+
+	imp := &cobra.Command{
+		Use:   "import <file.rgm>...",
+		Short: "导入文件索引（自动识别 JSON/二进制，- 表示标准输入）",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// 先全部读取校验，避免部分导入
+			ms := make([]manifest.Manifest, 0, len(args))
+			for _, p := range args {
+				m, err := readManifestFile(p)
+				if err != nil { return fmt.Errorf("%s: %w", p, err) }
+				ms = append(ms, m)
+			}
+
+			bs, _, closeStore, err := openStore(storeDir)
+			if err != nil { return err }
+			defer closeStore()
+
+			for _, m := range ms {
+				if err := m.Import(bs); err != nil { return err }
+				fmt.Printf("已导入索引：%s\n- 文件ID: %s\n- 大小: %d bytes\n- 分片: %d 个\n", m.File.Name, m.File.ID, m.File.Size, m.File.ChunkCount)
+			}
+			return nil
+		},
+	}
+
+	c.AddCommand(export, imp)
+	return c
+}
+
+func readManifestFile(path string) (manifest.Manifest, error) {
+	if path == "-" {
+		return manifest.Read(os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil { return manifest.Manifest{}, err }
+	defer f.Close()
+	return manifest.Read(f)
+}
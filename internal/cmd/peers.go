@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/discovery"
+	"github.com/ripplego/ripplego/internal/index"
+)
+
+// peerPersistInterval 将发现到的节点写入节点缓存的间隔
+const peerPersistInterval = 30 * time.Second
+
+// newPeerFinder 创建探测引导节点与缓存节点的 Finder，探测结果写回节点缓存
+func newPeerFinder(g discovery.Greeter, ps index.PeerStore, self core.NodeID, static []string) *discovery.StaticFinder {
+	var targets []core.Node
+	for _, a := range static {
+		targets = append(targets, core.Node{Address: a})
+	}
+	for _, p := range index.KnownPeers(ps, index.PeerMaxAge) {
+		if p.ID != self {
+			targets = append(targets, p.Node())
+		}
+	}
+	f := discovery.NewStaticFinder(g, targets)
+	f.SelfID = self
+	f.OnResult = func(target, n core.Node, err error) {
+		if err != nil {
+			_ = index.RecordPeerResult(ps, target, err)
+			return
+		}
+		_ = index.RecordPeerSeen(ps, n)
+		_ = index.RecordPeerResult(ps, n, nil)
+	}
+	return f
+}
+
+// persistPeers 定期将各 Finder 发现的节点写入节点缓存，直到 ctx 结束
+func persistPeers(ctx context.Context, ps index.PeerStore, finders ...discovery.Finder) {
+	ticker := time.NewTicker(peerPersistInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			savePeers(ps, finders...)
+		}
+	}
+}
+
+func savePeers(ps index.PeerStore, finders ...discovery.Finder) {
+	for _, f := range finders {
+		for _, n := range f.Nodes() {
+			_ = index.RecordPeerSeen(ps, n)
+		}
+	}
+}
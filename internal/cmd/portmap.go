@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ripplego/ripplego/internal/portmap"
+	"github.com/ripplego/ripplego/internal/transfer"
+)
+
+// portmapFlags 网关端口映射相关的命令行参数（serve 使用）
+type portmapFlags struct {
+	method  string
+	gateway string
+	lease   time.Duration
+}
+
+func (f *portmapFlags) register(c *cobra.Command) {
+	c.Flags().StringVar(&f.method, "portmap", "off", "在网关上为传输服务端口（及 DHT、QUIC/LEDBAT 的 UDP 端口）自动建立端口映射：off、auto（先 NAT-PMP 后 UPnP）、natpmp 或 upnp")
+	c.Flags().StringVar(&f.gateway, "portmap-gateway", "", "NAT-PMP 网关地址，默认使用系统默认网关")
+	c.Flags().DurationVar(&f.lease, "portmap-lease", portmap.DefaultLifetime, "端口映射的租约时长，到期前自动续期")
+}
+
+// mapper 为 proto（TCP 或 UDP）端口创建映射器，未启用时返回 nil；映射建立或外部地址变化时以外部地址（host:port）调用 onAddr
+func (f *portmapFlags) mapper(proto string, onAddr func(addr string)) (transfer.PortMapper, error) {
+	switch f.method {
+	case "", "off":
+		return nil, nil
+	case portmap.MethodAuto, portmap.MethodNATPMP, portmap.MethodUPnP:
+	default:
+		return nil, fmt.Errorf("未知的端口映射方式：%s（可选 off、auto、natpmp、upnp）", f.method)
+	}
+	m := portmap.NewMapper(f.method)
+	m.Protocol, m.Gateway, m.Lifetime = proto, f.gateway, f.lease
+	var lastErr string
+	m.OnChange = func(mp portmap.Mapping, err error) {
+		if err != nil {
+			// 失败后每隔一段时间重试，相同的错误只提示一次
+			if err.Error() != lastErr {
+				lastErr = err.Error()
+				fmt.Fprintf(os.Stderr, "%s 端口映射失败：%v，稍后重试\n", proto, err)
+			}
+			return
+		}
+		lastErr = ""
+		addr := mp.ExternalAddr()
+		fmt.Printf("已通过 %s 建立 %s 端口映射：外部地址 %s -> 本机端口 %d\n", mp.Method, proto, addr, mp.InternalPort)
+		if addr != "" && onAddr != nil {
+			onAddr(addr)
+		}
+	}
+	return m, nil
+}
+
+// runUDP 为 UDP 端口（DHT、QUIC/LEDBAT）建立映射直到 ctx 结束，返回等待映射全部移除的函数；未启用时不做任何事
+func (f *portmapFlags) runUDP(ctx context.Context, ports []int) (wait func(), err error) {
+	var wg sync.WaitGroup
+	for _, port := range ports {
+		m, err := f.mapper(portmap.ProtoUDP, nil)
+		if err != nil || m == nil { return wg.Wait, err }
+		wg.Add(1)
+		go func() { defer wg.Done(); m.Run(ctx, port) }()
+	}
+	return wg.Wait, nil
+}
+
+// withMapped 返回在 advertise 基础上追加端口映射外部地址的宣告地址列表
+func withMapped(advertise []string, mapped string) []string {
+	out := slices.Clone(advertise)
+	if !slices.Contains(out, mapped) {
+		out = append(out, mapped)
+	}
+	return out
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/punch"
+	"github.com/ripplego/ripplego/internal/transfer"
+)
+
+// startPunch 创建 UDP 打洞客户端并作为 tr 的 Traverser：直连节点失败时经会合节点打洞
+// id 不为空时向会合节点登记，并在打通的流上提供与 TCP 相同的服务
+func startPunch(ctx context.Context, tr *transfer.TCPTransport, id core.NodeID, rendezvous []string) (*punch.Client, error) {
+	pc, err := punch.Listen(":0", id, rendezvous)
+	if err != nil { return nil, err }
+	tr.Traverser = pc
+	pc.Start(ctx)
+	if id == "" {
+		go func() { <-ctx.Done(); pc.Close() }()
+		return pc, nil
+	}
+	go func() {
+		if err := tr.ServeListener(ctx, pc); err != nil {
+			fmt.Fprintf(os.Stderr, "打洞服务退出: %v\n", err)
+		}
+	}()
+	go func() {
+		// 登记应答通常在一个往返内到达，超时未到达说明会合节点不可达
+		for i := 0; i < 50 && ctx.Err() == nil; i++ {
+			if obs := pc.Observed(); obs != "" {
+				fmt.Printf("已向会合节点登记，观察到的外部地址 %s（UDP %s）\n", obs, pc.Addr())
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "会合节点 %v 暂无应答，将继续定期重试\n", rendezvous)
+		}
+	}()
+	return pc, nil
+}
+
+// startRendezvous 在 UDP 地址 addr 上运行会合节点，直到 ctx 结束
+func startRendezvous(ctx context.Context, addr string) (*punch.Rendezvous, error) {
+	rv, err := punch.ListenRendezvous(addr)
+	if err != nil { return nil, err }
+	go rv.Serve(ctx)
+	return rv, nil
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/transfer"
+)
+
+// quicFlags QUIC 传输相关的命令行参数（serve 与 get 共用）
+type quicFlags struct {
+	enabled bool
+	peers   []string
+}
+
+// register 注册 --quic；get 还注册 --quic-peer
+func (f *quicFlags) register(c *cobra.Command, serve bool) {
+	if serve {
+		c.Flags().BoolVar(&f.enabled, "quic", false, "同时在传输服务端口（UDP）上提供 QUIC 传输，TCP 服务照常运行")
+		return
+	}
+	c.Flags().BoolVar(&f.enabled, "quic", false, "对全部源节点优先使用 QUIC 下载（每个节点一条连接、每个分片一条流），连接失败时回退到 TCP")
+	c.Flags().StringSliceVar(&f.peers, "quic-peer", nil, "只对这些源节点（节点ID或服务地址）使用 QUIC，其余使用 TCP，可重复指定")
+}
+
+// transport 启用 QUIC 时返回以 fallback 为回退的 QUIC 传输，否则返回 fallback 本身
+func (f *quicFlags) transport(tr *transfer.TCPTransport, fallback transfer.Transport) transfer.Transport {
+	if !f.enabled && len(f.peers) == 0 { return fallback }
+	qt := transfer.NewQUICTransport(tr, fallback)
+	if len(f.peers) > 0 && !f.enabled { qt.Select = peerSelector(f.peers) }
+	return qt
+}
+
+// peerSelector 返回匹配节点ID或任一服务地址在 peers 中的节点的判断函数
+func peerSelector(peers []string) func(core.Node) bool {
+	return func(n core.Node) bool {
+		if slices.Contains(peers, string(n.ID)) { return true }
+		for _, a := range n.ServiceAddrs() {
+			if slices.Contains(peers, a) { return true }
+		}
+		return false
+	}
+}
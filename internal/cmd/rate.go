@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ripplego/ripplego/internal/transfer"
+)
+
+// rateFlags 限速相关的命令行参数（serve 限制上传，get 限制下载）
+type rateFlags struct {
+	total    string
+	peer     string
+	schedule []string
+}
+
+// register serve 注册 --rate-up、--rate-peer-up；get 注册 --rate-down、--rate-peer-down；两者都注册 --rate-schedule
+func (f *rateFlags) register(c *cobra.Command, serve bool) {
+	if serve {
+		c.Flags().StringVar(&f.total, "rate-up", "0", "全部上传合计的速率上限（字节/秒，可带 K、M、G 后缀），0 表示不限")
+		c.Flags().StringVar(&f.peer, "rate-peer-up", "0", "向每个节点上传的速率上限，0 表示不限")
+	} else {
+		c.Flags().StringVar(&f.total, "rate-down", "0", "全部源节点合计的下载速率上限（字节/秒，可带 K、M、G 后缀），0 表示不限")
+		c.Flags().StringVar(&f.peer, "rate-peer-down", "0", "从每个源节点下载的速率上限，0 表示不限")
+	}
+	c.Flags().StringArrayVar(&f.schedule, "rate-schedule", nil, `按时段生效的限速，如 "mon-fri 09:00-18:00 up=1M peer-up=256K"，可重复指定，先列出的优先`)
+}
+
+// limiter 创建限速器并在后台按时段切换，直到 ctx 结束
+func (f *rateFlags) limiter(ctx context.Context, serve bool) (*transfer.RateLimiter, error) {
+	total, err := transfer.ParseRate(f.total)
+	if err != nil { return nil, err }
+	peer, err := transfer.ParseRate(f.peer)
+	if err != nil { return nil, err }
+	var base transfer.RateLimits
+	if serve {
+		base.Upload, base.PeerUpload = total, peer
+	} else {
+		base.Download, base.PeerDownload = total, peer
+	}
+	rules, err := parseRateRules(f.schedule)
+	if err != nil { return nil, err }
+	l := transfer.NewRateLimiter(base, rules)
+	go l.Run(ctx)
+	return l, nil
+}
+
+func parseRateRules(specs []string) ([]transfer.RateRule, error) {
+	rules := make([]transfer.RateRule, 0, len(specs))
+	for _, s := range specs {
+		r, err := transfer.ParseRateRule(s)
+		if err != nil { return nil, err }
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func newRateCmd() *cobra.Command {
+	var node string
+	var up, down, peerUp, peerDown string
+	var schedule []string
+	var clearSchedule bool
+
+	c := &cobra.Command{
+		Use:   "rate",
+		Short: "查看或修改本机运行中节点的上传限速与限速时段",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(cmd.Context(), 10*time.Second)
+			defer cancel()
+			st, err := transfer.RateControl(ctx, node, nil)
+			if err != nil { return err }
+
+			var update transfer.RateUpdate
+			base := st.Base
+			changed := false
+			for _, fl := range []struct {
+				name string
+				val  string
+				dst  *int64
+			}{{"up", up, &base.Upload}, {"down", down, &base.Download}, {"peer-up", peerUp, &base.PeerUpload}, {"peer-down", peerDown, &base.PeerDownload}} {
+				if !cmd.Flags().Changed(fl.name) { continue }
+				v, err := transfer.ParseRate(fl.val)
+				if err != nil { return fmt.Errorf("--%s: %w", fl.name, err) }
+				*fl.dst, changed = v, true
+			}
+			if changed { update.Base = &base }
+			if clearSchedule || len(schedule) > 0 {
+				// 先在本地校验，避免节点拒绝后才发现
+				if _, err := parseRateRules(schedule); err != nil { return err }
+				rules := append([]string{}, schedule...)
+				update.Rules = &rules
+			}
+			if update.Base != nil || update.Rules != nil {
+				if st, err = transfer.RateControl(ctx, node, &update); err != nil { return err }
+			}
+
+			fmt.Printf("基础限速：%s\n", st.Base)
+			fmt.Printf("当前生效：%s\n", st.Current)
+			if len(st.Rules) == 0 {
+				fmt.Println("限速时段：无")
+			}
+			for _, r := range st.Rules { fmt.Printf("限速时段：%s\n", r) }
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&node, "node", "127.0.0.1:9001", "本机节点的传输服务地址")
+	c.Flags().StringVar(&up, "up", "", "全部上传合计的速率上限（字节/秒，可带 K、M、G 后缀），0 表示不限")
+	c.Flags().StringVar(&down, "down", "", "全部下载合计的速率上限，0 表示不限")
+	c.Flags().StringVar(&peerUp, "peer-up", "", "向每个节点上传的速率上限，0 表示不限")
+	c.Flags().StringVar(&peerDown, "peer-down", "", "从每个节点下载的速率上限，0 表示不限")
+	c.Flags().StringArrayVar(&schedule, "schedule", nil, "替换全部限速时段，格式同 serve --rate-schedule，可重复指定")
+	c.Flags().BoolVar(&clearSchedule, "clear-schedule", false, "清除全部限速时段")
+	return c
+}
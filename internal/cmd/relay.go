@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ripplego/ripplego/internal/transfer"
+)
+
+// relayFlags 中继相关的命令行参数（serve 与 get 共用）
+type relayFlags struct {
+	relays    []string
+	serve     bool
+	rate      int64
+	totalRate int64
+	sessions  int
+}
+
+// register 注册 --relay；serve 为 true 时还注册作为中继节点的参数
+func (f *relayFlags) register(c *cobra.Command, serve bool) {
+	if serve {
+		c.Flags().StringSliceVar(&f.relays, "relay", nil, "中继节点的传输服务地址（host:port），向其登记以便无法直连的节点经中继下载，可重复指定")
+		c.Flags().BoolVar(&f.serve, "relay-serve", false, "同时作为中继节点，为其他节点转发加密的传输流（需本节点可被直接访问）")
+		c.Flags().Int64Var(&f.rate, "relay-rate", transfer.DefaultRelayRate, "作为中继时每个会话每个方向的速率上限（字节/秒），0 表示不限")
+		c.Flags().Int64Var(&f.totalRate, "relay-total-rate", transfer.DefaultRelayTotalRate, "作为中继时全部会话合计的速率上限（字节/秒），0 表示不限")
+		c.Flags().IntVar(&f.sessions, "relay-sessions", transfer.DefaultRelaySessions, "作为中继时同时转发的会话数上限")
+		return
+	}
+	c.Flags().StringSliceVar(&f.relays, "relay", nil, "中继节点的传输服务地址（host:port），源节点无法直连且打洞失败时经其下载，可重复指定")
+}
+
+// transport 启用 --relay 时返回包装 tr 的中继传输（直连与打洞都失败时使用），否则返回 tr 本身；
+// key 为本节点的签名私钥，为空时不向中继登记（如 get）
+// 需在设置 tr.Traverser（如打洞）之后调用
+func (f *relayFlags) transport(tr *transfer.TCPTransport, key ed25519.PrivateKey) transfer.Transport {
+	if f.serve {
+		rs := transfer.NewRelayServer()
+		rs.Rate, rs.TotalRate, rs.MaxSessions = f.rate, f.totalRate, f.sessions
+		tr.Relay = rs
+	}
+	if len(f.relays) == 0 { return tr }
+	rt := transfer.NewRelayTransport(tr, f.relays)
+	rt.Key = key
+	rt.OnRelay = func(relay string, err error) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "与中继 %s 的登记断开：%v，稍后重试\n", relay, err)
+			return
+		}
+		fmt.Printf("已在中继 %s 上登记\n", relay)
+	}
+	return rt
+}
@@ -2,16 +2,27 @@ package cmd
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/schollz/progressbar/v3"
 	"github.com/spf13/cobra"
 
+	"github.com/ripplego/ripplego/internal/core"
 	"github.com/ripplego/ripplego/internal/discovery"
+	"github.com/ripplego/ripplego/internal/index"
+	"github.com/ripplego/ripplego/internal/portmap"
+	"github.com/ripplego/ripplego/internal/transfer"
 )
 
 func NewRootCmd() *cobra.Command {
@@ -26,6 +37,11 @@ func NewRootCmd() *cobra.Command {
 	cmd.AddCommand(newServeCmd())
 	cmd.AddCommand(newShareCmd())
 	cmd.AddCommand(newGetCmd())
+	cmd.AddCommand(newFilesCmd())
+	cmd.AddCommand(newUnshareCmd())
+	cmd.AddCommand(newManifestCmd())
+	cmd.AddCommand(newTrackerCmd())
+	cmd.AddCommand(newRateCmd())
 
 	return cmd
 }
@@ -41,22 +57,49 @@ func newVersionCmd() *cobra.Command {
 }
 
 func newListCmd() *cobra.Command {
-	var port int
-	var name string
+	var storeDir string
+	var watch bool
+	var disc discoveryFlags
 
 	c := &cobra.Command{
 		Use:   "list",
-		Short: "发现局域网节点 (UDP 广播)",
+		Short: "发现节点 (UDP 广播/组播、mDNS、DHT、引导节点与缓存节点)",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+			if watch {
+				ctx, cancel = context.WithCancel(context.Background())
+			}
 			defer cancel()
 
-			finder := discovery.NewUDPFinderQuery(port)
-			if err := finder.Start(ctx); err != nil {
-				return err
+			// 节点缓存不可用（如 serve 正在使用同一目录且无法连接）时不使用缓存节点
+			var ps index.PeerStore = index.NewMemoryStore()
+			if bs, _, closeStore, err := openStore(storeDir); err == nil {
+				defer closeStore()
+				ps = bs
+			} else {
+				fmt.Fprintf(os.Stderr, "节点缓存不可用，仅使用实时发现：%v\n", err)
+			}
+
+			finder, err := disc.queryFinder(ps, transfer.NewTCPTransport("", ""), watch)
+			if err != nil { return err }
+			if err := finder.Start(ctx); err != nil { return err }
+			if watch {
+				watchNodes(finder)
+				savePeers(ps, finder)
+				return finder.Stop()
 			}
 			time.Sleep(2 * time.Second)
+			// 引导节点与缓存节点的探测可能超过广播等待时间
+			for _, f := range finder.Finders() {
+				if s, ok := f.(*discovery.StaticFinder); ok {
+					select {
+					case <-s.Probed():
+					case <-ctx.Done():
+					}
+				}
+			}
 			nodes := finder.Nodes()
+			savePeers(ps, finder)
 
 			bar := progressbar.NewOptions(100,
 				progressbar.OptionSetDescription("扫描网络中的节点..."),
@@ -66,44 +109,241 @@ func newListCmd() *cobra.Command {
 				_ = bar.Add(1)
 			}
 			fmt.Printf("\n发现节点数: %d\n", len(nodes))
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tSERVICE ADDR\tOTHER ADDRS\tDISCOVERY")
 			for _, n := range nodes {
-				fmt.Printf("- %s (%s)\n", n.ID, n.Address)
+				addr, others := "-", "-"
+				if sa := n.ServiceAddrs(); len(sa) > 0 {
+					addr = sa[0]
+					if len(sa) > 1 { others = strings.Join(sa[1:], ",") }
+				}
+				d := n.DiscoveryAddr
+				if d == "" { d = "peer" }
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", n.ID, addr, others, d)
 			}
+			_ = tw.Flush()
+			fmt.Println("\nSERVICE ADDR 可直接用于 get --addr；显示为 - 的节点未提供传输服务")
 			return finder.Stop()
 		},
 	}
 
-	c.Flags().IntVarP(&port, "port", "p", 7788, "UDP 广播端口")
-	c.Flags().StringVar(&name, "name", "ripplego", "节点名称")
+	c.Flags().StringVar(&storeDir, "store", ".ripplego/index", "索引持久化目录（节点缓存保存于此）")
+	c.Flags().BoolVarP(&watch, "watch", "w", false, "持续显示节点的加入、变化与离开，按 Ctrl+C 停止")
+	disc.register(c, []string{backendBroadcast, backendStatic, backendTracker}, false)
 	return c
 }
 
 func newServeCmd() *cobra.Command {
-	var port int
 	var name string
+	var listen string
+	var storeDir string
+	var watch time.Duration
+	var advertise []string
+	var rendezvous []string
+	var rendezvousListen string
+	var relay relayFlags
+	var pm portmapFlags
+	var quic quicFlags
+	var ledbat ledbatFlags
+	var rate rateFlags
+	limits := transfer.DefaultServerLimits()
+	var uploadSlots int
+	var cacheSize int64
+	var compress bool
+	var disc discoveryFlags
 
 	c := &cobra.Command{
 		Use:   "serve",
-		Short: "启动节点并广播存在 (UDP 广播)",
+		Short: "启动节点：通过所选发现方式宣告存在，并提供已分享文件的分片下载",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if quic.enabled && ledbat.enabled { return fmt.Errorf("--quic 与 --ledbat 共用传输服务的 UDP 端口，不能同时启用") }
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 
-			finder := discovery.NewUDPFinder(name, port)
+			bs, err := index.NewBadgerStore(storeDir)
+			if err != nil {
+				return err
+			}
+			defer bs.Close()
+
+			servicePort, err := listenPort(listen)
+			if err != nil { return err }
+			// 节点ID由保存在索引目录中的签名密钥生成，重启后不变，并可向中继与请求方证明身份
+			key, err := core.LoadNodeKey(filepath.Join(storeDir, nodeKeyFile))
+			if err != nil { return err }
+			self := nodeInfo{
+				ID:          core.KeyNodeID(name, key.Public().(ed25519.PublicKey)),
+				Name:        name,
+				ServicePort: servicePort,
+				Advertise:   advertiseAddrs(listen, advertise),
+			}
+
+			tr := transfer.NewTCPTransport(listen, "")
+			tr.Store = bs
+			tr.NodeID, tr.Name, tr.Advertise = self.ID, name, self.Advertise
+			tr.Limits = limits
+			tr.CacheSize = cacheSize
+			tr.Compress = compress
+			if uploadSlots > 0 {
+				tr.Scheduler = transfer.NewUploadScheduler(uploadSlots)
+				go tr.Scheduler.Run(ctx)
+			}
+			// 始终创建限速器，以便运行中通过 ripplego rate 修改
+			if tr.Limiter, err = rate.limiter(ctx, true); err != nil { return err }
+			// 需在传输服务启动前创建，启用 pex 时会设置 tr.PEX
+			finder, err := disc.nodeFinder(self, bs, tr)
+			if err != nil { return err }
+			if rendezvousListen != "" {
+				rv, err := startRendezvous(ctx, rendezvousListen)
+				if err != nil { return err }
+				fmt.Printf("会合节点已启动，监听 UDP %s\n", rv.Addr())
+			}
+			if len(rendezvous) > 0 {
+				if _, err := startPunch(ctx, tr, self.ID, rendezvous); err != nil { return err }
+			}
+			// 映射建立后将外部地址加入 HELLO 应答与各发现后端的宣告
+			tr.PortMap, err = pm.mapper(portmap.ProtoTCP, func(addr string) {
+				addrs := withMapped(self.Advertise, addr)
+				tr.SetAdvertise(addrs)
+				finder.SetAddrs(addrs)
+			})
+			if err != nil { return err }
+			// DHT 与 QUIC/LEDBAT 使用 UDP 端口，同样需要映射才能被 NAT 之外的节点访问；QUIC/LEDBAT 与 TCP 使用相同的端口号
+			var udpPorts []int
+			if quic.enabled || ledbat.enabled { udpPorts = append(udpPorts, servicePort) }
+			if p := disc.dhtUDPPort(); p > 0 && !slices.Contains(udpPorts, p) { udpPorts = append(udpPorts, p) }
+			waitUDP, err := pm.runUDP(ctx, udpPorts)
+			if err != nil { return err }
+			srv := ledbat.transport(tr, quic.transport(tr, relay.transport(tr, key)))
+			if relay.serve {
+				fmt.Printf("已启用中继：每会话 %d 字节/秒，合计 %d 字节/秒，最多 %d 个会话\n", relay.rate, relay.totalRate, relay.sessions)
+			}
+			if quic.enabled { fmt.Printf("已启用 QUIC 传输（UDP %s）\n", listen) }
+			if ledbat.enabled { fmt.Printf("已启用 LEDBAT 传输（UDP %s）\n", listen) }
+			if cur := tr.Limiter.Current(); cur != (transfer.RateLimits{}) { fmt.Printf("当前限速：%s\n", cur) }
+			if uploadSlots > 0 { fmt.Printf("已启用上传槽位调度：同时向 %d 个节点上传\n", uploadSlots) }
+			srvDone := make(chan struct{})
+			go func() {
+				defer close(srvDone)
+				if err := srv.Serve(ctx); err != nil {
+					fmt.Fprintf(os.Stderr, "传输服务退出: %v\n", err)
+				}
+			}()
+			// 本节点占用索引目录期间，同一目录上的其他命令经传输服务的 STORE 命令访问索引
+			defer writeNodeAddr(storeDir, listen, servicePort)()
+
+			if watch > 0 {
+				w := index.NewWatcher(bs, watch)
+				w.OnChange = func(old, cur core.FileInfo, err error) {
+					if err == index.ErrFileChanged {
+						fmt.Printf("文件已变更，重新分享：%s (%s -> %s)\n", old.Path, old.ID, cur.ID)
+						return
+					}
+					fmt.Printf("文件不再分享：%s (%s): %v\n", old.Path, old.ID, err)
+				}
+				go w.Run(ctx)
+			}
+
 			if err := finder.Start(ctx); err != nil {
 				return err
 			}
-			fmt.Printf("RippleGo 节点已启动，正在通过 UDP:%d 广播，名称=%s。按 Ctrl+C 停止。\n", port, name)
+			fmt.Printf("RippleGo 节点已启动，名称=%s，节点ID=%s，传输服务监听 %s，发现方式：%s。按 Ctrl+C 停止。\n", name, self.ID, listen, backendNames(finder))
+			go persistPeers(ctx, bs, finder)
 
 			sigCh := make(chan os.Signal, 1)
 			signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 			<-sigCh
 			fmt.Println("\n正在退出...")
-			return finder.Stop()
+			savePeers(bs, finder)
+			err = finder.Stop()
+			// 等待传输服务与 UDP 端口映射退出，使端口映射得以从网关移除
+			cancel()
+			mapDone := make(chan struct{})
+			go func() { <-srvDone; waitUDP(); close(mapDone) }()
+			select {
+			case <-mapDone:
+			case <-time.After(5 * time.Second):
+			}
+			return err
 		},
 	}
 
-	c.Flags().IntVarP(&port, "port", "p", 7788, "UDP 广播端口")
 	c.Flags().StringVar(&name, "name", "ripplego", "节点名称")
+	c.Flags().StringVar(&listen, "listen", ":9001", "TCP 传输服务监听地址")
+	c.Flags().StringVar(&storeDir, "store", ".ripplego/index", "索引持久化目录")
+	c.Flags().DurationVar(&watch, "watch", 0, "定期检查已分享文件的间隔（如 10s），文件变更时自动重新分享；0 表示关闭")
+	c.Flags().StringSliceVar(&advertise, "advertise", nil, "额外宣告的传输服务地址（host:port），如端口映射后的外部地址，可重复指定")
+	c.Flags().StringSliceVar(&rendezvous, "rendezvous", nil, "会合节点地址（host:port），向其登记以便 NAT 之后的本节点可被打洞连接，可重复指定")
+	relay.register(c, true)
+	pm.register(c)
+	quic.register(c, true)
+	ledbat.register(c, true)
+	rate.register(c, true)
+	c.Flags().IntVar(&limits.MaxConns, "max-conns", limits.MaxConns, "同时处理的传输连接数上限，超出时拒绝（对端会稍后重试或改用其他节点），0 表示不限")
+	c.Flags().IntVar(&limits.MaxPeerConns, "max-peer-conns", limits.MaxPeerConns, "每个对端 IP 同时处理的传输连接数上限，0 表示不限")
+	c.Flags().DurationVar(&limits.IdleTimeout, "idle-timeout", limits.IdleTimeout, "发送数据时对端停止接收超过该时长即断开，0 表示不限")
+	c.Flags().Int64Var(&cacheSize, "cache-size", transfer.DefaultChunkCacheSize, "热点分片缓存的大小（字节），用于 QUIC、UDP、中继与限速时的发送；0 表示不缓存")
+	c.Flags().BoolVar(&compress, "compress", false, "按下载端的请求以 zstd 压缩分片（不可压缩的分片自动跳过），适合日志、文本、未压缩的归档等")
+	c.Flags().IntVar(&uploadSlots, "upload-slots", 0, fmt.Sprintf("同时获得上传的节点（按 IP）数，其余节点的请求被暂时拒绝（choke），定期按回报与上传速率轮换，并保留一个随机轮换的乐观槽位（本机 get 指定 --node 时其下载计入各节点的回报）；0 表示不启用（建议 %d）", transfer.DefaultUploadSlots))
+	c.Flags().StringVar(&rendezvousListen, "rendezvous-listen", "", "同时作为会合节点，监听该 UDP 地址（如 :7071），需部署在各节点均可访问的地址上")
+	disc.register(c, []string{backendBroadcast, backendStatic, backendDHT, backendPEX, backendTracker}, true)
 	return c
-}
\ No newline at end of file
+}
+
+// watchNodes 持续打印 finder 的节点事件，直到收到退出信号或 finder 停止
+func watchNodes(finder *discovery.MultiFinder) {
+	events, unsubscribe := finder.Subscribe(0)
+	defer unsubscribe()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	fmt.Println("正在监听节点变化，按 Ctrl+C 停止。")
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok { return }
+			addr := "-"
+			if sa := ev.Node.ServiceAddrs(); len(sa) > 0 { addr = strings.Join(sa, ",") }
+			fmt.Printf("%s  %-7s  %s  %s\n", ev.Time.Format("15:04:05"), ev.Type, ev.Node.ID, addr)
+		case <-sigCh:
+			fmt.Printf("\n当前节点数: %d\n", len(finder.Nodes()))
+			return
+		}
+	}
+}
+
+// listenPort 解析监听地址中的端口，多个监听地址时取第一个
+func listenPort(listen string) (int, error) {
+	_, p, err := net.SplitHostPort(strings.TrimSpace(strings.Split(listen, ",")[0]))
+	if err != nil { return 0, err }
+	port, err := strconv.Atoi(p)
+	if err != nil || port <= 0 {
+		return 0, fmt.Errorf("无法从监听地址解析端口：%s", listen)
+	}
+	return port, nil
+}
+
+// advertiseAddrs 返回公告中额外携带的服务地址：监听于具体 IP 时包含监听地址，以及 --advertise 指定的地址
+func advertiseAddrs(listen string, extra []string) []string {
+	var out []string
+	for _, l := range strings.Split(listen, ",") {
+		l = strings.TrimSpace(l)
+		if host, _, err := net.SplitHostPort(l); err == nil && host != "" {
+			if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+				out = append(out, l)
+			}
+		}
+	}
+	return append(out, extra...)
+}
+
+// servedFiles 返回本地实际持有、可对外提供的文件
+func servedFiles(s index.IndexStore) []core.FileID {
+	var ids []core.FileID
+	for _, fi := range s.ListFiles() {
+		if !fi.Withdrawn && fi.Path != "" {
+			ids = append(ids, fi.ID)
+		}
+	}
+	return ids
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strconv"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/ripplego/ripplego/internal/core"
 	"github.com/ripplego/ripplego/internal/index"
+	"github.com/ripplego/ripplego/internal/link"
 )
 
 func newShareCmd() *cobra.Command {
@@ -17,6 +19,8 @@ func newShareCmd() *cobra.Command {
 		filePath  string
 		chunkSize int64
 		storeDir  string
+		hashName  string
+		peers     []string
 	)
 
 	c := &cobra.Command{
@@ -27,14 +31,19 @@ func newShareCmd() *cobra.Command {
 				return fmt.Errorf("请使用 --file 指定要分享的文件路径")
 			}
 
-			fi, chunks, err := index.BuildFileIndex(filePath, chunkSize)
+			algo, err := core.ParseHashAlgo(hashName)
 			if err != nil {
 				return err
 			}
 
-			bs, err := index.NewBadgerStore(storeDir)
+			fi, chunks, err := index.BuildFileIndex(filePath, chunkSize, algo)
+			if err != nil {
+				return err
+			}
+
+			bs, _, closeStore, err := openStore(storeDir)
 			if err != nil { return err }
-			defer bs.Close()
+			defer closeStore()
 
 			if err := bs.SaveFile(fi); err != nil { return err }
 			if err := bs.SaveChunks(fi.ID, chunks); err != nil { return err }
@@ -46,8 +55,11 @@ func newShareCmd() *cobra.Command {
 			for _, ch := range chunks { owned = append(owned, ch.ID) }
 			if err := bs.SaveNodeChunks(core.NodeChunkMap{NodeID: nodeID, ChunkIDs: owned}); err != nil { return err }
 
-			fmt.Printf("已建立并持久化索引：%s\n- 文件ID: %s\n- 大小: %d bytes\n- 分片: %d 个 (chunkSize=%d)\n",
-				fi.Name, fi.ID, fi.Size, fi.ChunkCount, fi.ChunkSize)
+			fmt.Printf("已建立并持久化索引：%s\n- 文件ID: %s\n- 大小: %d bytes\n- 分片: %d 个 (chunkSize=%d)\n- 哈希: %s %s\n",
+				fi.Name, fi.ID, fi.Size, fi.ChunkCount, fi.ChunkSize, fi.HashAlgo, fi.Hash)
+
+			if len(peers) == 0 { peers = localPeerAddrs(9001) }
+			fmt.Printf("- 分享链接: %s\n", link.FromFile(fi, peers))
 
 			_ = context.TODO()
 			_ = time.Second
@@ -58,5 +70,26 @@ func newShareCmd() *cobra.Command {
 	c.Flags().StringVarP(&filePath, "file", "f", "", "要分享的文件路径")
 	c.Flags().Int64Var(&chunkSize, "chunk-size", 4*1024*1024, "分片大小（字节），默认4MB")
 	c.Flags().StringVar(&storeDir, "store", ".ripplego/index", "索引持久化目录")
+	c.Flags().StringSliceVar(&peers, "peer", nil, "写入分享链接的节点传输地址（serve 的 --listen），可重复；默认使用本机IP与端口9001")
+	c.Flags().StringVar(&hashName, "hash", string(core.DefaultHashAlgo), "内容哈希算法：sha256 | blake3 | xxh64（xxh64 非密码学安全）")
 	return c
-}
\ No newline at end of file
+}
+
+// localPeerAddrs 返回本机非回环的IPv4与全局IPv6地址拼接端口，作为分享链接的默认节点地址
+func localPeerAddrs(port int) []string {
+	var out, out6 []string
+	ifs, err := net.Interfaces()
+	if err != nil { return out }
+	for _, iface := range ifs {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 { continue }
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			// 链路本地地址需要携带网卡名，对其他主机无意义，不写入链接
+			if !ok || !ipNet.IP.IsGlobalUnicast() { continue }
+			addr := net.JoinHostPort(ipNet.IP.String(), strconv.Itoa(port))
+			if ipNet.IP.To4() != nil { out = append(out, addr) } else { out6 = append(out6, addr) }
+		}
+	}
+	return append(out, out6...)
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ripplego/ripplego/internal/index"
+	"github.com/ripplego/ripplego/internal/transfer"
+)
+
+// nodeAddrFile serve 运行期间在索引目录中记录本机可访问的传输服务地址，其他命令据此经 STORE 命令访问被占用的索引
+const nodeAddrFile = "serve.addr"
+
+// nodeKeyFile 索引目录中保存节点签名私钥的文件，节点ID由其公钥生成
+const nodeKeyFile = "node.key"
+
+// cliStore 命令行使用的索引与节点缓存存储
+type cliStore interface {
+	index.IndexStore
+	index.PeerStore
+}
+
+// openStore 打开索引目录；目录正被本机运行中的节点占用时改为经该节点访问，返回其地址（node）
+func openStore(storeDir string) (s cliStore, node string, closeFn func(), err error) {
+	bs, err := index.NewBadgerStore(storeDir)
+	if err == nil { return bs, "", func() { bs.Close() }, nil }
+	if !errors.Is(err, index.ErrStoreLocked) { return nil, "", nil, err }
+	data, rerr := os.ReadFile(filepath.Join(storeDir, nodeAddrFile))
+	node = strings.TrimSpace(string(data))
+	if rerr != nil || node == "" {
+		return nil, "", nil, fmt.Errorf("索引目录 %s 正被其他进程使用，且未找到运行中节点的地址；请停止该进程后重试，或使用其他 --store：%w", storeDir, err)
+	}
+	rs := transfer.NewRemoteStore(node)
+	if perr := rs.Ping(); perr != nil {
+		return nil, "", nil, fmt.Errorf("索引目录 %s 正被其他进程使用，无法经运行中的节点 %s 访问（%v）；请确认 serve 仍在运行，或停止该进程后重试", storeDir, node, perr)
+	}
+	fmt.Fprintf(os.Stderr, "索引目录正被运行中的节点使用，经 %s 访问其索引\n", node)
+	return rs, node, func() {}, nil
+}
+
+// writeNodeAddr 记录本机访问传输服务的地址，返回退出时删除记录的函数
+func writeNodeAddr(storeDir, listen string, port int) func() {
+	host, _, _ := net.SplitHostPort(strings.TrimSpace(strings.Split(listen, ",")[0]))
+	switch ip := net.ParseIP(host); {
+	case host == "" || ip != nil && ip.IsUnspecified() && ip.To4() != nil:
+		host = "127.0.0.1"
+	case ip != nil && ip.IsUnspecified():
+		host = "::1"
+	}
+	path := filepath.Join(storeDir, nodeAddrFile)
+	if err := os.WriteFile(path, []byte(net.JoinHostPort(host, strconv.Itoa(port))+"\n"), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "无法记录节点地址，其他命令将不能使用该索引目录：%v\n", err)
+		return func() {}
+	}
+	return func() { _ = os.Remove(path) }
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ripplego/ripplego/internal/tracker"
+)
+
+func newTrackerCmd() *cobra.Command {
+	var (
+		listen           string
+		ttl              time.Duration
+		interval         time.Duration
+		rendezvousListen string
+	)
+
+	c := &cobra.Command{
+		Use:   "tracker",
+		Short: "启动 tracker：节点登记所提供的文件，其他节点按文件查询持有者（用于广播不通的跨网段环境）",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if ttl <= interval { return fmt.Errorf("--ttl 需大于 --interval") }
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			ln, err := net.Listen("tcp", listen)
+			if err != nil { return err }
+			srv := tracker.NewServer()
+			srv.TTL, srv.Interval = ttl, interval
+			fmt.Printf("RippleGo tracker 已启动，监听 http://%s，节点 %s 未续期即移除。按 Ctrl+C 停止。\n", ln.Addr(), ttl)
+			fmt.Printf("节点使用：ripplego serve --tracker http://<本机地址>:%d\n", ln.Addr().(*net.TCPAddr).Port)
+			if rendezvousListen != "" {
+				rv, err := startRendezvous(ctx, rendezvousListen)
+				if err != nil { return err }
+				fmt.Printf("会合节点已启动，监听 UDP %s，节点使用：--rendezvous <本机地址>:%d\n", rv.Addr(), rv.Addr().(*net.UDPAddr).Port)
+			}
+			if err := srv.Serve(ctx, ln); err != nil { return err }
+			fmt.Println("\n正在退出...")
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&listen, "listen", ":7070", "HTTP 监听地址")
+	c.Flags().DurationVar(&ttl, "ttl", tracker.DefaultTTL, "节点未续期多久后被移除")
+	c.Flags().DurationVar(&interval, "interval", tracker.DefaultInterval, "建议节点续期的间隔")
+	c.Flags().StringVar(&rendezvousListen, "rendezvous-listen", "", "同时作为 UDP 打洞的会合节点，监听该 UDP 地址（如 :7071）")
+	return c
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"lukechampine.com/blake3"
+)
+
+// HashAlgo 内容哈希算法标识，随 FileInfo 持久化，下载端据此校验分片
+type HashAlgo string
+
+const (
+	HashSHA256 HashAlgo = "sha256"
+	HashBLAKE3 HashAlgo = "blake3"
+	HashXXH64  HashAlgo = "xxh64" // 非密码学哈希，仅适合可信网络内的去重与快速校验
+)
+
+// DefaultHashAlgo 默认算法；旧索引未记录算法时亦按此处理
+const DefaultHashAlgo = HashSHA256
+
+var (
+	hashMu  sync.RWMutex
+	hashers = map[HashAlgo]func() hash.Hash{
+		HashSHA256: sha256.New,
+		HashBLAKE3: func() hash.Hash { return blake3.New(32, nil) },
+		HashXXH64:  func() hash.Hash { return xxhash.New() },
+	}
+)
+
+// RegisterHashAlgo 注册（或替换）一个哈希算法实现
+func RegisterHashAlgo(algo HashAlgo, fn func() hash.Hash) {
+	hashMu.Lock()
+	defer hashMu.Unlock()
+	hashers[algo] = fn
+}
+
+// HashAlgos 返回已注册的算法列表（按名称排序）
+func HashAlgos() []HashAlgo {
+	hashMu.RLock()
+	defer hashMu.RUnlock()
+	out := make([]HashAlgo, 0, len(hashers))
+	for a := range hashers {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// ParseHashAlgo 解析算法名称（大小写不敏感），空串返回默认算法
+func ParseHashAlgo(s string) (HashAlgo, error) {
+	algo := HashAlgo(strings.ToLower(strings.TrimSpace(s))).OrDefault()
+	hashMu.RLock()
+	_, ok := hashers[algo]
+	hashMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unsupported hash algorithm: %s", s)
+	}
+	return algo, nil
+}
+
+// OrDefault 空算法标识视为默认算法
+func (a HashAlgo) OrDefault() HashAlgo {
+	if a == "" {
+		return DefaultHashAlgo
+	}
+	return a
+}
+
+// NewHasher 创建指定算法的 hash.Hash
+func NewHasher(algo HashAlgo) (hash.Hash, error) {
+	algo = algo.OrDefault()
+	hashMu.RLock()
+	fn, ok := hashers[algo]
+	hashMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm: %s", algo)
+	}
+	return fn(), nil
+}
+
+// HashHex 计算数据的哈希，返回十六进制字符串
+func HashHex(algo HashAlgo, data []byte) (string, error) {
+	h, err := NewHasher(algo)
+	if err != nil {
+		return "", err
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
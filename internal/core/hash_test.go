@@ -0,0 +1,120 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHashAlgo(t *testing.T) {
+	tests := []struct {
+		in   string
+		want HashAlgo
+	}{
+		{"", HashSHA256},
+		{"  ", HashSHA256},
+		{"sha256", HashSHA256},
+		{"BLAKE3", HashBLAKE3},
+		{" xxh64\n", HashXXH64},
+	}
+	for _, tt := range tests {
+		got, err := ParseHashAlgo(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseHashAlgo(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"md5", "sha-256", "blake3:32"} {
+		if got, err := ParseHashAlgo(in); err == nil {
+			t.Errorf("ParseHashAlgo(%q) = %q, want an error", in, got)
+		}
+	}
+	if _, err := NewHasher("md5"); err == nil {
+		t.Error("NewHasher accepted an unknown algorithm")
+	}
+	want := []HashAlgo{HashBLAKE3, HashSHA256, HashXXH64}
+	if got := HashAlgos(); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("HashAlgos() = %v, want %v", got, want)
+	}
+}
+
+func TestHashHexKnownAnswers(t *testing.T) {
+	tests := []struct {
+		algo HashAlgo
+		in   string
+		want string
+	}{
+		{HashSHA256, "", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{HashSHA256, "abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{HashBLAKE3, "", "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+		{HashBLAKE3, "abc", "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85"},
+		{HashXXH64, "", "ef46db3751d8e999"},
+		{HashXXH64, "abc", "44bc2cf5ad770999"},
+		// 未记录算法按 sha256
+		{"", "abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	}
+	for _, tt := range tests {
+		got, err := HashHex(tt.algo, []byte(tt.in))
+		if err != nil || got != tt.want {
+			t.Errorf("HashHex(%q, %q) = %s, %v; want %s", tt.algo, tt.in, got, err, tt.want)
+		}
+	}
+
+	// 分块写入与一次写入的结果相同
+	data := []byte(strings.Repeat("ripplego", 1000))
+	for _, algo := range HashAlgos() {
+		h, err := NewHasher(algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(data); i += 333 {
+			h.Write(data[i:min(i+333, len(data))])
+		}
+		want, _ := HashHex(algo, data)
+		if got := hex.EncodeToString(h.Sum(nil)); got != want {
+			t.Errorf("%s streaming = %s, want %s", algo, got, want)
+		}
+	}
+}
+
+func TestFileInfoHashAlgoGob(t *testing.T) {
+	fi := FileInfo{ID: "abcd", Name: "a.bin", Size: 3, Hash: "6437b3ac", HashAlgo: HashBLAKE3, CreatedAt: time.Unix(1700000000, 0)}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(fi); err != nil {
+		t.Fatal(err)
+	}
+	var got FileInfo
+	if err := gob.NewDecoder(&buf).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.HashAlgo != HashBLAKE3 || got.Hash != fi.Hash {
+		t.Fatalf("decoded HashAlgo %q, Hash %q", got.HashAlgo, got.Hash)
+	}
+
+	// 加入 HashAlgo 之前保存的索引没有该字段，解码后按 sha256 校验
+	type preSeriesFileInfo struct {
+		ID        FileID
+		Name      string
+		Size      int64
+		Hash      string
+		ChunkSize int64
+	}
+	buf.Reset()
+	old := preSeriesFileInfo{ID: "abcd", Name: "old.bin", Size: 3, Hash: "ba7816bf", ChunkSize: 1 << 20}
+	if err := gob.NewEncoder(&buf).Encode(old); err != nil {
+		t.Fatal(err)
+	}
+	got = FileInfo{}
+	if err := gob.NewDecoder(&buf).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.HashAlgo != "" || got.HashAlgo.OrDefault() != HashSHA256 || got.Hash != old.Hash {
+		t.Fatalf("pre-series index decoded as %+v", got)
+	}
+	h, err := NewHasher(got.HashAlgo)
+	if err != nil || h.Size() != 32 {
+		t.Fatalf("hasher for a pre-series index: %v", err)
+	}
+}
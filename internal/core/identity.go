@@ -0,0 +1,67 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 节点身份：NodeID 形如 <名称>-<公钥摘要>，摘要为 ed25519 公钥 SHA-256 的前 16 字节（hex），
+// 持有对应私钥的节点可签名证明自己是该 ID 的所有者（如中继登记与加密握手）
+
+const nodeKeyDigestLen = 16
+
+// KeyNodeID 由名称与公钥生成 NodeID
+func KeyNodeID(name string, pub ed25519.PublicKey) NodeID {
+	sum := sha256.Sum256(pub)
+	digest := hex.EncodeToString(sum[:nodeKeyDigestLen])
+	if name == "" {
+		return NodeID(digest)
+	}
+	return NodeID(name + "-" + digest)
+}
+
+// VerifyNodeKey 判断 pub 是否为 id 所绑定的公钥
+func VerifyNodeKey(id NodeID, pub ed25519.PublicKey) bool {
+	if len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	sum := sha256.Sum256(pub)
+	digest := string(id)
+	if i := strings.LastIndexByte(digest, '-'); i >= 0 {
+		digest = digest[i+1:]
+	}
+	return digest == hex.EncodeToString(sum[:nodeKeyDigestLen])
+}
+
+// LoadNodeKey 读取 path 中保存的节点私钥（hex 编码的 32 字节种子），文件不存在时生成并保存
+func LoadNodeKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid node key file %s", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key.Seed())+"\n"), 0o600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -22,21 +22,65 @@ type Node struct {
 	ID          NodeID    `json:"id"`           // 节点ID
 	Address     string    `json:"address"`      // IP:Port（服务端口）
 	ServicePort int       `json:"servicePort"`  // 服务端口（TCP传输）
+	Addrs       []string  `json:"addrs,omitempty"`         // 全部可用的服务地址（IP:Port），Address 为其中首选
+	DiscoveryAddr string  `json:"discoveryAddr,omitempty"` // 发现协议端点（UDP 广播/mDNS/DHT 地址），不可用于下载
 	LastSeen    time.Time `json:"lastSeen"`     // 上次心跳时间
 	Status      string    `json:"status"`       // online/offline
 }
 
+// ServiceAddrs 返回节点的全部服务地址，首选地址在前
+func (n Node) ServiceAddrs() []string {
+	out := make([]string, 0, len(n.Addrs)+1)
+	if n.Address != "" {
+		out = append(out, n.Address)
+	}
+	for _, a := range n.Addrs {
+		if a != n.Address {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// PeerInfo 已知节点的持久化记录，重启后用于快速重连
+type PeerInfo struct {
+	ID        NodeID    `json:"id"`        // 节点ID
+	Name      string    `json:"name"`      // 节点名称
+	Addrs     []string  `json:"addrs"`     // 服务地址（IP:Port），首个为最近一次可用的地址
+	LastSeen  time.Time `json:"lastSeen"`  // 上次发现或成功通信的时间
+	Successes int       `json:"successes"` // 成功通信次数
+	Failures  int       `json:"failures"`  // 失败通信次数
+}
+
+// SuccessRate 通信成功率，无记录时为 0.5（拉普拉斯平滑）
+func (p PeerInfo) SuccessRate() float64 {
+	return float64(p.Successes+1) / float64(p.Successes+p.Failures+2)
+}
+
+// Node 转换为节点，首个地址作为首选服务地址
+func (p PeerInfo) Node() Node {
+	n := Node{ID: p.ID, Addrs: p.Addrs, LastSeen: p.LastSeen, Status: "cached"}
+	if len(p.Addrs) > 0 {
+		n.Address = p.Addrs[0]
+	}
+	return n
+}
+
 // FileInfo 文件的元数据信息
 type FileInfo struct {
 	ID          FileID    `json:"id"`          // 文件唯一标识
 	Name        string    `json:"name"`        // 文件名
 	Path        string    `json:"path"`        // 本地文件路径
 	Size        int64     `json:"size"`        // 文件大小（字节）
-	Hash        string    `json:"hash"`        // SHA-256哈希值
+	Hash        string    `json:"hash"`        // 文件内容哈希值
+	HashAlgo    HashAlgo  `json:"hashAlgo"`    // 文件与分片哈希所用算法，空值表示 sha256
 	ChunkSize   int64     `json:"chunkSize"`   // 分片大小
 	ChunkCount  int       `json:"chunkCount"`  // 分片数量
 	CreatedAt   time.Time `json:"createdAt"`   // 创建时间
 	Description string    `json:"description"` // 文件描述
+	ModTime     time.Time `json:"modTime"`     // 建索引时的文件修改时间
+	Inode       uint64    `json:"inode"`       // 建索引时的 inode（不支持的平台为0）
+	Withdrawn   bool      `json:"withdrawn"`   // 文件已变更或缺失，不再对外提供
 }
 
 // ChunkInfo 分片信息
@@ -80,6 +124,7 @@ func GenerateFileID(filePath string, size int64) FileID {
 }
 
 // GenerateChunkID 生成分片ID
+// 分片ID仅作标识，固定使用SHA-256，与内容哈希算法无关，保证ID稳定
 func GenerateChunkID(fileID FileID, index int) ChunkID {
 	data := string(fileID) + ":" + strconv.Itoa(index)
 	hash := sha256.Sum256([]byte(data))
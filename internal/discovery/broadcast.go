@@ -0,0 +1,498 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// UDPFinder 基于UDP广播的局域网节点发现：IPv4 使用广播，IPv6 使用链路本地组播（见 broadcast6.go）
+// 简单有效，避免复杂依赖
+type UDPFinder struct {
+	// ServicePort 本节点传输服务端口，随公告与 who-has 应答发送，0 表示不提供下载（Start 前设置）
+	ServicePort int
+	// Addrs 额外宣告的服务地址（host:port），host 为空或未指定地址时由接收方替换为报文来源 IP（Start 前设置）
+	Addrs []string
+	// HasFile 判断本节点是否可提供 fileID，为空表示不应答 who-has 查询（Start 前设置）
+	HasFile func(core.FileID) bool
+	// TTL 节点多久未再宣告后视为离开，默认 30 秒（Start 前设置）
+	TTL time.Duration
+	// Watch 查询模式下同时监听发现端口，持续接收各节点的公告（如 list --watch）（Start 前设置）
+	Watch bool
+
+	port      int
+	name      string
+	selfID    string
+	queryOnly bool
+	peers     *peerTable
+	ctx       context.Context
+	cancel    context.CancelFunc
+	conn      *net.UDPConn   // IPv4
+	conn6     *net.UDPConn   // IPv6，主机不支持 IPv6 时为 nil
+	watching  []*net.UDPConn // Watch 模式下监听发现端口的 socket
+	stopCh    chan struct{}
+
+	addrMu sync.Mutex // 保护 Addrs（见 SetAddrs）
+
+	provMu    sync.Mutex
+	providers map[core.FileID][]*whoHasQuery // 进行中的 who-has 查询，同一文件的并发查询各自收集应答
+}
+
+// udpNodeTTL 节点默认的离开判定时间（公告间隔 2 秒）
+const udpNodeTTL = 30 * time.Second
+
+type BroadcastMsg struct {
+	Type        string      `json:"type"` // announce | query | whohas | has
+	NodeID      string      `json:"nodeId"`
+	Name        string      `json:"name"`
+	Address     string      `json:"address"`
+	Msg         string      `json:"msg"`
+	FileID      core.FileID `json:"fileId,omitempty"`      // whohas/has：查询的文件
+	ServicePort int         `json:"servicePort,omitempty"` // announce/has：传输服务端口
+	Addrs       []string    `json:"addrs,omitempty"`       // announce：额外的服务地址
+}
+
+func NewUDPFinder(name string, port int) *UDPFinder {
+	return &UDPFinder{
+		port:   port,
+		name:   name,
+		selfID: fmt.Sprintf("%s-%d", name, time.Now().UnixNano()),
+		TTL:    udpNodeTTL,
+		peers:  newPeerTable(),
+		stopCh: make(chan struct{}),
+
+		providers: make(map[core.FileID][]*whoHasQuery),
+	}
+}
+
+// NewUDPFinderQuery 创建仅用于一次性扫描的Finder，不占用固定端口
+func NewUDPFinderQuery(port int) *UDPFinder {
+	return &UDPFinder{
+		port:      port,
+		name:      "scanner",
+		selfID:    fmt.Sprintf("scanner-%d", time.Now().UnixNano()),
+		TTL:       udpNodeTTL,
+		peers:     newPeerTable(),
+		stopCh:    make(chan struct{}),
+		queryOnly: true,
+		providers: make(map[core.FileID][]*whoHasQuery),
+	}
+}
+
+// ID 返回本节点ID
+func (u *UDPFinder) ID() core.NodeID { return core.NodeID(u.selfID) }
+
+// SetID 使用指定的节点ID（如与传输服务、其他发现方式共用），需在 Start 之前调用
+func (u *UDPFinder) SetID(id core.NodeID) { u.selfID = string(id) }
+
+func (u *UDPFinder) Start(ctx context.Context) error {
+	u.ctx, u.cancel = context.WithCancel(ctx)
+	go u.peers.run(u.ctx)
+
+	if u.queryOnly {
+		// 仅扫描：使用随机端口接收回复，同时复用该 socket 发送查询（允许广播）
+		lc := net.ListenConfig{Control: broadcastControl}
+		pc, err := lc.ListenPacket(u.ctx, "udp4", ":0")
+		if err != nil {
+			return err
+		}
+		u.conn = pc.(*net.UDPConn)
+		u.conn6 = u.listen6()
+		u.startListeners()
+		if u.Watch {
+			u.startWatching()
+		}
+		go u.sendQuery()
+		return nil
+	}
+
+	// 服务模式：监听固定端口，启用端口复用
+	lc := net.ListenConfig{Control: reuseControl}
+	pc, err := lc.ListenPacket(u.ctx, "udp4", fmt.Sprintf(":%d", u.port))
+	if err != nil {
+		return err
+	}
+	u.conn = pc.(*net.UDPConn)
+	u.conn6 = u.listen6()
+
+	u.startListeners()
+	go u.sendBroadcast()
+	return nil
+}
+
+func (u *UDPFinder) Stop() error {
+	close(u.stopCh)
+	if u.conn != nil {
+		u.conn.Close()
+	}
+	if u.conn6 != nil {
+		u.conn6.Close()
+	}
+	for _, c := range u.watching {
+		c.Close()
+	}
+	if u.cancel != nil {
+		u.cancel()
+	}
+	return nil
+}
+
+func (u *UDPFinder) Nodes() []core.Node {
+	return u.peers.list()
+}
+
+// Subscribe 订阅节点事件：收到新节点的公告时为 PeerJoined，超过 TTL 未再宣告时为 PeerLost
+func (u *UDPFinder) Subscribe(buf int) (<-chan Event, func()) {
+	return u.peers.subscribe(buf)
+}
+
+// startWatching 以端口复用方式绑定发现端口，接收其他节点的周期公告；端口不可用时仅依赖查询应答
+func (u *UDPFinder) startWatching() {
+	lc := net.ListenConfig{Control: reuseControl}
+	if pc, err := lc.ListenPacket(u.ctx, "udp4", fmt.Sprintf(":%d", u.port)); err == nil {
+		u.watching = append(u.watching, pc.(*net.UDPConn))
+	}
+	if c := u.listenGroup6(); c != nil {
+		u.watching = append(u.watching, c)
+	}
+	for _, c := range u.watching {
+		go u.listenBroadcast(c)
+	}
+}
+
+func (u *UDPFinder) startListeners() {
+	go u.listenBroadcast(u.conn)
+	if u.conn6 != nil {
+		go u.listenBroadcast(u.conn6)
+	}
+}
+
+// listenBroadcast 处理 conn 上收到的报文，应答经同一 socket 单播回复
+func (u *UDPFinder) listenBroadcast(conn *net.UDPConn) {
+	buf := make([]byte, 2048)
+	for {
+		select {
+		case <-u.stopCh:
+			return
+		default:
+		}
+
+		conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			continue
+		}
+
+		var msg BroadcastMsg
+		if err := json.Unmarshal(buf[:n], &msg); err != nil {
+			continue
+		}
+
+		if msg.NodeID == u.selfID {
+			continue
+		}
+
+		// 询问谁持有某文件：仅持有者单播应答，附带传输服务端口
+		if msg.Type == "whohas" {
+			if !u.queryOnly && u.HasFile != nil && u.ServicePort > 0 && msg.FileID != "" && u.HasFile(msg.FileID) {
+				resp := BroadcastMsg{
+					Type:        "has",
+					NodeID:      u.selfID,
+					Name:        u.name,
+					FileID:      msg.FileID,
+					ServicePort: u.ServicePort,
+				}
+				data, _ := json.Marshal(resp)
+				conn.WriteToUDP(data, addr)
+			}
+			continue
+		}
+
+		if msg.Type == "has" {
+			if msg.FileID == "" || msg.ServicePort <= 0 || msg.ServicePort > 65535 {
+				continue
+			}
+			host := hostOf(addr)
+			node := core.Node{
+				ID:            core.NodeID(msg.NodeID),
+				Address:       net.JoinHostPort(host, strconv.Itoa(msg.ServicePort)),
+				ServicePort:   msg.ServicePort,
+				DiscoveryAddr: net.JoinHostPort(host, strconv.Itoa(u.port)),
+				LastSeen:      time.Now(),
+				Status:        "online",
+			}
+			node.Addrs = []string{node.Address}
+			u.provMu.Lock()
+			for _, q := range u.providers[msg.FileID] {
+				rec := mergeNode(q.nodes[node.ID], node)
+				q.nodes[nodeKey(rec)] = rec
+			}
+			u.provMu.Unlock()
+			continue
+		}
+
+		// 若收到查询且处于服务模式，单播回复公告
+		if msg.Type == "query" && !u.queryOnly {
+			data, _ := json.Marshal(u.announceMsg())
+			conn.WriteToUDP(data, addr)
+			continue
+		}
+
+		if msg.Type != "announce" {
+			continue
+		}
+
+		node := announcedNode(msg, hostOf(addr), u.port)
+		u.peers.put(node, u.TTL, mergeNode)
+	}
+}
+
+func (u *UDPFinder) interfaceBroadcastIPs() []net.IP {
+	ips := []net.IP{}
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return ips
+	}
+	for _, iface := range ifs {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok || ipNet.IP == nil || ipNet.IP.To4() == nil || ipNet.Mask == nil {
+				continue
+			}
+			ip := ipNet.IP.To4()
+			mask := ipNet.Mask
+			bcast := net.IPv4(ip[0]|^mask[0], ip[1]|^mask[1], ip[2]|^mask[2], ip[3]|^mask[3])
+			ips = append(ips, bcast)
+		}
+	}
+	return ips
+}
+
+func (u *UDPFinder) sendBroadcast() {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	// 单独的发送 socket，开启广播权限
+	lc := net.ListenConfig{Control: broadcastControl}
+	pc, err := lc.ListenPacket(u.ctx, "udp4", ":0")
+	if err != nil {
+		return
+	}
+	conn := pc.(*net.UDPConn)
+	defer conn.Close()
+
+	dests := u.destinations()
+	for {
+		select {
+		case <-u.stopCh:
+			return
+		case <-ticker.C:
+			data, _ := json.Marshal(u.announceMsg()) // 每次重新构造，SetAddrs 的更新随下一次公告发出
+			for _, d := range dests {
+				_ = conn.SetWriteDeadline(time.Now().Add(500 * time.Millisecond))
+				_, _ = conn.WriteToUDP(data, &d)
+			}
+			u.send6(data)
+		}
+	}
+}
+
+// SetAddrs 更新额外宣告的服务地址，下一次公告生效
+func (u *UDPFinder) SetAddrs(addrs []string) {
+	u.addrMu.Lock()
+	u.Addrs = slices.Clone(addrs)
+	u.addrMu.Unlock()
+}
+
+// announceMsg 构造本节点的公告：携带传输服务端口与额外宣告的地址
+func (u *UDPFinder) announceMsg() BroadcastMsg {
+	u.addrMu.Lock()
+	defer u.addrMu.Unlock()
+	return BroadcastMsg{
+		Type:        "announce",
+		NodeID:      u.selfID,
+		Name:        u.name,
+		Msg:         "RippleGo discovery",
+		ServicePort: u.ServicePort,
+		Addrs:       u.Addrs,
+	}
+}
+
+// announcedNode 由公告构造节点：服务地址取报文来源地址 host 与公告的服务端口，发现端点为来源地址与广播端口
+// 未携带服务端口的公告（旧版本或不提供下载的节点）只记录发现端点
+func announcedNode(msg BroadcastMsg, host string, discoveryPort int) core.Node {
+	node := core.Node{
+		ID:            core.NodeID(msg.NodeID),
+		DiscoveryAddr: net.JoinHostPort(host, strconv.Itoa(discoveryPort)),
+		LastSeen:      time.Now(),
+		Status:        "online",
+	}
+	if msg.ServicePort > 0 && msg.ServicePort <= 65535 {
+		node.ServicePort = msg.ServicePort
+		node.Address = net.JoinHostPort(host, strconv.Itoa(msg.ServicePort))
+		node.Addrs = append(node.Addrs, node.Address)
+	}
+	for _, a := range msg.Addrs {
+		h, port, err := net.SplitHostPort(a)
+		if err != nil {
+			continue
+		}
+		if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+			continue
+		}
+		// 公告的地址未指定主机（如 :9002、0.0.0.0:9002）时以报文来源地址代替
+		if ip := net.ParseIP(h); h == "" || ip != nil && ip.IsUnspecified() {
+			a = net.JoinHostPort(host, port)
+		}
+		if !slices.Contains(node.Addrs, a) {
+			node.Addrs = append(node.Addrs, a)
+		}
+	}
+	if node.Address == "" && len(node.Addrs) > 0 {
+		node.Address = node.Addrs[0]
+	}
+	return node
+}
+
+// mergeNode 合并同一节点的多次公告（如经多个 socket 收到）：保留已有首选地址与发现端点，合并全部服务地址
+// 节点ID是自报的，来源 IP 与已有记录不同的报告不并入该ID（否则任何主机都可为他人的ID添加地址），
+// 而是返回以其首选地址为键、不带ID的独立记录（见 nodeKey）
+func mergeNode(old, cur core.Node) core.Node {
+	if old.ID == "" {
+		return cur
+	}
+	if sourceHost(old) != sourceHost(cur) {
+		cur.ID = ""
+		return cur
+	}
+	if old.ServicePort != cur.ServicePort {
+		return cur
+	}
+	if old.Address != "" {
+		cur.Address = old.Address
+	}
+	if old.DiscoveryAddr != "" {
+		cur.DiscoveryAddr = old.DiscoveryAddr
+	}
+	addrs := append([]string(nil), old.Addrs...)
+	for _, a := range cur.Addrs {
+		if !slices.Contains(addrs, a) {
+			addrs = append(addrs, a)
+		}
+	}
+	cur.Addrs = addrs
+	return cur
+}
+
+// sourceHost 返回记录来源的主机：发现端点的主机，没有发现端点时为首选地址的主机
+func sourceHost(n core.Node) string {
+	addr := n.DiscoveryAddr
+	if addr == "" {
+		addr = n.Address
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	return host
+}
+
+// Providers 在局域网内广播 who-has 查询，收集持有 fileID 的节点（Address 为其传输服务地址）
+// 查询持续约 1.5 秒（或直到 ctx 结束），需在 Start 之后调用，通常用于查询模式的 Finder
+func (u *UDPFinder) Providers(ctx context.Context, fileID core.FileID) ([]core.Node, error) {
+	q := &whoHasQuery{nodes: make(map[core.NodeID]core.Node)}
+	u.provMu.Lock()
+	u.providers[fileID] = append(u.providers[fileID], q)
+	u.provMu.Unlock()
+	defer func() {
+		u.provMu.Lock()
+		defer u.provMu.Unlock()
+		qs := slices.DeleteFunc(u.providers[fileID], func(x *whoHasQuery) bool { return x == q })
+		if len(qs) == 0 {
+			delete(u.providers, fileID)
+		} else {
+			u.providers[fileID] = qs
+		}
+	}()
+
+	msg := BroadcastMsg{
+		Type:   "whohas",
+		NodeID: u.selfID,
+		Name:   u.name,
+		FileID: fileID,
+		Msg:    "RippleGo who-has query",
+	}
+	data, _ := json.Marshal(msg)
+	dests := u.destinations()
+	for i := 0; i < 3 && ctx.Err() == nil; i++ { // 重发几次提升成功率
+		for _, d := range dests {
+			_ = u.conn.SetWriteDeadline(time.Now().Add(300 * time.Millisecond))
+			_, _ = u.conn.WriteToUDP(data, &d)
+		}
+		u.send6(data)
+		select {
+		case <-ctx.Done():
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+
+	u.provMu.Lock()
+	out := make([]core.Node, 0, len(q.nodes))
+	for _, n := range q.nodes {
+		out = append(out, n)
+	}
+	u.provMu.Unlock()
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no LAN peer has %s", fileID)
+	}
+	return out, nil
+}
+
+// whoHasQuery 一次 Providers 调用收集的 has 应答（键见 nodeKey）
+type whoHasQuery struct {
+	nodes map[core.NodeID]core.Node
+}
+
+// destinations 返回广播目的地址：本机回环、受限广播与各网卡的子网广播
+func (u *UDPFinder) destinations() []net.UDPAddr {
+	dests := []net.UDPAddr{
+		{IP: net.ParseIP("127.0.0.1"), Port: u.port},
+		{IP: net.IPv4bcast, Port: u.port},
+	}
+	for _, ip := range u.interfaceBroadcastIPs() {
+		dests = append(dests, net.UDPAddr{IP: ip, Port: u.port})
+	}
+	return dests
+}
+
+func (u *UDPFinder) sendQuery() {
+	msg := BroadcastMsg{
+		Type:    "query",
+		NodeID:  u.selfID,
+		Name:    u.name,
+		Address: "",
+		Msg:     "RippleGo discovery query",
+	}
+
+	dests := u.destinations()
+	for i := 0; i < 3; i++ { // 重发几次提升成功率
+		data, _ := json.Marshal(msg)
+		for _, d := range dests {
+			_ = u.conn.SetWriteDeadline(time.Now().Add(300 * time.Millisecond))
+			_, _ = u.conn.WriteToUDP(data, &d)
+		}
+		u.send6(data)
+		time.Sleep(300 * time.Millisecond)
+	}
+}
@@ -0,0 +1,91 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/ipv6"
+)
+
+// udpGroup6 IPv6 节点发现使用的链路本地组播组，端口与 IPv4 广播端口相同
+var udpGroup6 = net.ParseIP("ff02::7270:6c67")
+
+// listen6 创建 IPv6 socket：服务模式绑定发现端口并在各网卡加入组播组，查询模式使用随机端口
+// 主机未启用 IPv6 时返回 nil，仅使用 IPv4 广播
+func (u *UDPFinder) listen6() *net.UDPConn {
+	if u.queryOnly {
+		pc, err := net.ListenPacket("udp6", "[::]:0")
+		if err != nil {
+			return nil
+		}
+		return pc.(*net.UDPConn)
+	}
+	return u.listenGroup6()
+}
+
+// listenGroup6 绑定发现端口（端口复用）并在各网卡加入组播组，失败时返回 nil
+func (u *UDPFinder) listenGroup6() *net.UDPConn {
+	lc := net.ListenConfig{Control: reuseControl}
+	pc, err := lc.ListenPacket(u.ctx, "udp6", fmt.Sprintf("[::]:%d", u.port))
+	if err != nil {
+		return nil
+	}
+	conn := pc.(*net.UDPConn)
+	p := ipv6.NewPacketConn(conn)
+	joined := 0
+	for _, iface := range multicastInterfaces6() {
+		if err := p.JoinGroup(&iface, &net.UDPAddr{IP: udpGroup6}); err == nil {
+			joined++
+		}
+	}
+	if joined == 0 {
+		conn.Close()
+		return nil
+	}
+	// 同一主机上的其他节点也需要收到本机发出的组播
+	_ = p.SetMulticastLoopback(true)
+	return conn
+}
+
+// send6 经各网卡向 IPv6 组播组发送报文
+func (u *UDPFinder) send6(data []byte) {
+	if u.conn6 == nil {
+		return
+	}
+	for _, iface := range multicastInterfaces6() {
+		dst := &net.UDPAddr{IP: udpGroup6, Port: u.port, Zone: iface.Name}
+		_ = u.conn6.SetWriteDeadline(time.Now().Add(300 * time.Millisecond))
+		_, _ = u.conn6.WriteToUDP(data, dst)
+	}
+}
+
+// multicastInterfaces6 返回已启用、支持组播且配置了 IPv6 地址的网卡
+func multicastInterfaces6() []net.Interface {
+	var out []net.Interface
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return out
+	}
+	for _, iface := range ifs {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.To4() == nil && ipNet.IP.To16() != nil {
+				out = append(out, iface)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// hostOf 返回报文来源的主机部分；IPv6 链路本地地址保留网卡（zone），如 fe80::1%eth0
+func hostOf(addr *net.UDPAddr) string {
+	if addr.Zone != "" && addr.IP.To4() == nil {
+		return addr.IP.String() + "%" + addr.Zone
+	}
+	return addr.IP.String()
+}
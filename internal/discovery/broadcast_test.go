@@ -0,0 +1,79 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+func TestConcurrentWhoHasQueriesCollectSeparately(t *testing.T) {
+	u := NewUDPFinderQuery(0)
+	if err := u.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer u.Stop()
+	queries := func() int {
+		u.provMu.Lock()
+		defer u.provMu.Unlock()
+		return len(u.providers["f1"])
+	}
+	type result struct {
+		nodes []core.Node
+		err   error
+	}
+	query := func(fileID core.FileID) <-chan result {
+		ch := make(chan result, 1)
+		go func() {
+			nodes, err := u.Providers(context.Background(), fileID)
+			ch <- result{nodes, err}
+		}()
+		return ch
+	}
+
+	// 第二个同文件查询晚于第一个开始：第一个结束时不能清除第二个的收集器
+	first := query("f1")
+	waitFor(t, func() bool { return queries() == 1 })
+	time.Sleep(500 * time.Millisecond)
+	second := query("f1")
+	other := query("f2")
+	waitFor(t, func() bool { return queries() == 2 })
+	if r := <-first; r.err == nil {
+		t.Fatalf("first query found %+v before any answer", r.nodes)
+	}
+
+	pc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	data, _ := json.Marshal(BroadcastMsg{Type: "has", NodeID: "holder", FileID: "f1", ServicePort: 9001})
+	dst := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: u.conn.LocalAddr().(*net.UDPAddr).Port}
+	if _, err := pc.WriteToUDP(data, dst); err != nil {
+		t.Fatal(err)
+	}
+	r := <-second
+	if r.err != nil || len(r.nodes) != 1 || r.nodes[0].ID != "holder" || r.nodes[0].Address != "127.0.0.1:9001" {
+		t.Fatalf("second query = %+v, %v", r.nodes, r.err)
+	}
+	if r := <-other; r.err == nil {
+		t.Errorf("query for another file got %+v", r.nodes)
+	}
+	if n := queries(); n != 0 {
+		t.Errorf("%d collectors left after the queries ended", n)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
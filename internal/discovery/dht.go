@@ -0,0 +1,664 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+const (
+	dhtRPCTimeout       = 2 * time.Second
+	dhtRefreshInterval  = 10 * time.Minute // 刷新路由表并重新宣告文件
+	dhtProviderTTL      = 30 * time.Minute
+	dhtMaxProviders     = 64    // 单个文件保存/返回的提供者上限
+	dhtMaxProvidedFiles = 10000 // 保存提供者记录的文件数上限
+	dhtMaxAddrs         = 8     // 每个提供者的服务地址数上限
+)
+
+// DHT 消息类型
+const (
+	dhtPing         = "ping"
+	dhtFindNode     = "find_node"
+	dhtAnnounce     = "announce"
+	dhtGetProviders = "get_providers"
+	dhtResponse     = "r"
+	dhtError        = "e"
+)
+
+// dhtMsg DHT RPC 消息，JSON 编码后以单个 UDP 报文传输，请求与响应通过 tx 关联
+type dhtMsg struct {
+	Type      string       `json:"t"`
+	Tx        string       `json:"tx"`
+	ID        core.NodeID  `json:"id"`              // 发送方节点ID
+	Port      int          `json:"port,omitempty"`  // 发送方传输服务端口
+	Addrs     []string     `json:"addrs,omitempty"` // announce：额外的传输服务地址（如端口映射的外部地址），主机为空或未指定时取报文来源 IP
+	RO        bool         `json:"ro,omitempty"`    // 只读节点（仅查询），不加入对方路由表
+	Target    string       `json:"target,omitempty"`
+	FileID    core.FileID  `json:"fileId,omitempty"`
+	Nodes     []dhtNodeMsg `json:"nodes,omitempty"`
+	Providers []dhtNodeMsg `json:"providers,omitempty"`
+	Err       string       `json:"err,omitempty"`
+}
+
+// dhtNodeMsg 节点信息：在 nodes 中 Addr 为 DHT 地址，在 providers 中 Addr 为首选传输服务地址、Addrs 为其余的服务地址
+type dhtNodeMsg struct {
+	ID    core.NodeID `json:"id"`
+	Addr  string      `json:"addr"`
+	Port  int         `json:"port,omitempty"`
+	Addrs []string    `json:"addrs,omitempty"`
+}
+
+type dhtProviderRecord struct {
+	addrs   []string // 为空表示本节点
+	expires time.Time
+}
+
+// dhtTableFile 路由表持久化格式，同时保存本节点ID以保持其在键空间中的位置
+type dhtTableFile struct {
+	Self     core.NodeID  `json:"self"`
+	Contacts []dhtContact `json:"contacts"`
+}
+
+// DHTFinder 基于 Kademlia 的节点与内容发现，适用于广播无法覆盖的跨网段/互联网场景
+// RPC：PING、FIND_NODE、ANNOUNCE（登记文件提供者）、GET_PROVIDERS，JSON 编码承载于 UDP
+// NodeID 与 FileID 经 SHA-256 映射到同一 256 位键空间，按 XOR 距离路由
+type DHTFinder struct {
+	// Bootstrap 启动时联系的已知节点 DHT 地址（host:port）（Start 前设置）
+	Bootstrap []string
+	// TablePath 路由表持久化文件，为空表示不持久化（Start 前设置）
+	TablePath string
+	// Files 返回本节点提供的文件，启动后及每次刷新时向 DHT 宣告；为空表示不宣告（Start 前设置）
+	Files func() []core.FileID
+
+	name        string
+	selfID      core.NodeID
+	port        int
+	servicePort int
+	readOnly    bool
+	addrMu      sync.Mutex
+	addrs       []string // 额外宣告的服务地址（见 SetAddrs）
+	table       *routingTable
+	conn        *net.UDPConn
+	txSeq       atomic.Uint64
+
+	pendMu  sync.Mutex
+	pending map[string]chan dhtMsg
+
+	provMu    sync.Mutex
+	providers map[core.FileID]map[core.NodeID]dhtProviderRecord
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	ready    chan struct{} // 引导完成后关闭
+}
+
+// NewDHTFinder 创建 DHT 节点：port 为 DHT UDP 端口（0 表示随机），servicePort 为 TCP 传输服务端口
+func NewDHTFinder(name string, port, servicePort int) *DHTFinder {
+	return &DHTFinder{
+		name:        name,
+		selfID:      core.NodeID(fmt.Sprintf("%s-%d", name, time.Now().UnixNano())),
+		port:        port,
+		servicePort: servicePort,
+		pending:     make(map[string]chan dhtMsg),
+		providers:   make(map[core.FileID]map[core.NodeID]dhtProviderRecord),
+		stopCh:      make(chan struct{}),
+		ready:       make(chan struct{}),
+	}
+}
+
+// NewDHTFinderQuery 创建仅用于查询的 DHT 节点（随机端口，不加入他人路由表，不提供服务）
+// 设置 TablePath 时只读取已持久化的路由表用于引导，不会写回
+func NewDHTFinderQuery() *DHTFinder {
+	d := NewDHTFinder("scanner", 0, 0)
+	d.readOnly = true
+	return d
+}
+
+// ID 返回本节点ID（Start 后可能替换为持久化的ID）
+func (d *DHTFinder) ID() core.NodeID { return d.selfID }
+
+// Addr 返回 DHT 监听地址，Start 之前为 nil
+func (d *DHTFinder) Addr() *net.UDPAddr {
+	if d.conn == nil {
+		return nil
+	}
+	return d.conn.LocalAddr().(*net.UDPAddr)
+}
+
+func (d *DHTFinder) Start(ctx context.Context) error {
+	d.ctx, d.cancel = context.WithCancel(ctx)
+
+	saved := d.loadTable()
+	if saved.Self != "" && !d.readOnly {
+		d.selfID = saved.Self
+	}
+	d.table = newRoutingTable(keyOf(string(d.selfID)))
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: d.port})
+	if err != nil {
+		return err
+	}
+	d.conn = conn
+
+	go d.listen()
+	go d.maintain(saved.Contacts)
+	return nil
+}
+
+// Stop 停止收发并保存路由表，可重复调用
+func (d *DHTFinder) Stop() error {
+	d.stopOnce.Do(func() {
+		close(d.stopCh)
+		if d.cancel != nil {
+			d.cancel()
+		}
+		if d.conn != nil {
+			d.conn.Close()
+		}
+		d.saveTable()
+	})
+	return nil
+}
+
+// Nodes 返回路由表中提供传输服务的节点
+func (d *DHTFinder) Nodes() []core.Node {
+	if d.table == nil {
+		return nil
+	}
+	var out []core.Node
+	for _, c := range d.table.all() {
+		if c.ServicePort == 0 {
+			continue
+		}
+		host, _, err := net.SplitHostPort(c.Addr)
+		if err != nil {
+			continue
+		}
+		out = append(out, core.Node{
+			ID:            c.ID,
+			Address:       net.JoinHostPort(host, strconv.Itoa(c.ServicePort)),
+			ServicePort:   c.ServicePort,
+			DiscoveryAddr: c.Addr,
+			LastSeen:      c.LastSeen,
+			Status:        "online",
+		})
+	}
+	return out
+}
+
+// Announce 将本节点登记为 fileID 的提供者：写入距离该文件最近的 K 个节点
+func (d *DHTFinder) Announce(ctx context.Context, fileID core.FileID) error {
+	if d.servicePort == 0 {
+		return errors.New("dht: no service port to announce")
+	}
+	if err := d.waitReady(ctx); err != nil {
+		return err
+	}
+	return d.announce(ctx, fileID)
+}
+
+// SetAddrs 更新额外宣告的服务地址（如端口映射的外部地址），下一次宣告生效
+func (d *DHTFinder) SetAddrs(addrs []string) {
+	d.addrMu.Lock()
+	d.addrs = slices.Clone(addrs)
+	d.addrMu.Unlock()
+}
+
+func (d *DHTFinder) extraAddrs() []string {
+	d.addrMu.Lock()
+	defer d.addrMu.Unlock()
+	return slices.Clone(d.addrs)
+}
+
+func (d *DHTFinder) announce(ctx context.Context, fileID core.FileID) error {
+	d.storeProvider(fileID, d.selfID, nil)
+	addrs := d.extraAddrs()
+	closest, _ := d.lookup(ctx, keyOf(string(fileID)), dhtFindNode, "")
+	if len(closest) == 0 {
+		return errors.New("dht: no nodes to announce to")
+	}
+	var wg sync.WaitGroup
+	var ok atomic.Int32
+	for _, c := range closest {
+		wg.Add(1)
+		go func(c dhtContact) {
+			defer wg.Done()
+			if _, err := d.call(ctx, c, dhtMsg{Type: dhtAnnounce, FileID: fileID, Addrs: addrs}); err == nil {
+				ok.Add(1)
+			}
+		}(c)
+	}
+	wg.Wait()
+	if ok.Load() == 0 {
+		return errors.New("dht: announce failed on all nodes")
+	}
+	return nil
+}
+
+// Providers 查找持有 fileID 的节点，返回节点的 Address 为其传输服务地址
+func (d *DHTFinder) Providers(ctx context.Context, fileID core.FileID) ([]core.Node, error) {
+	if err := d.waitReady(ctx); err != nil {
+		return nil, err
+	}
+	_, provs := d.lookup(ctx, keyOf(string(fileID)), dhtGetProviders, fileID)
+	for _, p := range d.localProviders(fileID) {
+		if p.ID != d.selfID {
+			provs = append(provs, p)
+		}
+	}
+	seen := map[core.NodeID]bool{}
+	var out []core.Node
+	for _, p := range provs {
+		if seen[p.ID] || p.Addr == "" {
+			continue
+		}
+		seen[p.ID] = true
+		_, port, _ := net.SplitHostPort(p.Addr)
+		sp, _ := strconv.Atoi(port)
+		n := core.Node{ID: p.ID, Address: p.Addr, ServicePort: sp, LastSeen: time.Now(), Status: "online"}
+		if len(p.Addrs) > 0 {
+			n.Addrs = append([]string{p.Addr}, p.Addrs...)
+		}
+		out = append(out, n)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("dht: no providers for %s", fileID)
+	}
+	return out, nil
+}
+
+// maintain 引导、周期刷新路由表并宣告本节点提供的文件
+func (d *DHTFinder) maintain(saved []dhtContact) {
+	d.bootstrap(saved)
+	close(d.ready)
+	ticker := time.NewTicker(dhtRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stopCh:
+			return
+		case <-ticker.C:
+			d.refresh()
+		}
+	}
+}
+
+// waitReady 等待引导完成，避免启动后立即查询时路由表为空
+func (d *DHTFinder) waitReady(ctx context.Context) error {
+	select {
+	case <-d.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// bootstrap 并发 ping 配置的引导节点与持久化的节点，响应者自动加入路由表，随后查找自身以填充路由表
+func (d *DHTFinder) bootstrap(saved []dhtContact) {
+	addrs := append([]string(nil), d.Bootstrap...)
+	for _, c := range saved {
+		addrs = append(addrs, c.Addr)
+	}
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			_, _ = d.callAddr(d.ctx, addr, dhtMsg{Type: dhtPing})
+		}(addr)
+	}
+	wg.Wait()
+	if d.table.size() > 0 {
+		d.refresh()
+	}
+}
+
+func (d *DHTFinder) refresh() {
+	ctx, cancel := context.WithTimeout(d.ctx, time.Minute)
+	defer cancel()
+	d.lookup(ctx, d.table.self, dhtFindNode, "")
+	if d.Files != nil && !d.readOnly {
+		for _, id := range d.Files() {
+			_ = d.announce(ctx, id)
+		}
+	}
+	d.saveTable()
+}
+
+// lookup Kademlia 迭代查找：每轮并发询问 alpha 个尚未询问的最近节点，直到最近的 K 个节点都已询问
+// typ 为 get_providers 时同时收集提供者
+func (d *DHTFinder) lookup(ctx context.Context, target dhtKey, typ string, fileID core.FileID) ([]dhtContact, []dhtNodeMsg) {
+	// 以整个路由表为初始候选，保证前 K 个节点失效时仍能继续向外查找
+	shortlist := d.table.all()
+	sortByDistance(shortlist, target)
+	seen := map[core.NodeID]bool{d.selfID: true}
+	for _, c := range shortlist {
+		seen[c.ID] = true
+	}
+	queried := map[core.NodeID]bool{}
+	failed := map[core.NodeID]bool{}
+	var providers []dhtNodeMsg
+	var mu sync.Mutex
+
+	for ctx.Err() == nil {
+		var batch []dhtContact
+		n := 0
+		for _, c := range shortlist {
+			if failed[c.ID] {
+				continue
+			}
+			if n++; n > dhtK {
+				break
+			}
+			if !queried[c.ID] && len(batch) < dhtAlpha {
+				batch = append(batch, c)
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		var wg sync.WaitGroup
+		for _, c := range batch {
+			queried[c.ID] = true
+			wg.Add(1)
+			go func(c dhtContact) {
+				defer wg.Done()
+				req := dhtMsg{Type: typ, Target: target.String(), FileID: fileID}
+				resp, err := d.call(ctx, c, req)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					failed[c.ID] = true
+					return
+				}
+				for _, p := range resp.Providers {
+					if p.Addr == "" && p.ID == resp.ID && p.Port > 0 {
+						host, _, _ := net.SplitHostPort(c.Addr)
+						addrs := providerAddrs(host, p.Port, p.Addrs)
+						p.Addr, p.Addrs = addrs[0], addrs[1:]
+					}
+					providers = append(providers, p)
+				}
+				for _, nm := range resp.Nodes {
+					if seen[nm.ID] || nm.Addr == "" {
+						continue
+					}
+					seen[nm.ID] = true
+					shortlist = append(shortlist, dhtContact{ID: nm.ID, Addr: nm.Addr, ServicePort: nm.Port, key: keyOf(string(nm.ID))})
+				}
+			}(c)
+		}
+		wg.Wait()
+		sortByDistance(shortlist, target)
+	}
+
+	var out []dhtContact
+	for _, c := range shortlist {
+		if queried[c.ID] && !failed[c.ID] {
+			out = append(out, c)
+			if len(out) == dhtK {
+				break
+			}
+		}
+	}
+	return out, providers
+}
+
+// call 向路由表中的节点发送请求，失败时将其移出路由表
+func (d *DHTFinder) call(ctx context.Context, c dhtContact, req dhtMsg) (dhtMsg, error) {
+	resp, err := d.callAddr(ctx, c.Addr, req)
+	if err != nil {
+		d.table.remove(c.ID)
+	}
+	return resp, err
+}
+
+func (d *DHTFinder) callAddr(ctx context.Context, addr string, req dhtMsg) (dhtMsg, error) {
+	ua, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return dhtMsg{}, err
+	}
+	req.Tx = strconv.FormatUint(d.txSeq.Add(1), 36)
+	ch := make(chan dhtMsg, 1)
+	d.pendMu.Lock()
+	d.pending[req.Tx] = ch
+	d.pendMu.Unlock()
+	defer func() {
+		d.pendMu.Lock()
+		delete(d.pending, req.Tx)
+		d.pendMu.Unlock()
+	}()
+
+	if err := d.send(req, ua); err != nil {
+		return dhtMsg{}, err
+	}
+	timer := time.NewTimer(dhtRPCTimeout)
+	defer timer.Stop()
+	select {
+	case resp := <-ch:
+		if resp.Type == dhtError {
+			return resp, fmt.Errorf("dht: %s", resp.Err)
+		}
+		return resp, nil
+	case <-timer.C:
+		return dhtMsg{}, fmt.Errorf("dht: %s timeout", req.Type)
+	case <-ctx.Done():
+		return dhtMsg{}, ctx.Err()
+	}
+}
+
+func (d *DHTFinder) send(m dhtMsg, to *net.UDPAddr) error {
+	m.ID = d.selfID
+	m.Port = d.servicePort
+	m.RO = d.readOnly
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_ = d.conn.SetWriteDeadline(time.Now().Add(500 * time.Millisecond))
+	_, err = d.conn.WriteToUDP(data, to)
+	return err
+}
+
+func (d *DHTFinder) listen() {
+	buf := make([]byte, 65536)
+	for {
+		select {
+		case <-d.stopCh:
+			return
+		default:
+		}
+
+		d.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+		n, addr, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			continue
+		}
+		var msg dhtMsg
+		if err := json.Unmarshal(buf[:n], &msg); err != nil || msg.ID == "" || msg.ID == d.selfID {
+			continue
+		}
+
+		if !msg.RO {
+			c := dhtContact{ID: msg.ID, Addr: addr.String(), ServicePort: msg.Port, LastSeen: time.Now()}
+			if oldest, stale := d.table.update(c); stale {
+				go d.evict(oldest, c)
+			}
+		}
+
+		switch msg.Type {
+		case dhtResponse, dhtError:
+			d.pendMu.Lock()
+			ch := d.pending[msg.Tx]
+			d.pendMu.Unlock()
+			if ch != nil {
+				select {
+				case ch <- msg:
+				default:
+				}
+			}
+		default:
+			d.handleRequest(msg, addr)
+		}
+	}
+}
+
+// evict ping 桶中最久未联系的节点 old，仅当其不应答时以新节点 c 替换
+func (d *DHTFinder) evict(old, c dhtContact) {
+	_, err := d.callAddr(d.ctx, old.Addr, dhtMsg{Type: dhtPing})
+	// 停止期间的失败不代表 old 离线
+	d.table.evictionDone(old, c, err == nil || d.ctx.Err() != nil)
+}
+
+func (d *DHTFinder) handleRequest(req dhtMsg, from *net.UDPAddr) {
+	resp := dhtMsg{Type: dhtResponse, Tx: req.Tx}
+	switch req.Type {
+	case dhtPing:
+	case dhtFindNode:
+		target, ok := parseKey(req.Target)
+		if !ok {
+			resp = dhtMsg{Type: dhtError, Tx: req.Tx, Err: "invalid target"}
+			break
+		}
+		resp.Nodes = d.closestMsgs(target, req.ID)
+	case dhtAnnounce:
+		if req.FileID == "" || req.Port <= 0 || req.RO {
+			resp = dhtMsg{Type: dhtError, Tx: req.Tx, Err: "invalid announce"}
+			break
+		}
+		// 提供者地址取报文来源IP，避免节点替他人登记
+		if !d.storeProvider(req.FileID, req.ID, providerAddrs(from.IP.String(), req.Port, req.Addrs)) {
+			resp = dhtMsg{Type: dhtError, Tx: req.Tx, Err: "provider storage full"}
+		}
+	case dhtGetProviders:
+		if req.FileID == "" {
+			resp = dhtMsg{Type: dhtError, Tx: req.Tx, Err: "invalid file id"}
+			break
+		}
+		resp.Providers = d.localProviders(req.FileID)
+		resp.Nodes = d.closestMsgs(keyOf(string(req.FileID)), req.ID)
+	default:
+		resp = dhtMsg{Type: dhtError, Tx: req.Tx, Err: "unknown request"}
+	}
+	_ = d.send(resp, from)
+}
+
+func (d *DHTFinder) closestMsgs(target dhtKey, exclude core.NodeID) []dhtNodeMsg {
+	var out []dhtNodeMsg
+	for _, c := range d.table.closest(target, dhtK+1) {
+		if c.ID == exclude || len(out) == dhtK {
+			continue
+		}
+		out = append(out, dhtNodeMsg{ID: c.ID, Addr: c.Addr, Port: c.ServicePort})
+	}
+	return out
+}
+
+// providerAddrs 构造提供者的服务地址：报文来源 IP + port 在前，其后为校验过的额外地址
+func providerAddrs(host string, port int, extra []string) []string {
+	out := []string{net.JoinHostPort(host, strconv.Itoa(port))}
+	for _, a := range extra {
+		h, p, err := net.SplitHostPort(a)
+		if err != nil || len(out) >= dhtMaxAddrs {
+			continue
+		}
+		if n, err := strconv.Atoi(p); err != nil || n <= 0 || n > 65535 {
+			continue
+		}
+		if ip := net.ParseIP(h); h == "" || ip != nil && ip.IsUnspecified() {
+			a = net.JoinHostPort(host, p)
+		}
+		if !slices.Contains(out, a) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// storeProvider 保存提供者记录；addrs 为空表示本节点
+func (d *DHTFinder) storeProvider(fileID core.FileID, id core.NodeID, addrs []string) bool {
+	d.provMu.Lock()
+	defer d.provMu.Unlock()
+	m := d.providers[fileID]
+	if m == nil {
+		if len(d.providers) >= dhtMaxProvidedFiles {
+			return false
+		}
+		m = make(map[core.NodeID]dhtProviderRecord)
+		d.providers[fileID] = m
+	}
+	if _, ok := m[id]; !ok && len(m) >= dhtMaxProviders {
+		return false
+	}
+	m[id] = dhtProviderRecord{addrs: addrs, expires: time.Now().Add(dhtProviderTTL)}
+	return true
+}
+
+func (d *DHTFinder) localProviders(fileID core.FileID) []dhtNodeMsg {
+	d.provMu.Lock()
+	defer d.provMu.Unlock()
+	m := d.providers[fileID]
+	now := time.Now()
+	var out []dhtNodeMsg
+	for id, rec := range m {
+		if now.After(rec.expires) {
+			delete(m, id)
+			continue
+		}
+		if len(rec.addrs) == 0 {
+			// 本节点不知道自己对外可见的IP，只返回端口与额外地址，由查询方按报文来源补全
+			out = append(out, dhtNodeMsg{ID: id, Port: d.servicePort, Addrs: d.extraAddrs()})
+			continue
+		}
+		out = append(out, dhtNodeMsg{ID: id, Addr: rec.addrs[0], Addrs: rec.addrs[1:]})
+	}
+	if len(m) == 0 {
+		delete(d.providers, fileID)
+	}
+	return out
+}
+
+func (d *DHTFinder) loadTable() dhtTableFile {
+	var f dhtTableFile
+	if d.TablePath == "" {
+		return f
+	}
+	data, err := os.ReadFile(d.TablePath)
+	if err != nil {
+		return f
+	}
+	_ = json.Unmarshal(data, &f)
+	return f
+}
+
+func (d *DHTFinder) saveTable() {
+	if d.TablePath == "" || d.table == nil || d.readOnly {
+		return
+	}
+	f := dhtTableFile{Self: d.selfID, Contacts: d.table.all()}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(d.TablePath), 0755); err != nil {
+		return
+	}
+	tmp := d.TablePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err == nil {
+		_ = os.Rename(tmp, d.TablePath)
+	}
+}
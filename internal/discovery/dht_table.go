@@ -0,0 +1,196 @@
+package discovery
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math/bits"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+const (
+	dhtK        = 8 // 每个桶的容量，也是查找返回的最近节点数
+	dhtAlpha    = 3 // 迭代查找的并发度
+	dhtKeyBits  = 256
+	dhtStaleAge = 15 * time.Minute // 桶满时，超过该时间未联系的节点经 ping 确认不在线后被替换
+)
+
+// dhtKey DHT 键空间中的位置：NodeID 与 FileID 均取 SHA-256 映射到同一 256 位空间
+type dhtKey [32]byte
+
+func keyOf(s string) dhtKey { return sha256.Sum256([]byte(s)) }
+
+func (k dhtKey) String() string { return hex.EncodeToString(k[:]) }
+
+func parseKey(s string) (dhtKey, bool) {
+	var k dhtKey
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(k) {
+		return k, false
+	}
+	copy(k[:], b)
+	return k, true
+}
+
+func (k dhtKey) xor(o dhtKey) dhtKey {
+	var d dhtKey
+	for i := range k {
+		d[i] = k[i] ^ o[i]
+	}
+	return d
+}
+
+// less 比较两个距离的大小
+func (k dhtKey) less(o dhtKey) bool {
+	for i := range k {
+		if k[i] != o[i] {
+			return k[i] < o[i]
+		}
+	}
+	return false
+}
+
+// bucketIndex 返回与 self 的公共前缀长度，即所属桶序号；相同键返回 -1
+func bucketIndex(self, k dhtKey) int {
+	d := self.xor(k)
+	for i, b := range d {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return -1
+}
+
+// dhtContact 路由表中的节点
+type dhtContact struct {
+	ID          core.NodeID `json:"id"`
+	Addr        string      `json:"addr"`        // DHT UDP 地址
+	ServicePort int         `json:"servicePort"` // 传输服务端口，0 表示不提供下载
+	LastSeen    time.Time   `json:"lastSeen"`
+	key         dhtKey
+}
+
+// routingTable Kademlia k-桶路由表
+type routingTable struct {
+	self    dhtKey
+	mu      sync.RWMutex
+	buckets [dhtKeyBits][]dhtContact // 每个桶按最近联系时间升序排列
+	pinging [dhtKeyBits]bool         // 正在确认桶中最久未联系的节点是否在线
+}
+
+func newRoutingTable(self dhtKey) *routingTable {
+	return &routingTable{self: self}
+}
+
+// update 记录一次成功联系；桶满时丢弃新节点（偏好长期在线节点），
+// 但最久未联系的节点已过期时返回它（stale 为 true），由调用方 ping 确认后调用 evictionDone
+// 同一个桶同时只确认一个节点，确认期间到来的其他新节点被丢弃
+func (t *routingTable) update(c dhtContact) (oldest dhtContact, stale bool) {
+	c.key = keyOf(string(c.ID))
+	idx := bucketIndex(t.self, c.key)
+	if idx < 0 {
+		return dhtContact{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.buckets[idx]
+	for i := range b {
+		if b[i].ID == c.ID {
+			b = append(b[:i], b[i+1:]...)
+			t.buckets[idx] = append(b, c)
+			return dhtContact{}, false
+		}
+	}
+	if len(b) < dhtK {
+		t.buckets[idx] = append(b, c)
+		return dhtContact{}, false
+	}
+	if t.pinging[idx] || time.Since(b[0].LastSeen) <= dhtStaleAge {
+		return dhtContact{}, false
+	}
+	t.pinging[idx] = true
+	return b[0], true
+}
+
+// evictionDone 结束对 old 的确认：old 应答了 ping 时保留它（应答已将其移到桶尾）并丢弃 c，
+// 否则移除 old 并加入 c
+func (t *routingTable) evictionDone(old, c dhtContact, alive bool) {
+	idx := bucketIndex(t.self, old.key)
+	if idx < 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pinging[idx] = false
+	if alive {
+		return
+	}
+	b := t.buckets[idx]
+	for i := range b {
+		if b[i].ID == old.ID {
+			b = append(b[:i], b[i+1:]...)
+			break
+		}
+	}
+	if len(b) < dhtK && !slices.ContainsFunc(b, func(x dhtContact) bool { return x.ID == c.ID }) {
+		c.key = keyOf(string(c.ID))
+		b = append(b, c)
+	}
+	t.buckets[idx] = b
+}
+
+func (t *routingTable) remove(id core.NodeID) {
+	idx := bucketIndex(t.self, keyOf(string(id)))
+	if idx < 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.buckets[idx]
+	for i := range b {
+		if b[i].ID == id {
+			t.buckets[idx] = append(b[:i], b[i+1:]...)
+			return
+		}
+	}
+}
+
+// closest 返回距离 target 最近的 n 个节点
+func (t *routingTable) closest(target dhtKey, n int) []dhtContact {
+	all := t.all()
+	sortByDistance(all, target)
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+func (t *routingTable) all() []dhtContact {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var out []dhtContact
+	for _, b := range t.buckets {
+		out = append(out, b...)
+	}
+	return out
+}
+
+func (t *routingTable) size() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	n := 0
+	for _, b := range t.buckets {
+		n += len(b)
+	}
+	return n
+}
+
+func sortByDistance(cs []dhtContact, target dhtKey) {
+	sort.Slice(cs, func(i, j int) bool {
+		return cs[i].key.xor(target).less(cs[j].key.xor(target))
+	})
+}
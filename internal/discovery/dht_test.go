@@ -0,0 +1,244 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// startDHTNetwork 在 127.0.0.1 上启动 n 个 DHT 节点，第 0 个之外的节点都以第 0 个为引导节点
+func startDHTNetwork(t *testing.T, ctx context.Context, n int) []*DHTFinder {
+	t.Helper()
+	nodes := make([]*DHTFinder, 0, n)
+	var boot string
+	for i := 0; i < n; i++ {
+		d := NewDHTFinder(fmt.Sprintf("dht%02d", i), 0, 10000+i)
+		if boot != "" {
+			d.Bootstrap = []string{boot}
+		}
+		if err := d.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { d.Stop() })
+		wctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := d.waitReady(wctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("node %d bootstrap: %v", i, err)
+		}
+		if boot == "" {
+			boot = net.JoinHostPort("127.0.0.1", strconv.Itoa(d.Addr().Port))
+		}
+		nodes = append(nodes, d)
+	}
+	return nodes
+}
+
+// closestIDs 返回除 self 之外距离 target 最近的 k 个节点ID
+func closestIDs(nodes []*DHTFinder, self core.NodeID, target dhtKey, k int) []core.NodeID {
+	var cs []dhtContact
+	for _, d := range nodes {
+		if d.ID() != self {
+			cs = append(cs, dhtContact{ID: d.ID(), key: keyOf(string(d.ID()))})
+		}
+	}
+	sortByDistance(cs, target)
+	var out []core.NodeID
+	for _, c := range cs[:min(k, len(cs))] {
+		out = append(out, c.ID)
+	}
+	return out
+}
+
+func TestDHTFindNodeConverges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := startDHTNetwork(t, ctx, 20)
+
+	for _, target := range []dhtKey{keyOf("target-a"), keyOf("target-b"), keyOf(string(nodes[7].ID()))} {
+		for i, d := range nodes {
+			lctx, lcancel := context.WithTimeout(ctx, 10*time.Second)
+			got, _ := d.lookup(lctx, target, dhtFindNode, "")
+			lcancel()
+			want := closestIDs(nodes, d.ID(), target, dhtK)
+			if len(got) != len(want) {
+				t.Fatalf("node %d lookup %s: got %d contacts, want %d", i, target, len(got), len(want))
+			}
+			for j := range want {
+				if got[j].ID != want[j] {
+					t.Fatalf("node %d lookup %s: contact %d = %s, want %s", i, target, j, got[j].ID, want[j])
+				}
+			}
+		}
+	}
+}
+
+func TestDHTAnnounceProviders(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := startDHTNetwork(t, ctx, 12)
+
+	const fileID = core.FileID("file-under-test")
+	actx, acancel := context.WithTimeout(ctx, 10*time.Second)
+	defer acancel()
+	for _, i := range []int{3, 9} {
+		if err := nodes[i].Announce(actx, fileID); err != nil {
+			t.Fatalf("announce from node %d: %v", i, err)
+		}
+	}
+
+	for i, d := range nodes {
+		provs, err := d.Providers(actx, fileID)
+		if err != nil {
+			t.Fatalf("node %d providers: %v", i, err)
+		}
+		got := map[core.NodeID]string{}
+		for _, p := range provs {
+			got[p.ID] = p.Address
+		}
+		for _, j := range []int{3, 9} {
+			if j == i {
+				continue // 本节点不会出现在自己的查询结果中
+			}
+			want := net.JoinHostPort("127.0.0.1", strconv.Itoa(10000+j))
+			if got[nodes[j].ID()] != want {
+				t.Errorf("node %d: provider %s address = %q, want %q", i, nodes[j].ID(), got[nodes[j].ID()], want)
+			}
+		}
+	}
+
+	if _, err := nodes[0].Providers(actx, "unknown-file"); err == nil {
+		t.Error("providers of an unannounced file: want error")
+	}
+}
+
+func TestDHTAnnounceCarriesMappedAddrs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := startDHTNetwork(t, ctx, 6)
+
+	const fileID = core.FileID("mapped-file")
+	// 端口映射建立后经 MultiFinder 更新宣告地址
+	var f Finder = nodes[2]
+	f.(AddrSetter).SetAddrs([]string{"203.0.113.9:41000", ":42000", "bad"})
+	actx, acancel := context.WithTimeout(ctx, 10*time.Second)
+	defer acancel()
+	if err := nodes[2].Announce(actx, fileID); err != nil {
+		t.Fatal(err)
+	}
+	provs, err := nodes[5].Providers(actx, fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"127.0.0.1:10002", "203.0.113.9:41000", "127.0.0.1:42000"}
+	if len(provs) != 1 || fmt.Sprint(provs[0].ServiceAddrs()) != fmt.Sprint(want) {
+		t.Fatalf("providers = %+v, want addrs %v", provs, want)
+	}
+}
+
+func TestDHTStopIsIdempotent(t *testing.T) {
+	d := NewDHTFinder("stop", 0, 0)
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	d.Stop()
+	d.Stop()
+	// 未启动的节点同样可以停止
+	NewDHTFinder("idle", 0, 0).Stop()
+}
+
+// startInBucket0 启动一个 ID 落在 self 路由表第 0 个桶中的 DHT 节点
+func startInBucket0(t *testing.T, ctx context.Context, self *DHTFinder, name string) *DHTFinder {
+	t.Helper()
+	for {
+		d := NewDHTFinder(name, 0, 0)
+		if bucketIndex(self.table.self, keyOf(string(d.ID()))) != 0 {
+			continue
+		}
+		if err := d.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { d.Stop() })
+		return d
+	}
+}
+
+// fillBucket0 用 oldest 和若干无法联系的新近节点填满 d 的第 0 个桶，oldest 已过期
+func fillBucket0(d *DHTFinder, oldest dhtContact) {
+	oldest.LastSeen = time.Now().Add(-2 * dhtStaleAge)
+	d.table.update(oldest)
+	for i := 0; d.table.size() < dhtK; i++ {
+		id := core.NodeID(fmt.Sprintf("filler%d", i))
+		if bucketIndex(d.table.self, keyOf(string(id))) == 0 {
+			d.table.update(dhtContact{ID: id, Addr: "127.0.0.1:1", LastSeen: time.Now()})
+		}
+	}
+}
+
+func bucket0Has(d *DHTFinder, id core.NodeID) bool {
+	d.table.mu.RLock()
+	defer d.table.mu.RUnlock()
+	for _, c := range d.table.buckets[0] {
+		if c.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDHTKeepsLiveOldestContact(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := startDHTNetwork(t, ctx, 1)[0]
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(d.Addr().Port))
+	alive := startInBucket0(t, ctx, d, "alive")
+	fillBucket0(d, dhtContact{ID: alive.ID(), Addr: net.JoinHostPort("127.0.0.1", strconv.Itoa(alive.Addr().Port))})
+
+	// 桶满且最久未联系的节点过期：先 ping 它，仍在线则保留并丢弃新节点
+	newcomer := startInBucket0(t, ctx, d, "newcomer")
+	if _, err := newcomer.callAddr(ctx, addr, dhtMsg{Type: dhtPing}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		d.table.mu.RLock()
+		defer d.table.mu.RUnlock()
+		b := d.table.buckets[0]
+		return !d.table.pinging[0] && b[len(b)-1].ID == alive.ID()
+	})
+	if bucket0Has(d, newcomer.ID()) || d.table.size() != dhtK {
+		t.Fatalf("newcomer replaced a live contact")
+	}
+}
+
+func TestDHTEvictsDeadOldestContact(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := startDHTNetwork(t, ctx, 1)[0]
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(d.Addr().Port))
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := pc.LocalAddr().String()
+	pc.Close()
+	dead := core.NodeID("dead")
+	for i := 0; bucketIndex(d.table.self, keyOf(string(dead))) != 0; i++ {
+		dead = core.NodeID(fmt.Sprintf("dead%d", i))
+	}
+	fillBucket0(d, dhtContact{ID: dead, Addr: deadAddr})
+
+	// 最久未联系的节点不应答 ping：移除它并加入新节点
+	newcomer := startInBucket0(t, ctx, d, "newcomer")
+	if _, err := newcomer.callAddr(ctx, addr, dhtMsg{Type: dhtPing}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return bucket0Has(d, newcomer.ID()) })
+	if bucket0Has(d, dead) || d.table.size() != dhtK {
+		t.Fatalf("bucket after eviction: %+v", d.table.all())
+	}
+}
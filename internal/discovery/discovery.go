@@ -11,4 +11,12 @@ type Finder interface {
 	Start(ctx context.Context) error
 	Stop() error
 	Nodes() []core.Node
-}
\ No newline at end of file
+}
+// ProviderFinder 按文件查找持有者，返回节点的 Address 为其传输服务地址
+type ProviderFinder interface {
+	Providers(ctx context.Context, fileID core.FileID) ([]core.Node, error)
+}
+// AddrSetter 可在运行中更新宣告的额外服务地址（如端口映射得到的外部地址）的发现后端
+type AddrSetter interface {
+	SetAddrs(addrs []string)
+}
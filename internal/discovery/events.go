@@ -0,0 +1,338 @@
+package discovery
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// EventType 节点事件类型
+type EventType int
+
+const (
+	PeerJoined  EventType = iota + 1 // 新发现节点
+	PeerUpdated                      // 节点地址、端口等信息变化（仅刷新 LastSeen 不产生事件）
+	PeerLost                         // 节点超时、离开或探测失败
+)
+
+func (t EventType) String() string {
+	switch t {
+	case PeerJoined:
+		return "joined"
+	case PeerUpdated:
+		return "updated"
+	case PeerLost:
+		return "lost"
+	}
+	return "unknown"
+}
+
+// Event 节点事件；PeerLost 事件中的 Node 为最后一次已知的状态
+type Event struct {
+	Type    EventType
+	Node    core.Node
+	Time    time.Time
+	Expires time.Time // 节点记录的过期时间（发出事件时），零值表示不过期；只刷新过期时间不产生事件
+}
+
+// Subscriber 可订阅节点事件的 Finder，用于替代轮询 Nodes()
+type Subscriber interface {
+	// Subscribe 返回事件通道与取消函数，buf 为通道缓冲（<=0 时使用默认值）
+	// 订阅时已知的节点先以 PeerJoined 事件送出；Finder 停止或取消订阅后通道被关闭
+	// 通道已满时事件按节点合并后排队（如 Joined 后 Lost 相互抵消），不会丢失 PeerLost，消费者应及时读取
+	Subscribe(buf int) (<-chan Event, func())
+}
+
+const (
+	defaultEventBuffer = 64
+	expireInterval     = time.Second // 后台清理超时节点的周期
+)
+
+// peerTable 带过期时间的节点表：增删改时向订阅者发送事件，由 run 在后台清理超时节点
+// 各 Finder 共用，替代各自维护的节点 map 与 Nodes() 中的惰性清理
+type peerTable struct {
+	mu     sync.Mutex
+	nodes  map[core.NodeID]core.Node
+	expiry map[core.NodeID]time.Time // 无记录表示不过期
+	subs   map[*subscription]struct{}
+	closed bool
+	// extend 可选：节点到期时（不持有锁）调用，返回正数时将过期时间顺延该时长而不删除
+	extend func(n core.Node) time.Duration
+}
+
+// subscription 一个订阅：通道有空间且没有积压时直接发送，否则按节点合并后排队，由 pump 依次送出
+type subscription struct {
+	ch       chan Event
+	wake     chan struct{}
+	done     chan struct{}
+	pending  map[core.NodeID]Event
+	order    []core.NodeID
+	inflight bool // pump 已取出一个事件但尚未送出
+}
+
+func newPeerTable() *peerTable {
+	return &peerTable{
+		nodes:  make(map[core.NodeID]core.Node),
+		expiry: make(map[core.NodeID]time.Time),
+		subs:   make(map[*subscription]struct{}),
+	}
+}
+
+// nodeKey 节点在节点表中的键：NodeID；无ID的记录（如来源未经证实的地址，见 mergeNode）按首选地址区分
+func nodeKey(n core.Node) core.NodeID {
+	if n.ID == "" {
+		return core.NodeID("addr:" + n.Address)
+	}
+	return n.ID
+}
+
+// put 记录节点，ttl<=0 表示不过期；merge 不为空时与已有记录合并，
+// merge 返回其他键的记录（如来源不同的独立记录）时按新键保存，原记录不变
+func (t *peerTable) put(n core.Node, ttl time.Duration, merge func(old, cur core.Node) core.Node) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := nodeKey(n)
+	old, ok := t.nodes[key]
+	if ok && merge != nil {
+		n = merge(old, n)
+		if k := nodeKey(n); k != key {
+			key = k
+			old, ok = t.nodes[key]
+		}
+	}
+	t.nodes[key] = n
+	if ttl > 0 {
+		t.expiry[key] = time.Now().Add(ttl)
+	} else {
+		delete(t.expiry, key)
+	}
+	switch {
+	case !ok:
+		t.emitLocked(PeerJoined, n)
+	case !sameNode(old, n):
+		t.emitLocked(PeerUpdated, n)
+	}
+}
+
+// remove 删除键为 id（见 nodeKey）的节点并发送 PeerLost 事件
+func (t *peerTable) remove(id core.NodeID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.removeLocked(id)
+}
+
+// retain 删除 keep 返回 false 的节点
+func (t *peerTable) retain(keep func(core.Node) bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, n := range t.nodes {
+		if !keep(n) {
+			t.removeLocked(id)
+		}
+	}
+}
+
+func (t *peerTable) removeLocked(id core.NodeID) {
+	n, ok := t.nodes[id]
+	if !ok {
+		return
+	}
+	delete(t.nodes, id)
+	delete(t.expiry, id)
+	t.emitLocked(PeerLost, n)
+}
+
+func (t *peerTable) list() []core.Node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	out := make([]core.Node, 0, len(t.nodes))
+	for id, n := range t.nodes {
+		if exp, ok := t.expiry[id]; ok && now.After(exp) {
+			continue
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// expire 删除已过期的节点；设置了 extend 时先询问是否顺延
+func (t *peerTable) expire() {
+	t.mu.Lock()
+	now := time.Now()
+	var due []core.Node
+	for id, exp := range t.expiry {
+		if now.After(exp) {
+			due = append(due, t.nodes[id])
+		}
+	}
+	if t.extend == nil {
+		for _, n := range due {
+			t.removeLocked(nodeKey(n))
+		}
+		t.mu.Unlock()
+		return
+	}
+	t.mu.Unlock()
+
+	for _, n := range due {
+		d := t.extend(n)
+		t.mu.Lock()
+		// 期间节点可能已被刷新或删除
+		key := nodeKey(n)
+		if exp, ok := t.expiry[key]; ok && now.After(exp) {
+			if d > 0 {
+				t.expiry[key] = time.Now().Add(d)
+			} else {
+				t.removeLocked(key)
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// run 定期清理过期节点，直到 ctx 结束；结束时关闭全部订阅
+func (t *peerTable) run(ctx context.Context) {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			t.close()
+			return
+		case <-ticker.C:
+			t.expire()
+		}
+	}
+}
+
+func (t *peerTable) subscribe(buf int) (<-chan Event, func()) {
+	if buf <= 0 {
+		buf = defaultEventBuffer
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sub := &subscription{
+		ch:      make(chan Event, buf),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		pending: make(map[core.NodeID]Event),
+	}
+	if t.closed {
+		close(sub.ch)
+		return sub.ch, func() {}
+	}
+	now := time.Now()
+	for id, n := range t.nodes {
+		t.deliverLocked(sub, Event{Type: PeerJoined, Node: n, Time: now, Expires: t.expiry[id]})
+	}
+	t.subs[sub] = struct{}{}
+	go t.pump(sub)
+	return sub.ch, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if _, ok := t.subs[sub]; ok {
+			delete(t.subs, sub)
+			close(sub.done)
+		}
+	}
+}
+
+// close 关闭全部订阅，之后的订阅立即得到已关闭的通道
+func (t *peerTable) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	for sub := range t.subs {
+		close(sub.done)
+	}
+	clear(t.subs)
+}
+
+func (t *peerTable) emitLocked(typ EventType, n core.Node) {
+	ev := Event{Type: typ, Node: n, Time: time.Now(), Expires: t.expiry[nodeKey(n)]}
+	for sub := range t.subs {
+		t.deliverLocked(sub, ev)
+	}
+}
+
+// deliverLocked 没有积压时直接写入通道，通道已满或已有积压时排队以保持同一节点的事件顺序
+func (t *peerTable) deliverLocked(sub *subscription, ev Event) {
+	if len(sub.order) == 0 && !sub.inflight {
+		select {
+		case sub.ch <- ev:
+			return
+		default:
+		}
+	}
+	sub.queueLocked(ev)
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+// queueLocked 将事件并入积压队列：同一节点只保留合并后的一个事件
+// - Joined 后 Updated 仍为 Joined；Joined 后 Lost 相互抵消（订阅者从未得知该节点）
+// - Lost 后 Joined 变为 Updated；其余情况以新事件为准
+func (sub *subscription) queueLocked(ev Event) {
+	id := nodeKey(ev.Node)
+	prev, ok := sub.pending[id]
+	if !ok {
+		sub.pending[id] = ev
+		sub.order = append(sub.order, id)
+		return
+	}
+	switch {
+	case prev.Type == PeerJoined && ev.Type == PeerLost:
+		delete(sub.pending, id)
+		sub.order = slices.DeleteFunc(sub.order, func(x core.NodeID) bool { return x == id })
+		return
+	case prev.Type == PeerJoined:
+		ev.Type = PeerJoined
+	case prev.Type == PeerLost && ev.Type == PeerJoined:
+		ev.Type = PeerUpdated
+	}
+	sub.pending[id] = ev
+}
+
+// pump 依次送出积压的事件，订阅取消或节点表关闭后关闭通道
+func (t *peerTable) pump(sub *subscription) {
+	defer close(sub.ch)
+	for {
+		select {
+		case <-sub.wake:
+		case <-sub.done:
+			return
+		}
+		for {
+			t.mu.Lock()
+			sub.inflight = false
+			if len(sub.order) == 0 {
+				t.mu.Unlock()
+				break
+			}
+			id := sub.order[0]
+			sub.order = sub.order[1:]
+			ev := sub.pending[id]
+			delete(sub.pending, id)
+			sub.inflight = true
+			t.mu.Unlock()
+
+			select {
+			case sub.ch <- ev:
+			case <-sub.done:
+				return
+			}
+		}
+	}
+}
+
+// sameNode 比较除 LastSeen 以外的节点信息
+func sameNode(a, b core.Node) bool {
+	return a.ID == b.ID && a.Address == b.Address && a.ServicePort == b.ServicePort &&
+		a.DiscoveryAddr == b.DiscoveryAddr && a.Status == b.Status && slices.Equal(a.Addrs, b.Addrs)
+}
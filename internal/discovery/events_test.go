@@ -0,0 +1,152 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// drain 读取通道中已有的事件，直到 quiet 时间内没有新事件
+func drain(ch <-chan Event, quiet time.Duration) []Event {
+	var out []Event
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return out
+			}
+			out = append(out, ev)
+		case <-time.After(quiet):
+			return out
+		}
+	}
+}
+
+// replay 按事件序列重建订阅者视角下的节点集合
+func replay(t *testing.T, evs []Event) map[core.NodeID]core.Node {
+	t.Helper()
+	view := map[core.NodeID]core.Node{}
+	for _, ev := range evs {
+		_, known := view[ev.Node.ID]
+		switch ev.Type {
+		case PeerJoined:
+			if known {
+				t.Fatalf("joined for known node %s", ev.Node.ID)
+			}
+			view[ev.Node.ID] = ev.Node
+		case PeerUpdated:
+			if !known {
+				t.Fatalf("updated for unknown node %s", ev.Node.ID)
+			}
+			view[ev.Node.ID] = ev.Node
+		case PeerLost:
+			if !known {
+				t.Fatalf("lost for unknown node %s", ev.Node.ID)
+			}
+			delete(view, ev.Node.ID)
+		}
+	}
+	return view
+}
+
+func TestPeerTableSlowSubscriberKeepsConsistentView(t *testing.T) {
+	pt := newPeerTable()
+	ch, cancel := pt.subscribe(1)
+	defer cancel()
+
+	// 订阅者不读取期间产生远超缓冲的事件：反复加入、更新、离开
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 50; i++ {
+			id := core.NodeID(fmt.Sprintf("n%d", i))
+			pt.put(core.Node{ID: id, Address: fmt.Sprintf("10.0.0.%d:%d", i, 9000+round)}, 0, nil)
+		}
+		for i := 0; i < 50; i += 2 {
+			pt.remove(core.NodeID(fmt.Sprintf("n%d", i)))
+		}
+	}
+	pt.remove("n1")
+
+	view := replay(t, drain(ch, 200*time.Millisecond))
+	want := pt.list()
+	if len(view) != len(want) {
+		t.Fatalf("subscriber sees %d nodes, table has %d", len(view), len(want))
+	}
+	for _, n := range want {
+		if got, ok := view[n.ID]; !ok || got.Address != n.Address {
+			t.Errorf("node %s: subscriber has %+v, table has %+v", n.ID, got, n)
+		}
+	}
+	if _, ok := view["n1"]; ok {
+		t.Error("PeerLost for n1 was dropped")
+	}
+}
+
+func TestPeerTableUnsubscribeClosesChannel(t *testing.T) {
+	pt := newPeerTable()
+	ch, cancel := pt.subscribe(1)
+	pt.put(core.Node{ID: "a"}, 0, nil)
+	pt.put(core.Node{ID: "b"}, 0, nil)
+	cancel()
+	cancel()
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("channel not closed after unsubscribe")
+		}
+	}
+}
+
+// fakeFinder 由测试直接写入节点表的后端
+type fakeFinder struct {
+	peers *peerTable
+}
+
+func (f *fakeFinder) Start(ctx context.Context) error { go f.peers.run(ctx); return nil }
+func (f *fakeFinder) Stop() error                     { f.peers.close(); return nil }
+func (f *fakeFinder) Nodes() []core.Node              { return f.peers.list() }
+func (f *fakeFinder) Subscribe(buf int) (<-chan Event, func()) {
+	return f.peers.subscribe(buf)
+}
+
+func TestMultiFinderExpiresWithBackendTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backend := &fakeFinder{peers: newPeerTable()}
+	m := NewMultiFinder(backend)
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	ch, unsub := m.Subscribe(0)
+	defer unsub()
+
+	backend.peers.put(core.Node{ID: "kept", Address: "10.0.0.1:9000"}, 500*time.Millisecond, nil)
+	backend.peers.put(core.Node{ID: "gone", Address: "10.0.0.2:9000"}, 500*time.Millisecond, nil)
+	waitEvent(t, ch, PeerJoined, "kept", time.Second)
+
+	// kept 只刷新过期时间（不产生事件），合并视图到期时应发现后端仍列出它而保留；
+	// gone 被后端直接删除而不发事件，合并视图到期后应移除
+	backend.peers.put(core.Node{ID: "kept", Address: "10.0.0.1:9000"}, time.Hour, nil)
+	backend.peers.mu.Lock()
+	delete(backend.peers.nodes, "gone")
+	delete(backend.peers.expiry, "gone")
+	backend.peers.mu.Unlock()
+
+	waitEvent(t, ch, PeerLost, "gone", 3*time.Second)
+	time.Sleep(1500 * time.Millisecond)
+	var ids []core.NodeID
+	for _, n := range m.peers.list() {
+		ids = append(ids, n.ID)
+	}
+	if len(ids) != 1 || ids[0] != "kept" {
+		t.Fatalf("merged view = %v, want [kept]", ids)
+	}
+}
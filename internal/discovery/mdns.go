@@ -2,296 +2,530 @@ package discovery
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net"
-	"syscall"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+
 	"github.com/ripplego/ripplego/internal/core"
 )
 
-// UDPFinder 基于UDP广播的局域网节点发现
-// 简单有效，避免复杂依赖
-type UDPFinder struct {
-	port      int
-	name      string
-	selfID    string
-	queryOnly bool
-	mu        sync.RWMutex
-	nodes     map[core.NodeID]core.Node
-	ctx       context.Context
-	cancel    context.CancelFunc
-	conn      *net.UDPConn
-	stopCh    chan struct{}
-}
+const (
+	// MDNSService DNS-SD 服务类型
+	MDNSService = "_ripplego._tcp.local."
+	// MDNSProtoVersion TXT 记录中的协议版本，主版本不同的节点不会被采纳
+	MDNSProtoVersion = "1"
+
+	mdnsPort        = 5353
+	mdnsTTL         = 120 // 秒，主机/服务记录的常用 TTL
+	mdnsLegacyTTL   = 10  // 传统单播查询的回复 TTL 上限（RFC 6762 §6.7）
+	mdnsServicesPTR = "_services._dns-sd._udp.local."
+	classCacheFlush = 0x8000
+)
+
+var mdnsGroupV4 = net.IPv4(224, 0, 0, 251)
 
-type BroadcastMsg struct {
-	Type    string `json:"type"` // announce | query
-	NodeID  string `json:"nodeId"`
-	Name    string `json:"name"`
-	Address string `json:"address"`
-	Msg     string `json:"msg"`
+// MDNSFinder 基于 mDNS/DNS-SD（RFC 6762/6763）的节点发现
+// 服务模式下通告 _ripplego._tcp 服务（SRV 指向传输服务端口，TXT 携带节点ID、服务端口与协议版本）
+// 并响应查询；同时周期性浏览同类服务。可与 avahi、Bonjour 等标准响应器共存与互通
+type MDNSFinder struct {
+	// Port mDNS 端口，默认 5353；测试时可改为其他端口以避免与系统响应器冲突（Start 前设置）
+	Port int
+	// Interfaces 参与收发的网卡，为空表示所有已启用且支持组播的网卡（Start 前设置）
+	Interfaces []net.Interface
+
+	name        string
+	selfID      string
+	servicePort int
+	queryOnly   bool
+	instance    string
+	host        string
+
+	sendMu   sync.Mutex // 串行化组播发送（发送前需切换出口网卡）
+	peers    *peerTable
+	ctx      context.Context
+	cancel   context.CancelFunc
+	conn     *net.UDPConn
+	pconn    *ipv4.PacketConn
+	ifaces   []net.Interface
+	stopCh   chan struct{}
+	stopOnce sync.Once
 }
 
-func NewUDPFinder(name string, port int) *UDPFinder {
-	return &UDPFinder{
-		port:   port,
-		name:   name,
-		selfID: fmt.Sprintf("%s-%d", name, time.Now().UnixNano()),
-		nodes:  make(map[core.NodeID]core.Node),
-		stopCh: make(chan struct{}),
+// NewMDNSFinder 创建服务模式的 mDNS Finder，servicePort 为 TCP 传输服务端口
+func NewMDNSFinder(name string, servicePort int) *MDNSFinder {
+	m := &MDNSFinder{
+		Port:        mdnsPort,
+		name:        name,
+		servicePort: servicePort,
+		peers:       newPeerTable(),
+		stopCh:      make(chan struct{}),
 	}
+	m.SetID(core.NodeID(fmt.Sprintf("%s-%d", name, time.Now().UnixNano())))
+	return m
 }
 
-// NewUDPFinderQuery 创建仅用于一次性扫描的Finder，不占用固定端口
-func NewUDPFinderQuery(port int) *UDPFinder {
-	return &UDPFinder{
-		port:      port,
-		name:      "scanner",
-		selfID:    fmt.Sprintf("scanner-%d", time.Now().UnixNano()),
-		nodes:     make(map[core.NodeID]core.Node),
-		stopCh:    make(chan struct{}),
-		queryOnly: true,
-	}
+// SetID 使用指定的节点ID（如与传输服务、其他发现方式共用），实例名与主机名随之变化，需在 Start 之前调用
+func (m *MDNSFinder) SetID(id core.NodeID) {
+	label := strings.NewReplacer(".", "-", " ", "-").Replace(string(id))
+	m.selfID = string(id)
+	m.instance = label + "." + MDNSService
+	m.host = label + ".local."
 }
 
-func (u *UDPFinder) Start(ctx context.Context) error {
-	u.ctx, u.cancel = context.WithCancel(ctx)
+// NewMDNSFinderQuery 创建仅用于浏览的 mDNS Finder，不占用 5353 端口也不通告自身
+func NewMDNSFinderQuery() *MDNSFinder {
+	m := NewMDNSFinder("scanner", 0)
+	m.queryOnly = true
+	return m
+}
 
-	if u.queryOnly {
-		// 仅扫描：使用随机端口接收回复，同时复用该 socket 发送查询
-		pc, err := net.ListenPacket("udp4", ":0")
-		if err != nil {
-			return err
-		}
-		u.conn = pc.(*net.UDPConn)
-		// 允许广播
-		if rc, err := u.conn.SyscallConn(); err == nil {
-			_ = rc.Control(func(fd uintptr) {
-				_ = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
-			})
-		}
-		go u.listenBroadcast()
-		go u.sendQuery()
-		return nil
-	}
-
-	// 服务模式：监听固定端口，启用端口复用
-	lc := net.ListenConfig{
-		Control: func(network, address string, c syscall.RawConn) error {
-			var ctrlErr error
-			if err := c.Control(func(fd uintptr) {
-				_ = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
-				_ = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEPORT, 1)
-			}); err != nil {
-				ctrlErr = err
-			}
-			return ctrlErr
-		},
+func (m *MDNSFinder) Start(ctx context.Context) error {
+	m.ctx, m.cancel = context.WithCancel(ctx)
+	m.ifaces = m.multicastInterfaces()
+	go m.peers.run(m.ctx)
+
+	addr := fmt.Sprintf(":%d", m.Port)
+	if m.queryOnly {
+		// 查询方使用随机端口，响应器按传统单播规则直接回复到该端口
+		addr = ":0"
 	}
-	pc, err := lc.ListenPacket(u.ctx, "udp4", fmt.Sprintf(":%d", u.port))
+	lc := net.ListenConfig{Control: reuseControl}
+	pc, err := lc.ListenPacket(m.ctx, "udp4", addr)
 	if err != nil {
 		return err
 	}
-	u.conn = pc.(*net.UDPConn)
+	m.conn = pc.(*net.UDPConn)
+	m.pconn = ipv4.NewPacketConn(m.conn)
+	_ = m.pconn.SetMulticastLoopback(true)
+	_ = m.pconn.SetMulticastTTL(255)
+	if !m.queryOnly {
+		joined := 0
+		for i := range m.ifaces {
+			if err := m.pconn.JoinGroup(&m.ifaces[i], &net.UDPAddr{IP: mdnsGroupV4}); err == nil {
+				joined++
+			}
+		}
+		if joined == 0 {
+			m.conn.Close()
+			return fmt.Errorf("mdns: failed to join %s on any interface", mdnsGroupV4)
+		}
+	}
 
-	go u.listenBroadcast()
-	go u.sendBroadcast()
+	go m.listen()
+	go m.browse()
+	if !m.queryOnly {
+		go m.announce()
+	}
 	return nil
 }
 
-func (u *UDPFinder) Stop() error {
-	close(u.stopCh)
-	if u.conn != nil {
-		u.conn.Close()
-	}
-	if u.cancel != nil {
-		u.cancel()
-	}
+// Stop 停止收发并发送 goodbye，可重复调用
+func (m *MDNSFinder) Stop() error {
+	m.stopOnce.Do(func() {
+		close(m.stopCh)
+		if m.conn != nil {
+			if !m.queryOnly {
+				// 发送 goodbye（TTL=0），让其他节点立即移除本节点
+				if pkt, err := m.buildResponse(0, 0, nil); err == nil {
+					m.sendMulticast(pkt)
+				}
+			}
+			m.conn.Close()
+		}
+		if m.cancel != nil {
+			m.cancel()
+		}
+	})
 	return nil
 }
 
-func (u *UDPFinder) Nodes() []core.Node {
-	u.mu.Lock()
-	defer u.mu.Unlock()
+func (m *MDNSFinder) Nodes() []core.Node {
+	return m.peers.list()
+}
+
+// Subscribe 订阅节点事件：记录 TTL 到期或收到 goodbye 时为 PeerLost
+func (m *MDNSFinder) Subscribe(buf int) (<-chan Event, func()) {
+	return m.peers.subscribe(buf)
+}
 
-	// 清理超时节点（30秒未响应）
-	cutoff := time.Now().Add(-30 * time.Second)
-	for id, node := range u.nodes {
-		if node.LastSeen.Before(cutoff) {
-			delete(u.nodes, id)
+func (m *MDNSFinder) multicastInterfaces() []net.Interface {
+	if len(m.Interfaces) > 0 {
+		return m.Interfaces
+	}
+	var out []net.Interface
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return out
+	}
+	for _, iface := range ifs {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
 		}
+		out = append(out, iface)
 	}
+	return out
+}
 
-	out := make([]core.Node, 0, len(u.nodes))
-	for _, n := range u.nodes {
-		out = append(out, n)
+func (m *MDNSFinder) sendMulticast(pkt []byte) {
+	m.sendMu.Lock()
+	defer m.sendMu.Unlock()
+	dst := &net.UDPAddr{IP: mdnsGroupV4, Port: m.Port}
+	if len(m.ifaces) == 0 {
+		m.writeTo(pkt, dst)
+		return
 	}
-	return out
+	for i := range m.ifaces {
+		if err := m.pconn.SetMulticastInterface(&m.ifaces[i]); err != nil {
+			continue
+		}
+		m.writeTo(pkt, dst)
+	}
+}
+
+func (m *MDNSFinder) writeTo(pkt []byte, dst *net.UDPAddr) {
+	_ = m.conn.SetWriteDeadline(time.Now().Add(500 * time.Millisecond))
+	_, _ = m.conn.WriteToUDP(pkt, dst)
 }
 
-func (u *UDPFinder) listenBroadcast() {
-	buf := make([]byte, 2048)
+// announce 启动时按 RFC 6762 §8.3 连续通告两次，之后在 TTL 过半前重复通告
+func (m *MDNSFinder) announce() {
+	delays := []time.Duration{0, time.Second}
+	for i := 0; ; i++ {
+		d := time.Duration(mdnsTTL/2) * time.Second
+		if i < len(delays) {
+			d = delays[i]
+		}
+		select {
+		case <-m.stopCh:
+			return
+		case <-time.After(d):
+		}
+		if pkt, err := m.buildResponse(0, mdnsTTL, nil); err == nil {
+			m.sendMulticast(pkt)
+		}
+	}
+}
+
+// browse 周期性查询服务 PTR 记录，间隔由 1s 逐步退避至 60s
+func (m *MDNSFinder) browse() {
+	interval := time.Second
 	for {
+		if pkt, err := m.buildQuery(); err == nil {
+			m.sendMulticast(pkt)
+		}
 		select {
-		case <-u.stopCh:
+		case <-m.stopCh:
 			return
-		default:
+		case <-time.After(interval):
 		}
+		if interval < time.Minute {
+			interval *= 2
+		}
+	}
+}
 
-		u.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-		n, addr, err := u.conn.ReadFromUDP(buf)
-		if err != nil {
-			if ne, ok := err.(net.Error); ok && ne.Timeout() {
-				continue
-			}
-			continue
+func (m *MDNSFinder) buildQuery() ([]byte, error) {
+	name, err := dnsmessage.NewName(MDNSService)
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// buildResponse 构造本节点的完整服务记录：PTR 作为答案，SRV/TXT/A 作为附加记录
+// question 非空时（传统单播查询）原样回带问题
+func (m *MDNSFinder) buildResponse(id uint16, ttl uint32, question *dnsmessage.Question) ([]byte, error) {
+	svc, err := dnsmessage.NewName(MDNSService)
+	if err != nil {
+		return nil, err
+	}
+	inst, err := dnsmessage.NewName(m.instance)
+	if err != nil {
+		return nil, err
+	}
+	host, err := dnsmessage.NewName(m.host)
+	if err != nil {
+		return nil, err
+	}
+	enum, err := dnsmessage.NewName(mdnsServicesPTR)
+	if err != nil {
+		return nil, err
+	}
+	// 传统单播回复不设置 cache-flush 位
+	flush := dnsmessage.Class(classCacheFlush)
+	if question != nil {
+		flush = 0
+	}
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, 1024), dnsmessage.Header{ID: id, Response: true, Authoritative: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if question != nil {
+		if err := b.Question(*question); err != nil {
+			return nil, err
 		}
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	hdr := func(name dnsmessage.Name, class dnsmessage.Class) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET | class, TTL: ttl}
+	}
+	if err := b.PTRResource(hdr(svc, 0), dnsmessage.PTRResource{PTR: inst}); err != nil {
+		return nil, err
+	}
+	if err := b.PTRResource(hdr(enum, 0), dnsmessage.PTRResource{PTR: svc}); err != nil {
+		return nil, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	if err := b.SRVResource(hdr(inst, flush), dnsmessage.SRVResource{Port: uint16(m.servicePort), Target: host}); err != nil {
+		return nil, err
+	}
+	txt := []string{
+		"id=" + m.selfID,
+		"name=" + m.name,
+		"port=" + strconv.Itoa(m.servicePort),
+		"proto=" + MDNSProtoVersion,
+	}
+	if err := b.TXTResource(hdr(inst, flush), dnsmessage.TXTResource{TXT: txt}); err != nil {
+		return nil, err
+	}
+	for _, ip := range localIPv4s(m.ifaces) {
+		var a [4]byte
+		copy(a[:], ip)
+		if err := b.AResource(hdr(host, flush), dnsmessage.AResource{A: a}); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
 
-		var msg BroadcastMsg
-		if err := json.Unmarshal(buf[:n], &msg); err != nil {
-			continue
+func localIPv4s(ifaces []net.Interface) []net.IP {
+	var out []net.IP
+	for _, iface := range ifaces {
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil {
+				continue
+			}
+			out = append(out, ipNet.IP.To4())
 		}
+	}
+	return out
+}
 
-		if msg.NodeID == u.selfID {
-			continue
+func (m *MDNSFinder) listen() {
+	buf := make([]byte, 9000)
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		default:
 		}
 
-		// 若收到查询且处于服务模式，单播回复公告
-		if msg.Type == "query" && !u.queryOnly {
-			resp := BroadcastMsg{
-				Type:    "announce",
-				NodeID:  u.selfID,
-				Name:    u.name,
-				Address: "",
-				Msg:     "RippleGo discovery",
-			}
-			data, _ := json.Marshal(resp)
-			u.conn.WriteToUDP(data, addr)
+		m.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+		n, addr, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
 			continue
 		}
 
-		if msg.Type != "announce" {
+		var p dnsmessage.Parser
+		h, err := p.Start(buf[:n])
+		if err != nil {
 			continue
 		}
-
-		node := core.Node{
-			ID:          core.NodeID(msg.NodeID),
-			Address:     fmt.Sprintf("%s:%d", addr.IP.String(), u.port),
-			ServicePort: u.port,
-			LastSeen:    time.Now(),
-			Status:      "online",
+		if h.Response {
+			m.handleResponse(&p, addr)
+		} else if !m.queryOnly {
+			m.handleQuery(&p, h, addr)
 		}
-		u.mu.Lock()
-		u.nodes[node.ID] = node
-		u.mu.Unlock()
 	}
 }
 
-
-func (u *UDPFinder) interfaceBroadcastIPs() []net.IP {
-	ips := []net.IP{}
-	ifs, err := net.Interfaces()
+func (m *MDNSFinder) handleQuery(p *dnsmessage.Parser, h dnsmessage.Header, addr *net.UDPAddr) {
+	qs, err := p.AllQuestions()
 	if err != nil {
-		return ips
+		return
 	}
-	for _, iface := range ifs {
-		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+	for _, q := range qs {
+		if !m.answers(q) {
 			continue
 		}
-		addrs, _ := iface.Addrs()
-		for _, a := range addrs {
-			ipNet, ok := a.(*net.IPNet)
-			if !ok || ipNet.IP == nil || ipNet.IP.To4() == nil || ipNet.Mask == nil {
-				continue
+		if addr.Port != m.Port {
+			// 传统单播查询（RFC 6762 §6.7）：回带ID与问题，直接回复到来源地址
+			q := q
+			q.Class &^= classCacheFlush
+			if pkt, err := m.buildResponse(h.ID, mdnsLegacyTTL, &q); err == nil {
+				m.writeTo(pkt, addr)
 			}
-			ip := ipNet.IP.To4()
-			mask := ipNet.Mask
-			bcast := net.IPv4(ip[0]|^mask[0], ip[1]|^mask[1], ip[2]|^mask[2], ip[3]|^mask[3])
-			ips = append(ips, bcast)
+			return
 		}
+		if pkt, err := m.buildResponse(0, mdnsTTL, nil); err == nil {
+			if q.Class&classCacheFlush != 0 {
+				// QU 位：请求单播回复
+				m.writeTo(pkt, addr)
+			} else {
+				m.sendMulticast(pkt)
+			}
+		}
+		return
 	}
-	return ips
 }
 
-func (u *UDPFinder) sendBroadcast() {
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
-
-	// 单独的发送 socket，开启广播权限
-	lc := net.ListenConfig{
-		Control: func(network, address string, c syscall.RawConn) error {
-			var ctrlErr error
-			if err := c.Control(func(fd uintptr) {
-				_ = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
-			}); err != nil {
-				ctrlErr = err
-			}
-			return ctrlErr
-		},
+// answers 判断问题是否与本节点的记录相关
+func (m *MDNSFinder) answers(q dnsmessage.Question) bool {
+	name := q.Name.String()
+	switch q.Type {
+	case dnsmessage.TypePTR:
+		return strings.EqualFold(name, MDNSService) || strings.EqualFold(name, mdnsServicesPTR)
+	case dnsmessage.TypeSRV, dnsmessage.TypeTXT:
+		return strings.EqualFold(name, m.instance)
+	case dnsmessage.TypeA:
+		return strings.EqualFold(name, m.host)
+	case dnsmessage.TypeALL:
+		return strings.EqualFold(name, MDNSService) || strings.EqualFold(name, m.instance) || strings.EqualFold(name, m.host)
 	}
-	pc, err := lc.ListenPacket(u.ctx, "udp4", ":0")
-	if err != nil {
+	return false
+}
+
+// mdnsInstance 解析过程中汇总的单个服务实例信息
+type mdnsInstance struct {
+	target string
+	port   int
+	txt    map[string]string
+	ttl    uint32
+}
+
+func (m *MDNSFinder) handleResponse(p *dnsmessage.Parser, src *net.UDPAddr) {
+	if err := p.SkipAllQuestions(); err != nil {
 		return
 	}
-	conn := pc.(*net.UDPConn)
-	defer conn.Close()
-
-	msg := BroadcastMsg{
-		Type:    "announce",
-		NodeID:  u.selfID,
-		Name:    u.name,
-		Address: "",
-		Msg:     "RippleGo discovery",
+	var rrs []dnsmessage.Resource
+	for _, section := range []func() ([]dnsmessage.Resource, error){p.AllAnswers, p.AllAuthorities, p.AllAdditionals} {
+		rs, err := section()
+		if err != nil {
+			return
+		}
+		rrs = append(rrs, rs...)
 	}
 
-	dests := []net.UDPAddr{
-		{IP: net.ParseIP("127.0.0.1"), Port: u.port},
-		{IP: net.IPv4bcast, Port: u.port},
+	instances := map[string]*mdnsInstance{}
+	inst := func(name string) *mdnsInstance {
+		key := strings.ToLower(name)
+		if instances[key] == nil {
+			instances[key] = &mdnsInstance{txt: map[string]string{}}
+		}
+		return instances[key]
 	}
-	for _, ip := range u.interfaceBroadcastIPs() {
-		dests = append(dests, net.UDPAddr{IP: ip, Port: u.port})
+	hosts := map[string][]net.IP{}
+	for _, rr := range rrs {
+		name := rr.Header.Name.String()
+		switch body := rr.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if strings.EqualFold(name, MDNSService) {
+				inst(body.PTR.String()).ttl = rr.Header.TTL
+			}
+		case *dnsmessage.SRVResource:
+			if hasSuffixFold(name, "."+MDNSService) {
+				in := inst(name)
+				in.target, in.port, in.ttl = body.Target.String(), int(body.Port), rr.Header.TTL
+			}
+		case *dnsmessage.TXTResource:
+			if hasSuffixFold(name, "."+MDNSService) {
+				in := inst(name)
+				for _, kv := range body.TXT {
+					k, v, _ := strings.Cut(kv, "=")
+					in.txt[strings.ToLower(k)] = v
+				}
+			}
+		case *dnsmessage.AResource:
+			key := strings.ToLower(name)
+			hosts[key] = append(hosts[key], net.IP(body.A[:]))
+		}
 	}
 
-	for {
-		select {
-		case <-u.stopCh:
-			return
-		case <-ticker.C:
-			data, _ := json.Marshal(msg)
-			for _, d := range dests {
-				_ = conn.SetWriteDeadline(time.Now().Add(500 * time.Millisecond))
-				_, _ = conn.WriteToUDP(data, &d)
+	now := time.Now()
+	for name, in := range instances {
+		if strings.EqualFold(name, m.instance) || in.port == 0 && in.txt["port"] == "" {
+			continue
+		}
+		if proto := in.txt["proto"]; proto != "" && strings.Split(proto, ".")[0] != strings.Split(MDNSProtoVersion, ".")[0] {
+			continue
+		}
+		id := core.NodeID(in.txt["id"])
+		if id == "" {
+			id = core.NodeID(strings.TrimSuffix(name, "."+strings.ToLower(MDNSService)))
+		}
+		if string(id) == m.selfID {
+			continue
+		}
+		if in.ttl == 0 {
+			// goodbye
+			m.peers.remove(id)
+			continue
+		}
+		port := in.port
+		if port == 0 {
+			port, _ = strconv.Atoi(in.txt["port"])
+		}
+		// 优先使用与报文来源一致的地址，其次为 A 记录中的第一个
+		ip := src.IP
+		if ips := hosts[strings.ToLower(in.target)]; len(ips) > 0 && !containsIP(ips, src.IP) {
+			ip = ips[0]
+		}
+		addr := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		addrs := []string{addr}
+		for _, x := range hosts[strings.ToLower(in.target)] {
+			if !x.Equal(ip) {
+				addrs = append(addrs, net.JoinHostPort(x.String(), strconv.Itoa(port)))
 			}
 		}
+		node := core.Node{
+			ID:            id,
+			Address:       addr,
+			ServicePort:   port,
+			Addrs:         addrs,
+			DiscoveryAddr: src.String(),
+			LastSeen:      now,
+			Status:        "online",
+		}
+		// 传统单播回复的 TTL 很短，浏览期间会持续重新查询，因此按常规 TTL 保留
+		ttl := in.ttl
+		if ttl < mdnsTTL {
+			ttl = mdnsTTL
+		}
+		m.peers.put(node, time.Duration(ttl)*time.Second, nil)
 	}
 }
 
-func (u *UDPFinder) sendQuery() {
-	msg := BroadcastMsg{
-		Type:    "query",
-		NodeID:  u.selfID,
-		Name:    u.name,
-		Address: "",
-		Msg:     "RippleGo discovery query",
-	}
-
-	dests := []net.UDPAddr{
-		{IP: net.ParseIP("127.0.0.1"), Port: u.port},
-		{IP: net.IPv4bcast, Port: u.port},
-	}
-	for _, ip := range u.interfaceBroadcastIPs() {
-		dests = append(dests, net.UDPAddr{IP: ip, Port: u.port})
-	}
-
-	for i := 0; i < 3; i++ { // 重发几次提升成功率
-		data, _ := json.Marshal(msg)
-		for _, d := range dests {
-			_ = u.conn.SetWriteDeadline(time.Now().Add(300 * time.Millisecond))
-			_, _ = u.conn.WriteToUDP(data, &d)
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, x := range ips {
+		if x.Equal(ip) {
+			return true
 		}
-		time.Sleep(300 * time.Millisecond)
 	}
-}
\ No newline at end of file
+	return false
+}
+
+func hasSuffixFold(s, suffix string) bool {
+	return len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix)
+}
@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// loopbackInterface 返回回环网卡，不支持回环组播的环境跳过测试
+func loopbackInterface(t *testing.T) net.Interface {
+	t.Helper()
+	ifs, err := net.Interfaces()
+	if err != nil {
+		t.Skipf("list interfaces: %v", err)
+	}
+	for _, iface := range ifs {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			return iface
+		}
+	}
+	t.Skip("no loopback interface")
+	return net.Interface{}
+}
+
+// freeUDPPort 返回一个当前未被占用的 UDP 端口
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	return pc.LocalAddr().(*net.UDPAddr).Port
+}
+
+func startMDNS(t *testing.T, ctx context.Context, id string, port, servicePort int, lo net.Interface) *MDNSFinder {
+	t.Helper()
+	m := NewMDNSFinder(id, servicePort)
+	m.SetID(core.NodeID(id))
+	m.Port = port
+	m.Interfaces = []net.Interface{lo}
+	if err := m.Start(ctx); err != nil {
+		t.Skipf("mdns on loopback unavailable: %v", err)
+	}
+	t.Cleanup(func() { m.Stop() })
+	return m
+}
+
+// waitEvent 等待 id 的 typ 事件
+func waitEvent(t *testing.T, ch <-chan Event, typ EventType, id core.NodeID, timeout time.Duration) Event {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				t.Fatalf("event channel closed while waiting for %s %s", typ, id)
+			}
+			if ev.Type == typ && ev.Node.ID == id {
+				return ev
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for %s %s", typ, id)
+		}
+	}
+}
+
+func TestMDNSLoopbackDiscoveryAndGoodbye(t *testing.T) {
+	lo := loopbackInterface(t)
+	port := freeUDPPort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := startMDNS(t, ctx, "node-a", port, 9101, lo)
+	evA, unsubA := a.Subscribe(0)
+	defer unsubA()
+	b := startMDNS(t, ctx, "node-b", port, 9102, lo)
+	evB, unsubB := b.Subscribe(0)
+	defer unsubB()
+
+	ev := waitEvent(t, evA, PeerJoined, "node-b", 5*time.Second)
+	if ev.Node.ServicePort != 9102 {
+		t.Errorf("node-b service port = %d, want 9102", ev.Node.ServicePort)
+	}
+	if host, p, _ := net.SplitHostPort(ev.Node.Address); p != "9102" || !net.ParseIP(host).IsLoopback() {
+		t.Errorf("node-b address = %q, want loopback:9102", ev.Node.Address)
+	}
+	waitEvent(t, evB, PeerJoined, "node-a", 5*time.Second)
+
+	// goodbye（TTL=0）使对端立即移除本节点
+	if err := b.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, evA, PeerLost, "node-b", 3*time.Second)
+	for _, n := range a.Nodes() {
+		if n.ID == "node-b" {
+			t.Fatalf("node-b still listed after goodbye: %+v", n)
+		}
+	}
+
+	// 重复 Stop 不应 panic
+	if err := b.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
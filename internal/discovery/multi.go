@@ -0,0 +1,289 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// MultiFinder 组合多个发现方式（广播、mDNS、DHT、静态节点、PEX 等）：
+// 并发启动各后端，按 NodeID 去重并合并地址与最近发现时间
+type MultiFinder struct {
+	finders []Finder
+	started []Finder
+
+	mu    sync.Mutex
+	views map[core.NodeID]map[int]Event // 各后端（按下标）最近一次报告该节点（键见 nodeKey）的事件
+	peers *peerTable                    // 合并后的节点视图，用于事件订阅
+	fwd   sync.WaitGroup
+}
+
+// multiRecheck 合并视图中的节点到期而后端仍列出它时顺延的时长（后端只刷新过期时间时不产生事件）
+const multiRecheck = 10 * time.Second
+
+// NewMultiFinder 组合给定的 Finder，nil 会被忽略
+func NewMultiFinder(finders ...Finder) *MultiFinder {
+	m := &MultiFinder{
+		views: make(map[core.NodeID]map[int]Event),
+		peers: newPeerTable(),
+	}
+	m.peers.extend = m.stillListed
+	for _, f := range finders {
+		if f != nil {
+			m.finders = append(m.finders, f)
+		}
+	}
+	return m
+}
+
+// Add 追加后端，需在 Start 之前调用
+func (m *MultiFinder) Add(f Finder) { m.finders = append(m.finders, f) }
+
+// Finders 返回组合中的全部后端
+func (m *MultiFinder) Finders() []Finder { return m.finders }
+
+// SetAddrs 更新各支持 AddrSetter 的后端宣告的额外服务地址
+func (m *MultiFinder) SetAddrs(addrs []string) {
+	for _, f := range m.finders {
+		if s, ok := f.(AddrSetter); ok {
+			s.SetAddrs(addrs)
+		}
+	}
+}
+
+// Start 并发启动全部后端；部分后端启动失败时继续使用其余后端，全部失败时返回错误
+func (m *MultiFinder) Start(ctx context.Context) error {
+	errs := make([]error, len(m.finders))
+	var wg sync.WaitGroup
+	for i, f := range m.finders {
+		wg.Add(1)
+		go func(i int, f Finder) {
+			defer wg.Done()
+			errs[i] = f.Start(ctx)
+		}(i, f)
+	}
+	wg.Wait()
+	for i, f := range m.finders {
+		if errs[i] == nil {
+			m.started = append(m.started, f)
+		}
+	}
+	if len(m.started) == 0 && len(m.finders) > 0 {
+		return fmt.Errorf("discovery: all backends failed: %w", errors.Join(errs...))
+	}
+	go m.peers.run(ctx)
+	for i, f := range m.started {
+		if sub, ok := f.(Subscriber); ok {
+			ch, _ := sub.Subscribe(multiEventBuffer)
+			m.fwd.Add(1)
+			go m.forward(i, ch)
+		}
+	}
+	return nil
+}
+
+func (m *MultiFinder) Stop() error {
+	var errs []error
+	for _, f := range m.started {
+		if err := f.Stop(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	m.mu.Lock()
+	m.started = nil
+	m.mu.Unlock()
+	// 后端停止后其订阅通道关闭，转发协程随之退出
+	m.fwd.Wait()
+	m.peers.close()
+	return errors.Join(errs...)
+}
+
+// multiEventBuffer 订阅各后端事件时使用的缓冲
+const multiEventBuffer = 256
+
+// Subscribe 订阅合并后的节点事件：节点首次被任一后端发现时为 PeerJoined，
+// 合并后的信息变化时为 PeerUpdated，所有后端都失去该节点时为 PeerLost
+// 只有实现 Subscriber 的后端（广播、mDNS、静态节点、PEX）参与，DHT 等后端的节点仍需通过 Nodes() 获取
+func (m *MultiFinder) Subscribe(buf int) (<-chan Event, func()) {
+	return m.peers.subscribe(buf)
+}
+
+// forward 将后端 i 的事件并入合并视图，后端的订阅结束时撤回其报告的全部节点
+func (m *MultiFinder) forward(i int, ch <-chan Event) {
+	defer m.fwd.Done()
+	for ev := range ch {
+		m.apply(i, ev, ev.Type != PeerLost)
+	}
+	m.mu.Lock()
+	var nodes []core.Node
+	for _, v := range m.views {
+		if ev, ok := v[i]; ok {
+			nodes = append(nodes, ev.Node)
+		}
+	}
+	m.mu.Unlock()
+	for _, n := range nodes {
+		m.apply(i, Event{Node: n}, false)
+	}
+}
+
+// apply 更新后端 i 对节点的报告，并据此更新合并视图
+// 合并后的记录在各后端中最晚的过期时间到期，任一后端的记录不过期时合并记录也不过期
+func (m *MultiFinder) apply(i int, ev Event, present bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := nodeKey(ev.Node)
+	v := m.views[id]
+	if present {
+		if v == nil {
+			v = make(map[int]Event)
+			m.views[id] = v
+		}
+		v[i] = ev
+	} else {
+		delete(v, i)
+	}
+	if len(v) == 0 {
+		delete(m.views, id)
+		m.peers.remove(id)
+		return
+	}
+	// 按后端顺序合并，使合并结果稳定
+	keys := make([]int, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	nodes := make([]core.Node, 0, len(keys))
+	var expires time.Time
+	forever := false
+	for _, k := range keys {
+		nodes = append(nodes, v[k].Node)
+		if v[k].Expires.IsZero() {
+			forever = true
+		} else if v[k].Expires.After(expires) {
+			expires = v[k].Expires
+		}
+	}
+	var ttl time.Duration
+	if !forever {
+		ttl = max(time.Until(expires), time.Millisecond)
+	}
+	m.peers.put(MergeNodes(nodes)[0], ttl, nil)
+}
+
+// stillListed 合并视图中的节点到期时调用：报告过该节点的后端仍列出它时顺延 multiRecheck，否则撤回这些后端的报告
+func (m *MultiFinder) stillListed(n core.Node) time.Duration {
+	m.mu.Lock()
+	var idx []int
+	key := nodeKey(n)
+	for k := range m.views[key] {
+		idx = append(idx, k)
+	}
+	started := m.started
+	m.mu.Unlock()
+	for _, k := range idx {
+		if k >= len(started) {
+			continue
+		}
+		if slices.ContainsFunc(started[k].Nodes(), func(x core.Node) bool { return nodeKey(x) == key }) {
+			return multiRecheck
+		}
+	}
+	m.mu.Lock()
+	delete(m.views, key)
+	m.mu.Unlock()
+	return 0
+}
+
+// Nodes 合并各后端发现的节点
+func (m *MultiFinder) Nodes() []core.Node {
+	var all []core.Node
+	for _, f := range m.started {
+		all = append(all, f.Nodes()...)
+	}
+	return MergeNodes(all)
+}
+
+// Providers 并发向支持按文件查找的后端查询 fileID 的持有者并合并结果，任一后端找到即成功
+func (m *MultiFinder) Providers(ctx context.Context, fileID core.FileID) ([]core.Node, error) {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		all  []core.Node
+		errs []error
+	)
+	for _, f := range m.started {
+		pf, ok := f.(ProviderFinder)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(pf ProviderFinder) {
+			defer wg.Done()
+			nodes, err := pf.Providers(ctx, fileID)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			all = append(all, nodes...)
+		}(pf)
+	}
+	wg.Wait()
+	if len(all) == 0 {
+		if len(errs) == 0 {
+			return nil, errors.New("discovery: no backend supports provider lookup")
+		}
+		return nil, errors.Join(errs...)
+	}
+	return MergeNodes(all), nil
+}
+
+// MergeNodes 按 NodeID 合并节点：地址取并集（较新的记录的地址在前），最近发现时间取最大值，
+// 无ID的节点按首选地址去重
+func MergeNodes(nodes []core.Node) []core.Node {
+	idx := make(map[string]int)
+	var out []core.Node
+	for _, n := range nodes {
+		k := string(n.ID)
+		if k == "" {
+			k = "addr:" + n.Address
+		}
+		i, ok := idx[k]
+		if !ok {
+			idx[k] = len(out)
+			out = append(out, n)
+			continue
+		}
+		out[i] = combineNode(out[i], n)
+	}
+	return out
+}
+
+func combineNode(a, b core.Node) core.Node {
+	// 以较新的记录为主；没有服务地址的记录不能覆盖有服务地址的记录
+	if b.LastSeen.After(a.LastSeen) && b.Address != "" || a.Address == "" {
+		a, b = b, a
+	}
+	addrs := a.ServiceAddrs()
+	for _, x := range b.ServiceAddrs() {
+		if !slices.Contains(addrs, x) {
+			addrs = append(addrs, x)
+		}
+	}
+	a.Addrs = addrs
+	if a.DiscoveryAddr == "" {
+		a.DiscoveryAddr = b.DiscoveryAddr
+	}
+	if b.LastSeen.After(a.LastSeen) {
+		a.LastSeen = b.LastSeen
+	}
+	return a
+}
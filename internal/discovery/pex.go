@@ -0,0 +1,270 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+const (
+	pexPeerTTL       = 30 * time.Minute // 节点记录的有效期
+	pexMinAskGap     = time.Minute      // 对同一节点、同一文件两次交换的最小间隔
+	pexMaxSwarmPeers = 200              // 每个文件保存的节点数上限
+	pexFirstRound    = 5 * time.Second  // 启动后首轮交换的等待时间
+)
+
+// PeerExchanger 可与节点交换某文件节点列表的传输实现（如 TCPTransport 的 PEX 命令）
+type PeerExchanger interface {
+	ExchangePeers(ctx context.Context, node core.Node, fileID core.FileID, self core.NodeID, port int) ([]core.Node, error)
+}
+
+// PEXFinder 通过节点交换（PEX）发现持有同一文件的节点：定期向已知节点询问其所知的节点，
+// 并在应答对方请求时登记对方，从而使节点群扩展到广播域之外而无需 DHT
+// 同时实现 transfer.PeerExchange，可直接设置为 TCPTransport.PEX
+type PEXFinder struct {
+	// Files 返回本节点提供、需要交换节点的文件（Start 前设置）
+	Files func() []core.FileID
+	// Candidates 返回尚不知道持有哪些文件的候选节点（如其他 Finder 发现的节点），
+	// 某文件没有已知节点时向其中若干个询问（Start 前设置）
+	Candidates func() []core.Node
+	// Interval 交换间隔，默认 1 分钟（Start 前设置）
+	Interval time.Duration
+	// PerRound 每轮每个文件最多询问的节点数，默认 8（Start 前设置）
+	PerRound int
+
+	ex          PeerExchanger
+	selfID      core.NodeID
+	servicePort int
+
+	mu      sync.Mutex
+	swarms  map[core.FileID]map[core.NodeID]core.Node
+	lastAsk map[string]time.Time
+	peers   *peerTable // 各文件节点的并集，用于事件订阅
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewPEXFinder 创建 PEX 发现器；selfID 为空或 servicePort 为 0 时只查询，不向对方宣告本节点
+func NewPEXFinder(ex PeerExchanger, selfID core.NodeID, servicePort int) *PEXFinder {
+	return &PEXFinder{
+		Interval:    time.Minute,
+		PerRound:    8,
+		ex:          ex,
+		selfID:      selfID,
+		servicePort: servicePort,
+		swarms:      make(map[core.FileID]map[core.NodeID]core.Node),
+		lastAsk:     make(map[string]time.Time),
+		peers:       newPeerTable(),
+		done:        make(chan struct{}),
+	}
+}
+
+func (p *PEXFinder) Start(ctx context.Context) error {
+	ctx, p.cancel = context.WithCancel(ctx)
+	go p.peers.run(ctx)
+	go func() {
+		defer close(p.done)
+		// 首轮稍作等待，让其他发现方式先找到候选节点
+		timer := time.NewTimer(min(p.Interval, pexFirstRound))
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+				p.round(ctx)
+				timer.Reset(p.Interval)
+			}
+		}
+	}()
+	return nil
+}
+
+func (p *PEXFinder) Stop() error {
+	if p.cancel != nil {
+		p.cancel()
+		<-p.done
+	}
+	return nil
+}
+
+// Nodes 返回所有文件的已知节点（去重）
+func (p *PEXFinder) Nodes() []core.Node {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expireLocked()
+	seen := make(map[core.NodeID]bool)
+	var out []core.Node
+	for _, sw := range p.swarms {
+		for id, n := range sw {
+			if !seen[id] {
+				seen[id] = true
+				out = append(out, n)
+			}
+		}
+	}
+	return out
+}
+
+// Subscribe 订阅节点事件：经交换新登记的节点为 PeerJoined，在所有文件中都超过有效期时为 PeerLost
+func (p *PEXFinder) Subscribe(buf int) (<-chan Event, func()) {
+	return p.peers.subscribe(buf)
+}
+
+// Providers 与已知持有 fileID 的节点（尚无时为候选节点）交换一次节点列表后返回全部已知节点
+func (p *PEXFinder) Providers(ctx context.Context, fileID core.FileID) ([]core.Node, error) {
+	targets := p.PeersFor(fileID, "")
+	if len(targets) == 0 && p.Candidates != nil {
+		targets = p.Candidates()
+	}
+	p.Exchange(ctx, fileID, targets)
+	out := p.PeersFor(fileID, "")
+	if len(out) == 0 {
+		return nil, fmt.Errorf("pex: no peers for %s", fileID)
+	}
+	return out, nil
+}
+
+// Exchange 并发向 nodes 询问持有 fileID 的节点，登记并返回新发现的节点
+// 距上次就同一文件询问同一节点不足 1 分钟的会被跳过
+func (p *PEXFinder) Exchange(ctx context.Context, fileID core.FileID, nodes []core.Node) []core.Node {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var found []core.Node
+	for _, n := range nodes {
+		if n.ID == p.selfID && n.ID != "" || !p.shouldAsk(n, fileID) {
+			continue
+		}
+		wg.Add(1)
+		go func(n core.Node) {
+			defer wg.Done()
+			actx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			peers, err := p.ex.ExchangePeers(actx, n, fileID, p.selfID, p.servicePort)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, x := range peers {
+				if p.add(fileID, x) {
+					found = append(found, x)
+				}
+			}
+		}(n)
+	}
+	wg.Wait()
+	return found
+}
+
+// PeersFor 返回已知持有 fileID 的节点（实现 transfer.PeerExchange）
+func (p *PEXFinder) PeersFor(fileID core.FileID, exclude core.NodeID) []core.Node {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expireLocked()
+	var out []core.Node
+	for _, n := range p.swarms[fileID] {
+		if n.ID != exclude || n.ID == "" {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// AddPeer 登记持有 fileID 的节点（实现 transfer.PeerExchange）
+func (p *PEXFinder) AddPeer(fileID core.FileID, n core.Node) {
+	p.add(fileID, n)
+}
+
+// add 登记节点，返回是否为新节点；每个文件的节点数达到上限后只更新已有节点
+// 来源 IP 与已知记录不同的同ID节点登记为不带ID的独立记录（见 mergeNode），不会改变已知记录的地址
+func (p *PEXFinder) add(fileID core.FileID, n core.Node) bool {
+	if n.ID == "" || n.ID == p.selfID || len(n.ServiceAddrs()) == 0 {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sw := p.swarms[fileID]
+	if sw == nil {
+		sw = make(map[core.NodeID]core.Node)
+		p.swarms[fileID] = sw
+	}
+	if n.LastSeen.IsZero() {
+		n.LastSeen = time.Now()
+	}
+	old, exists := sw[n.ID]
+	rec := mergeNode(old, n)
+	key := nodeKey(rec)
+	if key != n.ID {
+		_, exists = sw[key]
+	}
+	if !exists && len(sw) >= pexMaxSwarmPeers {
+		return false
+	}
+	sw[key] = rec
+	p.peers.put(rec, pexPeerTTL, mergeNode)
+	return !exists
+}
+
+func (p *PEXFinder) shouldAsk(n core.Node, fileID core.FileID) bool {
+	k := string(n.ID) + "|" + n.Address + "|" + string(fileID)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Since(p.lastAsk[k]) < pexMinAskGap {
+		return false
+	}
+	p.lastAsk[k] = time.Now()
+	return true
+}
+
+// round 对每个提供的文件，向若干已知节点（不足时补充候选节点）交换节点列表
+func (p *PEXFinder) round(ctx context.Context) {
+	if p.Files == nil {
+		return
+	}
+	var candidates []core.Node
+	if p.Candidates != nil {
+		candidates = p.Candidates()
+	}
+	for _, id := range p.Files() {
+		targets := p.PeersFor(id, "")
+		rand.Shuffle(len(targets), func(i, j int) { targets[i], targets[j] = targets[j], targets[i] })
+		if len(targets) < p.PerRound {
+			rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+			targets = append(targets, candidates...)
+		}
+		if len(targets) > p.PerRound {
+			targets = targets[:p.PerRound]
+		}
+		p.Exchange(ctx, id, targets)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+	p.mu.Lock()
+	for k, t := range p.lastAsk {
+		if time.Since(t) > pexMinAskGap {
+			delete(p.lastAsk, k)
+		}
+	}
+	p.mu.Unlock()
+}
+
+func (p *PEXFinder) expireLocked() {
+	cutoff := time.Now().Add(-pexPeerTTL)
+	for fid, sw := range p.swarms {
+		for id, n := range sw {
+			if n.LastSeen.Before(cutoff) {
+				delete(sw, id)
+			}
+		}
+		if len(sw) == 0 {
+			delete(p.swarms, fid)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package discovery
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+func TestMergeNodeKeepsOtherSourcesApart(t *testing.T) {
+	known := core.Node{ID: "n1", Address: "10.0.0.1:9001", DiscoveryAddr: "10.0.0.1:7788", ServicePort: 9001,
+		Addrs: []string{"10.0.0.1:9001"}}
+	tests := []struct {
+		name      string
+		cur       core.Node
+		wantID    core.NodeID
+		wantAddrs []string
+	}{
+		{"same source merges addresses",
+			core.Node{ID: "n1", Address: "10.0.0.1:9001", DiscoveryAddr: "10.0.0.1:7788", ServicePort: 9001, Addrs: []string{"10.0.0.1:9001", "203.0.113.9:41000"}},
+			"n1", []string{"10.0.0.1:9001", "203.0.113.9:41000"}},
+		{"other source becomes a separate record",
+			core.Node{ID: "n1", Address: "10.0.0.66:9001", DiscoveryAddr: "10.0.0.66:7788", ServicePort: 9001, Addrs: []string{"10.0.0.66:9001"}},
+			"", []string{"10.0.0.66:9001"}},
+		{"other source without a discovery endpoint",
+			core.Node{ID: "n1", Address: "10.0.0.66:9001", ServicePort: 9001, Addrs: []string{"10.0.0.66:9001"}},
+			"", []string{"10.0.0.66:9001"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeNode(known, tt.cur)
+			if got.ID != tt.wantID || !slices.Equal(got.Addrs, tt.wantAddrs) {
+				t.Fatalf("merged = %+v, want ID %q addrs %v", got, tt.wantID, tt.wantAddrs)
+			}
+		})
+	}
+}
+
+func TestPEXAddDoesNotHijackKnownID(t *testing.T) {
+	p := NewPEXFinder(nil, "self", 9000)
+	events, unsubscribe := p.peers.subscribe(0)
+	defer unsubscribe()
+	const file = core.FileID("f1")
+	victim := core.Node{ID: "victim", Address: "10.0.0.1:9001", ServicePort: 9001, Addrs: []string{"10.0.0.1:9001"}}
+	if !p.add(file, victim) {
+		t.Fatal("first record not added")
+	}
+	// 另一主机自称同一节点：登记为独立记录，原记录的地址不变
+	forged := core.Node{ID: "victim", Address: "10.0.0.66:9001", ServicePort: 9001, Addrs: []string{"10.0.0.66:9001"}}
+	if !p.add(file, forged) {
+		t.Fatal("record from another source not added separately")
+	}
+	if p.add(file, forged) {
+		t.Fatal("repeated record from another source counted as new")
+	}
+
+	peers := p.PeersFor(file, "")
+	slices.SortFunc(peers, func(a, b core.Node) int { return len(b.ID) - len(a.ID) })
+	if len(peers) != 2 || peers[0].ID != "victim" || !slices.Equal(peers[0].Addrs, []string{"10.0.0.1:9001"}) ||
+		peers[1].ID != "" || peers[1].Address != "10.0.0.66:9001" {
+		t.Fatalf("peers = %+v", peers)
+	}
+	if got := p.PeersFor(file, "victim"); len(got) != 1 || got[0].ID != "" {
+		t.Errorf("peers excluding the victim = %+v", got)
+	}
+
+	// 节点表中两条记录各自产生加入事件
+	evs := drain(events, 100*time.Millisecond)
+	if len(evs) != 2 || evs[0].Type != PeerJoined || evs[1].Type != PeerJoined || evs[1].Node.Address != "10.0.0.66:9001" {
+		t.Errorf("events = %+v", evs)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package discovery
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reuseControl 允许多个进程绑定同一UDP端口（多个节点/系统 mDNS 响应器共存）
+func reuseControl(network, address string, c syscall.RawConn) error {
+	var ctrlErr error
+	if err := c.Control(func(fd uintptr) {
+		_ = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+		_ = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); err != nil {
+		ctrlErr = err
+	}
+	return ctrlErr
+}
+
+// broadcastControl 开启 SO_BROADCAST 以发送广播包
+func broadcastControl(network, address string, c syscall.RawConn) error {
+	var ctrlErr error
+	if err := c.Control(func(fd uintptr) {
+		_ = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_BROADCAST, 1)
+	}); err != nil {
+		ctrlErr = err
+	}
+	return ctrlErr
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package discovery
+
+import "syscall"
+
+// reuseControl Windows 仅支持 SO_REUSEADDR，语义已包含端口共享
+func reuseControl(network, address string, c syscall.RawConn) error {
+	var ctrlErr error
+	if err := c.Control(func(fd uintptr) {
+		_ = syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	}); err != nil {
+		ctrlErr = err
+	}
+	return ctrlErr
+}
+
+// broadcastControl 开启 SO_BROADCAST 以发送广播包
+func broadcastControl(network, address string, c syscall.RawConn) error {
+	var ctrlErr error
+	if err := c.Control(func(fd uintptr) {
+		_ = syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+	}); err != nil {
+		ctrlErr = err
+	}
+	return ctrlErr
+}
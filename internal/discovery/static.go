@@ -0,0 +1,182 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// Greeter 可向节点询问其身份与服务地址的传输实现（如 TCPTransport 的 HELLO）
+type Greeter interface {
+	Hello(ctx context.Context, node core.Node) (core.Node, error)
+}
+
+// FileChecker 可询问节点是否提供某文件的传输实现（如 TCPTransport 的 META）
+type FileChecker interface {
+	HasFile(ctx context.Context, node core.Node, fileID core.FileID) (bool, error)
+}
+
+// staticMaxFileProbes 按文件查找时最多询问的目标数（按目标顺序）
+const staticMaxFileProbes = 32
+
+// StaticFinder 定期探测一组已知节点（配置的引导节点与持久化的节点缓存），
+// 用于广播不可达的跨网段场景以及重启后快速重连
+type StaticFinder struct {
+	// SelfID 本节点ID，探测到自身时忽略（Start 前设置）
+	SelfID core.NodeID
+	// Interval 探测间隔，默认 30 秒（Start 前设置）
+	Interval time.Duration
+	// OnResult 每次探测后回调，target 为探测目标，n 为应答节点（失败时为零值）（Start 前设置）
+	OnResult func(target, n core.Node, err error)
+
+	greeter Greeter
+	targets []core.Node
+
+	peers  *peerTable
+	cancel context.CancelFunc
+	done   chan struct{}
+	probed chan struct{}
+}
+
+// NewStaticFinder 创建静态节点探测器，targets 只需提供服务地址，ID 可为空
+func NewStaticFinder(g Greeter, targets []core.Node) *StaticFinder {
+	return &StaticFinder{
+		Interval: 30 * time.Second,
+		greeter:  g,
+		targets:  targets,
+		peers:    newPeerTable(),
+		done:     make(chan struct{}),
+		probed:   make(chan struct{}),
+	}
+}
+
+func (s *StaticFinder) Start(ctx context.Context) error {
+	ctx, s.cancel = context.WithCancel(ctx)
+	go func() {
+		defer close(s.done)
+		defer s.peers.close()
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		s.Probe(ctx)
+		close(s.probed)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.Probe(ctx)
+			}
+		}
+	}()
+	return nil
+}
+
+// Probed 返回 Start 后首轮探测完成时关闭的通道，一次性扫描可据此等待结果
+func (s *StaticFinder) Probed() <-chan struct{} {
+	return s.probed
+}
+
+func (s *StaticFinder) Stop() error {
+	if s.cancel != nil {
+		s.cancel()
+		<-s.done
+	}
+	return nil
+}
+
+// Nodes 返回最近一次探测中应答的节点
+func (s *StaticFinder) Nodes() []core.Node {
+	return s.peers.list()
+}
+
+// Subscribe 订阅节点事件：目标首次应答时为 PeerJoined，一轮探测中未应答时为 PeerLost
+func (s *StaticFinder) Subscribe(buf int) (<-chan Event, func()) {
+	return s.peers.subscribe(buf)
+}
+
+// Probe 立即并发探测全部目标，完成后返回；一次性扫描时可直接调用而无需 Start
+// 应答的节点立即可见，全部完成后未应答的节点被移除
+func (s *StaticFinder) Probe(ctx context.Context) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	alive := make(map[core.NodeID]core.Node)
+	for _, t := range s.targets {
+		wg.Add(1)
+		go func(t core.Node) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			n, err := s.greeter.Hello(pctx, t)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil && n.ID == s.SelfID {
+				return
+			}
+			if s.OnResult != nil {
+				s.OnResult(t, n, err)
+			}
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			alive[n.ID] = n
+			mu.Unlock()
+			s.peers.put(n, 0, nil)
+		}(t)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+	s.peers.retain(func(n core.Node) bool {
+		_, ok := alive[n.ID]
+		return ok
+	})
+}
+
+// Providers 并发询问各目标是否提供 fileID（需要 greeter 实现 FileChecker），返回提供者
+func (s *StaticFinder) Providers(ctx context.Context, fileID core.FileID) ([]core.Node, error) {
+	fc, ok := s.greeter.(FileChecker)
+	if !ok {
+		return nil, errors.New("static: provider lookup unsupported")
+	}
+	targets := s.targets
+	if len(targets) > staticMaxFileProbes {
+		targets = targets[:staticMaxFileProbes]
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var out []core.Node
+	for _, t := range targets {
+		if t.ID == s.SelfID && t.ID != "" {
+			continue
+		}
+		wg.Add(1)
+		go func(t core.Node) {
+			defer wg.Done()
+			// 逐个地址询问，返回的节点以应答的地址为首选
+			for _, a := range t.ServiceAddrs() {
+				n := t
+				n.Address = a
+				if has, err := fc.HasFile(ctx, n, fileID); err == nil && has {
+					mu.Lock()
+					out = append(out, n)
+					mu.Unlock()
+					return
+				}
+			}
+		}(t)
+	}
+	wg.Wait()
+	if len(out) == 0 {
+		return nil, fmt.Errorf("static: no peer has %s", fileID)
+	}
+	return out, nil
+}
@@ -0,0 +1,352 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/tracker"
+)
+
+const (
+	trackerTimeout     = 10 * time.Second
+	maxTrackerResponse = 1 << 20
+)
+
+// TrackerFinder 通过 tracker（见 internal/tracker）发现节点与文件持有者，用于广播、组播不通的跨网段环境
+// 服务模式下定期向各 tracker 登记本节点提供的文件；两种模式都会定期拉取节点列表
+type TrackerFinder struct {
+	// Files 返回本节点提供的文件，为空时只登记节点本身（Start 前设置）
+	Files func() []core.FileID
+	// Addrs 额外宣告的服务地址（host:port）（Start 前设置）
+	Addrs []string
+	// Interval 登记与拉取节点列表的间隔，默认 30 秒，tracker 建议的间隔更长时以其为准（Start 前设置）
+	Interval time.Duration
+	// Client 发送请求的 HTTP 客户端，默认使用 10 秒超时的客户端
+	Client *http.Client
+
+	urls        []string
+	selfID      core.NodeID
+	servicePort int
+	peers       *peerTable
+
+	mu       sync.Mutex
+	interval time.Duration     // 实际使用的间隔
+	secrets  map[string]string // 各 tracker 分配的登记凭据，续期与注销时携带
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewTrackerFinder 创建服务模式的 tracker Finder，servicePort 为 TCP 传输服务端口
+// trackers 为 tracker 地址，可省略 http:// 前缀
+func NewTrackerFinder(trackers []string, selfID core.NodeID, servicePort int) *TrackerFinder {
+	t := &TrackerFinder{
+		Interval:    tracker.DefaultInterval,
+		Client:      &http.Client{Timeout: trackerTimeout},
+		selfID:      selfID,
+		servicePort: servicePort,
+		peers:       newPeerTable(),
+		done:        make(chan struct{}),
+	}
+	for _, u := range trackers {
+		if u = strings.TrimSpace(u); u == "" {
+			continue
+		}
+		if !strings.Contains(u, "://") {
+			u = "http://" + u
+		}
+		t.urls = append(t.urls, strings.TrimRight(u, "/"))
+	}
+	return t
+}
+
+// NewTrackerFinderQuery 创建只查询、不登记自身的 tracker Finder
+func NewTrackerFinderQuery(trackers []string) *TrackerFinder {
+	return NewTrackerFinder(trackers, "", 0)
+}
+
+func (t *TrackerFinder) Start(ctx context.Context) error {
+	if len(t.urls) == 0 {
+		return errors.New("tracker: no tracker configured")
+	}
+	ctx, t.cancel = context.WithCancel(ctx)
+	t.interval = t.Interval
+	go t.peers.run(ctx)
+	go func() {
+		defer close(t.done)
+		for {
+			t.round(ctx)
+			t.mu.Lock()
+			d := t.interval
+			t.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d):
+			}
+		}
+	}()
+	return nil
+}
+
+func (t *TrackerFinder) Stop() error {
+	if t.cancel == nil {
+		return nil
+	}
+	t.cancel()
+	<-t.done
+	if t.announcing() {
+		// 尽力注销，让其他节点不必等待 tracker 超时
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, u := range t.urls {
+			_, _ = t.announce(ctx, u, tracker.AnnounceRequest{ID: t.selfID, Leave: true})
+		}
+	}
+	return nil
+}
+
+func (t *TrackerFinder) Nodes() []core.Node {
+	return t.peers.list()
+}
+
+// Subscribe 订阅节点事件：节点出现在 tracker 的节点列表中时为 PeerJoined，从所有 tracker 消失时为 PeerLost
+func (t *TrackerFinder) Subscribe(buf int) (<-chan Event, func()) {
+	return t.peers.subscribe(buf)
+}
+
+// Providers 并发询问各 tracker 持有 fileID 的节点
+func (t *TrackerFinder) Providers(ctx context.Context, fileID core.FileID) ([]core.Node, error) {
+	q := url.Values{"file": {string(fileID)}}
+	if t.selfID != "" {
+		q.Set("exclude", string(t.selfID))
+	}
+	nodes, err := t.query(ctx, "/v1/providers?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("tracker: no providers for %s", fileID)
+	}
+	return nodes, nil
+}
+
+func (t *TrackerFinder) announcing() bool {
+	return t.selfID != "" && t.servicePort > 0
+}
+
+// SetAddrs 更新额外宣告的服务地址，下一轮登记生效
+func (t *TrackerFinder) SetAddrs(addrs []string) {
+	t.mu.Lock()
+	t.Addrs = slices.Clone(addrs)
+	t.mu.Unlock()
+}
+
+// round 向各 tracker 登记（服务模式）并刷新节点列表
+func (t *TrackerFinder) round(ctx context.Context) {
+	if t.announcing() {
+		t.mu.Lock()
+		req := tracker.AnnounceRequest{ID: t.selfID, Port: t.servicePort, Addrs: t.Addrs}
+		t.mu.Unlock()
+		if t.Files != nil {
+			req.Files = t.Files()
+			if len(req.Files) > tracker.MaxFiles {
+				req.Files = req.Files[:tracker.MaxFiles]
+			}
+		}
+		for _, u := range t.urls {
+			resp, err := t.announce(ctx, u, req)
+			if err != nil || resp.Interval <= 0 {
+				continue
+			}
+			if d := time.Duration(resp.Interval) * time.Second; d > t.Interval {
+				t.mu.Lock()
+				t.interval = d
+				t.mu.Unlock()
+			}
+		}
+	}
+
+	q := url.Values{}
+	if t.selfID != "" {
+		q.Set("exclude", string(t.selfID))
+	}
+	nodes, err := t.query(ctx, "/v1/nodes?"+q.Encode())
+	if err != nil {
+		return
+	}
+	seen := make(map[core.NodeID]bool, len(nodes))
+	for _, n := range nodes {
+		seen[n.ID] = true
+		t.peers.put(n, 0, nil)
+	}
+	t.peers.retain(func(n core.Node) bool { return seen[n.ID] })
+}
+
+// announce 向 base 登记或注销，携带并记录其分配的凭据
+func (t *TrackerFinder) announce(ctx context.Context, base string, req tracker.AnnounceRequest) (tracker.AnnounceResponse, error) {
+	var out tracker.AnnounceResponse
+	t.mu.Lock()
+	req.Secret = t.secrets[base]
+	t.mu.Unlock()
+	body, err := json.Marshal(req)
+	if err != nil {
+		return out, err
+	}
+	hr, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/v1/announce", bytes.NewReader(body))
+	if err != nil {
+		return out, err
+	}
+	hr.Header.Set("Content-Type", "application/json")
+	resp, err := t.Client.Do(hr)
+	if err != nil {
+		return out, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return out, err
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return out, nil
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxTrackerResponse)).Decode(&out); err != nil {
+		return out, err
+	}
+	if out.Secret != "" {
+		t.mu.Lock()
+		if t.secrets == nil {
+			t.secrets = make(map[string]string)
+		}
+		t.secrets[base] = out.Secret
+		t.mu.Unlock()
+	}
+	return out, nil
+}
+
+// query 并发向各 tracker 发送 GET 请求，合并返回的节点；全部失败时返回错误
+func (t *TrackerFinder) query(ctx context.Context, path string) ([]core.Node, error) {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		all  []core.Node
+		errs []error
+	)
+	for _, u := range t.urls {
+		wg.Add(1)
+		go func(base string) {
+			defer wg.Done()
+			nodes, err := t.get(ctx, base+path)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", base, err))
+				return
+			}
+			all = append(all, nodes...)
+		}(u)
+	}
+	wg.Wait()
+	if len(errs) == len(t.urls) {
+		return nil, errors.Join(errs...)
+	}
+	return MergeNodes(all), nil
+}
+
+func (t *TrackerFinder) get(ctx context.Context, u string) ([]core.Node, error) {
+	hr, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.Client.Do(hr)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+	var pr tracker.PeersResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxTrackerResponse)).Decode(&pr); err != nil {
+		return nil, err
+	}
+	if len(pr.Peers) > tracker.MaxPeers {
+		pr.Peers = pr.Peers[:tracker.MaxPeers]
+	}
+	host := hostOfURL(u)
+	var out []core.Node
+	for _, p := range pr.Peers {
+		if n, ok := trackerNode(p, host); ok && n.ID != t.selfID {
+			out = append(out, n)
+		}
+	}
+	return out, nil
+}
+
+// trackerNode 校验 tracker 返回的节点：丢弃格式不合法、未指定与组播地址
+func trackerNode(p tracker.Peer, trackerHost string) (core.Node, bool) {
+	if p.ID == "" || len(p.ID) > 256 {
+		return core.Node{}, false
+	}
+	var addrs []string
+	for _, a := range p.Addrs {
+		if len(addrs) == tracker.MaxAddrs {
+			break
+		}
+		host, port, err := net.SplitHostPort(a)
+		if err != nil || host == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil && (ip.IsUnspecified() || ip.IsMulticast()) {
+			continue
+		}
+		addrs = append(addrs, a)
+	}
+	if len(addrs) == 0 {
+		return core.Node{}, false
+	}
+	_, port, _ := net.SplitHostPort(addrs[0])
+	sp, _ := strconv.Atoi(port)
+	lastSeen := p.LastSeen
+	if lastSeen.IsZero() || lastSeen.After(time.Now()) {
+		lastSeen = time.Now()
+	}
+	return core.Node{
+		ID:            p.ID,
+		Address:       addrs[0],
+		ServicePort:   sp,
+		Addrs:         addrs,
+		DiscoveryAddr: trackerHost,
+		LastSeen:      lastSeen,
+		Status:        "tracker",
+	}, true
+}
+
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("tracker: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
+
+func hostOfURL(u string) string {
+	if pu, err := url.Parse(u); err == nil {
+		return pu.Host
+	}
+	return ""
+}
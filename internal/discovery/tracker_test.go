@@ -0,0 +1,66 @@
+package discovery
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/tracker"
+)
+
+func TestTrackerFinderAgainstServer(t *testing.T) {
+	srv := tracker.NewServer()
+	srv.Interval = 0 // 不建议更长的续期间隔，使用 Finder 自己的间隔
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	seeder := NewTrackerFinder([]string{ts.Listener.Addr().String()}, "seeder", 9001)
+	seeder.Interval = 100 * time.Millisecond
+	seeder.Files = func() []core.FileID { return []core.FileID{"f1"} }
+	if err := seeder.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer seeder.Stop()
+
+	query := NewTrackerFinderQuery([]string{ts.URL})
+	query.Interval = 100 * time.Millisecond
+	if err := query.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer query.Stop()
+	events, unsub := query.Subscribe(0)
+	defer unsub()
+
+	ev := waitEvent(t, events, PeerJoined, "seeder", 3*time.Second)
+	if ev.Node.Address != "127.0.0.1:9001" {
+		t.Errorf("seeder address = %q, want 127.0.0.1:9001", ev.Node.Address)
+	}
+
+	provs, err := query.Providers(ctx, "f1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provs) != 1 || provs[0].ID != "seeder" || provs[0].Address != "127.0.0.1:9001" {
+		t.Fatalf("providers of f1 = %+v", provs)
+	}
+	if _, err := query.Providers(ctx, "missing"); err == nil {
+		t.Error("providers of an unknown file: want error")
+	}
+	// 服务模式的 Finder 查询时排除自身
+	if _, err := seeder.Providers(ctx, "f1"); err == nil {
+		t.Error("seeder found itself as provider")
+	}
+
+	// 停止时注销，查询方在下一轮拉取后得到 PeerLost
+	if err := seeder.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Len() != 0 {
+		t.Errorf("tracker still lists %d nodes after leave", srv.Len())
+	}
+	waitEvent(t, events, PeerLost, "seeder", 3*time.Second)
+}
@@ -1,8 +1,9 @@
 package index
 
 import (
+	"encoding/hex"
 	"fmt"
-	"math"
+	"os"
 	"path/filepath"
 	"time"
 
@@ -10,9 +11,15 @@ import (
 )
 
 // BuildFileIndex 读取文件，计算哈希，生成文件元信息与分片列表
-// 返回 FileInfo 和对应的 ChunkInfo 列表
-func BuildFileIndex(filePath string, chunkSize int64) (core.FileInfo, []core.ChunkInfo, error) {
-	fileHash, size, err := ComputeFileSHA256(filePath)
+// 返回 FileInfo 和对应的 ChunkInfo 列表；algo 为空时使用默认算法
+func BuildFileIndex(filePath string, chunkSize int64, algo core.HashAlgo) (core.FileInfo, []core.ChunkInfo, error) {
+	algo = algo.OrDefault()
+	// 先记录文件状态再计算哈希：哈希期间发生的修改会在服务前被识别
+	st, err := os.Stat(filePath)
+	if err != nil {
+		return core.FileInfo{}, nil, err
+	}
+	fileHash, size, err := ComputeFileHash(algo, filePath)
 	if err != nil {
 		return core.FileInfo{}, nil, err
 	}
@@ -20,7 +27,7 @@ func BuildFileIndex(filePath string, chunkSize int64) (core.FileInfo, []core.Chu
 	if chunkSize <= 0 {
 		chunkSize = 4 * 1024 * 1024 // 默认 4MB
 	}
-	chunkCount := int(int64(math.Ceil(float64(size) / float64(chunkSize))))
+	chunkCount := ChunkCount(size, chunkSize)
 
 	fi := core.FileInfo{
 		ID:         core.GenerateFileID(filePath, size),
@@ -28,10 +35,13 @@ func BuildFileIndex(filePath string, chunkSize int64) (core.FileInfo, []core.Chu
 		Path:       filePath,
 		Size:       size,
 		Hash:       fileHash,
+		HashAlgo:   algo,
 		ChunkSize:  chunkSize,
 		ChunkCount: chunkCount,
 		CreatedAt:  time.Now(),
 		Description:"",
+		ModTime:    st.ModTime(),
+		Inode:      fileInode(st),
 	}
 
 	chunks := make([]core.ChunkInfo, 0, chunkCount)
@@ -43,7 +53,7 @@ func BuildFileIndex(filePath string, chunkSize int64) (core.FileInfo, []core.Chu
 			sz = remaining
 		}
 		cid := core.GenerateChunkID(fi.ID, i)
-		chash, err := ComputeChunkSHA256(filePath, offset, sz)
+		chash, err := ComputeChunkHash(algo, filePath, offset, sz)
 		if err != nil { return core.FileInfo{}, nil, err }
 		chunks = append(chunks, core.ChunkInfo{
 			ID:     cid,
@@ -60,4 +70,62 @@ func BuildFileIndex(filePath string, chunkSize int64) (core.FileInfo, []core.Chu
 		return core.FileInfo{}, nil, fmt.Errorf("size mismatch: got offset=%d, size=%d", offset, size)
 	}
 	return fi, chunks, nil
-}
\ No newline at end of file
+}
+
+// chunkIDSize 分片ID解码后的字节数（见 core.GenerateChunkID）
+const chunkIDSize = 8
+
+// ChunkCount 返回大小为 size 的文件按 chunkSize 切分的分片数，即 ceil(size/chunkSize)
+func ChunkCount(size, chunkSize int64) int {
+	n := size / chunkSize
+	if size%chunkSize != 0 {
+		n++
+	}
+	return int(n)
+}
+
+// ValidateChunks 校验分片列表与文件元信息是否自洽（来自远端或外部导入的索引在使用前应先校验）：
+// 分片数由大小与分片大小决定，除最后一个分片外大小都等于 ChunkSize，分片ID与哈希须能按声明的算法解码
+func ValidateChunks(fi core.FileInfo, chunks []core.ChunkInfo) error {
+	if fi.ID == "" || fi.Size < 0 || fi.ChunkSize <= 0 {
+		return fmt.Errorf("invalid file info: id=%q size=%d chunkSize=%d", fi.ID, fi.Size, fi.ChunkSize)
+	}
+	h, err := core.NewHasher(fi.HashAlgo)
+	if err != nil {
+		return err
+	}
+	algo := fi.HashAlgo.OrDefault()
+	if want := ChunkCount(fi.Size, fi.ChunkSize); fi.ChunkCount != want || len(chunks) != want {
+		return fmt.Errorf("chunk count mismatch: got %d (declared %d), want %d for size %d / chunk size %d",
+			len(chunks), fi.ChunkCount, want, fi.Size, fi.ChunkSize)
+	}
+	if fi.Hash != "" && !isDigest(fi.Hash, h.Size()) {
+		return fmt.Errorf("file hash is not a %s digest", algo)
+	}
+	var offset int64
+	for i, ch := range chunks {
+		if ch.FileID != fi.ID || ch.Index != i || ch.Offset != offset || ch.Size != min(fi.ChunkSize, fi.Size-offset) {
+			return fmt.Errorf("invalid chunk %d", i)
+		}
+		if !isDigest(string(ch.ID), chunkIDSize) {
+			return fmt.Errorf("chunk %d: invalid chunk id %q", i, ch.ID)
+		}
+		if !isDigest(ch.Hash, h.Size()) {
+			return fmt.Errorf("chunk %d: hash is not a %s digest", i, algo)
+		}
+		offset += ch.Size
+	}
+	if offset != fi.Size {
+		return fmt.Errorf("size mismatch: got offset=%d, size=%d", offset, fi.Size)
+	}
+	return nil
+}
+
+// isDigest 判断 s 是否为 size 字节的十六进制串
+func isDigest(s string, size int) bool {
+	if len(s) != 2*size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
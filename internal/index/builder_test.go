@@ -0,0 +1,73 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// buildIndex 为 size 字节的临时文件建立索引
+func buildIndex(t *testing.T, size int, chunkSize int64, algo core.HashAlgo) (core.FileInfo, []core.ChunkInfo) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, []byte(strings.Repeat("ripplego", size/8+1)[:size]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fi, chunks, err := BuildFileIndex(path, chunkSize, algo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi, chunks
+}
+
+func TestValidateChunks(t *testing.T) {
+	for _, algo := range []core.HashAlgo{core.HashSHA256, core.HashBLAKE3, core.HashXXH64} {
+		fi, chunks := buildIndex(t, 100, 32, algo)
+		if err := ValidateChunks(fi, chunks); err != nil {
+			t.Errorf("%s: built index rejected: %v", algo, err)
+		}
+	}
+	if fi, chunks := buildIndex(t, 0, 32, ""); ValidateChunks(fi, chunks) != nil || len(chunks) != 0 {
+		t.Errorf("empty file: %d chunks, err = %v", len(chunks), ValidateChunks(fi, chunks))
+	}
+
+	fi, chunks := buildIndex(t, 100, 32, core.HashSHA256)
+	tests := []struct {
+		name   string
+		mutate func(fi *core.FileInfo, chunks []core.ChunkInfo) []core.ChunkInfo
+		want   string
+	}{
+		{"declared count differs", func(fi *core.FileInfo, c []core.ChunkInfo) []core.ChunkInfo { fi.ChunkCount = 5; return c }, "chunk count"},
+		{"missing chunk", func(fi *core.FileInfo, c []core.ChunkInfo) []core.ChunkInfo { fi.ChunkCount = 3; return c[:3] }, "chunk count"},
+		{"count for another chunk size", func(fi *core.FileInfo, c []core.ChunkInfo) []core.ChunkInfo { fi.ChunkSize = 64; return c }, "chunk count"},
+		{"short middle chunk", func(_ *core.FileInfo, c []core.ChunkInfo) []core.ChunkInfo {
+			c[1].Size = 16
+			c[2].Offset, c[3].Offset = 48, 80
+			c[3].Size = 20
+			return c
+		}, "invalid chunk 1"},
+		{"oversized last chunk", func(_ *core.FileInfo, c []core.ChunkInfo) []core.ChunkInfo { c[3].Size = 32; return c }, "invalid chunk 3"},
+		{"wrong index", func(_ *core.FileInfo, c []core.ChunkInfo) []core.ChunkInfo { c[2].Index = 5; return c }, "invalid chunk 2"},
+		{"chunk id not hex", func(_ *core.FileInfo, c []core.ChunkInfo) []core.ChunkInfo { c[0].ID = "zzzzzzzzzzzzzzzz"; return c }, "invalid chunk id"},
+		{"chunk id too long", func(_ *core.FileInfo, c []core.ChunkInfo) []core.ChunkInfo { c[0].ID += "00"; return c }, "invalid chunk id"},
+		{"hash not hex", func(_ *core.FileInfo, c []core.ChunkInfo) []core.ChunkInfo {
+			c[1].Hash = strings.Repeat("g", 64)
+			return c
+		}, "not a sha256 digest"},
+		{"hash of another algorithm", func(_ *core.FileInfo, c []core.ChunkInfo) []core.ChunkInfo { c[1].Hash = c[1].Hash[:16]; return c }, "not a sha256 digest"},
+		{"file hash of another algorithm", func(fi *core.FileInfo, c []core.ChunkInfo) []core.ChunkInfo { fi.Hash = fi.Hash[:16]; return c }, "file hash"},
+		{"unknown algorithm", func(fi *core.FileInfo, c []core.ChunkInfo) []core.ChunkInfo { fi.HashAlgo = "md5"; return c }, "unsupported hash algorithm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, c := fi, append([]core.ChunkInfo(nil), chunks...)
+			c = tt.mutate(&f, c)
+			if err := ValidateChunks(f, c); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,21 +1,25 @@
 package index
 
 import (
-	"crypto/sha256"
 	"encoding/hex"
 	"io"
 	"os"
+
+	"github.com/ripplego/ripplego/internal/core"
 )
 
-// ComputeFileSHA256 计算文件的SHA-256哈希，返回十六进制字符串
-func ComputeFileSHA256(path string) (string, int64, error) {
+// ComputeFileHash 使用指定算法计算文件哈希，返回十六进制字符串与文件大小
+func ComputeFileHash(algo core.HashAlgo, path string) (string, int64, error) {
+	h, err := core.NewHasher(algo)
+	if err != nil {
+		return "", 0, err
+	}
 	f, err := os.Open(path)
 	if err != nil {
 		return "", 0, err
 	}
 	defer f.Close()
 
-	h := sha256.New()
 	size, err := io.Copy(h, f)
 	if err != nil {
 		return "", 0, err
@@ -23,14 +27,25 @@ func ComputeFileSHA256(path string) (string, int64, error) {
 	return hex.EncodeToString(h.Sum(nil)), size, nil
 }
 
-// ComputeChunkSHA256 计算指定偏移与长度的分片哈希
-func ComputeChunkSHA256(path string, offset int64, size int64) (string, error) {
+// ComputeChunkHash 使用指定算法计算指定偏移与长度的分片哈希
+func ComputeChunkHash(algo core.HashAlgo, path string, offset int64, size int64) (string, error) {
+	h, err := core.NewHasher(algo)
+	if err != nil { return "", err }
 	f, err := os.Open(path)
 	if err != nil { return "", err }
 	defer f.Close()
 	if _, err := f.Seek(offset, io.SeekStart); err != nil { return "", err }
-	h := sha256.New()
 	lr := io.LimitReader(f, size)
 	if _, err := io.Copy(h, lr); err != nil { return "", err }
 	return hex.EncodeToString(h.Sum(nil)), nil
-}
\ No newline at end of file
+}
+
+// ComputeFileSHA256 计算文件的SHA-256哈希，返回十六进制字符串
+func ComputeFileSHA256(path string) (string, int64, error) {
+	return ComputeFileHash(core.HashSHA256, path)
+}
+
+// ComputeChunkSHA256 计算指定偏移与长度的分片哈希
+func ComputeChunkSHA256(path string, offset int64, size int64) (string, error) {
+	return ComputeChunkHash(core.HashSHA256, path, offset, size)
+}
@@ -0,0 +1,103 @@
+package index
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+const (
+	// PeerMaxAge 超过该时间未见的缓存节点在加载时被清理
+	PeerMaxAge = 7 * 24 * time.Hour
+	// peerMaxAddrs 每个节点保留的地址数上限
+	peerMaxAddrs = 8
+)
+
+// peerMu 串行化节点缓存的读-改-写
+var peerMu sync.Mutex
+
+// RecordPeerSeen 记录发现到的节点：合并服务地址（新地址在前）并更新最近发现时间
+// 无ID或不提供传输服务的节点不缓存
+func RecordPeerSeen(s PeerStore, n core.Node) error {
+	addrs := n.ServiceAddrs()
+	if n.ID == "" || len(addrs) == 0 {
+		return nil
+	}
+	peerMu.Lock()
+	defer peerMu.Unlock()
+	p, err := s.GetPeer(n.ID)
+	if err != nil {
+		p = core.PeerInfo{ID: n.ID}
+	}
+	for _, a := range p.Addrs {
+		if !slices.Contains(addrs, a) {
+			addrs = append(addrs, a)
+		}
+	}
+	if len(addrs) > peerMaxAddrs {
+		addrs = addrs[:peerMaxAddrs]
+	}
+	p.Addrs = addrs
+	if n.LastSeen.After(p.LastSeen) {
+		p.LastSeen = n.LastSeen
+	}
+	if p.LastSeen.IsZero() {
+		p.LastSeen = time.Now()
+	}
+	return s.SavePeer(p)
+}
+
+// RecordPeerResult 记录一次与节点的通信结果；n.ID 为空时按服务地址匹配已缓存的节点，匹配不到则忽略
+func RecordPeerResult(s PeerStore, n core.Node, err error) error {
+	peerMu.Lock()
+	defer peerMu.Unlock()
+	p, gerr := s.GetPeer(n.ID)
+	if n.ID == "" || gerr != nil {
+		var ok bool
+		if p, ok = findPeerByAddr(s, n.ServiceAddrs()); !ok {
+			return nil
+		}
+	}
+	if err != nil {
+		p.Failures++
+	} else {
+		p.Successes++
+		p.LastSeen = time.Now()
+	}
+	return s.SavePeer(p)
+}
+
+func findPeerByAddr(s PeerStore, addrs []string) (core.PeerInfo, bool) {
+	for _, p := range s.ListPeers() {
+		for _, a := range addrs {
+			if slices.Contains(p.Addrs, a) {
+				return p, true
+			}
+		}
+	}
+	return core.PeerInfo{}, false
+}
+
+// KnownPeers 返回缓存的节点，按成功率、最近发现时间降序排列；同时删除超过 maxAge 未见的节点
+func KnownPeers(s PeerStore, maxAge time.Duration) []core.PeerInfo {
+	peerMu.Lock()
+	defer peerMu.Unlock()
+	var out []core.PeerInfo
+	for _, p := range s.ListPeers() {
+		if maxAge > 0 && time.Since(p.LastSeen) > maxAge {
+			_ = s.DeletePeer(p.ID)
+			continue
+		}
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if ri, rj := out[i].SuccessRate(), out[j].SuccessRate(); ri != rj {
+			return ri > rj
+		}
+		return out[i].LastSeen.After(out[j].LastSeen)
+	})
+	return out
+}
@@ -0,0 +1,105 @@
+package index
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// revalidateMu 串行化重建索引，避免服务端的后台任务与 Watcher 重复计算哈希
+var revalidateMu sync.Mutex
+
+// Revalidate 校验已分享文件是否仍与索引一致
+// - 一致：返回原 FileInfo
+// - 已修改：按原分片大小与哈希算法重建索引并保存；若 FileID 变化则删除旧记录及其分片（见 RemoveFile），
+//   返回新 FileInfo 与 ErrFileChanged
+// 重建需读取整个文件，调用方不应在处理请求时同步调用
+// - 已缺失：撤回记录并返回 ErrFileMissing
+// 旧 FileID 对应的内容已不存在，因此后两种情况调用方都不应再按旧索引提供数据
+func Revalidate(store IndexStore, id core.FileID) (core.FileInfo, error) {
+	revalidateMu.Lock()
+	defer revalidateMu.Unlock()
+
+	fi, err := store.GetFile(id)
+	if err != nil {
+		return core.FileInfo{}, err
+	}
+	if fi.Withdrawn || fi.Path == "" {
+		return fi, ErrFileMissing
+	}
+	switch err := CheckFile(fi); err {
+	case nil:
+		return fi, nil
+	case ErrFileChanged:
+	case ErrFileMissing:
+		fi.Withdrawn = true
+		if serr := store.SaveFile(fi); serr != nil {
+			return fi, serr
+		}
+		return fi, err
+	default:
+		return fi, err
+	}
+
+	nfi, chunks, err := BuildFileIndex(fi.Path, fi.ChunkSize, fi.HashAlgo)
+	if err != nil {
+		return fi, err
+	}
+	nfi.Description = fi.Description
+	if err := store.SaveChunks(nfi.ID, chunks); err != nil {
+		return fi, err
+	}
+	if err := store.SaveFile(nfi); err != nil {
+		return fi, err
+	}
+	if nfi.ID != fi.ID {
+		if err := RemoveFile(store, fi.ID); err != nil {
+			return nfi, err
+		}
+	}
+	return nfi, ErrFileChanged
+}
+
+// Watcher 定期检查已分享文件，发现修改后自动重新分享、缺失时撤回
+// 采用轮询实现，跨平台且无需额外依赖
+type Watcher struct {
+	Store    IndexStore
+	Interval time.Duration
+	// OnChange 文件被重新索引或撤回时回调（可选），err 为 ErrFileChanged/ErrFileMissing 或重建失败原因
+	OnChange func(old, cur core.FileInfo, err error)
+}
+
+func NewWatcher(store IndexStore, interval time.Duration) *Watcher {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return &Watcher{Store: store, Interval: interval}
+}
+
+// Run 阻塞运行直到 ctx 结束
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.scan()
+		}
+	}
+}
+
+func (w *Watcher) scan() {
+	for _, fi := range w.Store.ListFiles() {
+		if fi.Withdrawn || fi.Path == "" || CheckFile(fi) == nil {
+			continue
+		}
+		cur, err := Revalidate(w.Store, fi.ID)
+		if w.OnChange != nil && err != nil {
+			w.OnChange(fi, cur, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package index
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+var (
+	// ErrFileChanged 文件在建立索引后被修改或替换
+	ErrFileChanged = errors.New("shared file changed since indexed")
+	// ErrFileMissing 文件已被删除或移走
+	ErrFileMissing = errors.New("shared file missing")
+)
+
+// CheckFile 对比文件当前状态（大小/修改时间/inode）与索引记录
+// 旧索引未记录修改时间或 inode 时仅比较可用字段
+func CheckFile(fi core.FileInfo) error {
+	st, err := os.Stat(fi.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrFileMissing
+		}
+		return err
+	}
+	if !st.Mode().IsRegular() || st.Size() != fi.Size {
+		return ErrFileChanged
+	}
+	if !fi.ModTime.IsZero() && !st.ModTime().Equal(fi.ModTime) {
+		return ErrFileChanged
+	}
+	if ino := fileInode(st); fi.Inode != 0 && ino != 0 && ino != fi.Inode {
+		return ErrFileChanged
+	}
+	return nil
+}
+
+// StampFile 将索引指向本地文件 path，并记录其当前状态（用于下载完成后继续分享）
+func StampFile(fi core.FileInfo, path string) (core.FileInfo, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fi, err
+	}
+	st, err := os.Stat(abs)
+	if err != nil {
+		return fi, err
+	}
+	if st.Size() != fi.Size {
+		return fi, ErrFileChanged
+	}
+	fi.Path = abs
+	fi.ModTime = st.ModTime()
+	fi.Inode = fileInode(st)
+	fi.Withdrawn = false
+	return fi, nil
+}
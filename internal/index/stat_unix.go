@@ -0,0 +1,16 @@
+//go:build !windows
+
+package index
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode 返回文件 inode，用于识别同路径下被替换（移动覆盖）的文件
+func fileInode(st os.FileInfo) uint64 {
+	if sys, ok := st.Sys().(*syscall.Stat_t); ok {
+		return uint64(sys.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package index
+
+import "os"
+
+// fileInode Windows 下 os.FileInfo 不携带文件索引号，仅依赖大小与修改时间判断
+func fileInode(st os.FileInfo) uint64 { return 0 }
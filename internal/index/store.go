@@ -2,7 +2,9 @@ package index
 
 import (
 	"errors"
+	"fmt"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	badger "github.com/dgraph-io/badger/v4"
@@ -16,12 +18,64 @@ type IndexStore interface {
 	SaveFile(info core.FileInfo) error
 	GetFile(id core.FileID) (core.FileInfo, error)
 	ListFiles() []core.FileInfo
+	DeleteFile(id core.FileID) error
 
 	SaveChunks(fileID core.FileID, chunks []core.ChunkInfo) error
 	GetChunks(fileID core.FileID) ([]core.ChunkInfo, error)
+	DeleteChunks(fileID core.FileID) error
 
 	SaveNodeChunks(m core.NodeChunkMap) error
 	GetNodeChunks(nodeID core.NodeID) (core.NodeChunkMap, error)
+	DeleteNodeChunks(nodeID core.NodeID) error
+}
+
+// PeerStore 已知节点缓存的存储接口
+type PeerStore interface {
+	SavePeer(p core.PeerInfo) error
+	GetPeer(id core.NodeID) (core.PeerInfo, error)
+	ListPeers() []core.PeerInfo
+	DeletePeer(id core.NodeID) error
+}
+
+// fileRemover 可在一次事务内删除文件记录、分片列表并从各节点-分片映射中移除该文件的分片
+type fileRemover interface {
+	RemoveFile(id core.FileID) error
+}
+
+// RemoveFile 删除文件记录及其分片列表（取消分享），记录不存在时不报错
+// 存储实现了 fileRemover（MemoryStore、BadgerStore）时原子删除并清理节点-分片映射，否则依次删除
+func RemoveFile(s IndexStore, id core.FileID) error {
+	if r, ok := s.(fileRemover); ok {
+		return r.RemoveFile(id)
+	}
+	if err := s.DeleteChunks(id); err != nil {
+		return err
+	}
+	return s.DeleteFile(id)
+}
+
+// chunkIDSet 返回分片ID集合
+func chunkIDSet(chunks []core.ChunkInfo) map[core.ChunkID]bool {
+	set := make(map[core.ChunkID]bool, len(chunks))
+	for _, c := range chunks {
+		set[c.ID] = true
+	}
+	return set
+}
+
+// withoutChunks 返回去掉 drop 中分片后的映射，changed 表示是否有分片被移除
+func withoutChunks(m core.NodeChunkMap, drop map[core.ChunkID]bool) (core.NodeChunkMap, bool) {
+	kept := make([]core.ChunkID, 0, len(m.ChunkIDs))
+	for _, id := range m.ChunkIDs {
+		if !drop[id] {
+			kept = append(kept, id)
+		}
+	}
+	if len(kept) == len(m.ChunkIDs) {
+		return m, false
+	}
+	m.ChunkIDs = kept
+	return m, true
 }
 
 // MemoryStore 内存实现，后续可替换为持久化
@@ -30,6 +84,7 @@ type MemoryStore struct {
 	files      map[core.FileID]core.FileInfo
 	chunks     map[core.FileID][]core.ChunkInfo
 	nodeChunks map[core.NodeID]core.NodeChunkMap
+	peers      map[core.NodeID]core.PeerInfo
 }
 
 func NewMemoryStore() *MemoryStore {
@@ -37,6 +92,7 @@ func NewMemoryStore() *MemoryStore {
 		files:      make(map[core.FileID]core.FileInfo),
 		chunks:     make(map[core.FileID][]core.ChunkInfo),
 		nodeChunks: make(map[core.NodeID]core.NodeChunkMap),
+		peers:      make(map[core.NodeID]core.PeerInfo),
 	}
 }
 
@@ -67,6 +123,13 @@ func (s *MemoryStore) ListFiles() []core.FileInfo {
 	return out
 }
 
+func (s *MemoryStore) DeleteFile(id core.FileID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, id)
+	return nil
+}
+
 func (s *MemoryStore) SaveChunks(fileID core.FileID, chunks []core.ChunkInfo) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -85,6 +148,31 @@ func (s *MemoryStore) GetChunks(fileID core.FileID) ([]core.ChunkInfo, error) {
 	return out, nil
 }
 
+func (s *MemoryStore) DeleteChunks(fileID core.FileID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.chunks, fileID)
+	return nil
+}
+
+// RemoveFile 在同一把锁内删除文件记录、分片列表与节点映射中的对应分片
+func (s *MemoryStore) RemoveFile(id core.FileID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	drop := chunkIDSet(s.chunks[id])
+	delete(s.chunks, id)
+	delete(s.files, id)
+	if len(drop) == 0 {
+		return nil
+	}
+	for nid, m := range s.nodeChunks {
+		if m, changed := withoutChunks(m, drop); changed {
+			s.nodeChunks[nid] = m
+		}
+	}
+	return nil
+}
+
 func (s *MemoryStore) SaveNodeChunks(m core.NodeChunkMap) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -102,16 +190,62 @@ func (s *MemoryStore) GetNodeChunks(nodeID core.NodeID) (core.NodeChunkMap, erro
 	return m, nil
 }
 
+func (s *MemoryStore) DeleteNodeChunks(nodeID core.NodeID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.nodeChunks, nodeID)
+	return nil
+}
+
+func (s *MemoryStore) SavePeer(p core.PeerInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.Addrs = append([]string(nil), p.Addrs...)
+	s.peers[p.ID] = p
+	return nil
+}
+
+func (s *MemoryStore) GetPeer(id core.NodeID) (core.PeerInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.peers[id]
+	if !ok {
+		return core.PeerInfo{}, errors.New("peer not found")
+	}
+	return p, nil
+}
+
+func (s *MemoryStore) ListPeers() []core.PeerInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]core.PeerInfo, 0, len(s.peers))
+	for _, p := range s.peers {
+		out = append(out, p)
+	}
+	return out
+}
+
+func (s *MemoryStore) DeletePeer(id core.NodeID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.peers, id)
+	return nil
+}
+
 // Badger 持久化实现
 // 数据布局：
 // - file/<fileID> -> gob(FileInfo)
 // - chunks/<fileID> -> gob([]ChunkInfo)
 // - nodechunks/<nodeID> -> gob(NodeChunkMap)
+// - peer/<nodeID> -> gob(PeerInfo)
 
 type BadgerStore struct {
 	db *badger.DB
 }
 
+// ErrStoreLocked 索引目录正被其他进程（如运行中的 serve）独占使用
+var ErrStoreLocked = errors.New("index store is in use by another process")
+
 func NewBadgerStore(dir string) (*BadgerStore, error) {
 	if dir == "" { dir = ".ripplego/index" }
 	abs, err := filepath.Abs(dir)
@@ -119,6 +253,8 @@ func NewBadgerStore(dir string) (*BadgerStore, error) {
 	opts := badger.DefaultOptions(abs)
 	opts = opts.WithLogger(badgerLogger{})
 	db, err := badger.Open(opts)
+	// Badger 未导出目录锁的错误，按其消息识别
+	if err != nil && strings.Contains(err.Error(), "Another process is using this Badger database") { return nil, fmt.Errorf("%w: %s", ErrStoreLocked, abs) }
 	if err != nil { return nil, err }
 	return &BadgerStore{db: db}, nil
 }
@@ -134,7 +270,8 @@ func (s *BadgerStore) SaveFile(info core.FileInfo) error {
 	b, err := encode(info)
 	if err != nil { return err }
 	return s.db.Update(func(txn *badger.Txn) error {
-		return txn.SetEntry(badger.NewEntry(key("file", string(info.ID)), b).WithTTL(0))
+		// 不设置 TTL：Badger 的 WithTTL(0) 会将过期时间设为当前时间，记录写入即失效
+		return txn.SetEntry(badger.NewEntry(key("file", string(info.ID)), b))
 	})
 }
 
@@ -167,6 +304,12 @@ func (s *BadgerStore) ListFiles() []core.FileInfo {
 	return out
 }
 
+func (s *BadgerStore) DeleteFile(id core.FileID) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key("file", string(id)))
+	})
+}
+
 func (s *BadgerStore) SaveChunks(fileID core.FileID, chunks []core.ChunkInfo) error {
 	b, err := encode(chunks)
 	if err != nil { return err }
@@ -185,6 +328,46 @@ func (s *BadgerStore) GetChunks(fileID core.FileID) ([]core.ChunkInfo, error) {
 	return out, err
 }
 
+func (s *BadgerStore) DeleteChunks(fileID core.FileID) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key("chunks", string(fileID)))
+	})
+}
+
+// RemoveFile 在一个事务内删除文件记录、分片列表并更新引用其分片的节点映射
+func (s *BadgerStore) RemoveFile(id core.FileID) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		var chunks []core.ChunkInfo
+		item, err := txn.Get(key("chunks", string(id)))
+		switch {
+		case err == nil:
+			if err := item.Value(func(val []byte) error { return decode(val, &chunks) }); err != nil { return err }
+		case !errors.Is(err, badger.ErrKeyNotFound):
+			return err
+		}
+		if err := txn.Delete(key("chunks", string(id))); err != nil { return err }
+		if err := txn.Delete(key("file", string(id))); err != nil { return err }
+		if len(chunks) == 0 { return nil }
+
+		drop := chunkIDSet(chunks)
+		var updated []core.NodeChunkMap
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		prefix := []byte("nodechunks/")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var m core.NodeChunkMap
+			if err := it.Item().Value(func(val []byte) error { return decode(val, &m) }); err != nil { continue }
+			if m, changed := withoutChunks(m, drop); changed { updated = append(updated, m) }
+		}
+		it.Close()
+		for _, m := range updated {
+			b, err := encode(m)
+			if err != nil { return err }
+			if err := txn.Set(key("nodechunks", string(m.NodeID)), b); err != nil { return err }
+		}
+		return nil
+	})
+}
+
 func (s *BadgerStore) SaveNodeChunks(m core.NodeChunkMap) error {
 	b, err := encode(m)
 	if err != nil { return err }
@@ -203,5 +386,53 @@ func (s *BadgerStore) GetNodeChunks(nodeID core.NodeID) (core.NodeChunkMap, erro
 	return out, err
 }
 
+func (s *BadgerStore) DeleteNodeChunks(nodeID core.NodeID) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key("nodechunks", string(nodeID)))
+	})
+}
+
+func (s *BadgerStore) SavePeer(p core.PeerInfo) error {
+	b, err := encode(p)
+	if err != nil { return err }
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key("peer", string(p.ID)), b)
+	})
+}
+
+func (s *BadgerStore) GetPeer(id core.NodeID) (core.PeerInfo, error) {
+	var out core.PeerInfo
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key("peer", string(id)))
+		if err != nil { return err }
+		return item.Value(func(val []byte) error { return decode(val, &out) })
+	})
+	return out, err
+}
+
+func (s *BadgerStore) ListPeers() []core.PeerInfo {
+	var out []core.PeerInfo
+	_ = s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte("peer/")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			_ = it.Item().Value(func(val []byte) error {
+				var p core.PeerInfo
+				if err := decode(val, &p); err == nil { out = append(out, p) }
+				return nil
+			})
+		}
+		return nil
+	})
+	return out
+}
+
+func (s *BadgerStore) DeletePeer(id core.NodeID) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key("peer", string(id)))
+	})
+}
+
 // gob 编解码工具与简易Logger
 // 为了避免引入额外依赖，这里用标准库gob持久化结构
\ No newline at end of file
@@ -0,0 +1,64 @@
+package index
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+func TestBadgerStoreFileRoundTripAndRemove(t *testing.T) {
+	dir := t.TempDir()
+	bs, err := NewBadgerStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi := core.FileInfo{ID: "f1", Name: "a.bin", Size: 10, ChunkSize: 4, ChunkCount: 3}
+	chunks := []core.ChunkInfo{{ID: "c0", FileID: "f1"}, {ID: "c1", FileID: "f1", Index: 1}, {ID: "c2", FileID: "f1", Index: 2}}
+	if err := bs.SaveFile(fi); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.SaveChunks(fi.ID, chunks); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.SaveNodeChunks(core.NodeChunkMap{NodeID: "n1", ChunkIDs: []core.ChunkID{"c0", "x", "c2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := bs.GetFile(fi.ID); err != nil || got.Name != fi.Name {
+		t.Fatalf("GetFile = %+v, %v", got, err)
+	}
+	if files := bs.ListFiles(); len(files) != 1 {
+		t.Fatalf("ListFiles = %+v", files)
+	}
+
+	// 第二个进程打开同一目录时报告目录被占用
+	if _, err := NewBadgerStore(dir); err == nil || !errors.Is(err, ErrStoreLocked) {
+		t.Errorf("second open: err = %v, want ErrStoreLocked", err)
+	}
+
+	if err := RemoveFile(bs, fi.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bs.GetFile(fi.ID); err == nil {
+		t.Error("file record left after RemoveFile")
+	}
+	if _, err := bs.GetChunks(fi.ID); err == nil {
+		t.Error("chunk list left after RemoveFile")
+	}
+	if m, err := bs.GetNodeChunks("n1"); err != nil || len(m.ChunkIDs) != 1 || m.ChunkIDs[0] != "x" {
+		t.Errorf("node chunks after RemoveFile = %+v, %v", m, err)
+	}
+
+	// 关闭后重新打开，记录仍然持久
+	if err := bs.SaveFile(fi); err != nil {
+		t.Fatal(err)
+	}
+	bs.Close()
+	if bs, err = NewBadgerStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+	if _, err := bs.GetFile(fi.ID); err != nil {
+		t.Errorf("file record lost after reopen: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package link
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// Scheme 分享链接的 URI scheme
+const Scheme = "ripplego"
+
+// Link 单个字符串即可分享文件的链接（类似 magnet URI）
+// 格式：
+//
+//	ripplego://<fileID>?name=<文件名>&size=<字节数>&hash=<算法>:<十六进制>&peer=<host:port>&peer=...
+//
+// - fileID：必填，即 share 输出的文件ID
+// - name/size/hash：可选，下载端用于默认输出文件名以及校验元数据与最终文件
+// - peer：可重复，已知持有该文件的节点传输地址（IPv6 使用 [addr]:port）
+type Link struct {
+	FileID   core.FileID
+	Name     string
+	Size     int64
+	HashAlgo core.HashAlgo
+	Hash     string
+	Peers    []string
+}
+
+// FromFile 根据文件索引与节点地址生成链接
+func FromFile(fi core.FileInfo, peers []string) Link {
+	return Link{
+		FileID:   fi.ID,
+		Name:     fi.Name,
+		Size:     fi.Size,
+		HashAlgo: fi.HashAlgo.OrDefault(),
+		Hash:     fi.Hash,
+		Peers:    append([]string(nil), peers...),
+	}
+}
+
+// IsLink 判断字符串是否为 ripplego:// 链接
+func IsLink(s string) bool {
+	return strings.HasPrefix(strings.ToLower(s), Scheme+"://")
+}
+
+func (l Link) String() string {
+	q := url.Values{}
+	if l.Name != "" {
+		q.Set("name", l.Name)
+	}
+	if l.Size > 0 {
+		q.Set("size", strconv.FormatInt(l.Size, 10))
+	}
+	if l.Hash != "" {
+		q.Set("hash", string(l.HashAlgo.OrDefault())+":"+l.Hash)
+	}
+	for _, p := range l.Peers {
+		q.Add("peer", p)
+	}
+	u := url.URL{Scheme: Scheme, Host: string(l.FileID), RawQuery: q.Encode()}
+	return u.String()
+}
+
+// Parse 解析 ripplego:// 链接
+func Parse(s string) (Link, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return Link{}, fmt.Errorf("invalid link: %w", err)
+	}
+	if !strings.EqualFold(u.Scheme, Scheme) {
+		return Link{}, fmt.Errorf("invalid link scheme: %q", u.Scheme)
+	}
+	id := strings.ToLower(u.Host)
+	if id == "" {
+		return Link{}, errors.New("invalid link: missing file id")
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return Link{}, fmt.Errorf("invalid link file id: %s", id)
+	}
+
+	q := u.Query()
+	l := Link{FileID: core.FileID(id), Name: q.Get("name")}
+	if v := q.Get("size"); v != "" {
+		if l.Size, err = strconv.ParseInt(v, 10, 64); err != nil || l.Size < 0 {
+			return Link{}, fmt.Errorf("invalid link size: %s", v)
+		}
+	}
+	if v := q.Get("hash"); v != "" {
+		algo, sum, ok := strings.Cut(v, ":")
+		if !ok {
+			algo, sum = "", v
+		}
+		if l.HashAlgo, err = core.ParseHashAlgo(algo); err != nil {
+			return Link{}, err
+		}
+		if _, err := hex.DecodeString(sum); err != nil {
+			return Link{}, fmt.Errorf("invalid link hash: %s", v)
+		}
+		l.Hash = strings.ToLower(sum)
+	}
+	for _, p := range q["peer"] {
+		if _, _, err := net.SplitHostPort(p); err != nil {
+			return Link{}, fmt.Errorf("invalid link peer %q: %w", p, err)
+		}
+		l.Peers = append(l.Peers, p)
+	}
+	return l, nil
+}
@@ -0,0 +1,103 @@
+package link
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+func TestStringParseRoundTrip(t *testing.T) {
+	tests := []Link{
+		{FileID: "0a1b2c"},
+		{
+			FileID:   "0a1b2c",
+			Name:     "报告 2024 & 附录=final?.pdf",
+			Size:     1 << 40,
+			HashAlgo: core.HashBLAKE3,
+			Hash:     "00ff" + strings.Repeat("ab", 30),
+			Peers:    []string{"192.0.2.1:7000", "[2001:db8::1]:7000", "host.example:7001"},
+		},
+		{FileID: "ffff", Name: "a/b#c%20d", HashAlgo: core.HashXXH64, Hash: "0123456789abcdef", Peers: []string{"10.0.0.1:1"}},
+	}
+	for _, l := range tests {
+		s := l.String()
+		if !IsLink(s) {
+			t.Errorf("IsLink(%q) = false", s)
+		}
+		got, err := Parse(s)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, l) {
+			t.Errorf("Parse(%q) = %+v, want %+v", s, got, l)
+		}
+	}
+}
+
+func TestFromFileDefaultsHashAlgo(t *testing.T) {
+	fi := core.FileInfo{ID: "abcd", Name: "old.bin", Size: 10, Hash: "beef"}
+	l := FromFile(fi, []string{"127.0.0.1:7000"})
+	if l.HashAlgo != core.HashSHA256 {
+		t.Fatalf("link of a pre-series index uses %q, want sha256", l.HashAlgo)
+	}
+	if !strings.Contains(l.String(), "hash=sha256%3Abeef") {
+		t.Fatalf("link %s does not name the hash algorithm", l)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Link
+	}{
+		{
+			"ripplego://ABCD?peer=192.0.2.1:7000&name=x&peer=%5B::1%5D:7001&peer=192.0.2.2:7000",
+			Link{FileID: "abcd", Name: "x", Peers: []string{"192.0.2.1:7000", "[::1]:7001", "192.0.2.2:7000"}},
+		},
+		{"  RippleGo://abcd?hash=BLAKE3:ABCD\n", Link{FileID: "abcd", HashAlgo: core.HashBLAKE3, Hash: "abcd"}},
+		// 未注明算法的哈希按默认算法
+		{"ripplego://abcd?hash=beef", Link{FileID: "abcd", HashAlgo: core.HashSHA256, Hash: "beef"}},
+		{"ripplego://abcd?name=a+b%26c&size=0", Link{FileID: "abcd", Name: "a b&c"}},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tests := []struct {
+		in, wantErr string
+	}{
+		{"magnet:?xt=urn:btih:abcd", "scheme"},
+		{"https://abcd?name=x", "scheme"},
+		{"abcd", "scheme"},
+		{"ripplego://?name=x", "missing file id"},
+		{"ripplego://not-hex", "file id"},
+		{"ripplego://abcd?size=-1", "size"},
+		{"ripplego://abcd?size=big", "size"},
+		{"ripplego://abcd?hash=md5:abcd", "unsupported hash algorithm"},
+		{"ripplego://abcd?hash=sha256:xyz", "invalid link hash"},
+		{"ripplego://abcd?hash=sha256:abc", "invalid link hash"},
+		{"ripplego://abcd?hash=:sha256:abcd", "invalid link hash"},
+		{"ripplego://abcd?peer=192.0.2.1:7000&peer=nohost", "invalid link peer"},
+		{"ripplego://abcd?peer=::1:7000", "invalid link peer"},
+	}
+	for _, tt := range tests {
+		if _, err := Parse(tt.in); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Parse(%q) err = %v, want %q", tt.in, err, tt.wantErr)
+		}
+	}
+	if IsLink("https://abcd") {
+		t.Error("IsLink accepted another scheme")
+	}
+}
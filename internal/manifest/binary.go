@@ -0,0 +1,193 @@
+package manifest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+const magic = "RGM\x00"
+
+// 解码时的长度上限，防止损坏或恶意文件导致过量分配
+const (
+	maxStringLen = 64 << 10
+	maxChunks    = 1 << 24
+)
+
+// WriteBinary 以紧凑二进制编码写出
+func (m Manifest) WriteBinary(w io.Writer) error {
+	fi := m.File
+	fileHash, err := hex.DecodeString(fi.Hash)
+	if err != nil {
+		return fmt.Errorf("manifest: file hash is not hex: %w", err)
+	}
+	for i, ch := range m.Chunks {
+		if ch.ID != core.GenerateChunkID(fi.ID, i) {
+			return fmt.Errorf("manifest: chunk %d id is not derivable, use JSON format", i)
+		}
+	}
+
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	var tmp [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) { bw.Write(tmp[:binary.PutUvarint(tmp[:], v)]) }
+	putBytes := func(b []byte) { putUvarint(uint64(len(b))); bw.Write(b) }
+
+	bw.WriteString(magic)
+	putUvarint(Version)
+	putBytes([]byte(fi.ID))
+	putBytes([]byte(fi.Name))
+	putBytes([]byte(fi.Description))
+	putBytes([]byte(fi.HashAlgo.OrDefault()))
+	putBytes(fileHash)
+	putUvarint(uint64(fi.Size))
+	putUvarint(uint64(fi.ChunkSize))
+	var created int64 // 0 表示未记录
+	if !fi.CreatedAt.IsZero() {
+		created = fi.CreatedAt.UnixNano()
+	}
+	bw.Write(tmp[:binary.PutVarint(tmp[:], created)])
+	putUvarint(uint64(len(m.Chunks)))
+	for i, ch := range m.Chunks {
+		h, err := hex.DecodeString(ch.Hash)
+		if err != nil {
+			return fmt.Errorf("manifest: chunk %d hash is not hex: %w", i, err)
+		}
+		putBytes(h)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	_, err = w.Write(sum[:])
+	return err
+}
+
+// binReader 读取时同步计算 CRC，并记录首个错误
+type binReader struct {
+	r   *bufio.Reader
+	crc hashWriter
+	err error
+}
+
+type hashWriter interface {
+	io.Writer
+	Sum32() uint32
+}
+
+func (b *binReader) ReadByte() (byte, error) {
+	c, err := b.r.ReadByte()
+	if err == nil {
+		b.crc.Write([]byte{c})
+	}
+	return c, err
+}
+
+func (b *binReader) uvarint() uint64 {
+	if b.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(b)
+	b.err = err
+	return v
+}
+
+func (b *binReader) varint() int64 {
+	if b.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(b)
+	b.err = err
+	return v
+}
+
+func (b *binReader) bytes() []byte {
+	n := b.uvarint()
+	if b.err != nil {
+		return nil
+	}
+	if n > maxStringLen {
+		b.err = errors.New("field too long")
+		return nil
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(b.r, buf); err != nil {
+		b.err = err
+		return nil
+	}
+	b.crc.Write(buf)
+	return buf
+}
+
+func readBinary(r *bufio.Reader) (Manifest, error) {
+	b := &binReader{r: r, crc: crc32.NewIEEE()}
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, head); err != nil {
+		return Manifest{}, fmt.Errorf("manifest: %w", err)
+	}
+	b.crc.Write(head)
+	if v := b.uvarint(); b.err == nil && v != Version {
+		return Manifest{}, fmt.Errorf("manifest: unsupported version %d", v)
+	}
+
+	var fi core.FileInfo
+	fi.ID = core.FileID(b.bytes())
+	fi.Name = string(b.bytes())
+	fi.Description = string(b.bytes())
+	fi.HashAlgo = core.HashAlgo(b.bytes())
+	fi.Hash = hex.EncodeToString(b.bytes())
+	fi.Size = int64(b.uvarint())
+	fi.ChunkSize = int64(b.uvarint())
+	if created := b.varint(); created != 0 {
+		fi.CreatedAt = time.Unix(0, created)
+	}
+	n := b.uvarint()
+	if b.err == nil && (n > maxChunks || fi.Size < 0 || fi.ChunkSize <= 0) {
+		b.err = errors.New("invalid header")
+	}
+	if b.err != nil {
+		return Manifest{}, fmt.Errorf("manifest: %w", b.err)
+	}
+	fi.ChunkCount = int(n)
+
+	// 分片数与大小是否一致由 Validate（index.ValidateChunks）检查；这里不按声明的分片数预先分配，
+	// 伪造的分片数只会在读到文件末尾时失败
+	chunks := make([]core.ChunkInfo, 0, min(n, 4096))
+	var offset int64
+	for i := 0; i < int(n); i++ {
+		sz := fi.ChunkSize
+		if rem := fi.Size - offset; rem < sz {
+			sz = rem
+		}
+		chunks = append(chunks, core.ChunkInfo{
+			ID:     core.GenerateChunkID(fi.ID, i),
+			FileID: fi.ID,
+			Index:  i,
+			Size:   sz,
+			Hash:   hex.EncodeToString(b.bytes()),
+			Offset: offset,
+		})
+		offset += sz
+	}
+	if b.err != nil {
+		return Manifest{}, fmt.Errorf("manifest: %w", b.err)
+	}
+
+	want := b.crc.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return Manifest{}, fmt.Errorf("manifest: %w", err)
+	}
+	if binary.BigEndian.Uint32(sum[:]) != want {
+		return Manifest{}, errors.New("manifest: checksum mismatch")
+	}
+	return Manifest{File: fi, Chunks: chunks}, nil
+}
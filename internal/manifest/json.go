@@ -0,0 +1,82 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+type jsonManifest struct {
+	Format  string      `json:"format"`
+	Version int         `json:"version"`
+	File    jsonFile    `json:"file"`
+	Chunks  []jsonChunk `json:"chunks"`
+}
+
+type jsonFile struct {
+	ID          core.FileID   `json:"id"`
+	Name        string        `json:"name"`
+	Size        int64         `json:"size"`
+	HashAlgo    core.HashAlgo `json:"hashAlgo"`
+	Hash        string        `json:"hash"`
+	ChunkSize   int64         `json:"chunkSize"`
+	ChunkCount  int           `json:"chunkCount"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	Description string        `json:"description,omitempty"`
+}
+
+type jsonChunk struct {
+	Index  int          `json:"index"`
+	ID     core.ChunkID `json:"id"`
+	Offset int64        `json:"offset"`
+	Size   int64        `json:"size"`
+	Hash   string       `json:"hash"`
+}
+
+// WriteJSON 以 JSON 编码写出
+func (m Manifest) WriteJSON(w io.Writer) error {
+	fi := m.File
+	jm := jsonManifest{
+		Format:  Format,
+		Version: Version,
+		File: jsonFile{
+			ID: fi.ID, Name: fi.Name, Size: fi.Size, HashAlgo: fi.HashAlgo.OrDefault(), Hash: fi.Hash,
+			ChunkSize: fi.ChunkSize, ChunkCount: fi.ChunkCount, CreatedAt: fi.CreatedAt, Description: fi.Description,
+		},
+		Chunks: make([]jsonChunk, 0, len(m.Chunks)),
+	}
+	for _, ch := range m.Chunks {
+		jm.Chunks = append(jm.Chunks, jsonChunk{Index: ch.Index, ID: ch.ID, Offset: ch.Offset, Size: ch.Size, Hash: ch.Hash})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jm)
+}
+
+func readJSON(r io.Reader) (Manifest, error) {
+	var jm jsonManifest
+	if err := json.NewDecoder(r).Decode(&jm); err != nil {
+		return Manifest{}, fmt.Errorf("manifest: %w", err)
+	}
+	if jm.Format != Format {
+		return Manifest{}, fmt.Errorf("manifest: unknown format %q", jm.Format)
+	}
+	if jm.Version != Version {
+		return Manifest{}, fmt.Errorf("manifest: unsupported version %d", jm.Version)
+	}
+	f := jm.File
+	m := Manifest{
+		File: core.FileInfo{
+			ID: f.ID, Name: f.Name, Size: f.Size, HashAlgo: f.HashAlgo.OrDefault(), Hash: f.Hash,
+			ChunkSize: f.ChunkSize, ChunkCount: f.ChunkCount, CreatedAt: f.CreatedAt, Description: f.Description,
+		},
+		Chunks: make([]core.ChunkInfo, 0, len(jm.Chunks)),
+	}
+	for _, ch := range jm.Chunks {
+		m.Chunks = append(m.Chunks, core.ChunkInfo{ID: ch.ID, FileID: f.ID, Index: ch.Index, Offset: ch.Offset, Size: ch.Size, Hash: ch.Hash})
+	}
+	return m, nil
+}
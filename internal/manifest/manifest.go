@@ -0,0 +1,131 @@
+// Package manifest 定义可移植的文件索引（manifest）格式，用于在无网络元数据获取的情况下
+// 交换某个文件的 FileInfo 与分片列表，例如作为附件随工单传递。
+//
+// manifest 只包含与节点无关的信息（文件ID、名称、大小、哈希、分片），
+// 不包含本地路径、修改时间、inode 等；导入后的索引没有本地路径，需要下载后才能作为源提供。
+//
+// 支持两种编码，读取时按首字节自动识别：
+//
+// JSON（便于阅读与编辑）：
+//
+//	{
+//	  "format": "ripplego-manifest",
+//	  "version": 1,
+//	  "file": {"id": "...", "name": "...", "size": 0, "hashAlgo": "sha256", "hash": "<hex>",
+//	           "chunkSize": 0, "chunkCount": 0, "createdAt": "RFC3339", "description": ""},
+//	  "chunks": [{"index": 0, "id": "...", "offset": 0, "size": 0, "hash": "<hex>"}, ...]
+//	}
+//
+// 二进制（紧凑，全部整数为 varint，字符串与字节串均为 uvarint 长度前缀）：
+//
+//	magic       4 bytes   "RGM\x00"
+//	version     uvarint   1
+//	fileID      string
+//	name        string
+//	description string
+//	hashAlgo    string
+//	hash        bytes     文件哈希原始字节（十六进制解码）
+//	size        uvarint
+//	chunkSize   uvarint
+//	createdAt   varint    Unix 纳秒，0 表示未记录
+//	chunkCount  uvarint
+//	chunkHash   bytes     × chunkCount，按分片序号排列
+//	crc32       4 bytes   大端 IEEE CRC32，覆盖此前全部字节
+//
+// 二进制格式中分片的偏移与大小由 size/chunkSize 推导，分片ID由 core.GenerateChunkID 生成，
+// 因此仅适用于按 index.BuildFileIndex 规则切分的文件。
+package manifest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/index"
+)
+
+// Version 当前格式版本
+const Version = 1
+
+// Format JSON 编码中的格式标识
+const Format = "ripplego-manifest"
+
+// Manifest 可移植的文件索引
+type Manifest struct {
+	File   core.FileInfo
+	Chunks []core.ChunkInfo
+}
+
+// New 基于文件索引创建 manifest，去除节点本地信息
+func New(fi core.FileInfo, chunks []core.ChunkInfo) Manifest {
+	fi.Path = ""
+	fi.ModTime = time.Time{}
+	fi.Inode = 0
+	fi.Withdrawn = false
+	fi.HashAlgo = fi.HashAlgo.OrDefault()
+	return Manifest{File: fi, Chunks: append([]core.ChunkInfo(nil), chunks...)}
+}
+
+// Export 从索引存储导出指定文件的 manifest
+func Export(s index.IndexStore, id core.FileID) (Manifest, error) {
+	fi, err := s.GetFile(id)
+	if err != nil {
+		return Manifest{}, err
+	}
+	chunks, err := s.GetChunks(id)
+	if err != nil {
+		return Manifest{}, err
+	}
+	m := New(fi, chunks)
+	return m, m.Validate()
+}
+
+// Import 校验后写入索引存储；本地已分享同ID文件时保持不变（内容不一致时报错）
+func (m Manifest) Import(s index.IndexStore) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	if cur, err := s.GetFile(m.File.ID); err == nil && cur.Path != "" {
+		if cur.Hash != m.File.Hash || cur.Size != m.File.Size {
+			return fmt.Errorf("file %s already shared locally with different content", m.File.ID)
+		}
+		return nil
+	}
+	if err := s.SaveChunks(m.File.ID, m.Chunks); err != nil {
+		return err
+	}
+	return s.SaveFile(m.File)
+}
+
+// Validate 校验 manifest 自洽
+func (m Manifest) Validate() error {
+	if _, err := core.ParseHashAlgo(string(m.File.HashAlgo)); err != nil {
+		return err
+	}
+	if m.File.Hash == "" {
+		return errors.New("manifest: missing file hash")
+	}
+	return index.ValidateChunks(m.File, m.Chunks)
+}
+
+// Read 读取 manifest，自动识别 JSON 与二进制编码
+func Read(r io.Reader) (Manifest, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(magic))
+	if err != nil && len(head) == 0 {
+		return Manifest{}, fmt.Errorf("manifest: %w", err)
+	}
+	var m Manifest
+	if string(head) == magic {
+		m, err = readBinary(br)
+	} else {
+		m, err = readJSON(br)
+	}
+	if err != nil {
+		return Manifest{}, err
+	}
+	return m, m.Validate()
+}
@@ -0,0 +1,130 @@
+package manifest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/index"
+)
+
+func testManifest(t *testing.T, algo core.HashAlgo) Manifest {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "report.log")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("0123456789"), 10), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fi, chunks, err := index.BuildFileIndex(path, 32, algo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi.Description = "weekly report"
+	fi.CreatedAt = time.Unix(1700000000, 123).UTC()
+	return New(fi, chunks)
+}
+
+func TestRoundTrip(t *testing.T) {
+	formats := []struct {
+		name  string
+		write func(Manifest, *bytes.Buffer) error
+	}{
+		{"json", func(m Manifest, b *bytes.Buffer) error { return m.WriteJSON(b) }},
+		{"binary", func(m Manifest, b *bytes.Buffer) error { return m.WriteBinary(b) }},
+	}
+	for _, algo := range []core.HashAlgo{core.HashSHA256, core.HashBLAKE3, core.HashXXH64} {
+		m := testManifest(t, algo)
+		for _, f := range formats {
+			t.Run(string(algo)+"/"+f.name, func(t *testing.T) {
+				var buf bytes.Buffer
+				if err := f.write(m, &buf); err != nil {
+					t.Fatal(err)
+				}
+				got, err := Read(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got.File.CreatedAt = got.File.CreatedAt.UTC()
+				if !reflect.DeepEqual(got, m) {
+					t.Fatalf("read back %+v\nwant %+v", got, m)
+				}
+			})
+		}
+	}
+}
+
+func TestBinaryRejectsCorruption(t *testing.T) {
+	m := testManifest(t, core.HashSHA256)
+	var buf bytes.Buffer
+	if err := m.WriteBinary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// 分片数与大小不一致（CRC 有效）：由 Validate 拒绝
+	forged := m
+	forged.Chunks = append(forged.Chunks[:len(forged.Chunks):len(forged.Chunks)],
+		core.ChunkInfo{ID: core.GenerateChunkID(m.File.ID, 4), FileID: m.File.ID, Index: 4, Size: 32, Offset: 100, Hash: m.Chunks[0].Hash})
+	var extra bytes.Buffer
+	if err := forged.WriteBinary(&extra); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"flipped hash byte", flip(data, len(data)-10), "checksum mismatch"},
+		{"flipped crc", flip(data, len(data)-1), "checksum mismatch"},
+		{"flipped name", flip(data, bytes.Index(data, []byte("report.log"))), "checksum mismatch"},
+		{"truncated", data[:len(data)-20], "EOF"},
+		{"missing crc", data[:len(data)-4], "EOF"},
+		{"extra chunk", extra.Bytes(), "chunk count"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Read(bytes.NewReader(tt.data)); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONRejectsInconsistentChunks(t *testing.T) {
+	m := testManifest(t, core.HashBLAKE3)
+	var buf bytes.Buffer
+	if err := m.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	good := buf.String()
+	tests := []struct {
+		name, old, new, want string
+	}{
+		{"declared algorithm", `"hashAlgo": "blake3"`, `"hashAlgo": "xxh64"`, "not a xxh64 digest"},
+		{"chunk count", `"chunkCount": 4`, `"chunkCount": 3`, "chunk count"},
+		{"chunk hash", m.Chunks[2].Hash, m.Chunks[2].Hash[:10], "chunk 2: hash"},
+		{"chunk id", string(m.Chunks[1].ID), "not-a-chunk-id", "chunk 1: invalid chunk id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(good, tt.old) {
+				t.Fatalf("manifest does not contain %q", tt.old)
+			}
+			_, err := Read(strings.NewReader(strings.Replace(good, tt.old, tt.new, 1)))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func flip(data []byte, i int) []byte {
+	out := bytes.Clone(data)
+	out[i] ^= 0x40
+	return out
+}
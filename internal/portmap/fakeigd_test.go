@@ -0,0 +1,486 @@
+package portmap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIGD 本地 UPnP-IGD 应答器：在普通 UDP 地址上应答 SSDP 查询，并通过 HTTP 提供设备描述与
+// WANIPConnection:1 的 SOAP 接口，映射只保存在内存中。配合 Mapper.SSDPAddr 可在无路由器的环境中测试端口映射
+type fakeIGD struct {
+	// PermanentOnly 为 true 时拒绝非零租约（错误 725），模拟只支持永久映射的网关
+	PermanentOnly bool
+
+	externalIP net.IP
+	udp        *net.UDPConn
+	ln         net.Listener
+	srv        *http.Server
+
+	mu       sync.Mutex
+	mappings map[fakeKey]fakeMapping
+}
+
+// fakeKey 映射按协议与外部端口区分，同一端口可同时有 TCP 与 UDP 映射
+type fakeKey struct {
+	protocol string
+	port     int
+}
+
+// fakeMapping fakeIGD 上的一条映射
+type fakeMapping struct {
+	Protocol       string
+	ExternalPort   int
+	InternalClient string
+	InternalPort   int
+	Description    string
+	Lease          time.Duration
+	Expires        time.Time // 租约为 0 时为零值
+}
+
+// newFakeIGD 在 ssdpAddr（如 127.0.0.1:0）上监听 SSDP 查询，外部地址报告为 externalIP
+func newFakeIGD(ssdpAddr string, externalIP net.IP) (*fakeIGD, error) {
+	ua, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+	udp, err := net.ListenUDP("udp4", ua)
+	if err != nil {
+		return nil, err
+	}
+	host := ua.IP.String()
+	if ua.IP == nil || ua.IP.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+	g := &fakeIGD{externalIP: externalIP, udp: udp, ln: ln, mappings: make(map[fakeKey]fakeMapping)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", g.handleDesc)
+	mux.HandleFunc("/ctl/IPConn", g.handleControl)
+	g.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	return g, nil
+}
+
+// SSDPAddr 返回 SSDP 监听地址，供 Mapper.SSDPAddr 或 DiscoverUPnP 使用
+func (g *fakeIGD) SSDPAddr() string { return g.udp.LocalAddr().String() }
+
+// Serve 应答 SSDP 查询并提供 HTTP 接口，直到 ctx 结束或 Close
+func (g *fakeIGD) Serve(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { _ = g.Close() })
+	defer stop()
+	go func() { _ = g.srv.Serve(g.ln) }()
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := g.udp.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" {
+			continue
+		}
+		if st := req.Header.Get("ST"); st != igdDeviceType && st != "ssdp:all" {
+			continue
+		}
+		resp := "HTTP/1.1 200 OK\r\n" +
+			"CACHE-CONTROL: max-age=120\r\n" +
+			"ST: " + igdDeviceType + "\r\n" +
+			"USN: uuid:fake-igd::" + igdDeviceType + "\r\n" +
+			"EXT:\r\n" +
+			"SERVER: RippleGo/1.0 UPnP/1.1 FakeIGD/1.0\r\n" +
+			"LOCATION: http://" + g.ln.Addr().String() + "/rootDesc.xml\r\n\r\n"
+		_, _ = g.udp.WriteToUDP([]byte(resp), from)
+	}
+}
+
+// Mappings 返回当前未过期的映射，按外部端口与协议排序
+func (g *fakeIGD) Mappings() []fakeMapping {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.expireLocked()
+	out := make([]fakeMapping, 0, len(g.mappings))
+	for _, m := range g.mappings {
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ExternalPort != out[j].ExternalPort {
+			return out[i].ExternalPort < out[j].ExternalPort
+		}
+		return out[i].Protocol < out[j].Protocol
+	})
+	return out
+}
+
+func (g *fakeIGD) Close() error {
+	err := g.udp.Close()
+	_ = g.srv.Close()
+	return err
+}
+
+func (g *fakeIGD) expireLocked() {
+	now := time.Now()
+	for k, m := range g.mappings {
+		if !m.Expires.IsZero() && now.After(m.Expires) {
+			delete(g.mappings, k)
+		}
+	}
+}
+
+const fakeIGDDesc = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<device>
+<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+<friendlyName>RippleGo FakeIGD</friendlyName>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+<serviceList><service>
+<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+<serviceId>urn:upnp-org:serviceId:WANIPConn1</serviceId>
+<controlURL>/ctl/IPConn</controlURL>
+</service></serviceList>
+</device></deviceList>
+</device></deviceList>
+</device>
+</root>
+`
+
+func (g *fakeIGD) handleDesc(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	_, _ = io.WriteString(w, fakeIGDDesc)
+}
+
+// fakeEnvelope 解析任意操作的 SOAP 请求参数
+type fakeEnvelope struct {
+	Body struct {
+		Action struct {
+			XMLName xml.Name
+			Args    []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+func (g *fakeIGD) handleControl(w http.ResponseWriter, r *http.Request) {
+	var env fakeEnvelope
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxUPnPResponse)).Decode(&env); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	action := env.Body.Action.XMLName.Local
+	args := make(map[string]string)
+	for _, a := range env.Body.Action.Args {
+		args[a.XMLName.Local] = strings.TrimSpace(a.Value)
+	}
+	const serviceType = "urn:schemas-upnp-org:service:WANIPConnection:1"
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.expireLocked()
+	var result string
+	switch action {
+	case "GetExternalIPAddress":
+		result = "<NewExternalIPAddress>" + g.externalIP.String() + "</NewExternalIPAddress>"
+	case "AddPortMapping":
+		ext, err1 := strconv.Atoi(args["NewExternalPort"])
+		in, err2 := strconv.Atoi(args["NewInternalPort"])
+		lease, err3 := strconv.Atoi(args["NewLeaseDuration"])
+		if err1 != nil || err2 != nil || err3 != nil || ext <= 0 || ext > 65535 || in <= 0 || in > 65535 || lease < 0 {
+			fakeFault(w, 402, "Invalid Args")
+			return
+		}
+		proto := args["NewProtocol"]
+		if proto != ProtoTCP && proto != ProtoUDP {
+			fakeFault(w, 402, "Invalid Args")
+			return
+		}
+		if g.PermanentOnly && lease != 0 {
+			fakeFault(w, upnpOnlyPermLease, "OnlyPermanentLeasesSupported")
+			return
+		}
+		client := args["NewInternalClient"]
+		key := fakeKey{proto, ext}
+		if old, ok := g.mappings[key]; ok && (old.InternalClient != client || old.InternalPort != in) {
+			fakeFault(w, upnpConflict, "ConflictInMappingEntry")
+			return
+		}
+		m := fakeMapping{Protocol: proto, ExternalPort: ext, InternalClient: client, InternalPort: in,
+			Description: args["NewPortMappingDescription"], Lease: time.Duration(lease) * time.Second}
+		if lease > 0 {
+			m.Expires = time.Now().Add(m.Lease)
+		}
+		g.mappings[key] = m
+	case "DeletePortMapping":
+		ext, _ := strconv.Atoi(args["NewExternalPort"])
+		key := fakeKey{args["NewProtocol"], ext}
+		if _, ok := g.mappings[key]; !ok {
+			fakeFault(w, 714, "NoSuchEntryInArray")
+			return
+		}
+		delete(g.mappings, key)
+	default:
+		fakeFault(w, 401, "Invalid Action")
+		return
+	}
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><u:%sResponse xmlns:u="%s">%s</u:%sResponse></s:Body></s:Envelope>`,
+		action, serviceType, result, action)
+}
+
+func fakeFault(w http.ResponseWriter, code int, desc string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`,
+		code, desc)
+}
+
+func startFakeIGD(t *testing.T, ctx context.Context, permanentOnly bool) *fakeIGD {
+	t.Helper()
+	g, err := newFakeIGD("127.0.0.1:0", net.ParseIP("203.0.113.5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.PermanentOnly = permanentOnly
+	go g.Serve(ctx)
+	t.Cleanup(func() { g.Close() })
+	return g
+}
+
+// runMapper 启动 Mapper.Run（proto 为空时映射 TCP），返回映射回调通道与等待 Run 返回的函数
+func runMapper(t *testing.T, ctx context.Context, g *fakeIGD, proto string, port int, lifetime time.Duration) (<-chan Mapping, func()) {
+	t.Helper()
+	changes := make(chan Mapping, 8)
+	p := NewMapper(MethodUPnP)
+	p.Protocol, p.Lifetime = proto, lifetime
+	p.SSDPAddr = g.SSDPAddr()
+	p.OnChange = func(m Mapping, err error) {
+		if err != nil {
+			t.Errorf("mapping failed: %v", err)
+			return
+		}
+		changes <- m
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.Run(ctx, port)
+	}()
+	return changes, wg.Wait
+}
+
+func waitMapping(t *testing.T, changes <-chan Mapping) Mapping {
+	t.Helper()
+	select {
+	case m := <-changes:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mapping reported")
+		return Mapping{}
+	}
+}
+
+func TestMapperAddRenewDelete(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g := startFakeIGD(t, ctx, false)
+	mctx, stop := context.WithCancel(ctx)
+	changes, wait := runMapper(t, mctx, g, "", 40001, 2*time.Second)
+
+	m := waitMapping(t, changes)
+	if m.Method != MethodUPnP || m.Protocol != ProtoTCP || m.InternalPort != 40001 || m.ExternalPort != 40001 || m.Lifetime != 2*time.Second {
+		t.Fatalf("mapping = %+v", m)
+	}
+	if got := m.ExternalAddr(); got != "203.0.113.5:40001" {
+		t.Errorf("external addr = %q, want 203.0.113.5:40001", got)
+	}
+	ms := g.Mappings()
+	if len(ms) != 1 || ms[0].ExternalPort != 40001 || ms[0].InternalPort != 40001 || ms[0].InternalClient != "127.0.0.1" || ms[0].Lease != 2*time.Second {
+		t.Fatalf("gateway mappings = %+v", ms)
+	}
+
+	// 租约过半时续期：超过原租约后映射仍在，且到期时间后移；外部地址未变不再回调
+	first := ms[0].Expires
+	time.Sleep(2500 * time.Millisecond)
+	ms = g.Mappings()
+	if len(ms) != 1 || !ms[0].Expires.After(first) {
+		t.Fatalf("mapping not renewed: %+v (first expiry %s)", ms, first)
+	}
+	select {
+	case m := <-changes:
+		t.Errorf("unexpected change on renewal: %+v", m)
+	default:
+	}
+
+	// 退出时移除映射
+	stop()
+	wait()
+	if ms := g.Mappings(); len(ms) != 0 {
+		t.Errorf("mappings after cancel = %+v", ms)
+	}
+}
+
+func TestMapperPermanentOnlyGateway(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g := startFakeIGD(t, ctx, true)
+	mctx, stop := context.WithCancel(ctx)
+	changes, wait := runMapper(t, mctx, g, "", 40002, time.Hour)
+
+	m := waitMapping(t, changes)
+	if m.Lifetime != 0 {
+		t.Errorf("lifetime = %s, want permanent", m.Lifetime)
+	}
+	if ms := g.Mappings(); len(ms) != 1 || ms[0].Lease != 0 || !ms[0].Expires.IsZero() {
+		t.Fatalf("gateway mappings = %+v", ms)
+	}
+	stop()
+	wait()
+	if ms := g.Mappings(); len(ms) != 0 {
+		t.Errorf("mappings after cancel = %+v", ms)
+	}
+}
+
+func TestMapperConflictPicksOtherPort(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g := startFakeIGD(t, ctx, false)
+	// 外部端口已被局域网内其他主机占用
+	g.mu.Lock()
+	g.mappings[fakeKey{ProtoTCP, 40003}] = fakeMapping{Protocol: ProtoTCP, ExternalPort: 40003, InternalClient: "192.168.1.20", InternalPort: 40003}
+	g.mu.Unlock()
+	mctx, stop := context.WithCancel(ctx)
+	changes, wait := runMapper(t, mctx, g, "", 40003, time.Hour)
+
+	m := waitMapping(t, changes)
+	if m.ExternalPort == 40003 || m.InternalPort != 40003 {
+		t.Fatalf("mapping = %+v, want another external port", m)
+	}
+	if ms := g.Mappings(); len(ms) != 2 {
+		t.Fatalf("gateway mappings = %+v", ms)
+	}
+	stop()
+	wait()
+	if ms := g.Mappings(); len(ms) != 1 || ms[0].InternalClient != "192.168.1.20" {
+		t.Errorf("mappings after cancel = %+v, want only the other host's", ms)
+	}
+}
+
+func TestMapperUDPAlongsideTCP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g := startFakeIGD(t, ctx, false)
+	tctx, stopTCP := context.WithCancel(ctx)
+	tcp, waitTCP := runMapper(t, tctx, g, ProtoTCP, 40004, time.Hour)
+	uctx, stopUDP := context.WithCancel(ctx)
+	udp, waitUDP := runMapper(t, uctx, g, ProtoUDP, 40004, time.Hour)
+
+	// 同一端口的 TCP 与 UDP 映射互不冲突，外部端口相同
+	tm, um := waitMapping(t, tcp), waitMapping(t, udp)
+	if tm.Protocol != ProtoTCP || um.Protocol != ProtoUDP || um.ExternalPort != 40004 || um.ExternalAddr() != tm.ExternalAddr() {
+		t.Fatalf("mappings = %+v, %+v", tm, um)
+	}
+	ms := g.Mappings()
+	if len(ms) != 2 || ms[0].Protocol != ProtoTCP || ms[1].Protocol != ProtoUDP {
+		t.Fatalf("gateway mappings = %+v", ms)
+	}
+
+	// 退出时只移除各自协议的映射
+	stopUDP()
+	waitUDP()
+	if ms := g.Mappings(); len(ms) != 1 || ms[0].Protocol != ProtoTCP {
+		t.Fatalf("mappings after the UDP mapper stopped = %+v", ms)
+	}
+	stopTCP()
+	waitTCP()
+	if ms := g.Mappings(); len(ms) != 0 {
+		t.Errorf("mappings after cancel = %+v", ms)
+	}
+}
+
+// fakeNATPMP 应答 NAT-PMP 请求，记录收到的映射操作码
+func fakeNATPMP(t *testing.T) (string, <-chan byte) {
+	t.Helper()
+	pc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	ops := make(chan byte, 8)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, from, err := pc.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n < 2 {
+				continue
+			}
+			op := buf[1]
+			resp := []byte{0, op + 128, 0, 0, 0, 0, 0, 1}
+			if op == natpmpOpExternal {
+				resp = append(resp, 203, 0, 113, 5)
+			} else {
+				ops <- op
+				resp = append(resp, buf[4:12]...) // 内部端口、外部端口与租约原样返回
+			}
+			pc.WriteToUDP(resp, from)
+		}
+	}()
+	return pc.LocalAddr().String(), ops
+}
+
+func TestNATPMPProtocolOpcode(t *testing.T) {
+	gw, ops := fakeNATPMP(t)
+	c, err := NewNATPMP(gw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, tt := range []struct {
+		proto string
+		op    byte
+	}{{ProtoTCP, natpmpOpMapTCP}, {ProtoUDP, natpmpOpMapUDP}} {
+		m, err := c.Add(ctx, tt.proto, 40005, 40005, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Protocol != tt.proto || m.ExternalAddr() != "203.0.113.5:40005" || m.Lifetime != time.Hour {
+			t.Errorf("%s mapping = %+v", tt.proto, m)
+		}
+		if op := <-ops; op != tt.op {
+			t.Errorf("%s map opcode = %d, want %d", tt.proto, op, tt.op)
+		}
+		// 删除时使用映射自身的协议
+		if err := c.Delete(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+		if op := <-ops; op != tt.op {
+			t.Errorf("%s delete opcode = %d, want %d", tt.proto, op, tt.op)
+		}
+	}
+}
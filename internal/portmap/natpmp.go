@@ -0,0 +1,173 @@
+package portmap
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	natpmpPort       = 5351
+	natpmpRetries    = 4 // 首次等待 250ms，每次翻倍；RFC 建议最多 9 次，这里尽快回退到 UPnP
+	natpmpFirstWait  = 250 * time.Millisecond
+	natpmpOpExternal = 0
+	natpmpOpMapUDP   = 1
+	natpmpOpMapTCP   = 2
+)
+
+// NATPMP NAT-PMP 客户端（RFC 6886）
+type NATPMP struct {
+	gateway *net.UDPAddr
+}
+
+// NewNATPMP 创建指向网关 gateway（IP 或 IP:port，默认端口 5351）的客户端
+func NewNATPMP(gateway string) (*NATPMP, error) {
+	if net.ParseIP(gateway) != nil {
+		gateway = net.JoinHostPort(gateway, strconv.Itoa(natpmpPort))
+	}
+	ua, err := net.ResolveUDPAddr("udp4", gateway)
+	if err != nil {
+		return nil, err
+	}
+	return &NATPMP{gateway: ua}, nil
+}
+
+func (c *NATPMP) Method() string { return MethodNATPMP }
+
+// Probe 请求外部地址，确认网关支持 NAT-PMP
+func (c *NATPMP) Probe(ctx context.Context) error {
+	_, err := c.externalIP(ctx)
+	return err
+}
+
+func (c *NATPMP) Add(ctx context.Context, protocol string, internalPort, externalPort int, lifetime time.Duration) (Mapping, error) {
+	ip, err := c.externalIP(ctx)
+	if err != nil {
+		return Mapping{}, err
+	}
+	resp, err := c.mapPort(ctx, protocol, internalPort, externalPort, uint32(lifetime/time.Second))
+	if err != nil {
+		return Mapping{}, err
+	}
+	return Mapping{
+		Method:       MethodNATPMP,
+		Protocol:     protocol,
+		InternalPort: int(binary.BigEndian.Uint16(resp[8:])),
+		ExternalPort: int(binary.BigEndian.Uint16(resp[10:])),
+		ExternalIP:   ip,
+		Lifetime:     time.Duration(binary.BigEndian.Uint32(resp[12:])) * time.Second,
+	}, nil
+}
+
+// Delete 以租约 0 请求移除映射
+func (c *NATPMP) Delete(ctx context.Context, m Mapping) error {
+	_, err := c.mapPort(ctx, m.Protocol, m.InternalPort, 0, 0)
+	return err
+}
+
+func (c *NATPMP) externalIP(ctx context.Context) (net.IP, error) {
+	resp, err := c.call(ctx, []byte{0, natpmpOpExternal}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IPv4(resp[8], resp[9], resp[10], resp[11]), nil
+}
+
+func (c *NATPMP) mapPort(ctx context.Context, protocol string, internalPort, externalPort int, lifetime uint32) ([]byte, error) {
+	req := make([]byte, 12)
+	req[1] = natpmpOpMapTCP
+	if protocol == ProtoUDP {
+		req[1] = natpmpOpMapUDP
+	}
+	binary.BigEndian.PutUint16(req[4:], uint16(internalPort))
+	binary.BigEndian.PutUint16(req[6:], uint16(externalPort))
+	binary.BigEndian.PutUint32(req[8:], lifetime)
+	return c.call(ctx, req, 16)
+}
+
+// call 发送请求并按指数退避重传，返回校验过操作码与结果码的应答
+func (c *NATPMP) call(ctx context.Context, req []byte, size int) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, c.gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	buf := make([]byte, 32)
+	wait := natpmpFirstWait
+	for i := 0; i < natpmpRetries; i++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(wait))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				return nil, err // 如 ICMP 端口不可达
+			}
+			if n < size || buf[0] != 0 || buf[1] != req[1]+128 {
+				continue
+			}
+			if code := binary.BigEndian.Uint16(buf[2:]); code != 0 {
+				return nil, fmt.Errorf("gateway result code %d", code)
+			}
+			return buf[:n], nil
+		}
+		wait *= 2
+	}
+	return nil, errors.New("no response from gateway")
+}
+
+// DefaultGateway 返回 IPv4 默认网关：Linux 上读取路由表，其他系统取本机主地址所在网段的 .1
+func DefaultGateway() (net.IP, error) {
+	if ip, err := linuxGateway(); err == nil {
+		return ip, nil
+	}
+	local, err := localIPFor("192.0.2.1:9") // 不发送数据，只用于选择出口地址
+	if err != nil {
+		return nil, fmt.Errorf("default gateway: %w", err)
+	}
+	ip4 := local.To4()
+	if ip4 == nil || ip4.IsLoopback() {
+		return nil, errors.New("default gateway: no IPv4 route")
+	}
+	return net.IPv4(ip4[0], ip4[1], ip4[2], 1), nil
+}
+
+func linuxGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		// Iface Destination Gateway Flags ...，地址为小端序十六进制
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		return net.IPv4(b[3], b[2], b[1], b[0]), nil
+	}
+	return nil, errors.New("no default route")
+}
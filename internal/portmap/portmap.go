@@ -0,0 +1,187 @@
+// Package portmap 在家用路由器上为传输服务端口自动建立端口映射，使 NAT 之后的节点可被直接访问
+// 支持 NAT-PMP（RFC 6886）与 UPnP-IGD（WANIPConnection / WANPPPConnection），Mapper 负责选择协议、
+// 定期续期租约并在退出时移除映射
+package portmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultLifetime = time.Hour
+	retryInterval   = 30 * time.Second // 映射失败后重试（并重新发现网关）的间隔
+	deleteTimeout   = 3 * time.Second
+	description     = "RippleGo"
+)
+
+// 协议名，用于 Mapper.Method
+const (
+	MethodAuto   = "auto"
+	MethodNATPMP = "natpmp"
+	MethodUPnP   = "upnp"
+)
+
+// 映射的传输层协议，用于 Mapper.Protocol
+const (
+	ProtoTCP = "TCP"
+	ProtoUDP = "UDP"
+)
+
+// Mapping 已建立的端口映射
+type Mapping struct {
+	Method       string // natpmp 或 upnp
+	Protocol     string // TCP 或 UDP
+	InternalPort int
+	ExternalPort int
+	ExternalIP   net.IP
+	Lifetime     time.Duration // 0 表示永久（部分 UPnP 网关只支持永久映射）
+}
+
+// ExternalAddr 返回外部地址（host:port），外部 IP 未知时为空
+func (m Mapping) ExternalAddr() string {
+	if m.ExternalIP == nil || m.ExternalIP.IsUnspecified() {
+		return ""
+	}
+	return net.JoinHostPort(m.ExternalIP.String(), strconv.Itoa(m.ExternalPort))
+}
+
+// Client 单个网关上的端口映射协议实现
+type Client interface {
+	Method() string
+	// Add 建立或续期 protocol（TCP 或 UDP）端口映射，externalPort 为建议的外部端口，网关可能分配其他端口
+	Add(ctx context.Context, protocol string, internalPort, externalPort int, lifetime time.Duration) (Mapping, error)
+	// Delete 移除映射
+	Delete(ctx context.Context, m Mapping) error
+}
+
+// Mapper 为传输服务端口维护端口映射（实现 transfer.PortMapper）
+type Mapper struct {
+	// Method 使用的协议：auto（先 NAT-PMP 后 UPnP）、natpmp 或 upnp
+	Method string
+	// Protocol 映射的端口协议：TCP（默认）或 UDP（如 DHT、QUIC）
+	Protocol string
+	// Lifetime 请求的租约时长，到期前一半时续期，默认 1 小时
+	Lifetime time.Duration
+	// Gateway NAT-PMP 网关地址，为空时使用默认网关
+	Gateway string
+	// SSDPAddr UPnP 发现请求的目的地址，默认 239.255.255.250:1900；测试时可指向本地的模拟网关
+	SSDPAddr string
+	// OnChange 映射建立、外部地址变化或映射失败（err 不为空）时回调（可选）
+	OnChange func(m Mapping, err error)
+}
+
+func NewMapper(method string) *Mapper {
+	return &Mapper{Method: method, Lifetime: DefaultLifetime}
+}
+
+// Run 建立 port 的映射并定期续期，ctx 结束时移除映射后返回
+func (p *Mapper) Run(ctx context.Context, port int) {
+	var (
+		client Client
+		cur    Mapping
+		mapped bool
+	)
+	defer func() {
+		if mapped {
+			dctx, cancel := context.WithTimeout(context.Background(), deleteTimeout)
+			defer cancel()
+			_ = client.Delete(dctx, cur)
+		}
+	}()
+	lifetime := p.Lifetime
+	if lifetime <= 0 {
+		lifetime = DefaultLifetime
+	}
+	proto := p.Protocol
+	if proto == "" {
+		proto = ProtoTCP
+	}
+	for {
+		wait := retryInterval
+		var err error
+		if client == nil {
+			client, err = p.discover(ctx)
+		}
+		if err == nil {
+			want := port
+			if mapped {
+				want = cur.ExternalPort // 续期时保持外部端口不变
+			}
+			var m Mapping
+			if m, err = client.Add(ctx, proto, port, want, lifetime); err == nil {
+				changed := !mapped || m.ExternalPort != cur.ExternalPort || !m.ExternalIP.Equal(cur.ExternalIP)
+				cur, mapped = m, true
+				if changed && p.OnChange != nil {
+					p.OnChange(m, nil)
+				}
+				if m.Lifetime > 0 {
+					wait = m.Lifetime / 2
+				} else {
+					wait = lifetime / 2 // 永久映射仍定期检查网关是否重启
+				}
+			} else {
+				client = nil // 网关可能已变化，下次重新发现
+			}
+		}
+		if err != nil && ctx.Err() == nil && p.OnChange != nil {
+			p.OnChange(cur, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// discover 按 Method 查找可用的网关协议
+func (p *Mapper) discover(ctx context.Context) (Client, error) {
+	var errs []error
+	if p.Method == "" || p.Method == MethodAuto || p.Method == MethodNATPMP {
+		c, err := p.natpmp()
+		if err == nil {
+			if err = c.Probe(ctx); err == nil {
+				return c, nil
+			}
+		}
+		errs = append(errs, fmt.Errorf("nat-pmp: %w", err))
+	}
+	if p.Method == "" || p.Method == MethodAuto || p.Method == MethodUPnP {
+		c, err := DiscoverUPnP(ctx, p.SSDPAddr)
+		if err == nil {
+			return c, nil
+		}
+		errs = append(errs, fmt.Errorf("upnp: %w", err))
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("portmap: unknown method %q", p.Method)
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (p *Mapper) natpmp() (*NATPMP, error) {
+	gw := p.Gateway
+	if gw == "" {
+		ip, err := DefaultGateway()
+		if err != nil {
+			return nil, err
+		}
+		gw = ip.String()
+	}
+	return NewNATPMP(gw)
+}
+
+// localIPFor 返回访问 remote 时使用的本机 IP
+func localIPFor(remote string) (net.IP, error) {
+	conn, err := net.Dial("udp4", remote)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -0,0 +1,294 @@
+package portmap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSSDPAddr   = "239.255.255.250:1900"
+	igdDeviceType     = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+	ssdpWait          = 2 * time.Second
+	upnpHTTPTimeout   = 5 * time.Second
+	maxUPnPResponse   = 1 << 20
+	upnpConflict      = 718 // ConflictInMappingEntry：外部端口已被其他主机占用
+	upnpOnlyPermLease = 725 // OnlyPermanentLeasesSupported
+	maxPortAttempts   = 4
+)
+
+// 支持的 WAN 连接服务，按优先级排列
+var wanServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// UPnP UPnP-IGD 客户端，通过 WAN 连接服务的 SOAP 接口管理端口映射
+type UPnP struct {
+	ControlURL  string
+	ServiceType string
+	LocalIP     net.IP // 映射的内部地址（本机访问网关时使用的地址）
+	client      *http.Client
+	permanent   bool // 网关只支持永久映射
+}
+
+// DiscoverUPnP 通过 SSDP 查找网关并读取其设备描述；ssdpAddr 为空时使用组播地址 239.255.255.250:1900
+func DiscoverUPnP(ctx context.Context, ssdpAddr string) (*UPnP, error) {
+	if ssdpAddr == "" {
+		ssdpAddr = DefaultSSDPAddr
+	}
+	location, err := ssdpSearch(ctx, ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+	return NewUPnP(ctx, location)
+}
+
+// NewUPnP 读取 location 处的设备描述，创建 WAN 连接服务的客户端
+func NewUPnP(ctx context.Context, location string) (*UPnP, error) {
+	hc := &http.Client{Timeout: upnpHTTPTimeout}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("device description: %s", resp.Status)
+	}
+	var desc struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxUPnPResponse)).Decode(&desc); err != nil {
+		return nil, fmt.Errorf("device description: %w", err)
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if desc.URLBase != "" {
+		if b, err := url.Parse(desc.URLBase); err == nil {
+			base = b
+		}
+	}
+	for _, st := range wanServices {
+		if svc, ok := desc.Device.find(st); ok {
+			ctrl, err := base.Parse(svc.ControlURL)
+			if err != nil {
+				return nil, err
+			}
+			hostport := ctrl.Host
+			if ctrl.Port() == "" {
+				hostport = net.JoinHostPort(ctrl.Hostname(), "80")
+			}
+			local, err := localIPFor(hostport)
+			if err != nil {
+				return nil, err
+			}
+			return &UPnP{ControlURL: ctrl.String(), ServiceType: st, LocalIP: local, client: hc}, nil
+		}
+	}
+	return nil, errors.New("no WAN connection service in device description")
+}
+
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// find 在设备树中查找服务
+func (d upnpDevice) find(serviceType string) (upnpService, bool) {
+	for _, s := range d.Services {
+		if s.ServiceType == serviceType {
+			return s, true
+		}
+	}
+	for _, sub := range d.Devices {
+		if s, ok := sub.find(serviceType); ok {
+			return s, true
+		}
+	}
+	return upnpService{}, false
+}
+
+func (c *UPnP) Method() string { return MethodUPnP }
+
+// Add 建立映射；外部端口被占用时改用其他端口，网关只支持永久映射时以租约 0 重试
+func (c *UPnP) Add(ctx context.Context, protocol string, internalPort, externalPort int, lifetime time.Duration) (Mapping, error) {
+	ip, err := c.externalIP(ctx)
+	if err != nil {
+		return Mapping{}, err
+	}
+	if c.permanent {
+		lifetime = 0
+	}
+	ext := externalPort
+	for attempt := 0; ; attempt++ {
+		err = c.soap(ctx, "AddPortMapping", [][2]string{
+			{"NewRemoteHost", ""},
+			{"NewExternalPort", strconv.Itoa(ext)},
+			{"NewProtocol", protocol},
+			{"NewInternalPort", strconv.Itoa(internalPort)},
+			{"NewInternalClient", c.LocalIP.String()},
+			{"NewEnabled", "1"},
+			{"NewPortMappingDescription", description},
+			{"NewLeaseDuration", strconv.Itoa(int(lifetime / time.Second))},
+		}, nil)
+		var se *soapError
+		switch {
+		case err == nil:
+			return Mapping{Method: MethodUPnP, Protocol: protocol, InternalPort: internalPort, ExternalPort: ext, ExternalIP: ip, Lifetime: lifetime}, nil
+		case errors.As(err, &se) && se.Code == upnpOnlyPermLease && lifetime > 0:
+			c.permanent, lifetime = true, 0
+		case errors.As(err, &se) && se.Code == upnpConflict && attempt < maxPortAttempts:
+			ext = 1024 + (ext-1024+attempt*7919+1)%(65535-1024)
+		default:
+			return Mapping{}, err
+		}
+	}
+}
+
+func (c *UPnP) Delete(ctx context.Context, m Mapping) error {
+	return c.soap(ctx, "DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(m.ExternalPort)},
+		{"NewProtocol", m.Protocol},
+	}, nil)
+}
+
+func (c *UPnP) externalIP(ctx context.Context) (net.IP, error) {
+	var out struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := c.soap(ctx, "GetExternalIPAddress", nil, &out); err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.TrimSpace(out.IP))
+	if ip == nil {
+		return nil, fmt.Errorf("invalid external address %q", out.IP)
+	}
+	return ip, nil
+}
+
+// soapError 网关返回的 UPnPError
+type soapError struct {
+	Code int
+	Desc string
+}
+
+func (e *soapError) Error() string { return fmt.Sprintf("upnp error %d: %s", e.Code, e.Desc) }
+
+// soap 调用 WAN 连接服务的操作，out 不为空时解析应答信封
+func (c *UPnP) soap(ctx context.Context, action string, args [][2]string, out any) error {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, c.ServiceType)
+	for _, a := range args {
+		fmt.Fprintf(&body, "<%s>", a[0])
+		_ = xml.EscapeText(&body, []byte(a[1]))
+		fmt.Fprintf(&body, "</%s>", a[0])
+	}
+	fmt.Fprintf(&body, `</u:%s></s:Body></s:Envelope>`, action)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.ControlURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, c.ServiceType, action))
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxUPnPResponse))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var fault struct {
+			Code int    `xml:"Body>Fault>detail>UPnPError>errorCode"`
+			Desc string `xml:"Body>Fault>detail>UPnPError>errorDescription"`
+		}
+		if xml.Unmarshal(data, &fault) == nil && fault.Code != 0 {
+			return &soapError{Code: fault.Code, Desc: fault.Desc}
+		}
+		return fmt.Errorf("%s: %s", action, resp.Status)
+	}
+	if out != nil {
+		return xml.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// ssdpSearch 发送 M-SEARCH 查询网关，返回第一个应答的设备描述地址
+func ssdpSearch(ctx context.Context, ssdpAddr string) (string, error) {
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return "", err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	msg := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"ST: " + igdDeviceType + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	deadline := time.Now().Add(ssdpWait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetReadDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	// UDP 可能丢包，发送两次
+	for i := 0; i < 2; i++ {
+		if _, err := conn.WriteToUDP([]byte(msg), dst); err != nil {
+			return "", err
+		}
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			return "", errors.New("no gateway responded to SSDP search")
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		loc := resp.Header.Get("Location")
+		if st := resp.Header.Get("ST"); loc != "" && (st == "" || st == igdDeviceType) {
+			if u, err := url.Parse(loc); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+				return loc, nil
+			}
+		}
+	}
+}
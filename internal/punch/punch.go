@@ -0,0 +1,467 @@
+// Package punch 实现经会合节点（rendezvous）协调的 UDP 打洞，使两个位于 NAT 之后的节点直接建立连接
+//
+// 流程：
+//  1. 服务节点定期向会合节点登记（register），会合节点记录其被观察到的公网地址（observed）与本地地址
+//  2. 下载节点向会合节点发起 connect，会合节点向双方各发送一条 punch，携带对方的候选地址与同一个 token
+//  3. 双方同时向对方的全部候选地址发送 probe，首个到达的 probe 确定可用路径，NAT 映射随之在两侧建立
+//  4. 下载节点在该路径上以 rudp 建立可靠流，之后按 TCP 传输相同的请求协议通信
+//
+// 控制消息为 JSON，作为 rudp 控制报文与数据流共用同一 UDP socket，因此打出的洞可直接用于传输
+// 对称型 NAT（每个目的地址分配不同端口）之间通常无法打通，此时 Connect 超时返回错误
+package punch
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/rudp"
+)
+
+const (
+	msgRegister   = "register"
+	msgRegistered = "registered"
+	msgConnect    = "connect"
+	msgPunch      = "punch"
+	msgProbe      = "probe"
+	msgKeepalive  = "keepalive"
+	msgError      = "error"
+
+	// MaxAddrs 每个节点的候选地址数上限
+	MaxAddrs = 8
+
+	registerInterval = 15 * time.Second // 登记与保活的间隔，需短于常见 NAT 的 UDP 映射超时
+	probeInterval    = 100 * time.Millisecond
+	punchTimeout     = 10 * time.Second
+	punchLinger      = 5 * time.Second  // 打洞结束后继续应答迟到 probe 的时间
+	pathTTL          = 60 * time.Second // 已打通路径在未使用时的保留时间
+	pathDialTimeout  = 2 * time.Second
+	failBackoff      = 30 * time.Second // 打洞失败后暂停对同一节点打洞的时间，使调用方尽快改用其他方式（如中继）
+	maxMessageSize   = 2048
+)
+
+// message 控制消息
+type message struct {
+	Type     string      `json:"type"`
+	ID       core.NodeID `json:"id,omitempty"`       // 发送方；punch 中为对方节点
+	Target   core.NodeID `json:"target,omitempty"`   // connect 的目标节点
+	Addrs    []string    `json:"addrs,omitempty"`    // 本地地址；punch 中为对方的候选地址
+	Observed string      `json:"observed,omitempty"` // 会合节点观察到的来源地址
+	Token    string      `json:"token,omitempty"`
+	Ack      bool        `json:"ack,omitempty"` // probe 的应答
+	Msg      string      `json:"msg,omitempty"`
+}
+
+// Client 打洞客户端：向会合节点登记以接受连接，或经会合节点连接其他节点
+// 实现 net.Listener，Accept 返回对端经打通路径发起的流
+type Client struct {
+	ID core.NodeID // 本节点ID，为空时不登记，只能主动连接
+
+	ep         *rudp.Endpoint
+	rendezvous []*net.UDPAddr
+	port       int
+
+	mu       sync.Mutex
+	observed string
+	attempts map[string]*attempt // token -> 进行中的打洞
+	paths    map[core.NodeID]path
+	calls    map[core.NodeID]*call // 合并同一目标的并发打洞
+	failed   map[core.NodeID]time.Time
+}
+
+type attempt struct {
+	peer   core.NodeID
+	result chan result // 缓冲 1，只接收第一个结果
+	stop   chan struct{}
+	once   sync.Once
+}
+
+type result struct {
+	addr *net.UDPAddr
+	err  error
+}
+
+type path struct {
+	addr  *net.UDPAddr
+	until time.Time
+}
+
+type call struct {
+	done chan struct{}
+	addr *net.UDPAddr
+	err  error
+}
+
+// Listen 在 UDP 地址 addr 上创建客户端，rendezvous 为会合节点地址（host:port）
+func Listen(addr string, id core.NodeID, rendezvous []string) (*Client, error) {
+	if len(rendezvous) == 0 {
+		return nil, errors.New("punch: no rendezvous configured")
+	}
+	c := &Client{
+		ID:       id,
+		attempts: make(map[string]*attempt),
+		paths:    make(map[core.NodeID]path),
+		calls:    make(map[core.NodeID]*call),
+		failed:   make(map[core.NodeID]time.Time),
+	}
+	for _, r := range rendezvous {
+		ua, err := net.ResolveUDPAddr("udp", r)
+		if err != nil {
+			return nil, fmt.Errorf("punch: rendezvous %s: %w", r, err)
+		}
+		c.rendezvous = append(c.rendezvous, ua)
+	}
+	// 收包协程可能在 Listen 返回前就收到报文，handle 需等 c.ep 赋值后再处理
+	ready := make(chan struct{})
+	ep, err := rudp.Listen("udp", addr, func(data []byte, from *net.UDPAddr) { <-ready; c.handle(data, from) }, true)
+	if err != nil {
+		return nil, err
+	}
+	c.ep = ep
+	c.port = ep.Addr().(*net.UDPAddr).Port
+	close(ready)
+	return c, nil
+}
+
+// Start 开始向会合节点登记（设置了 ID 时）并为已打通的路径保活，直到 ctx 结束
+func (c *Client) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(registerInterval)
+		defer ticker.Stop()
+		for {
+			c.refresh()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Accept 等待对端经打通路径发起的流
+func (c *Client) Accept() (net.Conn, error) { return c.ep.Accept() }
+
+// Addr 返回本地 UDP 地址
+func (c *Client) Addr() net.Addr { return c.ep.Addr() }
+
+func (c *Client) Close() error { return c.ep.Close() }
+
+// Observed 返回会合节点观察到的本节点公网地址，尚未收到登记应答时为空
+func (c *Client) Observed() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.observed
+}
+
+// Connect 建立到 target 的可靠流：优先复用已打通的路径，否则经会合节点打洞
+func (c *Client) Connect(ctx context.Context, target core.NodeID) (net.Conn, error) {
+	if target == "" {
+		return nil, errors.New("punch: empty target id")
+	}
+	if addr := c.path(target); addr != nil {
+		dctx, cancel := context.WithTimeout(ctx, pathDialTimeout)
+		s, err := c.ep.Dial(dctx, addr)
+		cancel()
+		if err == nil {
+			c.keepPath(target, addr)
+			return s, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		c.forgetPath(target, addr)
+	}
+	c.mu.Lock()
+	until, failed := c.failed[target]
+	if failed && time.Now().After(until) {
+		delete(c.failed, target)
+		failed = false
+	}
+	c.mu.Unlock()
+	if failed {
+		return nil, fmt.Errorf("punch: %s: failed recently, not retrying until %s", target, until.Format(time.TimeOnly))
+	}
+	addr, err := c.punchOnce(ctx, target)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.mu.Lock()
+			c.failed[target] = time.Now().Add(failBackoff)
+			c.mu.Unlock()
+		}
+		return nil, err
+	}
+	s, err := c.ep.Dial(ctx, addr)
+	if err != nil {
+		c.forgetPath(target, addr)
+		return nil, fmt.Errorf("punch: connect %s via %s: %w", target, addr, err)
+	}
+	return s, nil
+}
+
+// punchOnce 合并同一目标的并发打洞请求
+func (c *Client) punchOnce(ctx context.Context, target core.NodeID) (*net.UDPAddr, error) {
+	c.mu.Lock()
+	if cl, ok := c.calls[target]; ok {
+		c.mu.Unlock()
+		select {
+		case <-cl.done:
+			return cl.addr, cl.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	cl := &call{done: make(chan struct{})}
+	c.calls[target] = cl
+	c.mu.Unlock()
+
+	cl.addr, cl.err = c.punch(ctx, target)
+	c.mu.Lock()
+	delete(c.calls, target)
+	c.mu.Unlock()
+	close(cl.done)
+	return cl.addr, cl.err
+}
+
+func (c *Client) punch(ctx context.Context, target core.NodeID) (*net.UDPAddr, error) {
+	token := newToken()
+	a := c.newAttempt(token, target)
+	defer c.finish(token, a)
+
+	req := message{Type: msgConnect, ID: c.ID, Target: target, Addrs: c.localAddrs(), Token: token}
+	for _, r := range c.rendezvous {
+		_ = c.send(req, r)
+	}
+	timer := time.NewTimer(punchTimeout)
+	defer timer.Stop()
+	select {
+	case res := <-a.result:
+		if res.err != nil {
+			return nil, res.err
+		}
+		c.keepPath(target, res.addr)
+		return res.addr, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("punch: %s: no response within %s", target, punchTimeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// handle 处理控制报文，在 rudp 收包协程中调用
+func (c *Client) handle(data []byte, from *net.UDPAddr) {
+	if len(data) > maxMessageSize {
+		return
+	}
+	var m message
+	if json.Unmarshal(data, &m) != nil {
+		return
+	}
+	switch m.Type {
+	case msgRegistered:
+		if c.isRendezvous(from) {
+			c.mu.Lock()
+			c.observed = m.Observed
+			c.mu.Unlock()
+		}
+	case msgPunch:
+		if !c.isRendezvous(from) || m.Token == "" {
+			return
+		}
+		c.mu.Lock()
+		a := c.attempts[m.Token]
+		c.mu.Unlock()
+		if a == nil {
+			// 对端发起的打洞：本端作为接受方同样向对方发送 probe
+			a = c.newAttempt(m.Token, m.ID)
+			time.AfterFunc(punchTimeout, func() { c.finish(m.Token, a) })
+		}
+		go c.probe(a, m.Token, candidates(m.Addrs))
+	case msgProbe:
+		c.mu.Lock()
+		a := c.attempts[m.Token]
+		c.mu.Unlock()
+		if a == nil {
+			return
+		}
+		if !m.Ack {
+			_ = c.send(message{Type: msgProbe, ID: c.ID, Token: m.Token, Ack: true}, from)
+		}
+		a.deliver(result{addr: from})
+	case msgError:
+		if !c.isRendezvous(from) {
+			return
+		}
+		c.mu.Lock()
+		a := c.attempts[m.Token]
+		c.mu.Unlock()
+		if a != nil {
+			a.deliver(result{err: fmt.Errorf("punch: rendezvous %s: %s", from, m.Msg)})
+		}
+	}
+}
+
+// probe 每隔 probeInterval 向全部候选地址发送 probe，直到打通或超时
+func (c *Client) probe(a *attempt, token string, addrs []*net.UDPAddr) {
+	msg := message{Type: msgProbe, ID: c.ID, Token: token}
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+	deadline := time.After(punchTimeout)
+	for {
+		for _, addr := range addrs {
+			_ = c.send(msg, addr)
+		}
+		select {
+		case <-a.stop:
+			return
+		case <-deadline:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Client) newAttempt(token string, peer core.NodeID) *attempt {
+	a := &attempt{peer: peer, result: make(chan result, 1), stop: make(chan struct{})}
+	c.mu.Lock()
+	c.attempts[token] = a
+	c.mu.Unlock()
+	return a
+}
+
+// finish 停止 probe；token 再保留 punchLinger 以应答对端迟到的 probe
+func (c *Client) finish(token string, a *attempt) {
+	a.once.Do(func() { close(a.stop) })
+	time.AfterFunc(punchLinger, func() {
+		c.mu.Lock()
+		if c.attempts[token] == a {
+			delete(c.attempts, token)
+		}
+		c.mu.Unlock()
+	})
+}
+
+func (a *attempt) deliver(r result) {
+	select {
+	case a.result <- r:
+	default:
+	}
+	if r.err == nil {
+		a.once.Do(func() { close(a.stop) })
+	}
+}
+
+// refresh 向会合节点登记，并向仍在使用的路径发送保活报文
+func (c *Client) refresh() {
+	if c.ID != "" {
+		reg := message{Type: msgRegister, ID: c.ID, Addrs: c.localAddrs()}
+		for _, r := range c.rendezvous {
+			_ = c.send(reg, r)
+		}
+	}
+	now := time.Now()
+	var alive []*net.UDPAddr
+	c.mu.Lock()
+	for id, p := range c.paths {
+		if now.After(p.until) {
+			delete(c.paths, id)
+			continue
+		}
+		alive = append(alive, p.addr)
+	}
+	c.mu.Unlock()
+	for _, addr := range alive {
+		_ = c.send(message{Type: msgKeepalive, ID: c.ID}, addr)
+	}
+}
+
+func (c *Client) path(id core.NodeID) *net.UDPAddr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.paths[id]; ok && time.Now().Before(p.until) {
+		return p.addr
+	}
+	return nil
+}
+
+func (c *Client) keepPath(id core.NodeID, addr *net.UDPAddr) {
+	c.mu.Lock()
+	c.paths[id] = path{addr: addr, until: time.Now().Add(pathTTL)}
+	c.mu.Unlock()
+}
+
+func (c *Client) forgetPath(id core.NodeID, addr *net.UDPAddr) {
+	c.mu.Lock()
+	if p, ok := c.paths[id]; ok && p.addr.String() == addr.String() {
+		delete(c.paths, id)
+	}
+	c.mu.Unlock()
+}
+
+func (c *Client) isRendezvous(addr *net.UDPAddr) bool {
+	for _, r := range c.rendezvous {
+		if r.Port == addr.Port && r.IP.Equal(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) send(m message, to *net.UDPAddr) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return c.ep.WriteControl(b, to)
+}
+
+// localAddrs 返回本机各网卡地址加本地 UDP 端口，供同一内网的对端直接连接
+func (c *Client) localAddrs() []string {
+	ifaddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, a := range ifaddrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok || ipn.IP.IsLoopback() || ipn.IP.IsLinkLocalUnicast() || ipn.IP.IsLinkLocalMulticast() {
+			continue
+		}
+		out = append(out, net.JoinHostPort(ipn.IP.String(), strconv.Itoa(c.port)))
+		if len(out) == MaxAddrs {
+			break
+		}
+	}
+	return out
+}
+
+// candidates 解析并校验对端的候选地址：只接受 IP 字面量，丢弃未指定与组播地址
+func candidates(addrs []string) []*net.UDPAddr {
+	var out []*net.UDPAddr
+	for _, a := range addrs {
+		if len(out) == MaxAddrs {
+			break
+		}
+		ap, err := netip.ParseAddrPort(a)
+		if err != nil || ap.Port() == 0 || ap.Addr().IsUnspecified() || ap.Addr().IsMulticast() {
+			continue
+		}
+		out = append(out, net.UDPAddrFromAddrPort(ap))
+	}
+	return out
+}
+
+func newToken() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,165 @@
+package punch
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// 单机模拟 NAT：各方都只监听 127.0.0.1，登记时上报的网卡地址（非回环）不可达，
+// 只有会合节点观察到的来源地址能打通，与两侧都在 NAT 之后、只能经映射地址互通的情形一致
+
+func startRendezvous(t *testing.T, ctx context.Context) string {
+	t.Helper()
+	r, err := ListenRendezvous("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Serve(ctx)
+	t.Cleanup(func() { r.Close() })
+	return r.Addr().String()
+}
+
+func startClient(t *testing.T, ctx context.Context, id core.NodeID, rendezvous string) *Client {
+	t.Helper()
+	c, err := Listen("127.0.0.1:0", id, []string{rendezvous})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.Start(ctx)
+	return c
+}
+
+// waitRegistered 等待客户端收到会合节点的登记应答
+func waitRegistered(t *testing.T, c *Client) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for c.Observed() == "" {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: not registered with rendezvous", c.ID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPunchConnectsThroughRendezvous(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rv := startRendezvous(t, ctx)
+	server := startClient(t, ctx, "server", rv)
+	client := startClient(t, ctx, "", rv)
+	waitRegistered(t, server)
+	if got, want := server.Observed(), server.Addr().String(); got != want {
+		t.Errorf("observed = %s, want %s", got, want)
+	}
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if s, err := server.Accept(); err == nil {
+			accepted <- s
+		}
+	}()
+
+	cctx, ccancel := context.WithTimeout(ctx, 5*time.Second)
+	defer ccancel()
+	conn, err := client.Connect(cctx, "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	var s net.Conn
+	select {
+	case s = <-accepted:
+	case <-time.After(3 * time.Second):
+		t.Fatal("server did not accept the punched stream")
+	}
+	defer s.Close()
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(s, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("server read %q, %v", buf, err)
+	}
+
+	// 打通的路径被记录，再次连接直接复用而不经会合节点
+	if p := client.path("server"); p == nil || p.String() != server.Addr().String() {
+		t.Fatalf("path to server = %v, want %s", p, server.Addr())
+	}
+	conn2, err := client.Connect(cctx, "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn2.Close()
+}
+
+func TestPunchUnknownTarget(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rv := startRendezvous(t, ctx)
+	client := startClient(t, ctx, "", rv)
+
+	start := time.Now()
+	_, err := client.Connect(ctx, "nobody")
+	if err == nil || !strings.Contains(err.Error(), "unknown node") {
+		t.Fatalf("connect to unregistered node: err = %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("rendezvous error took %s, want an immediate reply", time.Since(start))
+	}
+}
+
+func TestPunchTimeoutAndBackoff(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the punch timeout")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rv := startRendezvous(t, ctx)
+	server := startClient(t, ctx, "server", rv)
+	client := startClient(t, ctx, "", rv)
+	waitRegistered(t, server)
+	// 目标仍登记在会合节点上，但已离线：不会有 probe 到达
+	server.Close()
+
+	start := time.Now()
+	_, err := client.Connect(ctx, "server")
+	if err == nil || !strings.Contains(err.Error(), "no response") {
+		t.Fatalf("connect to offline node: err = %v", err)
+	}
+	if d := time.Since(start); d < punchTimeout-time.Second {
+		t.Errorf("connect failed after %s, want about %s", d, punchTimeout)
+	}
+
+	// failBackoff 内不再打洞，立即失败
+	start = time.Now()
+	_, err = client.Connect(ctx, "server")
+	if err == nil || !strings.Contains(err.Error(), "failed recently") {
+		t.Fatalf("connect during backoff: err = %v", err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Errorf("connect during backoff took %s", time.Since(start))
+	}
+	client.mu.Lock()
+	until := client.failed["server"]
+	client.mu.Unlock()
+	if d := time.Until(until); d <= 0 || d > failBackoff {
+		t.Errorf("backoff ends in %s, want within %s", d, failBackoff)
+	}
+
+	// 退避结束后重新经会合节点尝试
+	client.mu.Lock()
+	client.failed["server"] = time.Now().Add(-time.Second)
+	client.mu.Unlock()
+	cctx, ccancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer ccancel()
+	if _, err := client.Connect(cctx, "server"); err != context.DeadlineExceeded {
+		t.Fatalf("connect after backoff: err = %v, want a new attempt cut short by the context", err)
+	}
+}
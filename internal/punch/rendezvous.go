@@ -0,0 +1,161 @@
+package punch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/rudp"
+)
+
+const (
+	// DefaultRegistrationTTL 节点未续期多久后从会合节点移除
+	DefaultRegistrationTTL = 90 * time.Second
+	maxRegistrations       = 10000
+	maxIDLen               = 256
+)
+
+// Rendezvous 会合节点：记录已登记节点被观察到的地址，并为连接请求双方交换候选地址
+// 须部署在双方都能直接访问的地址上（公网或双方共同可达的网段），本身不转发数据
+type Rendezvous struct {
+	// TTL 节点未续期多久后被移除，默认 DefaultRegistrationTTL（Serve 前设置）
+	TTL time.Duration
+
+	ep *rudp.Endpoint
+
+	mu    sync.Mutex
+	peers map[core.NodeID]*registration
+}
+
+type registration struct {
+	observed *net.UDPAddr
+	addrs    []string
+	expires  time.Time
+}
+
+// ListenRendezvous 在 UDP 地址 addr 上启动会合节点
+func ListenRendezvous(addr string) (*Rendezvous, error) {
+	r := &Rendezvous{TTL: DefaultRegistrationTTL, peers: make(map[core.NodeID]*registration)}
+	ready := make(chan struct{}) // 同 Listen：等 r.ep 赋值后再处理报文
+	ep, err := rudp.Listen("udp", addr, func(data []byte, from *net.UDPAddr) { <-ready; r.handle(data, from) }, false)
+	if err != nil {
+		return nil, err
+	}
+	r.ep = ep
+	close(ready)
+	return r, nil
+}
+
+// Addr 返回监听地址
+func (r *Rendezvous) Addr() net.Addr { return r.ep.Addr() }
+
+// Serve 定期清理过期登记，直到 ctx 结束后关闭
+func (r *Rendezvous) Serve(ctx context.Context) error {
+	ticker := time.NewTicker(r.TTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return r.Close()
+		case <-ticker.C:
+			r.expire()
+		}
+	}
+}
+
+func (r *Rendezvous) Close() error { return r.ep.Close() }
+
+// Len 返回已登记的节点数
+func (r *Rendezvous) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.peers)
+}
+
+func (r *Rendezvous) handle(data []byte, from *net.UDPAddr) {
+	if len(data) > maxMessageSize {
+		return
+	}
+	var m message
+	if json.Unmarshal(data, &m) != nil || m.ID != "" && len(m.ID) > maxIDLen {
+		return
+	}
+	switch m.Type {
+	case msgRegister:
+		if m.ID == "" {
+			return
+		}
+		if err := r.register(m.ID, from, m.Addrs); err != nil {
+			r.send(message{Type: msgError, Msg: err.Error()}, from)
+			return
+		}
+		r.send(message{Type: msgRegistered, Observed: from.String()}, from)
+	case msgConnect:
+		if m.Target == "" || m.Token == "" {
+			return
+		}
+		r.mu.Lock()
+		reg := r.peers[m.Target]
+		if reg != nil && time.Now().After(reg.expires) {
+			reg = nil
+		}
+		r.mu.Unlock()
+		if reg == nil {
+			r.send(message{Type: msgError, Token: m.Token, Msg: "unknown node " + string(m.Target)}, from)
+			return
+		}
+		// 双方各得到对方的观察地址（首选）与本地地址
+		toTarget := message{Type: msgPunch, ID: m.ID, Token: m.Token, Addrs: withObserved(from, m.Addrs)}
+		toSource := message{Type: msgPunch, ID: m.Target, Token: m.Token, Addrs: withObserved(reg.observed, reg.addrs)}
+		r.send(toTarget, reg.observed)
+		r.send(toSource, from)
+	}
+}
+
+func (r *Rendezvous) register(id core.NodeID, observed *net.UDPAddr, addrs []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.peers[id]; !ok && len(r.peers) >= maxRegistrations {
+		return errors.New("rendezvous full")
+	}
+	if len(addrs) > MaxAddrs {
+		addrs = addrs[:MaxAddrs]
+	}
+	r.peers[id] = &registration{observed: observed, addrs: addrs, expires: time.Now().Add(r.TTL)}
+	return nil
+}
+
+func (r *Rendezvous) expire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for id, reg := range r.peers {
+		if now.After(reg.expires) {
+			delete(r.peers, id)
+		}
+	}
+}
+
+func (r *Rendezvous) send(m message, to *net.UDPAddr) {
+	if b, err := json.Marshal(m); err == nil {
+		_ = r.ep.WriteControl(b, to)
+	}
+}
+
+// withObserved 返回以观察地址开头、去重后的候选地址
+func withObserved(observed *net.UDPAddr, addrs []string) []string {
+	out := []string{observed.String()}
+	for _, a := range addrs {
+		if len(out) == MaxAddrs {
+			break
+		}
+		if a != out[0] {
+			out = append(out, a)
+		}
+	}
+	return out
+}
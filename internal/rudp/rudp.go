@@ -0,0 +1,979 @@
+// Package rudp 在单个 UDP socket 上提供可靠、有序的多路字节流（Stream 实现 net.Conn，Endpoint 实现 net.Listener）
+// 主要用于 UDP 打洞（见 internal/punch）：会合与打洞消息作为控制报文与数据流共用同一 socket，
+// 从而复用 NAT 上已建立的映射
+//
+// 报文格式（大端序）：
+//
+//	控制报文：0x01 <payload>
+//	流报文：  0x02 <flags:1> <stream:4> <seq:4> <ack:4> <wnd:2> <ts:4> <delay:4> <payload>
+//
+// 每个流的序号按报文计数：发起方的 SYN 占用序号 0，数据与 FIN 各占一个序号；接受方的序号从 1 开始
+// ack 为累计确认（期望收到的下一个序号），wnd 为接收方剩余的缓冲报文数
+// ts 为发送时本端时钟（微秒，取低 32 位）；带 flagDelay 时 delay 为本端最近收到的对端报文的单向延迟
+// （收到时本端时钟减去报文的 ts，含两端时钟差），供对端的 LEDBAT 拥塞控制使用
+// 有乱序报文时，纯确认报文带 flagSACK，payload 为 32 字节位图：第 i 位表示序号 ack+1+i 已收到
+// 发起方发送的报文带 flagInit，以区分双方各自发起、ID 相同的流
+//
+// 拥塞控制默认按丢包调整窗口（慢启动 + 丢包减半）；Endpoint.SetLEDBAT 改用 LEDBAT（RFC 6817）：
+// 按单向延迟估计瓶颈处的排队延迟，低于目标时增大窗口、高于目标时减小，使后台传输让出带宽给交互流量
+package rudp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	kindControl byte = 0x01
+	kindStream  byte = 0x02
+
+	flagSYN  = 0x01
+	flagACK  = 0x02
+	flagFIN  = 0x04
+	flagRST  = 0x08
+	flagInit = 0x10
+	flagSACK = 0x20
+	// flagDelay delay 字段有效
+	flagDelay = 0x40
+
+	sackBits = 256
+	sackSize = sackBits / 8
+	// dupThresh 序号之后至少已有这么多个报文被选择确认时，判定该报文丢失
+	dupThresh = 3
+
+	headerSize = 24
+	// MaxPacketSize 单个 UDP 报文的最大长度，低于常见路径 MTU 以避免分片
+	MaxPacketSize = 1200
+	maxPayload    = MaxPacketSize - headerSize
+
+	sendWindow    = 256 // 在途报文数上限
+	initCwnd      = 16  // 初始拥塞窗口（报文数）
+	minCwnd       = 4
+	recvWindow    = 512 // 接收缓冲报文数上限（含乱序与未读）
+	acceptBacklog = 64
+
+	initRTO      = time.Second
+	minRTO       = 200 * time.Millisecond
+	maxRTO       = 3 * time.Second
+	idleTimeout  = 15 * time.Second // 有未确认数据但对端长时间无确认时判定连接断开
+	lingerTime   = 10 * time.Second // 本地关闭后等待 FIN 交换完成的时间
+	tickInterval = 10 * time.Millisecond
+	minLossDelay = 5 * time.Millisecond // 选择确认触发的重传之间的最小间隔
+
+	// LEDBAT 参数（RFC 6817）
+	ledbatTarget      = 100 * time.Millisecond // 目标排队延迟
+	ledbatGain        = 1.0                    // 每个 RTT 窗口变化的上限（报文数）
+	ledbatMinCwnd     = 2
+	ledbatInitCwnd    = 4
+	ledbatBaseHistory = 10          // 基础延迟取最近 10 个分钟桶的最小值，以适应路由变化与时钟漂移
+	ledbatBaseBucket  = time.Minute // 基础延迟桶的时长
+	ledbatCurFilter   = 4           // 当前延迟取最近 4 个样本的最小值，过滤噪声
+)
+
+var (
+	// ErrReset 对端重置了流
+	ErrReset = errors.New("rudp: stream reset by peer")
+	// ErrTimeout 对端长时间未确认数据
+	ErrTimeout = errors.New("rudp: peer not responding")
+)
+
+type streamKey struct {
+	addr  string
+	id    uint32
+	local bool // 本端发起
+}
+
+// Endpoint 持有 UDP socket，收发控制报文并复用多个可靠流
+type Endpoint struct {
+	conn      *net.UDPConn
+	onControl func(data []byte, from *net.UDPAddr)
+	accept    bool
+
+	mu      sync.Mutex
+	streams map[streamKey]*Stream
+	nextID  uint32
+	ledbat  bool
+	bases   map[string]*baseDelay // LEDBAT 基础延迟历史，按对端地址
+
+	acceptCh  chan *Stream
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewEndpoint 在 conn 上创建端点并开始收包
+// onControl 处理控制报文（可为 nil），在收包协程中调用，不应阻塞；accept 为 false 时拒绝对端发起的流
+func NewEndpoint(conn *net.UDPConn, onControl func(data []byte, from *net.UDPAddr), accept bool) *Endpoint {
+	e := &Endpoint{
+		conn:      conn,
+		onControl: onControl,
+		accept:    accept,
+		streams:   make(map[streamKey]*Stream),
+		acceptCh:  make(chan *Stream, acceptBacklog),
+		closed:    make(chan struct{}),
+	}
+	go e.readLoop()
+	go e.tickLoop()
+	return e
+}
+
+// Listen 监听 UDP 地址并创建端点，network 为 udp、udp4 或 udp6
+func Listen(network, addr string, onControl func(data []byte, from *net.UDPAddr), accept bool) (*Endpoint, error) {
+	ua, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP(network, ua)
+	if err != nil {
+		return nil, err
+	}
+	return NewEndpoint(conn, onControl, accept), nil
+}
+
+// SetLEDBAT 设置之后新建的流是否使用 LEDBAT 拥塞控制（作为发送方时生效）
+func (e *Endpoint) SetLEDBAT(on bool) {
+	e.mu.Lock()
+	e.ledbat = on
+	e.mu.Unlock()
+}
+
+// Addr 返回本地监听地址（实现 net.Listener）
+func (e *Endpoint) Addr() net.Addr { return e.conn.LocalAddr() }
+
+// WriteControl 向 to 发送控制报文
+func (e *Endpoint) WriteControl(data []byte, to *net.UDPAddr) error {
+	b := make([]byte, 1+len(data))
+	b[0] = kindControl
+	copy(b[1:], data)
+	_, err := e.conn.WriteToUDP(b, to)
+	return err
+}
+
+// Accept 等待对端发起的流（实现 net.Listener）
+func (e *Endpoint) Accept() (net.Conn, error) {
+	select {
+	case s := <-e.acceptCh:
+		return s, nil
+	case <-e.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Dial 向 raddr 发起新流，等待对端确认
+func (e *Endpoint) Dial(ctx context.Context, raddr *net.UDPAddr) (*Stream, error) {
+	e.mu.Lock()
+	select {
+	case <-e.closed:
+		e.mu.Unlock()
+		return nil, net.ErrClosed
+	default:
+	}
+	e.nextID++
+	s := newStream(e, streamKey{addr: raddr.String(), id: e.nextID, local: true}, raddr)
+	e.streams[s.key] = s
+	e.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rcvNext = 1
+	s.queueLocked(flagSYN, nil)
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer stop()
+	for !s.established && s.err == nil && ctx.Err() == nil {
+		s.cond.Wait()
+	}
+	switch {
+	case s.established:
+		return s, nil
+	case s.err != nil:
+		return nil, s.err
+	}
+	s.resetLocked(ctx.Err())
+	return nil, ctx.Err()
+}
+
+// Close 关闭 socket 与全部流
+func (e *Endpoint) Close() error {
+	var err error
+	e.closeOnce.Do(func() {
+		close(e.closed)
+		err = e.conn.Close()
+		e.mu.Lock()
+		streams := make([]*Stream, 0, len(e.streams))
+		for _, s := range e.streams {
+			streams = append(streams, s)
+		}
+		clear(e.streams)
+		e.mu.Unlock()
+		for _, s := range streams {
+			s.mu.Lock()
+			s.failLocked(net.ErrClosed)
+			s.mu.Unlock()
+		}
+	})
+	return err
+}
+
+func (e *Endpoint) readLoop() {
+	buf := make([]byte, 64<<10)
+	for {
+		n, from, err := e.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-e.closed:
+				return
+			default:
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			if errors.Is(err, net.ErrClosed) {
+				e.Close()
+				return
+			}
+			continue // ICMP 不可达等瞬时错误
+		}
+		if n == 0 {
+			continue
+		}
+		switch buf[0] {
+		case kindControl:
+			if e.onControl != nil {
+				e.onControl(append([]byte(nil), buf[1:n]...), from)
+			}
+		case kindStream:
+			if n >= headerSize {
+				e.input(buf[:n], from)
+			}
+		}
+	}
+}
+
+func (e *Endpoint) input(b []byte, from *net.UDPAddr) {
+	h := header{
+		flags: b[1],
+		id:    binary.BigEndian.Uint32(b[2:]),
+		seq:   binary.BigEndian.Uint32(b[6:]),
+		ack:   binary.BigEndian.Uint32(b[10:]),
+		wnd:   binary.BigEndian.Uint16(b[14:]),
+		ts:    binary.BigEndian.Uint32(b[16:]),
+		delay: binary.BigEndian.Uint32(b[20:]),
+	}
+	payload := b[headerSize:]
+	key := streamKey{addr: from.String(), id: h.id, local: h.flags&flagInit == 0}
+
+	e.mu.Lock()
+	s := e.streams[key]
+	if s == nil && !key.local && h.flags&flagSYN != 0 && e.accept {
+		s = newStream(e, key, from)
+		s.established = true
+		s.sndNext, s.sndUna = 1, 1
+		select {
+		case e.acceptCh <- s:
+			e.streams[key] = s
+		default:
+			s = nil // 积压已满
+		}
+	}
+	e.mu.Unlock()
+
+	if s == nil {
+		if h.flags&flagRST == 0 {
+			e.sendReset(h, from)
+		}
+		return
+	}
+	s.input(h, payload)
+}
+
+// sendReset 对未知流回复 RST
+func (e *Endpoint) sendReset(h header, to *net.UDPAddr) {
+	b := make([]byte, headerSize)
+	b[0] = kindStream
+	b[1] = flagRST
+	if h.flags&flagInit == 0 {
+		b[1] |= flagInit
+	}
+	binary.BigEndian.PutUint32(b[2:], h.id)
+	_, _ = e.conn.WriteToUDP(b, to)
+}
+
+func (e *Endpoint) remove(s *Stream) {
+	e.mu.Lock()
+	if e.streams[s.key] == s {
+		delete(e.streams, s.key)
+	}
+	e.mu.Unlock()
+}
+
+func (e *Endpoint) tickLoop() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.closed:
+			return
+		case now := <-ticker.C:
+			e.mu.Lock()
+			streams := make([]*Stream, 0, len(e.streams))
+			for _, s := range e.streams {
+				streams = append(streams, s)
+			}
+			e.mu.Unlock()
+			for _, s := range streams {
+				if s.tick(now) {
+					e.remove(s)
+				}
+			}
+		}
+	}
+}
+
+type header struct {
+	flags byte
+	id    uint32
+	seq   uint32
+	ack   uint32
+	wnd   uint16
+	ts    uint32
+	delay uint32
+}
+
+// epoch 本端时钟的起点
+var epoch = time.Now()
+
+// clockMicros 返回本端时钟（微秒，取低 32 位，约 71 分钟回绕一次，只用于差值）
+func clockMicros() uint32 {
+	return uint32(time.Since(epoch) / time.Microsecond)
+}
+
+type segment struct {
+	seq    uint32
+	flags  byte
+	data   []byte
+	sentAt time.Time
+	sends  int
+	sacked bool
+}
+
+// Stream 可靠有序的字节流，实现 net.Conn
+type Stream struct {
+	ep    *Endpoint
+	key   streamKey
+	raddr *net.UDPAddr
+
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// 发送方向
+	sndNext  uint32     // 下一个新报文的序号
+	sndUna   uint32     // 最早未确认的序号
+	unacked  []*segment // 按序号排列
+	peerWnd  int
+	srtt     time.Duration
+	rttvar   time.Duration
+	rto      time.Duration
+	highSack uint32 // 已被选择确认的最高序号，hasSack 为 false 时无效
+	hasSack  bool
+	nSacked  int     // unacked 中已被选择确认的报文数
+	cwnd     float64 // 拥塞窗口（报文数），慢启动 + 丢包减半
+	ssthresh float64
+	recovery uint32    // 本轮减窗时的 sndNext，其之前的丢包不再减窗
+	progress time.Time // 最近一次确认推进的时间
+
+	// LEDBAT（ledbat 为 false 时不使用）
+	ledbat    bool
+	base      *baseDelay // 与到同一对端的其他流共享
+	curDelays []uint32   // 最近的单向延迟样本
+
+	// 对端方向的单向延迟，随发出的报文回送给对端
+	owd    uint32
+	hasOWD bool
+
+	// 接收方向
+	rcvNext uint32
+	ooo     map[uint32]*segment // 乱序到达的报文
+	readBuf [][]byte
+	finRecv bool
+
+	established bool
+	finSent     bool
+	closed      bool // 本地已 Close
+	closedAt    time.Time
+	err         error
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+	rdTimer       *time.Timer
+	wrTimer       *time.Timer
+}
+
+// newStream 创建流，调用方持有 e.mu
+func newStream(e *Endpoint, key streamKey, raddr *net.UDPAddr) *Stream {
+	s := &Stream{
+		ep:       e,
+		key:      key,
+		raddr:    raddr,
+		peerWnd:  recvWindow,
+		rto:      initRTO,
+		cwnd:     initCwnd,
+		ssthresh: sendWindow,
+		progress: time.Now(),
+		ooo:      make(map[uint32]*segment),
+		ledbat:   e.ledbat,
+	}
+	if s.ledbat {
+		s.cwnd = ledbatInitCwnd
+		s.base = e.baseDelayLocked(key.addr, time.Now())
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *Stream) input(h header, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h.flags&flagRST != 0 {
+		s.failLocked(ErrReset)
+		return
+	}
+	if s.err != nil {
+		return
+	}
+	s.owd, s.hasOWD = clockMicros()-h.ts, true
+	if s.ledbat && h.flags&flagDelay != 0 {
+		s.delaySampleLocked(h.delay, time.Now())
+	}
+	var sack []byte
+	if h.flags&flagSACK != 0 && len(payload) >= sackSize {
+		sack, payload = payload[:sackSize], payload[sackSize:]
+	}
+	if h.flags&flagACK != 0 {
+		s.ackLocked(h, sack)
+	}
+	if len(payload) == 0 && h.flags&(flagSYN|flagFIN) == 0 {
+		return
+	}
+
+	// 占用序号的报文：按序交付，乱序暂存，重复或超出窗口的只回复确认
+	if d := int32(h.seq - s.rcvNext); d >= 0 && d < recvWindow && s.ooo[h.seq] == nil {
+		s.ooo[h.seq] = &segment{seq: h.seq, flags: h.flags, data: append([]byte(nil), payload...)}
+		for {
+			seg := s.ooo[s.rcvNext]
+			if seg == nil {
+				break
+			}
+			delete(s.ooo, s.rcvNext)
+			s.rcvNext++
+			if len(seg.data) > 0 {
+				s.readBuf = append(s.readBuf, seg.data)
+			}
+			if seg.flags&flagFIN != 0 {
+				s.finRecv = true
+			}
+		}
+		s.cond.Broadcast()
+	}
+	s.sendAckLocked()
+}
+
+// sendAckLocked 发送纯确认；有乱序报文时附带选择确认位图
+func (s *Stream) sendAckLocked() {
+	if len(s.ooo) == 0 {
+		s.sendLocked(0, s.sndNext, nil)
+		return
+	}
+	bitmap := make([]byte, sackSize)
+	for seq := range s.ooo {
+		if i := seq - s.rcvNext - 1; i < sackBits {
+			bitmap[i/8] |= 1 << (i % 8)
+		}
+	}
+	s.sendLocked(flagSACK, s.sndNext, bitmap)
+}
+
+// ackLocked 处理累计确认、选择确认与接收窗口
+func (s *Stream) ackLocked(h header, sack []byte) {
+	s.peerWnd = int(h.wnd)
+	now := time.Now()
+	if d := int32(h.ack - s.sndUna); d > 0 && int32(h.ack-s.sndNext) <= 0 {
+		// 填补空洞后的累计确认会确认早已到达的报文，只在没有乱序时以其估计 RTT
+		sample := !s.hasSack
+		for len(s.unacked) > 0 && int32(s.unacked[0].seq-h.ack) < 0 {
+			seg := s.unacked[0]
+			if sample && seg.sends == 1 {
+				s.updateRTT(now.Sub(seg.sentAt))
+			}
+			if seg.sacked {
+				s.nSacked--
+			} else {
+				s.growLocked()
+			}
+			s.unacked = s.unacked[1:]
+		}
+		s.sndUna = h.ack
+		s.progress = now
+		s.rto = s.baseRTO() // 确认推进后取消退避
+		if s.hasSack && int32(s.highSack-h.ack) < 0 {
+			s.hasSack = false
+		}
+		if !s.established && s.key.local {
+			s.established = true
+		}
+		s.cond.Broadcast()
+	} else if d < 0 {
+		return // 过时的确认
+	}
+	if sack != nil {
+		var newest *segment
+		for _, seg := range s.unacked {
+			i := seg.seq - h.ack - 1
+			if i >= sackBits {
+				continue
+			}
+			if !seg.sacked && sack[i/8]&(1<<(i%8)) != 0 {
+				seg.sacked = true
+				s.nSacked++
+				s.growLocked()
+				newest = seg
+				if !s.hasSack || int32(seg.seq-s.highSack) > 0 {
+					s.highSack, s.hasSack = seg.seq, true
+				}
+			}
+		}
+		if newest != nil && newest.sends == 1 {
+			s.updateRTT(now.Sub(newest.sentAt))
+		}
+		s.retransmitLostLocked(now)
+	}
+	if s.peerWnd > 0 {
+		s.cond.Broadcast()
+	}
+}
+
+// retransmitLostLocked 重传其后已有 dupThresh 个序号被选择确认、且距上次发送超过约一个 RTT 的报文
+func (s *Stream) retransmitLostLocked(now time.Time) {
+	if !s.hasSack {
+		return
+	}
+	wait := max(s.srtt+s.srtt/4, minLossDelay)
+	for _, seg := range s.unacked {
+		if int32(s.highSack-seg.seq) < dupThresh {
+			break
+		}
+		if !seg.sacked && now.Sub(seg.sentAt) >= wait {
+			if int32(seg.seq-s.recovery) >= 0 {
+				s.shrinkLocked()
+			}
+			s.retransmitLocked(seg)
+		}
+	}
+}
+
+// growLocked 每确认一个报文：慢启动阶段窗口加 1，之后每个窗口加 1；LEDBAT 见 ledbatGrowLocked
+func (s *Stream) growLocked() {
+	if s.ledbat {
+		s.ledbatGrowLocked()
+		return
+	}
+	if s.cwnd < s.ssthresh {
+		s.cwnd++
+	} else {
+		s.cwnd += 1 / s.cwnd
+	}
+	s.cwnd = min(s.cwnd, sendWindow)
+}
+
+// shrinkLocked 检测到丢包时窗口减半，同一窗口内的多次丢包只减一次
+func (s *Stream) shrinkLocked() {
+	s.ssthresh = max(s.cwnd/2, s.minCwnd())
+	s.cwnd = s.ssthresh
+	s.recovery = s.sndNext
+}
+
+func (s *Stream) minCwnd() float64 {
+	if s.ledbat {
+		return ledbatMinCwnd
+	}
+	return minCwnd
+}
+
+// ledbatGrowLocked 每确认一个报文按排队延迟与目标的差距调整窗口：
+// 排队延迟为 0 时每个 RTT 增加 ledbatGain 个报文，达到目标时不变，超过目标时按比例减小
+// 排队延迟低于目标的 1/4 且未发生过丢包时按慢启动增长，以便尽快用满空闲链路
+func (s *Stream) ledbatGrowLocked() {
+	queuing := s.queuingDelayLocked()
+	if s.cwnd < s.ssthresh && queuing < ledbatTarget/4 {
+		s.cwnd++
+	} else {
+		if s.cwnd < s.ssthresh {
+			s.ssthresh = s.cwnd // 排队延迟上升，结束慢启动
+		}
+		off := float64(ledbatTarget-queuing) / float64(ledbatTarget)
+		s.cwnd += ledbatGain * max(off, -1) / s.cwnd
+	}
+	s.cwnd = min(max(s.cwnd, ledbatMinCwnd), sendWindow)
+}
+
+// delaySampleLocked 记录对端回送的单向延迟样本（含两端时钟差，只用于与基础延迟作差）
+func (s *Stream) delaySampleLocked(d uint32, now time.Time) {
+	s.base.sample(d, now)
+	s.curDelays = append(s.curDelays, d)
+	if len(s.curDelays) > ledbatCurFilter {
+		s.curDelays = s.curDelays[1:]
+	}
+}
+
+// queuingDelayLocked 当前延迟减去基础延迟，即估计的排队延迟
+func (s *Stream) queuingDelayLocked() time.Duration {
+	if len(s.curDelays) == 0 {
+		return 0
+	}
+	base, ok := s.base.min()
+	if !ok {
+		return 0
+	}
+	cur := s.curDelays[0]
+	for _, d := range s.curDelays[1:] {
+		if int32(d-cur) < 0 {
+			cur = d
+		}
+	}
+	q := int32(cur - base)
+	if q <= 0 {
+		return 0
+	}
+	return time.Duration(q) * time.Microsecond
+}
+
+// baseDelay 到一个对端的基础延迟历史。下载端每个请求新建一个流，若各流从头估计基础延迟，
+// 瓶颈处已有的排队延迟会被当作基础延迟，LEDBAT 就不会让出带宽；因此由 Endpoint 按对端地址保存，在流之间共享
+type baseDelay struct {
+	mu    sync.Mutex
+	hist  []uint32  // 各分钟桶内的最小单向延迟，最后一个为当前桶
+	start time.Time // 当前桶的开始时间
+}
+
+// baseDelayLocked 返回到 addr 的基础延迟历史，并丢弃已整体过期的其他对端的历史；调用方持有 e.mu
+func (e *Endpoint) baseDelayLocked(addr string, now time.Time) *baseDelay {
+	if e.bases == nil {
+		e.bases = make(map[string]*baseDelay)
+	}
+	for a, b := range e.bases {
+		if a != addr && b.expired(now) {
+			delete(e.bases, a)
+		}
+	}
+	b := e.bases[addr]
+	if b == nil {
+		b = &baseDelay{start: now}
+		e.bases[addr] = b
+	}
+	return b
+}
+
+func (b *baseDelay) sample(d uint32, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch elapsed := now.Sub(b.start); {
+	case len(b.hist) == 0 || elapsed >= ledbatBaseHistory*ledbatBaseBucket:
+		b.hist, b.start = []uint32{d}, now
+	case elapsed >= ledbatBaseBucket:
+		b.hist = append(b.hist, d)
+		if len(b.hist) > ledbatBaseHistory {
+			b.hist = b.hist[1:]
+		}
+		b.start = now
+	default:
+		if last := &b.hist[len(b.hist)-1]; int32(d-*last) < 0 {
+			*last = d
+		}
+	}
+}
+
+// min 返回历史中的最小延迟，即基础延迟
+func (b *baseDelay) min() (uint32, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.hist) == 0 {
+		return 0, false
+	}
+	base := b.hist[0]
+	for _, d := range b.hist[1:] {
+		if int32(d-base) < 0 {
+			base = d
+		}
+	}
+	return base, true
+}
+
+func (b *baseDelay) expired(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return now.Sub(b.start) >= ledbatBaseHistory*ledbatBaseBucket
+}
+
+func (s *Stream) updateRTT(rtt time.Duration) {
+	if s.srtt == 0 {
+		s.srtt, s.rttvar = rtt, rtt/2
+	} else {
+		delta := s.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		s.rttvar = (3*s.rttvar + delta) / 4
+		s.srtt = (7*s.srtt + rtt) / 8
+	}
+	s.rto = s.baseRTO()
+}
+
+func (s *Stream) baseRTO() time.Duration {
+	if s.srtt == 0 {
+		return initRTO
+	}
+	return min(max(s.srtt+4*s.rttvar, minRTO), maxRTO)
+}
+
+// tick 处理重传与超时，返回 true 表示流已结束、可从端点移除
+func (s *Stream) tick(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return true
+	}
+	if s.closed && (s.finRecv && len(s.unacked) == 0 || now.Sub(s.closedAt) > lingerTime) {
+		s.failLocked(net.ErrClosed)
+		return true
+	}
+	if len(s.unacked) == 0 {
+		return false
+	}
+	if now.Sub(s.progress) > idleTimeout {
+		s.failLocked(ErrTimeout)
+		return true
+	}
+	if now.Sub(s.unacked[0].sentAt) >= s.rto {
+		s.shrinkLocked()
+		s.cwnd = s.minCwnd()
+		// 超时视为此前发出、未被选择确认的报文全部丢失，一次最多重传 ssthresh 个
+		n := 0
+		for _, seg := range s.unacked {
+			if n >= int(s.ssthresh) {
+				break
+			}
+			if !seg.sacked && now.Sub(seg.sentAt) >= s.rto {
+				s.retransmitLocked(seg)
+				n++
+			}
+		}
+		s.rto = min(s.rto*2, maxRTO)
+	}
+	return false
+}
+
+// queueLocked 分配序号并发送占用序号的报文（SYN、数据、FIN）
+func (s *Stream) queueLocked(flags byte, data []byte) {
+	seg := &segment{seq: s.sndNext, flags: flags, data: data}
+	s.sndNext++
+	if len(s.unacked) == 0 {
+		s.progress = time.Now()
+	}
+	s.unacked = append(s.unacked, seg)
+	s.retransmitLocked(seg)
+}
+
+func (s *Stream) retransmitLocked(seg *segment) {
+	seg.sentAt = time.Now()
+	seg.sends++
+	s.sendLocked(seg.flags, seg.seq, seg.data)
+}
+
+// sendLocked 发送报文，总是携带对对端数据的累计确认与本端剩余接收窗口
+func (s *Stream) sendLocked(flags byte, seq uint32, data []byte) {
+	b := make([]byte, headerSize+len(data))
+	b[0] = kindStream
+	b[1] = flags | flagACK
+	if s.key.local {
+		b[1] |= flagInit
+	}
+	binary.BigEndian.PutUint32(b[2:], s.key.id)
+	binary.BigEndian.PutUint32(b[6:], seq)
+	binary.BigEndian.PutUint32(b[10:], s.rcvNext)
+	binary.BigEndian.PutUint16(b[14:], uint16(s.recvAvailLocked()))
+	binary.BigEndian.PutUint32(b[16:], clockMicros())
+	if s.hasOWD {
+		b[1] |= flagDelay
+		binary.BigEndian.PutUint32(b[20:], s.owd)
+	}
+	copy(b[headerSize:], data)
+	_, _ = s.ep.conn.WriteToUDP(b, s.raddr)
+}
+
+func (s *Stream) recvAvailLocked() int {
+	return max(recvWindow-len(s.ooo)-len(s.readBuf), 0)
+}
+
+func (s *Stream) failLocked(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+	s.stopTimersLocked()
+	s.cond.Broadcast()
+}
+
+// resetLocked 放弃流并通知对端
+func (s *Stream) resetLocked(err error) {
+	s.sendLocked(flagRST, s.sndNext, nil)
+	s.failLocked(err)
+	go s.ep.remove(s)
+}
+
+func (s *Stream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if len(s.readBuf) > 0 {
+			before := s.recvAvailLocked()
+			n := copy(p, s.readBuf[0])
+			if n == len(s.readBuf[0]) {
+				s.readBuf = s.readBuf[1:]
+			} else {
+				s.readBuf[0] = s.readBuf[0][n:]
+			}
+			if before < recvWindow/4 && s.recvAvailLocked() >= recvWindow/4 {
+				// 窗口重新打开，通知对端继续发送
+				s.sendAckLocked()
+			}
+			return n, nil
+		}
+		switch {
+		case s.finRecv:
+			return 0, io.EOF
+		case s.closed:
+			return 0, net.ErrClosed
+		case s.err != nil:
+			return 0, s.err
+		case !s.readDeadline.IsZero() && !time.Now().Before(s.readDeadline):
+			return 0, os.ErrDeadlineExceeded
+		}
+		s.cond.Wait()
+	}
+}
+
+func (s *Stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		for {
+			switch {
+			case s.closed || s.finSent:
+				return written, net.ErrClosed
+			case s.err != nil:
+				return written, s.err
+			case !s.writeDeadline.IsZero() && !time.Now().Before(s.writeDeadline):
+				return written, os.ErrDeadlineExceeded
+			}
+			// 已被选择确认的报文不再占用在途额度，丢包恢复期间仍可继续发送新数据以获得新的确认；
+			// 总跨度不超过接收窗口。对端窗口为 0 时仍允许 1 个在途报文作为窗口探测
+			inflight := len(s.unacked) - s.nSacked
+			if inflight < min(int(s.cwnd), max(s.peerWnd, 1)) && len(s.unacked) < recvWindow {
+				break
+			}
+			s.cond.Wait()
+		}
+		n := min(len(p), maxPayload)
+		s.queueLocked(0, append([]byte(nil), p[:n]...))
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close 发送 FIN 并关闭本地读写；未确认的数据在后台继续重传
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed, s.closedAt = true, time.Now()
+	if s.err == nil && !s.finSent {
+		s.finSent = true
+		s.queueLocked(flagFIN, nil)
+	}
+	s.cond.Broadcast()
+	return nil
+}
+
+// CloseWrite 发送 FIN，之后仍可读取对端数据
+func (s *Stream) CloseWrite() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if !s.finSent {
+		s.finSent = true
+		s.queueLocked(flagFIN, nil)
+	}
+	return nil
+}
+
+func (s *Stream) LocalAddr() net.Addr  { return s.ep.conn.LocalAddr() }
+func (s *Stream) RemoteAddr() net.Addr { return s.raddr }
+
+func (s *Stream) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readDeadline = t
+	s.rdTimer = s.armLocked(s.rdTimer, t)
+	return nil
+}
+
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeDeadline = t
+	s.wrTimer = s.armLocked(s.wrTimer, t)
+	return nil
+}
+
+// armLocked 在截止时间到达时唤醒等待中的读写
+func (s *Stream) armLocked(timer *time.Timer, t time.Time) *time.Timer {
+	if timer != nil {
+		timer.Stop()
+	}
+	if t.IsZero() {
+		return nil
+	}
+	return time.AfterFunc(time.Until(t), func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+}
+
+func (s *Stream) stopTimersLocked() {
+	if s.rdTimer != nil {
+		s.rdTimer.Stop()
+	}
+	if s.wrTimer != nil {
+		s.wrTimer.Stop()
+	}
+}
@@ -0,0 +1,202 @@
+package rudp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ledbatStream 创建到 addr 的 LEDBAT 流（不收发报文），用于直接驱动拥塞控制
+func ledbatStream(e *Endpoint, addr string) *Stream {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ledbat = true
+	e.nextID++
+	return newStream(e, streamKey{addr: addr, id: e.nextID, local: true}, nil)
+}
+
+func TestQueuingDelay(t *testing.T) {
+	e := &Endpoint{}
+	s := ledbatStream(e, "peer:1")
+	now := time.Now()
+	if q := s.queuingDelayLocked(); q != 0 {
+		t.Fatalf("queuing delay without samples = %v", q)
+	}
+	// 时钟差使延迟样本很大，只有与基础延迟的差有意义；样本回绕 32 位也应正确作差
+	skew := ^uint32(0) - 20000
+	s.delaySampleLocked(skew+30000, now)
+	if q := s.queuingDelayLocked(); q != 0 {
+		t.Fatalf("first sample queuing delay = %v, want 0", q)
+	}
+	for _, d := range []uint32{80000, 60000, 90000, 70000} {
+		s.delaySampleLocked(skew+d, now)
+	}
+	// 当前延迟取最近 4 个样本的最小值（60ms），基础延迟 30ms
+	if q := s.queuingDelayLocked(); q != 30*time.Millisecond {
+		t.Fatalf("queuing delay = %v, want 30ms", q)
+	}
+
+	// 基础延迟在超过历史窗口后被遗忘
+	later := now.Add(ledbatBaseHistory * ledbatBaseBucket)
+	for range ledbatCurFilter {
+		s.delaySampleLocked(skew+50000, later)
+	}
+	if q := s.queuingDelayLocked(); q != 0 {
+		t.Fatalf("queuing delay after the base history expired = %v, want 0", q)
+	}
+}
+
+func TestBaseDelaySharedAcrossStreams(t *testing.T) {
+	e := &Endpoint{}
+	now := time.Now()
+	first := ledbatStream(e, "peer:1")
+	first.delaySampleLocked(10000, now)
+
+	// 同一对端的新流沿用已有的基础延迟：链路已排队时新流也能测到排队延迟
+	second := ledbatStream(e, "peer:1")
+	for range ledbatCurFilter {
+		second.delaySampleLocked(150000, now)
+	}
+	if q := second.queuingDelayLocked(); q != 140*time.Millisecond {
+		t.Fatalf("second stream queuing delay = %v, want 140ms", q)
+	}
+	other := ledbatStream(e, "peer:2")
+	other.delaySampleLocked(150000, now)
+	if q := other.queuingDelayLocked(); q != 0 {
+		t.Fatalf("stream to another peer queuing delay = %v, want 0", q)
+	}
+}
+
+func TestLEDBATGrow(t *testing.T) {
+	now := time.Now()
+	grow := func(queuing time.Duration, cwnd, ssthresh float64) *Stream {
+		s := ledbatStream(&Endpoint{}, "peer:1")
+		s.delaySampleLocked(1000, now)
+		for range ledbatCurFilter {
+			s.delaySampleLocked(1000+uint32(queuing/time.Microsecond), now)
+		}
+		s.cwnd, s.ssthresh = cwnd, ssthresh
+		s.ledbatGrowLocked()
+		return s
+	}
+	tests := []struct {
+		name         string
+		queuing      time.Duration
+		cwnd, thresh float64
+		want         float64
+		wantThresh   float64
+	}{
+		{"slow start while idle", 0, 10, sendWindow, 11, sendWindow},
+		{"slow start ends on rising delay", ledbatTarget / 2, 10, sendWindow, 10 + 0.5/10, 10},
+		{"no delay grows one packet per window", 0, 20, 20, 20 + 1.0/20, 20},
+		{"at target holds", ledbatTarget, 20, 20, 20, 20},
+		{"above target shrinks", ledbatTarget * 3 / 2, 20, 20, 20 - 0.5/20, 20},
+		{"far above target shrinks at most gain per window", ledbatTarget * 10, 20, 20, 20 - 1.0/20, 20},
+		{"never below minimum", ledbatTarget * 10, ledbatMinCwnd, ledbatMinCwnd, ledbatMinCwnd, ledbatMinCwnd},
+		{"capped at send window", 0, sendWindow, sendWindow * 2, sendWindow, sendWindow * 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := grow(tt.queuing, tt.cwnd, tt.thresh)
+			if diff := s.cwnd - tt.want; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("cwnd = %v, want %v", s.cwnd, tt.want)
+			}
+			if s.ssthresh != tt.wantThresh {
+				t.Errorf("ssthresh = %v, want %v", s.ssthresh, tt.wantThresh)
+			}
+		})
+	}
+}
+
+// lossyProxy 在两个端点之间转发 UDP 报文，丢弃 client -> server 方向每 n 个流报文中的一个
+func lossyProxy(t *testing.T, server *net.UDPAddr, n int) (*net.UDPAddr, *atomic.Int64) {
+	t.Helper()
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	dropped := new(atomic.Int64)
+	go func() {
+		var client *net.UDPAddr
+		buf := make([]byte, 2048)
+		count := 0
+		for {
+			m, from, err := pc.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if from.String() == server.String() {
+				if client != nil {
+					pc.WriteToUDP(buf[:m], client)
+				}
+				continue
+			}
+			client = from
+			if count++; buf[0] == kindStream && count%n == 0 {
+				dropped.Add(1)
+				continue
+			}
+			pc.WriteToUDP(buf[:m], server)
+		}
+	}()
+	return pc.LocalAddr().(*net.UDPAddr), dropped
+}
+
+func TestStreamRetransmitsLostPackets(t *testing.T) {
+	server, err := Listen("udp", "127.0.0.1:0", nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := Listen("udp", "127.0.0.1:0", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	proxy, dropped := lossyProxy(t, server.Addr().(*net.UDPAddr), 7)
+
+	data := make([]byte, 300<<10)
+	rand.Read(data)
+	got := make(chan []byte, 1)
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			got <- nil
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		got <- b
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	s, err := client.Dial(ctx, proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case b := <-got:
+		if !bytes.Equal(b, data) {
+			t.Fatalf("received %d bytes, want the %d bytes sent", len(b), len(data))
+		}
+	case <-ctx.Done():
+		t.Fatal("transfer over a lossy path did not complete")
+	}
+	if dropped.Load() == 0 {
+		t.Fatal("proxy dropped nothing")
+	}
+	s.Close()
+}
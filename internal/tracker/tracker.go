@@ -0,0 +1,390 @@
+// Package tracker 实现轻量级 tracker：节点登记自己提供的 FileID 与服务地址，其他节点按文件查询持有者
+// 适用于广播、组播无法跨越的多网段环境，协议为 HTTP + JSON：
+//
+//	POST /v1/announce              登记或续期（AnnounceRequest），Leave 为 true 时注销
+//	GET  /v1/providers?file=<id>   查询持有文件的节点（PeersResponse）
+//	GET  /v1/nodes                 列出全部在线节点（PeersResponse）
+//
+// 两个查询接口都支持 exclude=<nodeID>（排除请求方自身）与 limit=<n>
+//
+// 节点ID首次登记时 tracker 分配一个凭据（AnnounceResponse.Secret），登记过期前该ID的续期与注销都须携带此凭据，
+// 其他客户端无法以同一节点ID注销或改写其登记
+package tracker
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+const (
+	DefaultTTL      = 2 * time.Minute  // 节点未续期多久后被移除
+	DefaultInterval = 30 * time.Second // 建议节点续期的间隔
+
+	MaxFiles     = 4096 // 单次登记的文件数上限
+	MaxAddrs     = 8    // 每个节点的服务地址数上限
+	MaxPeers     = 50   // 查询结果的节点数上限
+	maxNodes     = 10000
+	maxBodySize  = 1 << 20
+	maxIDLen     = 256
+	expirePeriod = 10 * time.Second
+)
+
+// AnnounceRequest 节点登记请求
+// 服务地址取请求来源 IP 与 Port；Addrs 为额外宣告的地址，host 为空或未指定地址时替换为来源 IP
+type AnnounceRequest struct {
+	ID    core.NodeID   `json:"id"`
+	Port  int           `json:"port"`
+	Addrs []string      `json:"addrs,omitempty"`
+	Files []core.FileID `json:"files,omitempty"`
+	Leave bool          `json:"leave,omitempty"`
+	// Secret 首次登记时 tracker 分配的凭据，续期与注销时须携带
+	Secret string `json:"secret,omitempty"`
+}
+
+// AnnounceResponse 登记应答，Interval 为建议的续期间隔（秒），Secret 为该节点ID的登记凭据
+type AnnounceResponse struct {
+	Interval int    `json:"interval"`
+	Secret   string `json:"secret"`
+}
+
+// ErrNotOwner 请求未携带节点ID登记时分配的凭据
+var ErrNotOwner = errors.New("node id registered by another client")
+
+// Peer 查询结果中的节点
+type Peer struct {
+	ID       core.NodeID `json:"id"`
+	Addrs    []string    `json:"addrs"`
+	LastSeen time.Time   `json:"lastSeen"`
+}
+
+// PeersResponse 查询应答
+type PeersResponse struct {
+	Peers []Peer `json:"peers"`
+}
+
+type entry struct {
+	peer    Peer
+	files   []core.FileID
+	expires time.Time
+	secret  string
+}
+
+// Server tracker 服务端，节点信息只保存在内存中
+type Server struct {
+	// TTL 节点未续期多久后被移除，默认 DefaultTTL（启动前设置）
+	TTL time.Duration
+	// Interval 建议节点续期的间隔，默认 DefaultInterval（启动前设置）
+	Interval time.Duration
+
+	mu    sync.Mutex
+	nodes map[core.NodeID]*entry
+	files map[core.FileID]map[core.NodeID]struct{}
+}
+
+func NewServer() *Server {
+	return &Server{
+		TTL:      DefaultTTL,
+		Interval: DefaultInterval,
+		nodes:    make(map[core.NodeID]*entry),
+		files:    make(map[core.FileID]map[core.NodeID]struct{}),
+	}
+}
+
+// Handler 返回 tracker 的 HTTP 处理器，可挂载到已有的 http.Server
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/announce", s.handleAnnounce)
+	mux.HandleFunc("/v1/providers", s.handleProviders)
+	mux.HandleFunc("/v1/nodes", s.handleNodes)
+	return mux
+}
+
+// ListenAndServe 在 addr 上提供服务并定期清理过期节点，直到 ctx 结束
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve 在 ln 上提供服务，直到 ctx 结束
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+	go func() {
+		ticker := time.NewTicker(expirePeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				_ = srv.Shutdown(shutdownCtx)
+				return
+			case <-ticker.C:
+				s.expire()
+			}
+		}
+	}()
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Len 返回在线节点数
+func (s *Server) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.nodes)
+}
+
+func (s *Server) handleAnnounce(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req AnnounceRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" || len(req.ID) > maxIDLen {
+		http.Error(w, "invalid node id", http.StatusBadRequest)
+		return
+	}
+	if len(req.Files) > MaxFiles {
+		http.Error(w, fmt.Sprintf("too many files (max %d)", MaxFiles), http.StatusBadRequest)
+		return
+	}
+	if req.Leave {
+		if err := s.remove(req.ID, req.Secret); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		http.Error(w, "invalid remote address", http.StatusBadRequest)
+		return
+	}
+	addrs := announcedAddrs(remote, req.Port, req.Addrs)
+	if len(addrs) == 0 {
+		http.Error(w, "no service address", http.StatusBadRequest)
+		return
+	}
+	secret, err := s.register(Peer{ID: req.ID, Addrs: addrs, LastSeen: time.Now()}, req.Files, req.Secret)
+	switch {
+	case errors.Is(err, ErrNotOwner):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, AnnounceResponse{Interval: int(s.Interval / time.Second), Secret: secret})
+}
+
+func (s *Server) handleProviders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := core.FileID(r.URL.Query().Get("file"))
+	if id == "" {
+		http.Error(w, "missing file", http.StatusBadRequest)
+		return
+	}
+	exclude, limit := queryOptions(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	resp := PeersResponse{Peers: []Peer{}}
+	for nid := range s.files[id] {
+		e := s.nodes[nid]
+		if nid == exclude || e == nil || now.After(e.expires) {
+			continue
+		}
+		resp.Peers = append(resp.Peers, e.peer)
+		if len(resp.Peers) >= limit {
+			break
+		}
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	exclude, limit := queryOptions(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	resp := PeersResponse{Peers: []Peer{}}
+	for nid, e := range s.nodes {
+		if nid == exclude || now.After(e.expires) {
+			continue
+		}
+		resp.Peers = append(resp.Peers, e.peer)
+		if len(resp.Peers) >= limit {
+			break
+		}
+	}
+	writeJSON(w, resp)
+}
+
+// register 登记节点，替换其此前登记的文件列表，返回该节点ID的凭据；
+// 节点ID已有未过期的登记时 secret 须与其凭据一致
+func (s *Server) register(p Peer, files []core.FileID, secret string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, exists := s.nodes[p.ID]
+	if exists && time.Now().After(old.expires) {
+		s.removeLocked(p.ID)
+		old, exists = nil, false
+	}
+	switch {
+	case exists && !validSecret(old.secret, secret):
+		return "", ErrNotOwner
+	case exists:
+		s.unindexLocked(p.ID, old.files)
+		secret = old.secret
+	case len(s.nodes) >= maxNodes:
+		return "", errors.New("tracker full")
+	default:
+		secret = newSecret()
+	}
+	s.nodes[p.ID] = &entry{peer: p, files: files, expires: time.Now().Add(s.TTL), secret: secret}
+	for _, f := range files {
+		m := s.files[f]
+		if m == nil {
+			m = make(map[core.NodeID]struct{})
+			s.files[f] = m
+		}
+		m[p.ID] = struct{}{}
+	}
+	return secret, nil
+}
+
+// remove 注销节点，secret 须与其登记凭据一致
+func (s *Server) remove(id core.NodeID, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.nodes[id]; ok && !validSecret(e.secret, secret) {
+		return ErrNotOwner
+	}
+	s.removeLocked(id)
+	return nil
+}
+
+func newSecret() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validSecret(want, got string) bool {
+	return subtle.ConstantTimeCompare([]byte(want), []byte(got)) == 1
+}
+
+func (s *Server) removeLocked(id core.NodeID) {
+	if e, ok := s.nodes[id]; ok {
+		s.unindexLocked(id, e.files)
+		delete(s.nodes, id)
+	}
+}
+
+func (s *Server) unindexLocked(id core.NodeID, files []core.FileID) {
+	for _, f := range files {
+		if m := s.files[f]; m != nil {
+			delete(m, id)
+			if len(m) == 0 {
+				delete(s.files, f)
+			}
+		}
+	}
+}
+
+func (s *Server) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, e := range s.nodes {
+		if now.After(e.expires) {
+			s.removeLocked(id)
+		}
+	}
+}
+
+// announcedAddrs 构造节点的服务地址：来源 IP + port 在前，其后为校验过的额外地址
+func announcedAddrs(remote string, port int, extra []string) []string {
+	var out []string
+	add := func(a string) {
+		for _, x := range out {
+			if x == a {
+				return
+			}
+		}
+		if len(out) < MaxAddrs {
+			out = append(out, a)
+		}
+	}
+	if port > 0 && port <= 65535 {
+		add(net.JoinHostPort(remote, strconv.Itoa(port)))
+	}
+	for _, a := range extra {
+		host, p, err := net.SplitHostPort(a)
+		if err != nil {
+			continue
+		}
+		if n, err := strconv.Atoi(p); err != nil || n <= 0 || n > 65535 {
+			continue
+		}
+		ip := net.ParseIP(host)
+		switch {
+		case host == "" || ip != nil && ip.IsUnspecified():
+			host = remote
+		case ip != nil && ip.IsMulticast():
+			continue
+		case ip == nil && len(host) > 253:
+			continue
+		}
+		add(net.JoinHostPort(host, p))
+	}
+	return out
+}
+
+func queryOptions(r *http.Request) (exclude core.NodeID, limit int) {
+	q := r.URL.Query()
+	limit = MaxPeers
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 && n < limit {
+		limit = n
+	}
+	return core.NodeID(q.Get("exclude")), limit
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,195 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+func announce(t *testing.T, base string, req AnnounceRequest) *http.Response {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	resp, err := http.Post(base+"/v1/announce", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+// join 登记节点并返回 tracker 分配的凭据
+func join(t *testing.T, base string, req AnnounceRequest) string {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	resp, err := http.Post(base+"/v1/announce", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("announce %s: %s", req.ID, resp.Status)
+	}
+	var ar AnnounceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ar); err != nil || ar.Secret == "" {
+		t.Fatalf("announce %s: response %+v, %v", req.ID, ar, err)
+	}
+	return ar.Secret
+}
+
+func peers(t *testing.T, base, path string, q url.Values) []Peer {
+	t.Helper()
+	resp, err := http.Get(base + path + "?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", path, resp.Status)
+	}
+	var pr PeersResponse
+	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+		t.Fatal(err)
+	}
+	return pr.Peers
+}
+
+func peerIDs(ps []Peer) []core.NodeID {
+	var ids []core.NodeID
+	for _, p := range ps {
+		ids = append(ids, p.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestRegisterAndQuery(t *testing.T) {
+	s := NewServer()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	secretA := join(t, ts.URL, AnnounceRequest{ID: "a", Port: 9001, Addrs: []string{":9101", "0.0.0.0:9201", "203.0.113.7:9301", "224.0.0.1:9401"}, Files: []core.FileID{"f1", "f2"}})
+	secretB := join(t, ts.URL, AnnounceRequest{ID: "b", Port: 9002, Files: []core.FileID{"f2"}})
+	announce(t, ts.URL, AnnounceRequest{ID: "c", Port: 9003})
+
+	ps := peers(t, ts.URL, "/v1/providers", url.Values{"file": {"f1"}})
+	if len(ps) != 1 || ps[0].ID != "a" {
+		t.Fatalf("providers of f1 = %v, want [a]", peerIDs(ps))
+	}
+	// 来源地址在前，未指定主机的地址替换为来源地址，组播地址被丢弃
+	want := []string{"127.0.0.1:9001", "127.0.0.1:9101", "127.0.0.1:9201", "203.0.113.7:9301"}
+	if !slices.Equal(ps[0].Addrs, want) {
+		t.Errorf("addrs of a = %v, want %v", ps[0].Addrs, want)
+	}
+
+	if got := peerIDs(peers(t, ts.URL, "/v1/providers", url.Values{"file": {"f2"}, "exclude": {"b"}})); !slices.Equal(got, []core.NodeID{"a"}) {
+		t.Errorf("providers of f2 excluding b = %v, want [a]", got)
+	}
+	if got := peerIDs(peers(t, ts.URL, "/v1/nodes", nil)); !slices.Equal(got, []core.NodeID{"a", "b", "c"}) {
+		t.Errorf("nodes = %v, want [a b c]", got)
+	}
+	if got := peers(t, ts.URL, "/v1/nodes", url.Values{"limit": {"2"}}); len(got) != 2 {
+		t.Errorf("nodes with limit=2 returned %d peers", len(got))
+	}
+
+	// 重新登记替换文件列表
+	if got := join(t, ts.URL, AnnounceRequest{ID: "a", Port: 9001, Files: []core.FileID{"f3"}, Secret: secretA}); got != secretA {
+		t.Errorf("re-announce changed the secret")
+	}
+	if got := peers(t, ts.URL, "/v1/providers", url.Values{"file": {"f1"}}); len(got) != 0 {
+		t.Errorf("providers of f1 after re-announce = %v, want none", peerIDs(got))
+	}
+
+	// 注销
+	if resp := announce(t, ts.URL, AnnounceRequest{ID: "b", Leave: true, Secret: secretB}); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("leave: %s", resp.Status)
+	}
+	if got := peerIDs(peers(t, ts.URL, "/v1/nodes", nil)); !slices.Equal(got, []core.NodeID{"a", "c"}) {
+		t.Errorf("nodes after leave = %v, want [a c]", got)
+	}
+}
+
+func TestRejectsInvalidAnnounce(t *testing.T) {
+	ts := httptest.NewServer(NewServer().Handler())
+	defer ts.Close()
+	for name, req := range map[string]AnnounceRequest{
+		"empty id":   {Port: 9001},
+		"no address": {ID: "a"},
+		"bad port":   {ID: "a", Port: 70000, Addrs: []string{"host:0"}},
+	} {
+		if resp := announce(t, ts.URL, req); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %s, want 400", name, resp.Status)
+		}
+	}
+	resp, err := http.Get(ts.URL + "/v1/providers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("providers without file: status %s, want 400", resp.Status)
+	}
+}
+
+func TestAnnounceRequiresSecret(t *testing.T) {
+	s := NewServer()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	secret := join(t, ts.URL, AnnounceRequest{ID: "a", Port: 9001, Files: []core.FileID{"f1"}})
+
+	// 不携带或携带错误凭据的续期与注销被拒绝，原登记不变
+	for name, req := range map[string]AnnounceRequest{
+		"re-announce without secret": {ID: "a", Port: 9666, Files: []core.FileID{"evil"}},
+		"re-announce wrong secret":   {ID: "a", Port: 9666, Secret: "00"},
+		"leave without secret":       {ID: "a", Leave: true},
+		"leave wrong secret":         {ID: "a", Leave: true, Secret: secret + "x"},
+	} {
+		if resp := announce(t, ts.URL, req); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: status %s, want 403", name, resp.Status)
+		}
+	}
+	ps := peers(t, ts.URL, "/v1/providers", url.Values{"file": {"f1"}})
+	if len(ps) != 1 || ps[0].Addrs[0] != "127.0.0.1:9001" {
+		t.Fatalf("providers of f1 = %+v, want the original registration", ps)
+	}
+
+	// 登记过期后节点ID可重新登记，并获得新的凭据
+	s.mu.Lock()
+	s.nodes["a"].expires = time.Now().Add(-time.Second)
+	s.mu.Unlock()
+	if got := join(t, ts.URL, AnnounceRequest{ID: "a", Port: 9002}); got == secret {
+		t.Error("expired registration kept its secret")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	s := NewServer()
+	s.TTL = 50 * time.Millisecond
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	announce(t, ts.URL, AnnounceRequest{ID: "a", Port: 9001, Files: []core.FileID{"f1"}})
+	time.Sleep(100 * time.Millisecond)
+	announce(t, ts.URL, AnnounceRequest{ID: "b", Port: 9002, Files: []core.FileID{"f1"}})
+
+	// 过期节点在清理前就不再出现在查询结果中
+	if got := peerIDs(peers(t, ts.URL, "/v1/providers", url.Values{"file": {"f1"}})); !slices.Equal(got, []core.NodeID{"b"}) {
+		t.Errorf("providers before expire = %v, want [b]", got)
+	}
+	s.expire()
+	if s.Len() != 1 {
+		t.Errorf("Len after expire = %d, want 1", s.Len())
+	}
+	s.mu.Lock()
+	_, indexed := s.files["f1"]["a"]
+	s.mu.Unlock()
+	if indexed {
+		t.Error("expired node still indexed under f1")
+	}
+}
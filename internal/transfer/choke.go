@@ -0,0 +1,299 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// 上传槽位调度（choke/unchoke）
+// - 同一时间只向 Slots 个对端（按 IP）提供上传；其余对端的 GET 应答 ERR choked，下载端稍后重试或改用其他源
+// - 每 rechokeInterval 重新选择：Slots-1 个常规槽位给回报最多的对端——优先是本节点正从其下载的速率（tit-for-tat，
+//   需将 Received 接到下载端，如 Downloader.Scheduler），其次是本节点向其上传的速率（只做种时即优先下载最快的对端）
+// - 剩余 1 个为乐观槽位，每 optimisticRounds 轮在被阻塞的对端中随机轮换，使新加入或暂无回报的对端也有机会开始交换
+// - 有空闲槽位时新对端的请求立即获得槽位；超过 interestTTL 未再请求的对端释放槽位
+// - 下载端在其他进程（如 ripplego get）时经 ReceivedReporter 与本机的 RECV 命令报告回报：
+//   客户端 -> 服务端：RECV <JSON {"对端IP":字节数}>\n，只接受经节点 TCP 监听器从本机回环地址连入的请求；服务端应答 OK 0\n
+
+const (
+	DefaultUploadSlots = 4
+	rechokeInterval    = 10 * time.Second
+	optimisticRounds   = 3 // 乐观槽位每 3 轮（30 秒）轮换一次
+	interestTTL        = 30 * time.Second
+	reportInterval     = rechokeInterval / 2 // ReceivedReporter 报告间隔，使每轮重新选择前都已计入
+)
+
+// ErrChoked 对端暂未为本节点分配上传槽位，稍后重试或改用其他源
+var ErrChoked = errors.New("choked")
+
+// Reciprocator 记录从对端下载并校验通过的字节数，作为其回报（见 Downloader.Scheduler）
+type Reciprocator interface {
+	Received(peer string, n int64)
+}
+
+// UploadScheduler 按对端回报分配上传槽位
+type UploadScheduler struct {
+	Slots int // 同时获得上传的对端数，至少为 1
+
+	mu         sync.Mutex
+	peers      map[string]*slotPeer
+	optimistic string // 当前乐观槽位的对端
+	round      int
+	last       time.Time // 上次重新选择的时间
+}
+
+type slotPeer struct {
+	up, down         int64   // 本轮向其上传、从其下载的字节数
+	upRate, downRate float64 // 平滑后的速率（字节/秒）
+	lastReq          time.Time
+	unchoked         bool
+}
+
+// NewUploadScheduler 创建调度器；slots 小于 1 时使用 DefaultUploadSlots。需调用 Run 才会定期重新选择
+func NewUploadScheduler(slots int) *UploadScheduler {
+	if slots < 1 {
+		slots = DefaultUploadSlots
+	}
+	return &UploadScheduler{Slots: slots, peers: make(map[string]*slotPeer), last: time.Now()}
+}
+
+// Run 定期重新分配槽位，直到 ctx 结束
+func (s *UploadScheduler) Run(ctx context.Context) {
+	tk := time.NewTicker(rechokeInterval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			s.rechoke()
+		}
+	}
+}
+
+// Allow 对端 peer 请求上传时调用：已获得槽位或有空闲槽位时返回 true；nil 调度器总是允许
+func (s *UploadScheduler) Allow(peer string) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.peerLocked(peer)
+	p.lastReq = time.Now()
+	if p.unchoked {
+		return true
+	}
+	if s.unchokedLocked() < s.Slots {
+		p.unchoked = true
+		return true
+	}
+	return false
+}
+
+// Uploaded 记录向 peer 上传的字节数
+func (s *UploadScheduler) Uploaded(peer string, n int64) {
+	if s == nil || n <= 0 {
+		return
+	}
+	s.mu.Lock()
+	s.peerLocked(peer).up += n
+	s.mu.Unlock()
+}
+
+// Received 记录从 peer 下载并校验通过的字节数，作为其回报
+func (s *UploadScheduler) Received(peer string, n int64) {
+	if s == nil || n <= 0 {
+		return
+	}
+	s.mu.Lock()
+	s.peerLocked(peer).down += n
+	s.mu.Unlock()
+}
+
+// Unchoked 返回当前获得槽位的对端
+func (s *UploadScheduler) Unchoked() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for k, p := range s.peers {
+		if p.unchoked {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (s *UploadScheduler) peerLocked(peer string) *slotPeer {
+	p := s.peers[peer]
+	if p == nil {
+		p = &slotPeer{}
+		s.peers[peer] = p
+	}
+	return p
+}
+
+func (s *UploadScheduler) unchokedLocked() int {
+	n := 0
+	for _, p := range s.peers {
+		if p.unchoked {
+			n++
+		}
+	}
+	return n
+}
+
+// rechoke 更新速率并重新分配常规槽位与乐观槽位
+func (s *UploadScheduler) rechoke() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	secs := now.Sub(s.last).Seconds()
+	s.last = now
+	if secs <= 0 {
+		secs = rechokeInterval.Seconds()
+	}
+
+	var interested []string
+	for k, p := range s.peers {
+		p.upRate = (p.upRate + float64(p.up)/secs) / 2
+		p.downRate = (p.downRate + float64(p.down)/secs) / 2
+		p.up, p.down = 0, 0
+		p.unchoked = false
+		if now.Sub(p.lastReq) <= interestTTL {
+			interested = append(interested, k)
+		} else if p.upRate < 1 && p.downRate < 1 {
+			delete(s.peers, k) // 不再请求且已无流量的对端
+		}
+	}
+	sort.Slice(interested, func(i, j int) bool {
+		a, b := s.peers[interested[i]], s.peers[interested[j]]
+		if a.downRate != b.downRate {
+			return a.downRate > b.downRate
+		}
+		if a.upRate != b.upRate {
+			return a.upRate > b.upRate
+		}
+		return interested[i] < interested[j]
+	})
+
+	regular := s.Slots - 1
+	if len(interested) <= s.Slots {
+		regular = len(interested)
+	}
+	for _, k := range interested[:regular] {
+		s.peers[k].unchoked = true
+	}
+
+	// 乐观槽位：当前对端仍被阻塞且仍有兴趣时保留到轮换，否则立即在被阻塞的对端中随机选择
+	rest := interested[regular:]
+	s.round++
+	keep := s.round%optimisticRounds != 0 && s.optimistic != ""
+	if keep {
+		if p := s.peers[s.optimistic]; p == nil || p.unchoked || now.Sub(p.lastReq) > interestTTL {
+			keep = false
+		}
+	}
+	if !keep {
+		// 轮换时不再选中上一轮的对端（除非没有其他候选）
+		if i := slices.Index(rest, s.optimistic); i >= 0 && len(rest) > 1 {
+			rest = slices.Delete(slices.Clone(rest), i, i+1)
+		}
+		s.optimistic = ""
+		if len(rest) > 0 {
+			s.optimistic = rest[rand.Intn(len(rest))]
+		}
+	}
+	if s.optimistic != "" {
+		s.peers[s.optimistic].unchoked = true
+	}
+}
+
+// handleReceived 将本机下载端报告的回报计入上传调度，只接受本机控制连接（local，见 serve）的请求
+func (t *TCPTransport) handleReceived(conn net.Conn, arg string, local bool) {
+	if !local {
+		fmt.Fprintf(conn, "ERR reciprocation reports only allowed from localhost\n")
+		return
+	}
+	if t.Scheduler == nil {
+		fmt.Fprintf(conn, "ERR upload scheduling not enabled\n")
+		return
+	}
+	var recv map[string]int64
+	if err := json.Unmarshal([]byte(arg), &recv); err != nil {
+		fmt.Fprintf(conn, "ERR bad report: %v\n", err)
+		return
+	}
+	for peer, n := range recv {
+		t.Scheduler.Received(peer, n)
+	}
+	fmt.Fprintf(conn, "OK 0\n")
+}
+
+// ReceivedReporter 汇总下载端从各对端收到的字节数，经 RECV 命令报告给本机运行中的节点 Node，
+// 使其上传调度优先回报这些对端；用于下载端与提供上传的节点不在同一进程的情形
+type ReceivedReporter struct {
+	Node string // 本机节点的传输服务地址
+
+	mu      sync.Mutex
+	pending map[string]int64
+}
+
+func NewReceivedReporter(node string) *ReceivedReporter {
+	return &ReceivedReporter{Node: node, pending: make(map[string]int64)}
+}
+
+// Received 记录从 peer 收到的字节数，待下次报告
+func (r *ReceivedReporter) Received(peer string, n int64) {
+	if n <= 0 {
+		return
+	}
+	r.mu.Lock()
+	r.pending[peer] += n
+	r.mu.Unlock()
+}
+
+// Run 定期报告，直到 ctx 结束；报告失败时丢弃该批数据（节点未运行或未启用上传调度）
+func (r *ReceivedReporter) Run(ctx context.Context) {
+	tk := time.NewTicker(reportInterval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			_ = r.Flush(ctx)
+		}
+	}
+}
+
+// Flush 立即报告尚未报告的数据
+func (r *ReceivedReporter) Flush(ctx context.Context) error {
+	r.mu.Lock()
+	recv := r.pending
+	r.pending = make(map[string]int64)
+	r.mu.Unlock()
+	if len(recv) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(recv)
+	if err != nil {
+		return err
+	}
+	conn, err := dialNode(ctx, core.Node{Address: r.Node})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = exchange(conn, "RECV "+string(data)+"\n")
+	return err
+}
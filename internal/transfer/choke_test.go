@@ -0,0 +1,130 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+)
+
+// interested 使对端发出请求（登记兴趣）
+func interested(s *UploadScheduler, peers ...string) {
+	for _, p := range peers {
+		s.Allow(p)
+	}
+}
+
+func TestRechokePrefersReciprocationThenUpload(t *testing.T) {
+	s := NewUploadScheduler(3)
+	interested(s, "a", "b", "c", "d", "e")
+	if got := s.Unchoked(); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Fatalf("first-come slots = %v, want [a b c]", got)
+	}
+	// d 向本节点回报最多；无回报时按本节点向其上传的速率，a 多于 b
+	s.Received("d", 1<<20)
+	s.Uploaded("a", 4<<20)
+	s.Uploaded("b", 1<<20)
+	s.Uploaded("d", 1<<10)
+	s.rechoke()
+
+	got := s.Unchoked()
+	if len(got) != 3 || !slices.Contains(got, "d") || !slices.Contains(got, "a") {
+		t.Fatalf("unchoked = %v, want d and a in regular slots plus one optimistic", got)
+	}
+	if s.optimistic == "d" || s.optimistic == "a" || !slices.Contains(got, s.optimistic) {
+		t.Errorf("optimistic = %q, unchoked = %v", s.optimistic, got)
+	}
+	if s.Allow("e") && s.optimistic != "e" {
+		t.Error("choked peer got a slot while all slots are taken")
+	}
+
+	// 回报在下一轮超过上传速率：e 取代 a
+	s.Received("e", 64<<20)
+	s.Uploaded("a", 4<<20)
+	s.rechoke()
+	if got := s.Unchoked(); !slices.Contains(got, "e") || !slices.Contains(got, "d") {
+		t.Errorf("unchoked after e reciprocated = %v, want d and e", got)
+	}
+}
+
+func TestOptimisticSlotRotates(t *testing.T) {
+	s := NewUploadScheduler(1) // 只有乐观槽位
+	interested(s, "a", "b", "c")
+
+	var picks []string
+	for round := 1; round <= 4*optimisticRounds; round++ {
+		interested(s, "a", "b", "c")
+		prev := s.optimistic
+		s.rechoke()
+		if got := s.Unchoked(); len(got) != 1 || got[0] != s.optimistic {
+			t.Fatalf("round %d: unchoked = %v, optimistic = %q", round, got, s.optimistic)
+		}
+		if round%optimisticRounds != 0 && round > 1 {
+			if s.optimistic != prev {
+				t.Fatalf("round %d: optimistic changed from %q to %q before rotation", round, prev, s.optimistic)
+			}
+			continue
+		}
+		if s.optimistic == prev {
+			t.Fatalf("round %d: rotation kept %q", round, prev)
+		}
+		picks = append(picks, s.optimistic)
+	}
+	if len(picks) < 4 {
+		t.Fatalf("rotations = %v", picks)
+	}
+}
+
+func TestOptimisticSlotReplacedWhenPeerLeaves(t *testing.T) {
+	s := NewUploadScheduler(1)
+	interested(s, "a", "b", "c")
+	s.rechoke()
+	gone := s.optimistic
+	// 乐观对端不再请求：下一轮（未到轮换）立即改选其他对端
+	s.peers[gone].lastReq = s.peers[gone].lastReq.Add(-2 * interestTTL)
+	s.rechoke()
+	if s.optimistic == gone || s.optimistic == "" || !slices.Equal(s.Unchoked(), []string{s.optimistic}) {
+		t.Errorf("optimistic = %q after %q left", s.optimistic, gone)
+	}
+}
+
+func TestReceivedReporterFeedsServingScheduler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTCPTransport("", "")
+	tr.Scheduler = NewUploadScheduler(1)
+	go tr.ServeListener(ctx, ln)
+
+	rep := NewReceivedReporter(ln.Addr().String())
+	if err := rep.Flush(ctx); err != nil {
+		t.Fatalf("empty flush: %v", err)
+	}
+	rep.Received("10.0.0.7", 1000)
+	rep.Received("10.0.0.7", 500)
+	rep.Received("10.0.0.8", 10)
+	if err := rep.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	tr.Scheduler.mu.Lock()
+	got7, got8 := tr.Scheduler.peers["10.0.0.7"], tr.Scheduler.peers["10.0.0.8"]
+	tr.Scheduler.mu.Unlock()
+	if got7 == nil || got7.down != 1500 || got8 == nil || got8.down != 10 {
+		t.Fatalf("scheduler received = %+v, %+v", got7, got8)
+	}
+
+	// 报告过的数据不再重复报告；未启用上传调度的节点拒绝报告
+	tr.Scheduler = nil
+	if err := rep.Flush(ctx); err != nil {
+		t.Errorf("flush with nothing pending: %v", err)
+	}
+	rep.Received("10.0.0.7", 1)
+	var re *RemoteError
+	if err := rep.Flush(ctx); !errors.As(err, &re) {
+		t.Errorf("report to node without scheduler: err = %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package transfer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// 分片压缩
+// - 客户端在 GET 请求行末尾附加支持的编码：GET <fileID> <offset> <size> zstd
+// - 识别该参数的服务端以帧发送应答体：<编码:1> <长度:8，大端> <数据>，编码 0 为原始数据、1 为 zstd；
+//   服务端未启用压缩、分片不可压缩（抽样或整体压缩率不足）时发送原始帧，原始帧仍可使用 sendfile
+// - 不识别该参数的旧节点应答 ERR invalid request，客户端改用不带参数的请求，并在 noCompressTTL 内不再附加
+// - 压缩只作用于传输，下载端解压后仍按索引中的哈希校验
+
+const (
+	codecRaw  byte = 0
+	codecZstd byte = 1
+
+	compressSample    = 64 << 10 // 抽样压缩的字节数
+	minSampleSaving   = 0.10     // 抽样至少节省 10% 才压缩整个分片
+	minChunkSaving    = 0.05     // 整个分片至少节省 5% 才发送压缩帧
+	noCompressTTL     = 10 * time.Minute
+	frameHeaderSize   = 9
+	codecOfferZstd    = "zstd"
+	invalidRequestMsg = "invalid request"
+)
+
+var (
+	zstdEnc     *zstd.Encoder
+	zstdEncOnce sync.Once
+	zstdDecs    sync.Pool // *zstd.Decoder
+)
+
+func zstdEncoder() *zstd.Encoder {
+	zstdEncOnce.Do(func() {
+		zstdEnc, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+	})
+	return zstdEnc
+}
+
+// compressChunk 返回 zstd 压缩后的分片；抽样或整体压缩率不足时返回空切片，表示发送原始数据
+func compressChunk(data []byte) []byte {
+	enc := zstdEncoder()
+	if len(data) > compressSample {
+		sample := enc.EncodeAll(data[:compressSample], nil)
+		if float64(len(sample)) > float64(compressSample)*(1-minSampleSaving) {
+			return []byte{}
+		}
+	}
+	out := enc.EncodeAll(data, make([]byte, 0, len(data)/2))
+	if float64(len(out)) > float64(len(data))*(1-minChunkSaving) {
+		return []byte{}
+	}
+	return out
+}
+
+// sendFramed 以帧发送分片：客户端支持 zstd 且服务端启用压缩时尝试压缩，否则发送原始帧
+// 返回写入连接的字节数（含帧头）
+func (t *TCPTransport) sendFramed(conn net.Conn, peer string, f *cachedFile, offset, size int64, codecs string) (int64, error) {
+	var zdata []byte
+	if t.Compress && hasCodec(codecs, codecOfferZstd) {
+		load := func() ([]byte, error) {
+			raw, err := readChunk(f.File, offset, size)
+			if err != nil {
+				return nil, err
+			}
+			return compressChunk(raw), nil
+		}
+		key := chunkKey{path: f.path, mod: f.info.ModTime().UnixNano(), fileSize: f.info.Size(), offset: offset, size: size, codec: codecOfferZstd}
+		var err error
+		if cc := t.cache(); cc != nil {
+			// 压缩的代价较高，压缩结果总是放入缓存
+			zdata, _, err = cc.get(key, true, load)
+		} else {
+			zdata, err = load()
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	w := t.Limiter.UploadWriter(peer, t.writerFor(conn))
+	var hdr [frameHeaderSize]byte
+	if len(zdata) == 0 {
+		hdr[0] = codecRaw
+		binary.BigEndian.PutUint64(hdr[1:], uint64(size))
+		if _, err := w.Write(hdr[:]); err != nil {
+			return 0, err
+		}
+		n, err := t.sendChunk(conn, peer, f, offset, size)
+		return frameHeaderSize + n, err
+	}
+	hdr[0] = codecZstd
+	binary.BigEndian.PutUint64(hdr[1:], uint64(len(zdata)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return 0, err
+	}
+	var sent int64
+	for len(zdata) > 0 {
+		n, err := w.Write(zdata[:min(len(zdata), sendfileStep)])
+		sent += int64(n)
+		if err != nil {
+			return frameHeaderSize + sent, err
+		}
+		zdata = zdata[n:]
+	}
+	return frameHeaderSize + sent, nil
+}
+
+// hasCodec 判断逗号分隔的编码列表中是否包含 codec
+func hasCodec(list, codec string) bool {
+	return slices.Contains(strings.Split(list, ","), codec)
+}
+
+// readFramed 读取帧格式的应答体，解压后将 size 字节写入 w
+func readFramed(br *bufio.Reader, w io.Writer, size int64) error {
+	var hdr [frameHeaderSize]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return err
+	}
+	n := int64(binary.BigEndian.Uint64(hdr[1:]))
+	switch hdr[0] {
+	case codecRaw:
+		if n != size {
+			return fmt.Errorf("raw frame size %d, want %d", n, size)
+		}
+		_, err := io.CopyN(w, br, size)
+		return err
+	case codecZstd:
+		if n <= 0 || n >= size {
+			return fmt.Errorf("invalid compressed frame size %d", n)
+		}
+		dec, _ := zstdDecs.Get().(*zstd.Decoder)
+		if dec == nil {
+			var err error
+			if dec, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1)); err != nil {
+				return err
+			}
+		}
+		defer zstdDecs.Put(dec)
+		lr := io.LimitReader(br, n)
+		if err := dec.Reset(lr); err != nil {
+			return err
+		}
+		// 只解压 size 字节，多出的数据视为错误，避免异常节点以高压缩比的数据耗尽内存
+		if _, err := io.CopyN(w, dec, size); err != nil {
+			return fmt.Errorf("zstd: %w", err)
+		}
+		if m, _ := dec.Read(make([]byte, 1)); m > 0 {
+			return errors.New("zstd: decompressed data exceeds chunk size")
+		}
+		_, err := io.Copy(io.Discard, lr)
+		return err
+	default:
+		return fmt.Errorf("unknown codec %d", hdr[0])
+	}
+}
+
+// getChunk 发送 GET 请求并读取分片；启用 Compress 时附加支持的编码，节点不识别时改用不带编码的请求
+// request 在某种传输（TCP、QUIC 流、UDP 流）上发送请求行并读取响应头
+func (t *TCPTransport) getChunk(node core.Node, fileID core.FileID, chunk core.ChunkInfo, w io.Writer,
+	request func(req string) (net.Conn, *bufio.Reader, error)) error {
+	req := fmt.Sprintf("GET %s %d %d", fileID, chunk.Offset, chunk.Size)
+	if t.Compress && !t.noCompress.skipped(peerKey(node)) {
+		conn, br, err := request(req + " " + codecOfferZstd + "\n")
+		var re *RemoteError
+		if err == nil {
+			defer conn.Close()
+			return readFramed(br, w, chunk.Size)
+		}
+		if !errors.As(err, &re) || re.Msg != invalidRequestMsg {
+			return err
+		}
+		t.noCompress.add(peerKey(node), noCompressTTL)
+	}
+	conn, br, err := request(req + "\n")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = io.CopyN(w, br, chunk.Size)
+	return err
+}
@@ -0,0 +1,251 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/index"
+)
+
+// chunkFrame 按帧格式编码应答体
+func chunkFrame(codec byte, body []byte) []byte {
+	out := make([]byte, frameHeaderSize, frameHeaderSize+len(body))
+	out[0] = codec
+	binary.BigEndian.PutUint64(out[1:], uint64(len(body)))
+	return append(out, body...)
+}
+
+func zstdFrame(data []byte) []byte {
+	return chunkFrame(codecZstd, zstdEncoder().EncodeAll(data, nil))
+}
+
+// pipeNode 返回经 net.Pipe 向模拟节点发送请求的 request 函数，reply 根据请求行给出完整响应
+// 收到的请求行按顺序记录在返回的切片中
+func pipeNode(t *testing.T, reply func(req string) string) (func(req string) (net.Conn, *bufio.Reader, error), func() []string) {
+	var mu sync.Mutex
+	var reqs []string
+	request := func(req string) (net.Conn, *bufio.Reader, error) {
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		go func() {
+			defer server.Close()
+			line, err := bufio.NewReader(server).ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			mu.Lock()
+			reqs = append(reqs, line)
+			mu.Unlock()
+			io.WriteString(server, reply(line))
+		}()
+		br, err := exchange(client, req)
+		return client, br, err
+	}
+	return request, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), reqs...)
+	}
+}
+
+func TestReadFramed(t *testing.T) {
+	data := bytes.Repeat([]byte("ripplego chunk "), 300)
+	size := int64(len(data))
+	tests := []struct {
+		name    string
+		body    []byte
+		wantErr string
+	}{
+		{"raw", chunkFrame(codecRaw, data), ""},
+		{"zstd", zstdFrame(data), ""},
+		{"raw frame size mismatch", chunkFrame(codecRaw, data[:100]), "raw frame size 100"},
+		{"decompression bomb", zstdFrame(make([]byte, 64*size)), "exceeds chunk size"},
+		{"decompressed data too short", zstdFrame(data[:size/2]), "zstd:"},
+		{"compressed frame not smaller", chunkFrame(codecZstd, data), "invalid compressed frame size"},
+		{"empty compressed frame", chunkFrame(codecZstd, nil), "invalid compressed frame size"},
+		{"unknown codec", chunkFrame(7, data), "unknown codec 7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			// 帧之后紧跟下一条响应，成功读取的帧不应多读或少读
+			go func() {
+				defer server.Close()
+				server.Write(append(tt.body, "next"...))
+			}()
+			br := bufio.NewReader(client)
+			var out bytes.Buffer
+			err := readFramed(br, &out, size)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), data) {
+				t.Fatalf("read %d bytes, want the %d bytes framed", out.Len(), size)
+			}
+			if rest, _ := io.ReadAll(br); string(rest) != "next" {
+				t.Fatalf("data after the frame = %q, want %q", rest, "next")
+			}
+		})
+	}
+}
+
+func TestGetChunkCompressionFallback(t *testing.T) {
+	data := bytes.Repeat([]byte("ripplego chunk "), 300)
+	chunk := core.ChunkInfo{Offset: 0, Size: int64(len(data))}
+	oldNode := func(req string) string {
+		if strings.HasSuffix(req, " "+codecOfferZstd) {
+			return "ERR " + invalidRequestMsg + "\n"
+		}
+		return fmt.Sprintf("OK %d\n%s", len(data), data)
+	}
+	get := func(t *testing.T, tr *TCPTransport, node core.Node, request func(string) (net.Conn, *bufio.Reader, error)) error {
+		t.Helper()
+		var out bytes.Buffer
+		err := tr.getChunk(node, "file", chunk, &out, request)
+		if err == nil && !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("got %d bytes, want the %d bytes sent", out.Len(), len(data))
+		}
+		return err
+	}
+
+	t.Run("old node", func(t *testing.T) {
+		tr := NewTCPTransport("", "")
+		tr.Compress = true
+		request, reqs := pipeNode(t, oldNode)
+		node := core.Node{ID: "old", Address: "127.0.0.1:1"}
+		if err := get(t, tr, node, request); err != nil {
+			t.Fatal(err)
+		}
+		// 不识别压缩参数的节点在一段时间内不再附加编码，其他节点不受影响
+		if err := get(t, tr, node, request); err != nil {
+			t.Fatal(err)
+		}
+		if err := get(t, tr, core.Node{ID: "other", Address: "127.0.0.1:1"}, request); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"GET file 0 4500 zstd", "GET file 0 4500", "GET file 0 4500", "GET file 0 4500 zstd", "GET file 0 4500"}
+		if got := reqs(); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("requests = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("other errors do not fall back", func(t *testing.T) {
+		tr := NewTCPTransport("", "")
+		tr.Compress = true
+		request, reqs := pipeNode(t, func(string) string { return "ERR busy: too many connections\n" })
+		node := core.Node{ID: "busy", Address: "127.0.0.1:1"}
+		if err := get(t, tr, node, request); err == nil || !strings.Contains(err.Error(), "busy") {
+			t.Fatalf("err = %v, want the busy error", err)
+		}
+		if len(reqs()) != 1 || tr.noCompress.skipped(peerKey(node)) {
+			t.Fatalf("requests = %q, skipped = %v; want one request and no skip", reqs(), tr.noCompress.skipped(peerKey(node)))
+		}
+	})
+
+	t.Run("compressing node", func(t *testing.T) {
+		tr := NewTCPTransport("", "")
+		tr.Compress = true
+		request, reqs := pipeNode(t, func(string) string { return "OK 0\n" + string(zstdFrame(data)) })
+		if err := get(t, tr, core.Node{ID: "new", Address: "127.0.0.1:1"}, request); err != nil {
+			t.Fatal(err)
+		}
+		if got := reqs(); len(got) != 1 || got[0] != "GET file 0 4500 zstd" {
+			t.Fatalf("requests = %q", got)
+		}
+	})
+
+	t.Run("compression disabled", func(t *testing.T) {
+		request, reqs := pipeNode(t, oldNode)
+		if err := get(t, NewTCPTransport("", ""), core.Node{ID: "old", Address: "127.0.0.1:1"}, request); err != nil {
+			t.Fatal(err)
+		}
+		if got := reqs(); len(got) != 1 || got[0] != "GET file 0 4500" {
+			t.Fatalf("requests = %q", got)
+		}
+	})
+}
+
+// fakeNode 在本地监听，对每个连接的请求以 reply 作答
+func fakeNode(t *testing.T, reply string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := bufio.NewReader(conn).ReadString('\n'); err == nil {
+					io.WriteString(conn, reply)
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestDownloadVerifiesDecompressedChunks(t *testing.T) {
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("compressible content "), 2000)
+	store := index.NewMemoryStore()
+	_, fi, chunks := shareFile(t, store, data, 16<<10)
+	download := func(t *testing.T, src string, chunks []core.ChunkInfo) ([]byte, error) {
+		t.Helper()
+		tr := NewTCPTransport("", "")
+		tr.Compress = true
+		path := filepath.Join(t.TempDir(), "out")
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := NewDownloader(tr, 2).Download(ctx, fi, chunks, []core.Node{{Address: src}}, f); err != nil {
+			return nil, err
+		}
+		return os.ReadFile(path)
+	}
+
+	// 启用压缩的节点发送 zstd 帧，解压后的数据通过校验
+	server := NewTCPTransport("", "")
+	server.Store, server.Compress = store, true
+	got, err := download(t, startNode(t, server), chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded data differs from the shared file")
+	}
+
+	// 解压本身成功但内容被篡改的分片不能通过哈希校验
+	tampered := bytes.Clone(data[:chunks[0].Size])
+	tampered[0] ^= 1
+	reply := "OK 0\n" + string(zstdFrame(tampered))
+	if _, err := download(t, fakeNode(t, reply), chunks[:1]); err == nil || !strings.Contains(err.Error(), "hash mismatch") {
+		t.Fatalf("tampered compressed chunk: err = %v, want hash mismatch", err)
+	}
+}
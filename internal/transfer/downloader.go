@@ -0,0 +1,201 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// Downloader 从一个或多个源节点并发下载文件分片
+// 每个分片按索引声明的哈希算法校验后写入目标；某个源失败时依次尝试其余源
+type Downloader struct {
+	Transport Transport
+	Workers   int
+	// OnChunk 分片校验并写入成功后回调（可选，用于进度展示），可能被并发调用
+	OnChunk func(ch core.ChunkInfo)
+	// OnSource 每次向源节点请求分片后回调（可选，用于统计节点成功率），err 为空表示数据校验通过，可能被并发调用
+	OnSource func(node core.Node, err error)
+	// NewSources 下载过程中新发现的源节点（可选，如订阅发现事件后持有该文件的节点），
+	// 之后开始的分片会轮流使用；已在源列表中（地址或节点ID相同）的节点被忽略
+	NewSources <-chan core.Node
+	// Limiter 下载限速（可选），按源节点与全局限制接收速率
+	Limiter *RateLimiter
+	// Scheduler 记录从各源节点收到的数据作为其回报（可选），使回报多的节点优先获得上传槽位：
+	// 同一进程内为本节点的 *UploadScheduler，否则为报告给本机节点的 *ReceivedReporter
+	Scheduler Reciprocator
+}
+
+func NewDownloader(tr Transport, workers int) *Downloader {
+	if workers <= 0 {
+		workers = 4
+	}
+	return &Downloader{Transport: tr, Workers: workers}
+}
+
+// Download 下载 chunks 中的全部分片并写入 w
+func (d *Downloader) Download(ctx context.Context, fi core.FileInfo, chunks []core.ChunkInfo, sources []core.Node, w io.WriterAt) error {
+	if len(sources) == 0 {
+		return errors.New("no source nodes")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	srcs := newSourceList(sources)
+	if d.NewSources != nil {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case n, ok := <-d.NewSources:
+					if !ok {
+						return
+					}
+					srcs.add(n)
+				}
+			}
+		}()
+	}
+
+	sem := make(chan struct{}, d.Workers)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	for i, ch := range chunks {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(start int, ch core.ChunkInfo) {
+			defer func() { <-sem; wg.Done() }()
+			err := d.fetchChunk(ctx, fi, ch, srcs.list(), start, w)
+			if err == nil && d.OnChunk != nil {
+				d.OnChunk(ch)
+			}
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}(i, ch)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// fetchChunk 从 sources[start%len(sources)] 开始轮流尝试，直到某个源返回校验通过的数据
+// 全部源都因连接数已满（ErrBusy）拒绝时等待后重试，最多 maxBusyRounds 轮；
+// 全部源都拒绝且至少一个是暂未分配上传槽位（ErrChoked）时持续重试，直到获得槽位或 ctx 结束
+func (d *Downloader) fetchChunk(ctx context.Context, fi core.FileInfo, ch core.ChunkInfo, sources []core.Node, start int, w io.WriterAt) error {
+	var lastErr error
+	for round := 0; ; round++ {
+		allRefused, choked := true, false
+		for i := 0; i < len(sources); i++ {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			node := sources[(start+i)%len(sources)]
+			buf := bytes.NewBuffer(make([]byte, 0, ch.Size))
+			err := d.Transport.Download(ctx, node, fi.ID, ch, d.Limiter.DownloadWriter(peerKey(node), buf))
+			if err == nil {
+				err = VerifyChunk(fi.HashAlgo, ch, buf.Bytes())
+			}
+			refused := errors.Is(err, ErrBusy) || errors.Is(err, ErrChoked)
+			// 连接数已满或未分配槽位不代表节点不可用，不计入节点统计
+			if d.OnSource != nil && ctx.Err() == nil && !refused {
+				d.OnSource(node, err)
+			}
+			if err != nil {
+				allRefused = allRefused && refused
+				choked = choked || errors.Is(err, ErrChoked)
+				lastErr = fmt.Errorf("chunk %d from %s: %w", ch.Index, node.Address, err)
+				continue
+			}
+			if d.Scheduler != nil {
+				d.Scheduler.Received(hostOf(node.Address), ch.Size)
+			}
+			_, err = w.WriteAt(buf.Bytes(), ch.Offset)
+			return err
+		}
+		if !allRefused || !choked && round+1 >= maxBusyRounds {
+			return lastErr
+		}
+		delay := time.Duration(round+1) * busyRetryDelay
+		if choked {
+			delay = chokedRetryDelay
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+const (
+	maxBusyRounds  = 5
+	busyRetryDelay = 500 * time.Millisecond // 第 n 轮重试前等待 n 倍
+	// chokedRetryDelay 被阻塞时的重试间隔；持续请求也使服务端保留本节点的兴趣，以便获得乐观槽位
+	chokedRetryDelay = 2 * time.Second
+)
+
+// sourceList 下载中可增长的源节点列表
+type sourceList struct {
+	mu    sync.RWMutex
+	nodes []core.Node
+}
+
+func newSourceList(nodes []core.Node) *sourceList {
+	return &sourceList{nodes: append([]core.Node(nil), nodes...)}
+}
+
+func (l *sourceList) list() []core.Node {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.nodes
+}
+
+func (l *sourceList) add(n core.Node) {
+	if n.Address == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, x := range l.nodes {
+		if x.Address == n.Address || n.ID != "" && x.ID == n.ID {
+			return
+		}
+	}
+	// 追加到新切片，不影响正在使用旧列表的分片
+	l.nodes = append(l.nodes[:len(l.nodes):len(l.nodes)], n)
+}
+
+// VerifyChunk 按指定算法校验分片数据
+func VerifyChunk(algo core.HashAlgo, ch core.ChunkInfo, data []byte) error {
+	if int64(len(data)) != ch.Size {
+		return fmt.Errorf("size mismatch: got %d, want %d", len(data), ch.Size)
+	}
+	sum, err := core.HashHex(algo, data)
+	if err != nil {
+		return err
+	}
+	if sum != ch.Hash {
+		return fmt.Errorf("hash mismatch (%s)", algo.OrDefault())
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+package transfer
+
+import (
+	"container/list"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// 分片发送
+// - 打开的文件句柄按路径缓存复用，每个句柄同一时间只由一个请求使用（sendfile 依赖文件偏移）；
+//   文件被替换或修改（inode、大小或修改时间变化）后旧句柄作废，空闲超过 fileIdleTTL 的句柄关闭
+// - 热点分片缓存（LRU）：分片在 hotWindow 内第二次被请求时才放入缓存，之后的请求从内存发送；
+//   同一分片的并发请求只读一次磁盘；命中缓存时预读下一个分片
+// - 未进入缓存的分片直接从文件发送：直接写入 *net.TCPConn 且不限速时经 io.Copy 使用 sendfile，数据不经过用户态；
+//   其余情况（QUIC、UDP 流、中继会话、限速）经用户态流式复制
+
+const (
+	DefaultChunkCacheSize = 64 << 20
+	maxIdleFiles          = 64
+	fileIdleTTL           = time.Minute
+	sendfileStep          = 1 << 20 // sendfile 每次发送的字节数，其间顺延写超时
+	hotWindow             = time.Minute
+	maxSeenChunks         = 4096 // 记录最近请求过的分片数上限，超出时清空
+)
+
+// cachedFile 可复用的文件句柄
+type cachedFile struct {
+	*os.File
+	path    string
+	info    os.FileInfo
+	lastUse time.Time
+}
+
+// fileCache 按路径缓存空闲的文件句柄
+type fileCache struct {
+	mu   sync.Mutex
+	idle map[string][]*cachedFile
+	n    int
+}
+
+// open 取出 path 的空闲句柄，文件已变化或没有空闲句柄时重新打开
+func (c *fileCache) open(path string) (*cachedFile, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	var stale []*cachedFile
+	var f *cachedFile
+	for hs := c.idle[path]; len(hs) > 0 && f == nil; hs = c.idle[path] {
+		h := hs[len(hs)-1]
+		c.setIdleLocked(path, hs[:len(hs)-1])
+		if sameFile(h.info, st) {
+			f = h
+		} else {
+			stale = append(stale, h)
+		}
+	}
+	c.mu.Unlock()
+	for _, h := range stale {
+		h.Close()
+	}
+	if f != nil {
+		return f, nil
+	}
+	of, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := of.Stat()
+	if err != nil {
+		of.Close()
+		return nil, err
+	}
+	return &cachedFile{File: of, path: path, info: info}, nil
+}
+
+// put 归还句柄；空闲句柄过多时关闭最久未用的
+func (c *fileCache) put(f *cachedFile) {
+	now := time.Now()
+	f.lastUse = now
+	var closing []*cachedFile
+	c.mu.Lock()
+	if c.idle == nil {
+		c.idle = make(map[string][]*cachedFile)
+	}
+	c.setIdleLocked(f.path, append(c.idle[f.path], f))
+	for path, hs := range c.idle {
+		for len(hs) > 0 && now.Sub(hs[0].lastUse) > fileIdleTTL {
+			closing = append(closing, hs[0])
+			hs = hs[1:]
+		}
+		c.setIdleLocked(path, hs)
+	}
+	for c.n > maxIdleFiles {
+		var oldest string
+		for path, hs := range c.idle {
+			if oldest == "" || hs[0].lastUse.Before(c.idle[oldest][0].lastUse) {
+				oldest = path
+			}
+		}
+		hs := c.idle[oldest]
+		closing = append(closing, hs[0])
+		c.setIdleLocked(oldest, hs[1:])
+	}
+	c.mu.Unlock()
+	for _, h := range closing {
+		h.Close()
+	}
+}
+
+// setIdleLocked 替换 path 的空闲句柄列表（按归还时间从早到晚）并维护计数
+func (c *fileCache) setIdleLocked(path string, hs []*cachedFile) {
+	c.n += len(hs) - len(c.idle[path])
+	if len(hs) == 0 {
+		delete(c.idle, path)
+		return
+	}
+	c.idle[path] = hs
+}
+
+// closeAll 关闭全部空闲句柄
+func (c *fileCache) closeAll() {
+	c.mu.Lock()
+	idle := c.idle
+	c.idle, c.n = nil, 0
+	c.mu.Unlock()
+	for _, hs := range idle {
+		for _, h := range hs {
+			h.Close()
+		}
+	}
+}
+
+func sameFile(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+// chunkKey 分片缓存的键；包含文件的大小与修改时间，文件变化后旧数据不再命中
+type chunkKey struct {
+	path         string
+	mod          int64
+	fileSize     int64
+	offset, size int64
+	codec        string // 缓存的是压缩后的数据时为编码名（见 compress.go）
+}
+
+// chunkCache 热点分片的 LRU 缓存，同一分片的并发加载合并为一次
+type chunkCache struct {
+	mu      sync.Mutex
+	max     int64
+	used    int64
+	ll      *list.List // 元素为 *chunkEntry，最近使用的在前
+	items   map[chunkKey]*list.Element
+	loading map[chunkKey]*chunkLoad
+	seen    map[chunkKey]time.Time // 最近请求过但未缓存的分片
+}
+
+type chunkEntry struct {
+	key  chunkKey
+	data []byte
+}
+
+type chunkLoad struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// get 返回分片数据，hit 表示已缓存或由并发请求加载
+// 未缓存的分片在 hotWindow 内首次被请求且 admit 为 false 时返回空数据，由调用方直接从文件发送；
+// 否则调用 load 加载并放入缓存（分片超过容量的 1/4 时不缓存）
+func (c *chunkCache) get(key chunkKey, admit bool, load func() ([]byte, error)) (data []byte, hit bool, err error) {
+	c.mu.Lock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*chunkEntry).data, true, nil
+	}
+	if l, ok := c.loading[key]; ok {
+		c.mu.Unlock()
+		<-l.done
+		return l.data, true, l.err
+	}
+	if c.items == nil {
+		c.ll, c.items, c.loading = list.New(), make(map[chunkKey]*list.Element), make(map[chunkKey]*chunkLoad)
+		c.seen = make(map[chunkKey]time.Time)
+	}
+	now := time.Now()
+	if at, ok := c.seen[key]; !admit && (!ok || now.Sub(at) > hotWindow) {
+		if len(c.seen) >= maxSeenChunks {
+			clear(c.seen)
+		}
+		c.seen[key] = now
+		c.mu.Unlock()
+		return nil, false, nil
+	}
+	delete(c.seen, key)
+	l := &chunkLoad{done: make(chan struct{})}
+	c.loading[key] = l
+	c.mu.Unlock()
+
+	l.data, l.err = load()
+	c.mu.Lock()
+	delete(c.loading, key)
+	if l.err == nil && key.size <= c.max/4 {
+		c.items[key] = c.ll.PushFront(&chunkEntry{key: key, data: l.data})
+		c.used += entrySize(l.data)
+		c.evictLocked()
+	}
+	c.mu.Unlock()
+	close(l.done)
+	return l.data, false, l.err
+}
+
+// entrySize 缓存条目计入容量的大小；空数据（如不可压缩的标记）也计入固定开销，使条目数有界
+func entrySize(data []byte) int64 { return max(int64(len(data)), 256) }
+
+// resize 设置容量上限，缩小时淘汰最久未用的分片
+func (c *chunkCache) resize(max int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.max = max
+	if c.ll != nil {
+		c.evictLocked()
+	}
+}
+
+// evictLocked 淘汰最久未用的分片直到不超过容量
+func (c *chunkCache) evictLocked() {
+	for c.used > c.max {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		ent := e.Value.(*chunkEntry)
+		delete(c.items, ent.key)
+		c.used -= entrySize(ent.data)
+	}
+}
+
+// has 判断分片已缓存或正在加载
+func (c *chunkCache) has(key chunkKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.items[key]
+	_, loading := c.loading[key]
+	return ok || loading
+}
+
+// cache 返回分片缓存，容量按 CacheSize 设置；CacheSize 为 0 时返回 nil
+func (t *TCPTransport) cache() *chunkCache {
+	t.mu.Lock()
+	size := t.CacheSize
+	t.mu.Unlock()
+	if size <= 0 {
+		return nil
+	}
+	t.chunks.resize(size)
+	return &t.chunks
+}
+
+// sendChunk 将 f 中 [offset, offset+size) 发送给对端 peer，返回写入的字节数
+// 热点分片经分片缓存发送；其余分片在 conn 为 TCP 连接且未限速时使用 sendfile，否则从文件流式复制
+func (t *TCPTransport) sendChunk(conn net.Conn, peer string, f *cachedFile, offset, size int64) (int64, error) {
+	cc := t.cache()
+	base := t.writerFor(conn)
+	w := t.Limiter.UploadWriter(peer, base)
+	direct := func() (int64, error) {
+		if tc, ok := conn.(*net.TCPConn); ok && w == base {
+			return t.sendfile(tc, f, offset, size)
+		}
+		return io.Copy(w, io.NewSectionReader(f, offset, size))
+	}
+	if cc == nil {
+		return direct()
+	}
+	key := chunkKey{path: f.path, mod: f.info.ModTime().UnixNano(), fileSize: f.info.Size(), offset: offset, size: size}
+	data, hit, err := cc.get(key, false, func() ([]byte, error) { return readChunk(f.File, offset, size) })
+	if err != nil {
+		return 0, err
+	}
+	if data == nil {
+		return direct()
+	}
+	if hit {
+		t.readAhead(cc, key)
+	}
+	// 分段写入，使写超时按段顺延
+	var sent int64
+	for len(data) > 0 {
+		n, err := w.Write(data[:min(len(data), sendfileStep)])
+		sent += int64(n)
+		if err != nil {
+			return sent, err
+		}
+		data = data[n:]
+	}
+	return sent, nil
+}
+
+// sendfile 经 sendfile 发送，每 sendfileStep 字节顺延一次写超时
+func (t *TCPTransport) sendfile(tc *net.TCPConn, f *cachedFile, offset, size int64) (int64, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	idle := t.limits().IdleTimeout
+	var sent int64
+	for sent < size {
+		if idle > 0 {
+			_ = tc.SetWriteDeadline(time.Now().Add(idle))
+		}
+		n, err := io.CopyN(tc, f.File, min(sendfileStep, size-sent))
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// readAhead 在后台将下一个分片（与本分片同样大小，文件末尾时截短）加载到缓存
+func (t *TCPTransport) readAhead(cc *chunkCache, key chunkKey) {
+	next := key
+	next.offset = key.offset + key.size
+	next.size = min(key.size, key.fileSize-next.offset)
+	if next.size <= 0 || cc.has(next) {
+		return
+	}
+	go func() {
+		f, err := t.files.open(next.path)
+		if err != nil {
+			return
+		}
+		defer t.files.put(f)
+		if f.info.ModTime().UnixNano() != next.mod || f.info.Size() != next.fileSize {
+			return
+		}
+		_, _, _ = cc.get(next, true, func() ([]byte, error) { return readChunk(f.File, next.offset, next.size) })
+	}()
+}
+
+func readChunk(f *os.File, offset, size int64) ([]byte, error) {
+	buf := make([]byte, size)
+	if _, err := f.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,134 @@
+package transfer
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	benchChunk  = 1 << 20
+	benchChunks = 8
+)
+
+// tcpPair 返回一对回环 TCP 连接：服务端一侧与持续读取的客户端一侧
+func tcpPair(tb testing.TB) (*net.TCPConn, net.Conn) {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	server, err := ln.Accept()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { server.Close(); client.Close() })
+	return server.(*net.TCPConn), client
+}
+
+func chunkFile(tb testing.TB, n int) (string, []byte) {
+	tb.Helper()
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7 + i>>12)
+	}
+	path := filepath.Join(tb.TempDir(), "data")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	return path, data
+}
+
+func TestSendChunkAdmitsHotChunksOnTCP(t *testing.T) {
+	path, data := chunkFile(t, 3*benchChunk)
+	server, client := tcpPair(t)
+	tr := NewTCPTransport("", "")
+	tr.CacheSize = DefaultChunkCacheSize
+
+	f, err := tr.files.open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.files.put(f)
+	key := chunkKey{path: f.path, mod: f.info.ModTime().UnixNano(), fileSize: f.info.Size(), offset: benchChunk, size: benchChunk}
+	for i := 0; i < 3; i++ {
+		go func() { _, _ = tr.sendChunk(server, "peer", f, benchChunk, benchChunk) }()
+		got := make([]byte, benchChunk)
+		if _, err := io.ReadFull(client, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data[benchChunk:2*benchChunk]) {
+			t.Fatalf("request %d: wrong chunk data", i+1)
+		}
+		// 首次请求经 sendfile 发送，不进入缓存；hotWindow 内再次请求后缓存
+		if cached := tr.chunks.has(key); cached != (i > 0) {
+			t.Fatalf("request %d: cached = %v", i+1, cached)
+		}
+	}
+
+	// 缩小容量时淘汰已缓存的分片
+	tr.CacheSize = benchChunk
+	if tr.cache(); tr.chunks.has(key) {
+		t.Error("chunk still cached after shrinking the cache")
+	}
+}
+
+// BenchmarkSendChunk 比较回环 TCP 上发送分片的方式：用户态 io.Copy、sendfile 与命中分片缓存
+func BenchmarkSendChunk(b *testing.B) {
+	path, _ := chunkFile(b, benchChunks*benchChunk)
+	run := func(b *testing.B, tr *TCPTransport, send func(tc *net.TCPConn, f *cachedFile, offset int64) (int64, error)) {
+		server, client := tcpPair(b)
+		go func() { _, _ = io.Copy(io.Discard, client) }()
+		f, err := tr.files.open(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer tr.files.put(f)
+		b.SetBytes(benchChunk)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := send(server, f, int64(i%benchChunks)*benchChunk); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("copy", func(b *testing.B) {
+		run(b, NewTCPTransport("", ""), func(tc *net.TCPConn, f *cachedFile, offset int64) (int64, error) {
+			return io.Copy(tc, io.NewSectionReader(f, offset, benchChunk))
+		})
+	})
+	b.Run("sendfile", func(b *testing.B) {
+		tr := NewTCPTransport("", "")
+		run(b, tr, func(tc *net.TCPConn, f *cachedFile, offset int64) (int64, error) {
+			return tr.sendfile(tc, f, offset, benchChunk)
+		})
+	})
+	b.Run("cache-hit", func(b *testing.B) {
+		tr := NewTCPTransport("", "")
+		tr.CacheSize = 4 * benchChunks * benchChunk
+		f, err := tr.files.open(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		cc := tr.cache()
+		for i := int64(0); i < benchChunks; i++ {
+			key := chunkKey{path: f.path, mod: f.info.ModTime().UnixNano(), fileSize: f.info.Size(), offset: i * benchChunk, size: benchChunk}
+			if _, _, err := cc.get(key, true, func() ([]byte, error) { return readChunk(f.File, key.offset, key.size) }); err != nil {
+				b.Fatal(err)
+			}
+		}
+		tr.files.put(f)
+		run(b, tr, func(tc *net.TCPConn, f *cachedFile, offset int64) (int64, error) {
+			return tr.sendChunk(tc, "peer", f, offset, benchChunk)
+		})
+	})
+}
@@ -0,0 +1,200 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// 服务端连接限制
+// - 同时处理的连接（含 QUIC、UDP 流与中继会话）总数与每个对端 IP 的连接数有上限，超出时应答 ERR busy 后关闭
+// - 请求行需在 RequestTimeout 内读完；发送应答时对端超过 IdleTimeout 未接收任何数据即断开，防止慢速对端长期占用连接
+// - GET 的 offset/size 必须是已分享文件的某个分片（未设置 Store 时须落在文件范围内且不超过 maxUnindexedChunk）
+
+const (
+	DefaultMaxConns       = 256
+	DefaultMaxPeerConns   = 32
+	DefaultRequestTimeout = 10 * time.Second
+	DefaultIdleTimeout    = 30 * time.Second
+
+	maxUnindexedChunk = 64 << 20 // 未设置 Store 时单次 GET 的大小上限
+	rejectTimeout     = time.Second
+)
+
+var (
+	// ErrBusy 节点连接数已满，稍后重试或改用其他节点
+	ErrBusy = errors.New("busy")
+	// ErrBadRequest 请求格式错误或请求的范围不是已分享文件的分片
+	ErrBadRequest = errors.New("bad request")
+)
+
+// ServerLimits 传输服务的连接限制，各项为 0 表示不限
+type ServerLimits struct {
+	MaxConns       int           // 同时处理的连接总数
+	MaxPeerConns   int           // 每个对端 IP 同时处理的连接数
+	RequestTimeout time.Duration // 读取请求行的超时
+	IdleTimeout    time.Duration // 发送应答时对端未接收数据的最长时间
+}
+
+// DefaultServerLimits 返回 NewTCPTransport 使用的默认限制
+func DefaultServerLimits() ServerLimits {
+	return ServerLimits{
+		MaxConns:       DefaultMaxConns,
+		MaxPeerConns:   DefaultMaxPeerConns,
+		RequestTimeout: DefaultRequestTimeout,
+		IdleTimeout:    DefaultIdleTimeout,
+	}
+}
+
+// LimitError 连接数超出限制，Scope 为 "server" 或 "peer"
+type LimitError struct {
+	Scope string
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("busy: too many connections (%s limit %d)", e.Scope, e.Max)
+}
+
+func (e *LimitError) Unwrap() error { return ErrBusy }
+
+// RangeError GET 请求的范围不是已分享文件的分片
+type RangeError struct {
+	Offset, Size int64
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("bad request: range %d+%d is not a chunk", e.Offset, e.Size)
+}
+
+func (e *RangeError) Unwrap() error { return ErrBadRequest }
+
+// Unwrap 按应答前缀还原服务端的错误类型，便于用 errors.Is 判断 ErrBusy、ErrBadRequest、ErrChoked
+func (e *RemoteError) Unwrap() error {
+	for _, target := range []error{ErrBusy, ErrBadRequest, ErrChoked} {
+		if p := target.Error(); e.Msg == p || strings.HasPrefix(e.Msg, p+":") {
+			return target
+		}
+	}
+	return nil
+}
+
+// connLimiter 统计正在处理的连接
+type connLimiter struct {
+	mu    sync.Mutex
+	total int
+	peers map[string]int
+}
+
+// acquire 占用一个连接名额，成功时返回释放函数
+func (l *connLimiter) acquire(host string, lim ServerLimits) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lim.MaxConns > 0 && l.total >= lim.MaxConns {
+		return nil, &LimitError{Scope: "server", Max: lim.MaxConns}
+	}
+	if lim.MaxPeerConns > 0 && l.peers[host] >= lim.MaxPeerConns {
+		return nil, &LimitError{Scope: "peer", Max: lim.MaxPeerConns}
+	}
+	if l.peers == nil {
+		l.peers = make(map[string]int)
+	}
+	l.total++
+	l.peers[host]++
+	var once sync.Once
+	return func() { once.Do(func() { l.release(host) }) }, nil
+}
+
+func (l *connLimiter) release(host string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if l.peers[host]--; l.peers[host] <= 0 {
+		delete(l.peers, host)
+	}
+}
+
+// admit 为连接占用名额；超出限制时应答错误并关闭连接
+func (t *TCPTransport) admit(conn net.Conn) (func(), bool) {
+	release, err := t.conns.acquire(peerHost(conn.RemoteAddr()), t.limits())
+	if err != nil {
+		_ = conn.SetWriteDeadline(time.Now().Add(rejectTimeout))
+		fmt.Fprintf(conn, "ERR %v\n", err)
+		conn.Close()
+		return nil, false
+	}
+	return release, true
+}
+
+// SetLimits 修改连接限制（可在服务运行中调用），已建立的连接不受影响
+func (t *TCPTransport) SetLimits(lim ServerLimits) {
+	t.mu.Lock()
+	t.Limits = lim
+	t.mu.Unlock()
+}
+
+func (t *TCPTransport) limits() ServerLimits {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.Limits
+}
+
+// idleWriter 每次写入前顺延写超时，对端停止接收超过 idle 时写入失败
+type idleWriter struct {
+	conn net.Conn
+	idle time.Duration
+}
+
+func (w idleWriter) Write(p []byte) (int, error) {
+	_ = w.conn.SetWriteDeadline(time.Now().Add(w.idle))
+	return w.conn.Write(p)
+}
+
+// writerFor 返回发送应答体使用的 Writer：设置了 IdleTimeout 时按写入顺延写超时
+func (t *TCPTransport) writerFor(conn net.Conn) io.Writer {
+	if idle := t.limits().IdleTimeout; idle > 0 {
+		return idleWriter{conn: conn, idle: idle}
+	}
+	return conn
+}
+
+// parseRange 解析 GET 的 offset 与 size
+func parseRange(offsetStr, sizeStr string) (int64, int64, error) {
+	offset, err1 := strconv.ParseInt(offsetStr, 10, 64)
+	size, err2 := strconv.ParseInt(sizeStr, 10, 64)
+	if err1 != nil || err2 != nil || offset < 0 || size <= 0 {
+		return 0, 0, fmt.Errorf("%w: invalid range %q %q", ErrBadRequest, offsetStr, sizeStr)
+	}
+	return offset, size, nil
+}
+
+// checkRange 确认请求的范围是文件的一个分片；未设置 Store 时只检查范围落在文件内
+func (t *TCPTransport) checkRange(fileID core.FileID, path string, offset, size int64) error {
+	if t.Store == nil {
+		st, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if size > maxUnindexedChunk || offset > st.Size() || size > st.Size()-offset {
+			return &RangeError{Offset: offset, Size: size}
+		}
+		return nil
+	}
+	chunks, err := t.Store.GetChunks(fileID)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(chunks, func(c core.ChunkInfo) bool { return c.Offset == offset && c.Size == size }) {
+		return &RangeError{Offset: offset, Size: size}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package transfer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/index"
+)
+
+func TestConnLimiter(t *testing.T) {
+	var l connLimiter
+	lim := ServerLimits{MaxConns: 3, MaxPeerConns: 2}
+	acquire := func(host string) func() {
+		t.Helper()
+		release, err := l.acquire(host, lim)
+		if err != nil {
+			t.Fatalf("acquire %s: %v", host, err)
+		}
+		return release
+	}
+	limited := func(host, scope string) {
+		t.Helper()
+		_, err := l.acquire(host, lim)
+		var le *LimitError
+		if !errors.As(err, &le) || le.Scope != scope || !errors.Is(err, ErrBusy) {
+			t.Fatalf("acquire %s: err = %v, want the %s limit", host, err, scope)
+		}
+	}
+
+	a1 := acquire("a")
+	acquire("a")
+	limited("a", "peer")
+	b1 := acquire("b")
+	limited("c", "server")
+
+	// 重复释放只归还一个名额
+	a1()
+	a1()
+	if l.total != 2 || l.peers["a"] != 1 {
+		t.Fatalf("after releasing a twice: total %d, a %d; want 2, 1", l.total, l.peers["a"])
+	}
+	acquire("c")
+	limited("c", "server")
+	b1()
+	if _, ok := l.peers["b"]; ok {
+		t.Fatal("released peer still counted")
+	}
+
+	// 0 表示不限
+	var unlimited connLimiter
+	for range 10 {
+		if _, err := unlimited.acquire("a", ServerLimits{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		offset, size         string
+		wantOffset, wantSize int64
+		ok                   bool
+	}{
+		{"0", "16", 0, 16, true},
+		{"32", "1", 32, 1, true},
+		{"-1", "16", 0, 0, false},
+		{"0", "0", 0, 0, false},
+		{"0", "-16", 0, 0, false},
+		{"x", "16", 0, 0, false},
+		{"0", "", 0, 0, false},
+	}
+	for _, tt := range tests {
+		offset, size, err := parseRange(tt.offset, tt.size)
+		if !tt.ok {
+			if !errors.Is(err, ErrBadRequest) {
+				t.Errorf("parseRange(%q, %q) err = %v, want ErrBadRequest", tt.offset, tt.size, err)
+			}
+			continue
+		}
+		if err != nil || offset != tt.wantOffset || size != tt.wantSize {
+			t.Errorf("parseRange(%q, %q) = %d, %d, %v", tt.offset, tt.size, offset, size, err)
+		}
+	}
+}
+
+func TestCheckRange(t *testing.T) {
+	store := index.NewMemoryStore()
+	path, fi, _ := shareFile(t, store, make([]byte, 40), 16)
+	indexed := NewTCPTransport("", "")
+	indexed.Store = store
+	unindexed := NewTCPTransport("", "")
+	tests := []struct {
+		name         string
+		offset, size int64
+		indexed      bool // 设置 Store 时是否接受
+		unindexed    bool // 未设置 Store 时是否接受
+	}{
+		{"first chunk", 0, 16, true, true},
+		{"last short chunk", 32, 8, true, true},
+		{"inside a chunk", 4, 8, false, true},
+		{"across chunks", 8, 16, false, true},
+		{"whole file", 0, 40, false, true},
+		{"past the end", 32, 16, false, false},
+		{"offset past the end", 41, 1, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, c := range []struct {
+				tr   *TCPTransport
+				want bool
+			}{{indexed, tt.indexed}, {unindexed, tt.unindexed}} {
+				err := c.tr.checkRange(fi.ID, path, tt.offset, tt.size)
+				if c.want && err != nil {
+					t.Errorf("store %v: err = %v, want accepted", c.tr.Store != nil, err)
+				}
+				var re *RangeError
+				if !c.want && (!errors.As(err, &re) || !errors.Is(err, ErrBadRequest)) {
+					t.Errorf("store %v: err = %v, want a RangeError", c.tr.Store != nil, err)
+				}
+			}
+		})
+	}
+	if err := indexed.checkRange(core.FileID("unknown"), path, 0, 16); err == nil {
+		t.Error("range of an unknown file accepted")
+	}
+}
+
+func TestRemoteErrorUnwrap(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want error
+	}{
+		{"busy: too many connections (peer limit 2)", ErrBusy},
+		{"busy", ErrBusy},
+		{"bad request: range 0+16 is not a chunk", ErrBadRequest},
+		{"choked: no upload slot", ErrChoked},
+		{"busybox: unrelated", nil},
+		{"file not found", nil},
+	}
+	for _, tt := range tests {
+		err := error(&RemoteError{Msg: tt.msg})
+		for _, target := range []error{ErrBusy, ErrBadRequest, ErrChoked} {
+			if got := errors.Is(err, target); got != (target == tt.want) {
+				t.Errorf("errors.Is(%q, %v) = %v", tt.msg, target, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// PEX（节点交换）：
+// - 客户端 -> 服务端：PEX <fileID> <nodeID|-> <port>\n，port>0 表示请求方也提供该文件，服务端将其登记为该文件的节点
+// - 服务端 -> 客户端：OK <len>\n 后续为 len 字节的 JSON {"peers":[{"id","addrs"}]}；或 ERR <msg>\n
+
+// PeerExchange PEX 的节点来源：设置到 TCPTransport.PEX 后启用 PEX 命令
+type PeerExchange interface {
+	// PeersFor 返回已知持有 fileID 的节点（不含 exclude）
+	PeersFor(fileID core.FileID, exclude core.NodeID) []core.Node
+	// AddPeer 登记持有 fileID 的节点
+	AddPeer(fileID core.FileID, n core.Node)
+}
+
+const (
+	maxPEXPeers = 50 // 单次 PEX 交换的节点数上限
+	maxPEXAddrs = 4  // 每个节点携带的地址数上限
+	maxPEXSize  = 64 << 10
+
+	pexRate  = 2 * time.Second // 每个来源 IP 平均每 2 秒一次请求
+	pexBurst = 10
+)
+
+type pexPeer struct {
+	ID    core.NodeID `json:"id"`
+	Addrs []string    `json:"addrs"`
+}
+
+type pexMsg struct {
+	Peers []pexPeer `json:"peers"`
+}
+
+// pexLimiter 按来源 IP 的令牌桶限流
+type pexLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*pexBucket
+}
+
+type pexBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (l *pexLimiter) allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*pexBucket)
+	}
+	// 清理已回满的桶，避免表无限增长
+	if len(l.buckets) > 4096 {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > pexRate*pexBurst {
+				delete(l.buckets, k)
+			}
+		}
+	}
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &pexBucket{tokens: pexBurst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens += float64(now.Sub(b.last)) / float64(pexRate)
+	if b.tokens > pexBurst {
+		b.tokens = pexBurst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (t *TCPTransport) handlePEX(conn net.Conn, parts []string) {
+	if t.PEX == nil {
+		fmt.Fprintf(conn, "ERR pex disabled\n")
+		return
+	}
+	remote, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if !t.pexLimit.allow(remote) {
+		fmt.Fprintf(conn, "ERR rate limited\n")
+		return
+	}
+	fileID, nodeID := core.FileID(parts[1]), core.NodeID(parts[2])
+	port, err := strconv.Atoi(parts[3])
+	if err != nil || port < 0 || port > 65535 {
+		fmt.Fprintf(conn, "ERR invalid port\n")
+		return
+	}
+	if nodeID == "-" {
+		nodeID = ""
+	}
+
+	var msg pexMsg
+	for _, n := range t.PEX.PeersFor(fileID, nodeID) {
+		if n.ID == "" {
+			continue // 来源未经证实的独立记录只供本机使用，不转告他人
+		}
+		p := pexPeer{ID: n.ID, Addrs: n.ServiceAddrs()}
+		if len(p.Addrs) > maxPEXAddrs {
+			p.Addrs = p.Addrs[:maxPEXAddrs]
+		}
+		msg.Peers = append(msg.Peers, p)
+		if len(msg.Peers) >= maxPEXPeers {
+			break
+		}
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Fprintf(conn, "ERR %v\n", err)
+		return
+	}
+	fmt.Fprintf(conn, "OK %d\n", len(data))
+	_, _ = conn.Write(data)
+
+	// 请求方自称也提供该文件：按连接来源 IP 登记，不信任其自报地址；
+	// 只登记本节点索引中的文件，避免任意请求方以任意文件ID填充节点表
+	if nodeID != "" && nodeID != t.NodeID && port > 0 && t.hasFile(fileID) {
+		addr := net.JoinHostPort(remote, strconv.Itoa(port))
+		t.PEX.AddPeer(fileID, core.Node{ID: nodeID, Address: addr, ServicePort: port, Addrs: []string{addr}, LastSeen: time.Now(), Status: "online"})
+	}
+}
+
+// hasFile 判断 fileID 是否在本节点的索引中且未撤回
+func (t *TCPTransport) hasFile(fileID core.FileID) bool {
+	if t.Store == nil {
+		return false
+	}
+	fi, err := t.Store.GetFile(fileID)
+	return err == nil && !fi.Withdrawn
+}
+
+// ExchangePeers 向节点请求持有 fileID 的其他节点；self/port 非空时同时告知对方本节点也提供该文件
+// 返回的节点经过校验：地址格式与端口合法，去除组播/未指定地址，来自远端的回环地址被丢弃
+func (t *TCPTransport) ExchangePeers(ctx context.Context, node core.Node, fileID core.FileID, self core.NodeID, port int) ([]core.Node, error) {
+	id := string(self)
+	if id == "" {
+		id, port = "-", 0
+	}
+	conn, br, err := t.request(ctx, node, fmt.Sprintf("PEX %s %s %d\n", fileID, id, port))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var msg pexMsg
+	if err := json.NewDecoder(io.LimitReader(br, maxPEXSize)).Decode(&msg); err != nil {
+		return nil, err
+	}
+	if len(msg.Peers) > maxPEXPeers {
+		return nil, errors.New("pex: too many peers")
+	}
+	remote, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	return sanitizePeers(msg.Peers, net.ParseIP(remote), self), nil
+}
+
+func sanitizePeers(peers []pexPeer, from net.IP, self core.NodeID) []core.Node {
+	fromLoopback := from != nil && from.IsLoopback()
+	var out []core.Node
+	seen := make(map[core.NodeID]bool)
+	for _, p := range peers {
+		if p.ID == "" || p.ID == self || seen[p.ID] || len(p.ID) > 256 {
+			continue
+		}
+		var addrs []string
+		for _, a := range p.Addrs {
+			if len(addrs) == maxPEXAddrs {
+				break
+			}
+			host, port, err := net.SplitHostPort(a)
+			if err != nil {
+				continue
+			}
+			if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+				continue
+			}
+			ip := net.ParseIP(host)
+			if ip == nil && host != "" && len(host) < 254 {
+				addrs = append(addrs, a) // 主机名
+				continue
+			}
+			if ip == nil || ip.IsUnspecified() || ip.IsMulticast() || ip.IsLoopback() && !fromLoopback {
+				continue
+			}
+			addrs = append(addrs, a)
+		}
+		if len(addrs) == 0 {
+			continue
+		}
+		seen[p.ID] = true
+		out = append(out, core.Node{ID: p.ID, Address: addrs[0], Addrs: addrs, LastSeen: time.Now(), Status: "pex"})
+	}
+	return out
+}
@@ -0,0 +1,67 @@
+package transfer
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/index"
+)
+
+// recordingPEX 记录经 AddPeer 登记的节点
+type recordingPEX struct {
+	mu    sync.Mutex
+	peers []core.Node
+	added map[core.FileID][]core.Node
+}
+
+func (p *recordingPEX) PeersFor(core.FileID, core.NodeID) []core.Node {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peers
+}
+
+func (p *recordingPEX) AddPeer(fileID core.FileID, n core.Node) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.added[fileID] = append(p.added[fileID], n)
+}
+
+func TestPEXRegistersCallerOnlyForStoredFiles(t *testing.T) {
+	store := index.NewMemoryStore()
+	_, fi, _ := shareFile(t, store, []byte("pex swarm file"), 8)
+	pex := &recordingPEX{
+		added: make(map[core.FileID][]core.Node),
+		peers: []core.Node{
+			{ID: "known", Address: "10.0.0.1:9001", Addrs: []string{"10.0.0.1:9001"}},
+			{Address: "10.0.0.66:9001", Addrs: []string{"10.0.0.66:9001"}}, // 来源未经证实的独立记录
+		},
+	}
+	tr := NewTCPTransport("", "")
+	tr.Store, tr.PEX, tr.NodeID = store, pex, "server"
+	node := core.Node{Address: startNode(t, tr)}
+	ctx := context.Background()
+
+	client := NewTCPTransport("", "")
+	peers, err := client.ExchangePeers(ctx, node, fi.ID, "caller", 9100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 || peers[0].ID != "known" {
+		t.Errorf("peers = %+v, want only the record with an ID", peers)
+	}
+	// 本节点索引中没有的文件：应答但不登记请求方
+	if _, err := client.ExchangePeers(ctx, node, "unknown-file", "caller", 9100); err != nil {
+		t.Fatal(err)
+	}
+
+	pex.mu.Lock()
+	defer pex.mu.Unlock()
+	if got := pex.added[fi.ID]; len(got) != 1 || got[0].ID != "caller" || got[0].Address != "127.0.0.1:9100" {
+		t.Errorf("registered for the stored file = %+v", got)
+	}
+	if got := pex.added["unknown-file"]; len(got) != 0 {
+		t.Errorf("registered for a file not in the store = %+v", got)
+	}
+}
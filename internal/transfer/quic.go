@@ -0,0 +1,387 @@
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// QUIC 传输
+// - 每个对端一条 QUIC 连接，每个请求（GET / META 等）一条双向流，流上的请求行与应答与 TCP 协议相同
+// - 多个分片在同一连接上并发传输，共享拥塞窗口，避免逐分片建连的慢启动，丢包只阻塞所在的流
+// - TLS 1.3 为 QUIC 内置：服务端使用启动时生成的自签名证书，客户端不校验证书（与 TCP 传输一样不做鉴权，分片按索引哈希校验）
+// - 客户端地址变化（NAT 重新绑定、切换网络）时服务端经路径验证后迁移连接，连接与进行中的流不中断
+// - 未启用 QUIC 的节点或 QUIC 连接失败时回退到 Fallback（TCP，及其打洞、中继）
+
+const (
+	quicALPN             = "ripplego/1"
+	quicHandshakeTimeout = 3 * time.Second
+	quicIdleTimeout      = 30 * time.Second
+	quicKeepAlive        = 10 * time.Second
+	quicMaxStreams       = 256         // 每条连接允许对端并发打开的流数
+	noQUICTTL            = time.Minute // QUIC 连接失败后直接使用 Fallback 的时长
+)
+
+// errNoQUIC 无法与节点建立 QUIC 连接，可回退到其他传输
+var errNoQUIC = errors.New("quic unavailable")
+
+// QUICTransport 基于 QUIC 的传输，服务端与 TCP 传输共用请求处理（见 TCPTransport.handle）
+type QUICTransport struct {
+	*TCPTransport
+	// QUICAddr UDP 监听地址，为空时使用 TCPTransport.Addr 中的首个地址（同一端口号的 UDP）
+	QUICAddr string
+	// Fallback 不使用 QUIC 的节点或 QUIC 连接失败时使用的传输，默认为 TCPTransport；可为包装它的 RelayTransport
+	// Serve 同时启动 Fallback 的服务
+	Fallback Transport
+	// Select 判断是否对节点使用 QUIC，为空时对全部节点尝试 QUIC
+	Select func(node core.Node) bool
+
+	noQUIC skipList // QUIC 连接失败的节点
+
+	qmu    sync.Mutex
+	peers  map[string]*quicPeer
+	udp    *quic.Transport // 客户端共用的 UDP socket，首次连接时创建
+	closed bool
+}
+
+// quicPeer 到一个节点的 QUIC 连接；ready 关闭后 conn 与 err 可读
+type quicPeer struct {
+	ready    chan struct{}
+	conn     *quic.Conn
+	err      error
+	canceled bool // 建连因发起者的 ctx 结束而中止，等待者应自行重试
+}
+
+// NewQUICTransport 创建 QUIC 传输，fallback 为空时回退到 tr
+func NewQUICTransport(tr *TCPTransport, fallback Transport) *QUICTransport {
+	if fallback == nil {
+		fallback = tr
+	}
+	return &QUICTransport{TCPTransport: tr, Fallback: fallback}
+}
+
+func quicConfig() *quic.Config {
+	return &quic.Config{
+		HandshakeIdleTimeout: quicHandshakeTimeout,
+		MaxIdleTimeout:       quicIdleTimeout,
+		KeepAlivePeriod:      quicKeepAlive,
+		MaxIncomingStreams:   quicMaxStreams,
+	}
+}
+
+// Serve 在 UDP 上接受 QUIC 连接，同时启动 Fallback 的服务，直到 ctx 结束
+func (q *QUICTransport) Serve(ctx context.Context) error {
+	tlsConf, err := selfSignedTLS()
+	if err != nil {
+		return err
+	}
+	ln, err := quic.ListenAddr(q.listenAddr(), tlsConf, quicConfig())
+	if err != nil {
+		return fmt.Errorf("quic listen: %w", err)
+	}
+	qerr := make(chan error, 1)
+	go func() { qerr <- q.serveQUIC(ctx, ln) }()
+	err = q.Fallback.Serve(ctx)
+	ln.Close()
+	if e := <-qerr; err == nil {
+		err = e
+	}
+	return err
+}
+
+// listenAddr 返回 QUIC 的 UDP 监听地址
+func (q *QUICTransport) listenAddr() string {
+	if q.QUICAddr != "" {
+		return q.QUICAddr
+	}
+	addr, _, _ := strings.Cut(q.Addr, ",")
+	return strings.TrimSpace(addr)
+}
+
+func (q *QUICTransport) serveQUIC(ctx context.Context, ln *quic.Listener) error {
+	for {
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, quic.ErrServerClosed) {
+				return nil
+			}
+			return err
+		}
+		go q.serveConn(ctx, conn)
+	}
+}
+
+// serveConn 每条流按 TCP 协议处理一个请求
+func (q *QUICTransport) serveConn(ctx context.Context, conn *quic.Conn) {
+	for {
+		s, err := conn.AcceptStream(ctx)
+		if err != nil {
+			_ = conn.CloseWithError(0, "")
+			return
+		}
+		go q.handle(&quicStream{Stream: s, conn: conn})
+	}
+}
+
+func (q *QUICTransport) Download(ctx context.Context, node core.Node, fileID core.FileID, chunk core.ChunkInfo, w io.Writer) error {
+	if !q.useQUIC(node) {
+		return q.Fallback.Download(ctx, node, fileID, chunk, w)
+	}
+	err := q.getChunk(node, fileID, chunk, w, func(req string) (net.Conn, *bufio.Reader, error) { return q.request(ctx, node, req) })
+	if errors.Is(err, errNoQUIC) {
+		return q.Fallback.Download(ctx, node, fileID, chunk, w)
+	}
+	return err
+}
+
+// FetchManifest 经 QUIC 获取文件索引，不可用时回退
+func (q *QUICTransport) FetchManifest(ctx context.Context, node core.Node, fileID core.FileID) (core.FileInfo, []core.ChunkInfo, error) {
+	fallback := func() (core.FileInfo, []core.ChunkInfo, error) {
+		if mf, ok := q.Fallback.(ManifestFetcher); ok {
+			return mf.FetchManifest(ctx, node, fileID)
+		}
+		return q.TCPTransport.FetchManifest(ctx, node, fileID)
+	}
+	if !q.useQUIC(node) {
+		return fallback()
+	}
+	s, br, err := q.request(ctx, node, fmt.Sprintf("META %s\n", fileID))
+	if errors.Is(err, errNoQUIC) {
+		return fallback()
+	}
+	if err != nil {
+		return core.FileInfo{}, nil, err
+	}
+	defer s.Close()
+	return decodeManifest(br, fileID)
+}
+
+// request 在到节点的 QUIC 连接上打开一条流并发送请求；无法建立连接时返回 errNoQUIC
+func (q *QUICTransport) request(ctx context.Context, node core.Node, req string) (net.Conn, *bufio.Reader, error) {
+	for attempt := 0; ; attempt++ {
+		conn, err := q.conn(ctx, node)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, err
+			}
+			q.noQUIC.add(peerKey(node), noQUICTTL)
+			return nil, nil, fmt.Errorf("%w: %v", errNoQUIC, err)
+		}
+		s, err := conn.OpenStreamSync(ctx)
+		if err != nil {
+			// 缓存的连接可能已断开，丢弃后重连一次
+			q.drop(node, conn)
+			if attempt == 0 && ctx.Err() == nil {
+				continue
+			}
+			return nil, nil, err
+		}
+		sc := &quicStream{Stream: s, conn: conn}
+		br, err := exchange(sc, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sc, br, nil
+	}
+}
+
+// conn 返回到节点的连接：复用已有连接，并发请求共享同一次建连
+func (q *QUICTransport) conn(ctx context.Context, node core.Node) (*quic.Conn, error) {
+	key := peerKey(node)
+	for {
+		q.qmu.Lock()
+		if q.closed {
+			q.qmu.Unlock()
+			return nil, net.ErrClosed
+		}
+		if q.peers == nil {
+			q.peers = make(map[string]*quicPeer)
+		}
+		p := q.peers[key]
+		if p == nil {
+			p = &quicPeer{ready: make(chan struct{})}
+			q.peers[key] = p
+			q.qmu.Unlock()
+			p.conn, p.err = q.dial(ctx, node)
+			p.canceled = ctx.Err() != nil
+			close(p.ready)
+			if p.err != nil {
+				q.drop(node, nil)
+			}
+			return p.conn, p.err
+		}
+		q.qmu.Unlock()
+		select {
+		case <-p.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if p.err != nil {
+			if p.canceled {
+				continue
+			}
+			return nil, p.err
+		}
+		if p.conn.Context().Err() == nil {
+			return p.conn, nil
+		}
+		q.drop(node, p.conn)
+	}
+}
+
+// drop 移除节点的缓存连接；conn 不为空时只在缓存的仍是该连接时移除
+func (q *QUICTransport) drop(node core.Node, conn *quic.Conn) {
+	key := peerKey(node)
+	q.qmu.Lock()
+	if p := q.peers[key]; p != nil {
+		select {
+		case <-p.ready:
+			if conn == nil || p.conn == conn {
+				delete(q.peers, key)
+			}
+		default:
+		}
+	}
+	q.qmu.Unlock()
+	if conn != nil {
+		_ = conn.CloseWithError(0, "")
+	}
+}
+
+// dial 依次尝试节点的服务地址（同端口号的 UDP）
+func (q *QUICTransport) dial(ctx context.Context, node core.Node) (*quic.Conn, error) {
+	addrs := node.ServiceAddrs()
+	if len(addrs) == 0 {
+		return nil, errors.New("empty node address")
+	}
+	tr, err := q.transport()
+	if err != nil {
+		return nil, err
+	}
+	tlsConf := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{quicALPN}, MinVersion: tls.VersionTLS13}
+	var lastErr error
+	for _, addr := range addrs {
+		ua, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		dctx, cancel := context.WithTimeout(ctx, quicHandshakeTimeout)
+		conn, err := tr.Dial(dctx, ua, tlsConf, quicConfig())
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// transport 返回客户端共用的 QUIC transport（单个 UDP socket）
+func (q *QUICTransport) transport() (*quic.Transport, error) {
+	q.qmu.Lock()
+	defer q.qmu.Unlock()
+	if q.udp != nil {
+		return q.udp, nil
+	}
+	pc, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	q.udp = &quic.Transport{Conn: pc}
+	return q.udp, nil
+}
+
+func (q *QUICTransport) useQUIC(node core.Node) bool {
+	return (q.Select == nil || q.Select(node)) && !q.noQUIC.skipped(peerKey(node))
+}
+
+// Close 关闭全部客户端连接
+func (q *QUICTransport) Close() error {
+	q.qmu.Lock()
+	q.closed = true
+	peers := q.peers
+	q.peers = nil
+	udp := q.udp
+	q.qmu.Unlock()
+	for _, p := range peers {
+		select {
+		case <-p.ready:
+			if p.conn != nil {
+				_ = p.conn.CloseWithError(0, "")
+			}
+		default:
+		}
+	}
+	if udp != nil {
+		return udp.Close()
+	}
+	return nil
+}
+
+// quicStream 将 QUIC 流包装为 net.Conn，供 TCPTransport.handle 与客户端请求使用
+type quicStream struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+func (s *quicStream) LocalAddr() net.Addr  { return s.conn.LocalAddr() }
+func (s *quicStream) RemoteAddr() net.Addr { return s.conn.RemoteAddr() }
+
+// Close 结束发送方向并放弃未读的数据
+func (s *quicStream) Close() error {
+	s.Stream.CancelRead(0)
+	return s.Stream.Close()
+}
+
+// CloseWrite 只结束发送方向（中继转发使用）
+func (s *quicStream) CloseWrite() error { return s.Stream.Close() }
+
+// selfSignedTLS 生成服务端使用的自签名证书
+func selfSignedTLS() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "ripplego"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{quicALPN},
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
@@ -0,0 +1,243 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/index"
+)
+
+// startQUICNode 只在 QUIC 上提供 tr 的服务（不监听 TCP），返回 UDP 地址
+func startQUICNode(t *testing.T, tr *TCPTransport) string {
+	t.Helper()
+	tlsConf, err := selfSignedTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := quic.ListenAddr("127.0.0.1:0", tlsConf, quicConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go NewQUICTransport(tr, nil).serveQUIC(ctx, ln)
+	t.Cleanup(func() { cancel(); ln.Close() })
+	return ln.Addr().String()
+}
+
+// quicClient 创建客户端 QUIC 传输，测试结束时关闭
+func quicClient(t *testing.T) *QUICTransport {
+	q := NewQUICTransport(NewTCPTransport("", ""), nil)
+	t.Cleanup(func() { q.Close() })
+	return q
+}
+
+// gatedProxy 在 open 置位前丢弃全部报文，之后在客户端与 server 之间转发
+func gatedProxy(t *testing.T, server string) (string, *atomic.Bool) {
+	t.Helper()
+	sa, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	open := new(atomic.Bool)
+	go func() {
+		var client *net.UDPAddr
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := pc.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !open.Load() {
+				continue
+			}
+			if from.String() == sa.String() {
+				if client != nil {
+					pc.WriteToUDP(buf[:n], client)
+				}
+				continue
+			}
+			client = from
+			pc.WriteToUDP(buf[:n], sa)
+		}
+	}()
+	return pc.LocalAddr().String(), open
+}
+
+func TestQUICGetAndMeta(t *testing.T) {
+	store := index.NewMemoryStore()
+	data := bytes.Repeat([]byte("over quic "), 100)
+	_, fi, chunks := shareFile(t, store, data, 256)
+	server := NewTCPTransport("", "")
+	server.Store = store
+	node := core.Node{ID: "server", Address: startQUICNode(t, server)}
+	q := quicClient(t)
+	ctx := context.Background()
+
+	got, gotChunks, err := q.FetchManifest(ctx, node, fi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != fi.ID || len(gotChunks) != len(chunks) {
+		t.Fatalf("manifest = %+v, %d chunks; want %d chunks", got, len(gotChunks), len(chunks))
+	}
+	var out bytes.Buffer
+	for _, ch := range gotChunks {
+		if err := q.Download(ctx, node, fi.ID, ch, &out); err != nil {
+			t.Fatalf("chunk %d: %v", ch.Index, err)
+		}
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("downloaded data differs from the shared file")
+	}
+	if q.noQUIC.skipped(peerKey(node)) {
+		t.Fatal("QUIC node marked as unavailable")
+	}
+}
+
+func TestQUICConcurrentConnsShareOneDial(t *testing.T) {
+	node := core.Node{ID: "server", Address: startQUICNode(t, NewTCPTransport("", ""))}
+	q := quicClient(t)
+	ctx := context.Background()
+
+	conns := make([]*quic.Conn, 8)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := q.conn(ctx, node)
+			if err != nil {
+				t.Error(err)
+			}
+			conns[i] = c
+		}()
+	}
+	wg.Wait()
+	for _, c := range conns {
+		if c == nil || c != conns[0] {
+			t.Fatal("concurrent requests did not share one connection")
+		}
+	}
+}
+
+func TestQUICRedialsClosedConn(t *testing.T) {
+	store := index.NewMemoryStore()
+	_, fi, _ := shareFile(t, store, []byte("redial after close"), 8)
+	server := NewTCPTransport("", "")
+	server.Store = store
+	node := core.Node{ID: "server", Address: startQUICNode(t, server)}
+	q := quicClient(t)
+	ctx := context.Background()
+
+	first, err := q.conn(ctx, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 缓存的连接断开后，下一个请求丢弃它并重新建连
+	_ = first.CloseWithError(0, "")
+	if _, _, err := q.FetchManifest(ctx, node, fi.ID); err != nil {
+		t.Fatal(err)
+	}
+	second, err := q.conn(ctx, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first || second.Context().Err() != nil {
+		t.Fatal("closed connection reused")
+	}
+}
+
+func TestQUICCanceledDialIsRetried(t *testing.T) {
+	addr, open := gatedProxy(t, startQUICNode(t, NewTCPTransport("", "")))
+	node := core.Node{ID: "server", Address: addr}
+	q := quicClient(t)
+
+	// 首个请求建连期间其 ctx 结束：等待同一次建连的其他请求不应得到它的取消错误，而是自行重试
+	ctx, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := q.conn(ctx, node)
+		firstErr <- err
+	}()
+	waitFor(t, func() bool {
+		q.qmu.Lock()
+		defer q.qmu.Unlock()
+		return q.peers[peerKey(node)] != nil
+	})
+	type result struct {
+		conn *quic.Conn
+		err  error
+	}
+	second := make(chan result, 1)
+	go func() {
+		wctx, wcancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer wcancel()
+		c, err := q.conn(wctx, node)
+		second <- result{c, err}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled dial err = %v", err)
+	}
+	open.Store(true)
+	r := <-second
+	if r.err != nil || r.conn == nil {
+		t.Fatalf("waiting request after the canceled dial: %v", r.err)
+	}
+}
+
+func TestQUICFallsBackWithoutUDPListener(t *testing.T) {
+	store := index.NewMemoryStore()
+	data := []byte("served over tcp only")
+	_, fi, chunks := shareFile(t, store, data, 64)
+	server := NewTCPTransport("", "")
+	server.Store = store
+	// 只监听 TCP：同端口号的 UDP 上没有 QUIC 服务
+	node := core.Node{ID: "tcp-only", Address: startNode(t, server)}
+	q := quicClient(t)
+	ctx := context.Background()
+
+	if _, _, err := q.request(ctx, node, "HELLO\n"); !errors.Is(err, errNoQUIC) {
+		t.Fatalf("request err = %v, want errNoQUIC", err)
+	}
+	if !q.noQUIC.skipped(peerKey(node)) {
+		t.Fatal("node without QUIC not skipped")
+	}
+
+	// 跳过期间直接使用 TCP，不再等待 QUIC 握手超时
+	start := time.Now()
+	if _, _, err := q.FetchManifest(ctx, node, fi.ID); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := q.Download(ctx, node, fi.ID, chunks[0], &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != string(data) {
+		t.Fatalf("downloaded %q", out.String())
+	}
+	if d := time.Since(start); d >= quicHandshakeTimeout {
+		t.Fatalf("fallback took %v, QUIC was retried", d)
+	}
+
+	// 首次请求本身也回退到 TCP
+	other := quicClient(t)
+	if _, _, err := other.FetchManifest(ctx, node, fi.ID); err != nil {
+		t.Fatalf("fallback on the first request: %v", err)
+	}
+}
@@ -0,0 +1,543 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// clock 限速使用的时钟，测试中替换为手动推进的时钟
+type clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// byteLimiter 按字节计的令牌桶，突发量为 1 秒的速率；nil 或速率为 0 表示不限速
+type byteLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+	clock  clock
+}
+
+func newByteLimiter(rate int64) *byteLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &byteLimiter{rate: float64(rate), tokens: float64(rate), last: time.Now(), clock: systemClock{}}
+}
+
+// wait 取出 n 个令牌，不足时等待补足（允许透支，下次调用补偿）
+func (l *byteLimiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := l.clock.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if d > 0 {
+		l.clock.Sleep(d)
+	}
+}
+
+// setRate 修改速率，0 表示不限速；已透支的部分按新速率补偿
+func (l *byteLimiter) setRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		l.tokens, l.last = float64(rate), l.clock.Now()
+	}
+	l.rate = float64(rate)
+	l.tokens = min(l.tokens, l.rate)
+}
+
+// RateLimits 传输限速（字节/秒），0 表示不限
+type RateLimits struct {
+	Upload       int64 `json:"upload"`       // 全部节点合计的上传速率
+	Download     int64 `json:"download"`     // 全部节点合计的下载速率
+	PeerUpload   int64 `json:"peerUpload"`   // 向单个节点上传的速率
+	PeerDownload int64 `json:"peerDownload"` // 从单个节点下载的速率
+}
+
+func (l RateLimits) String() string {
+	f := func(v int64) string {
+		if v <= 0 {
+			return "不限"
+		}
+		return FormatRate(v)
+	}
+	return fmt.Sprintf("上传 %s（每节点 %s），下载 %s（每节点 %s）", f(l.Upload), f(l.PeerUpload), f(l.Download), f(l.PeerDownload))
+}
+
+// RateRule 按星期与时段生效的限速，如 "mon-fri 09:00-18:00 up=1M peer-up=256K"
+type RateRule struct {
+	Days       [7]bool // 按 time.Weekday 下标
+	Start, End int     // 一天中的分钟数；Start > End 表示跨越午夜（按开始当天的星期判断）
+	Limits     RateLimits
+	spec       string
+}
+
+func (r RateRule) String() string { return r.spec }
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseRateRule 解析限速时段：<星期> <HH:MM>-<HH:MM> <键=速率>...
+// 星期为 mon..sun、区间（mon-fri）、逗号分隔的列表或 *；键为 up、down、peer-up、peer-down，
+// 速率为字节/秒，可带 K、M、G 后缀（按 1024 进位）；未给出的键表示该方向不限速
+func ParseRateRule(spec string) (RateRule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) < 3 {
+		return RateRule{}, fmt.Errorf("rate rule %q: want <days> <HH:MM>-<HH:MM> <key=rate>...", spec)
+	}
+	r := RateRule{spec: strings.Join(fields, " ")}
+	if err := parseDays(strings.ToLower(fields[0]), &r.Days); err != nil {
+		return RateRule{}, fmt.Errorf("rate rule %q: %w", spec, err)
+	}
+	from, to, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return RateRule{}, fmt.Errorf("rate rule %q: bad time range %q", spec, fields[1])
+	}
+	var err error
+	if r.Start, err = parseClock(from); err != nil {
+		return RateRule{}, fmt.Errorf("rate rule %q: %w", spec, err)
+	}
+	if r.End, err = parseClock(to); err != nil {
+		return RateRule{}, fmt.Errorf("rate rule %q: %w", spec, err)
+	}
+	if r.Start == r.End {
+		return RateRule{}, fmt.Errorf("rate rule %q: empty time range", spec)
+	}
+	for _, kv := range fields[2:] {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return RateRule{}, fmt.Errorf("rate rule %q: bad limit %q", spec, kv)
+		}
+		rate, err := ParseRate(v)
+		if err != nil {
+			return RateRule{}, fmt.Errorf("rate rule %q: %w", spec, err)
+		}
+		switch strings.ToLower(k) {
+		case "up":
+			r.Limits.Upload = rate
+		case "down":
+			r.Limits.Download = rate
+		case "peer-up":
+			r.Limits.PeerUpload = rate
+		case "peer-down":
+			r.Limits.PeerDownload = rate
+		default:
+			return RateRule{}, fmt.Errorf("rate rule %q: unknown key %q", spec, k)
+		}
+	}
+	return r, nil
+}
+
+func parseDays(s string, days *[7]bool) error {
+	if s == "*" || s == "daily" {
+		for i := range days {
+			days[i] = true
+		}
+		return nil
+	}
+	day := func(name string) (int, error) {
+		for i, d := range weekdays {
+			if d == name {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("unknown weekday %q", name)
+	}
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		a, err := day(from)
+		if err != nil {
+			return err
+		}
+		b := a
+		if isRange {
+			if b, err = day(to); err != nil {
+				return err
+			}
+		}
+		for i := a; ; i = (i + 1) % 7 {
+			days[i] = true
+			if i == b {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hh, err1 := strconv.Atoi(h)
+	mm, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hh < 0 || hh > 24 || mm < 0 || mm > 59 || hh == 24 && mm != 0 {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	return hh*60 + mm, nil
+}
+
+// matches 判断 t 是否落在时段内
+func (r RateRule) matches(t time.Time) bool {
+	now := t.Hour()*60 + t.Minute()
+	if r.Start < r.End {
+		return r.Days[t.Weekday()] && now >= r.Start && now < r.End
+	}
+	// 跨越午夜：开始当天的晚段或次日的早段
+	if now >= r.Start {
+		return r.Days[t.Weekday()]
+	}
+	return now < r.End && r.Days[(t.Weekday()+6)%7]
+}
+
+// ParseRate 解析速率（字节/秒）：整数，可带 K、M、G 后缀（按 1024 进位，可再加 iB 或 B），0 表示不限
+func ParseRate(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "B"), "I")
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(v, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(v, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(v, "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		v = v[:len(v)-1]
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad rate %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// FormatRate 以 B/s、KiB/s、MiB/s 显示速率
+func FormatRate(v int64) string {
+	switch {
+	case v >= 1<<20:
+		return strconv.FormatFloat(float64(v)/(1<<20), 'f', -1, 64) + " MiB/s"
+	case v >= 1<<10:
+		return strconv.FormatFloat(float64(v)/(1<<10), 'f', -1, 64) + " KiB/s"
+	}
+	return strconv.FormatInt(v, 10) + " B/s"
+}
+
+const (
+	peerBucketIdle  = 2 * time.Minute // 空闲超过该时长的节点令牌桶被回收
+	rateCheckPeriod = 30 * time.Second
+	limitedWriteMax = 16 << 10 // 限速写入时每次取令牌的最大字节数，使速率更平滑
+)
+
+// RateLimiter 全局与按节点的上传、下载令牌桶
+// 基础限速与限速时段都可在运行中修改；当前时间落在某个时段内时使用该时段的限速（多个时段重叠时取先列出的）
+type RateLimiter struct {
+	mu      sync.Mutex
+	base    RateLimits
+	rules   []RateRule
+	current RateLimits
+	up      *byteLimiter
+	down    *byteLimiter
+	peerUp  map[string]*peerBucket
+	peerDn  map[string]*peerBucket
+	clock   clock
+}
+
+type peerBucket struct {
+	l    *byteLimiter
+	used time.Time
+}
+
+// NewRateLimiter 创建限速器；时段限速需调用 Run 才会随时间切换
+func NewRateLimiter(base RateLimits, rules []RateRule) *RateLimiter {
+	return newRateLimiter(base, rules, systemClock{})
+}
+
+func newRateLimiter(base RateLimits, rules []RateRule, clk clock) *RateLimiter {
+	r := &RateLimiter{
+		base:   base,
+		rules:  rules,
+		up:     &byteLimiter{last: clk.Now(), clock: clk},
+		down:   &byteLimiter{last: clk.Now(), clock: clk},
+		peerUp: make(map[string]*peerBucket),
+		peerDn: make(map[string]*peerBucket),
+		clock:  clk,
+	}
+	r.apply()
+	return r
+}
+
+// Run 定期按时段切换限速，直到 ctx 结束
+func (r *RateLimiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(rateCheckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.apply()
+		}
+	}
+}
+
+// SetBase 修改时段之外的基础限速
+func (r *RateLimiter) SetBase(l RateLimits) {
+	r.mu.Lock()
+	r.base = l
+	r.mu.Unlock()
+	r.apply()
+}
+
+// SetRules 替换全部限速时段
+func (r *RateLimiter) SetRules(rules []RateRule) {
+	r.mu.Lock()
+	r.rules = append([]RateRule(nil), rules...)
+	r.mu.Unlock()
+	r.apply()
+}
+
+// Base 返回基础限速
+func (r *RateLimiter) Base() RateLimits {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.base
+}
+
+// Rules 返回限速时段
+func (r *RateLimiter) Rules() []RateRule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RateRule(nil), r.rules...)
+}
+
+// Current 返回当前生效的限速
+func (r *RateLimiter) Current() RateLimits {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// apply 按当前时间计算生效的限速并更新各令牌桶
+func (r *RateLimiter) apply() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur := r.base
+	now := r.clock.Now()
+	for _, rule := range r.rules {
+		if rule.matches(now) {
+			cur = rule.Limits
+			break
+		}
+	}
+	if cur == r.current {
+		return
+	}
+	r.current = cur
+	r.up.setRate(cur.Upload)
+	r.down.setRate(cur.Download)
+	for _, b := range r.peerUp {
+		b.l.setRate(cur.PeerUpload)
+	}
+	for _, b := range r.peerDn {
+		b.l.setRate(cur.PeerDownload)
+	}
+}
+
+// UploadWriter 返回向节点 peer 上传时使用的限速 Writer；不限速时返回 w 本身
+func (r *RateLimiter) UploadWriter(peer string, w io.Writer) io.Writer {
+	if r == nil {
+		return w
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current.Upload <= 0 && r.current.PeerUpload <= 0 {
+		return w
+	}
+	return &limitedWriter{w: w, limiters: []*byteLimiter{r.peerLocked(r.peerUp, peer, r.current.PeerUpload), r.up}}
+}
+
+// DownloadWriter 返回从节点 peer 下载时使用的限速 Writer；不限速时返回 w 本身
+func (r *RateLimiter) DownloadWriter(peer string, w io.Writer) io.Writer {
+	if r == nil {
+		return w
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current.Download <= 0 && r.current.PeerDownload <= 0 {
+		return w
+	}
+	return &limitedWriter{w: w, limiters: []*byteLimiter{r.peerLocked(r.peerDn, peer, r.current.PeerDownload), r.down}}
+}
+
+// peerLocked 返回节点的令牌桶，并回收长时间空闲的令牌桶
+func (r *RateLimiter) peerLocked(m map[string]*peerBucket, peer string, rate int64) *byteLimiter {
+	now := r.clock.Now()
+	b := m[peer]
+	if b == nil {
+		for k, old := range m {
+			if now.Sub(old.used) > peerBucketIdle {
+				delete(m, k)
+			}
+		}
+		b = &peerBucket{l: &byteLimiter{last: now, clock: r.clock}}
+		b.l.setRate(rate)
+		m[peer] = b
+	}
+	b.used = now
+	return b.l
+}
+
+// limitedWriter 写入前依次从各令牌桶取令牌
+type limitedWriter struct {
+	w        io.Writer
+	limiters []*byteLimiter
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), limitedWriteMax)
+		for _, lim := range l.limiters {
+			lim.wait(n)
+		}
+		m, err := l.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// RateStatus RATE 命令的应答
+type RateStatus struct {
+	Base    RateLimits `json:"base"`
+	Current RateLimits `json:"current"`
+	Rules   []string   `json:"rules,omitempty"`
+}
+
+// RateUpdate RATE 命令的修改请求，字段为空表示不修改
+type RateUpdate struct {
+	Base  *RateLimits `json:"base,omitempty"`
+	Rules *[]string   `json:"rules,omitempty"`
+}
+
+// handleRate 查询或修改运行中节点的限速，只接受经本节点 TCP 监听器从本机回环地址连入的请求（local）
+// 协议：RATE\n 或 RATE <RateUpdate JSON>\n，应答 OK <len>\n 后为 RateStatus JSON
+func (t *TCPTransport) handleRate(conn net.Conn, arg string, local bool) {
+	if !local {
+		fmt.Fprintf(conn, "ERR rate control only allowed from localhost\n")
+		return
+	}
+	if t.Limiter == nil {
+		fmt.Fprintf(conn, "ERR rate limiting not enabled\n")
+		return
+	}
+	if arg != "" {
+		var up RateUpdate
+		if err := json.Unmarshal([]byte(arg), &up); err != nil {
+			fmt.Fprintf(conn, "ERR bad rate update: %v\n", err)
+			return
+		}
+		if up.Rules != nil {
+			rules := make([]RateRule, 0, len(*up.Rules))
+			for _, spec := range *up.Rules {
+				r, err := ParseRateRule(spec)
+				if err != nil {
+					fmt.Fprintf(conn, "ERR %v\n", err)
+					return
+				}
+				rules = append(rules, r)
+			}
+			t.Limiter.SetRules(rules)
+		}
+		if up.Base != nil {
+			t.Limiter.SetBase(*up.Base)
+		}
+	}
+	st := RateStatus{Base: t.Limiter.Base(), Current: t.Limiter.Current()}
+	for _, r := range t.Limiter.Rules() {
+		st.Rules = append(st.Rules, r.String())
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		fmt.Fprintf(conn, "ERR %v\n", err)
+		return
+	}
+	fmt.Fprintf(conn, "OK %d\n", len(data))
+	_, _ = conn.Write(data)
+}
+
+// RateControl 向 addr 上运行的节点查询限速，update 不为空时先修改
+func RateControl(ctx context.Context, addr string, update *RateUpdate) (RateStatus, error) {
+	req := "RATE\n"
+	if update != nil {
+		data, err := json.Marshal(update)
+		if err != nil {
+			return RateStatus{}, err
+		}
+		req = "RATE " + string(data) + "\n"
+	}
+	conn, err := dialNode(ctx, core.Node{Address: addr})
+	if err != nil {
+		return RateStatus{}, err
+	}
+	defer conn.Close()
+	br, err := exchange(conn, req)
+	if err != nil {
+		return RateStatus{}, err
+	}
+	var st RateStatus
+	err = json.NewDecoder(io.LimitReader(br, maxHelloSize)).Decode(&st)
+	return st, err
+}
+
+func isLoopback(a net.Addr) bool {
+	host, _, err := net.SplitHostPort(a.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// peerHost 服务端按对端区分（上传限速、连接数、上传槽位）时使用的键：对端 IP
+func peerHost(a net.Addr) string { return hostOf(a.String()) }
+
+// hostOf 返回 host:port 的 host 部分
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,311 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/index"
+)
+
+// fakeClock 手动推进的时钟，Sleep 直接推进时间并累计等待时长
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.slept += d
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// takeSlept 返回并清零累计的等待时长
+func (c *fakeClock) takeSlept() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := c.slept
+	c.slept = 0
+	return d
+}
+
+// at 返回 2024-01-01（星期一）所在一周中第 day 天（0 为星期一）的 hh:mm
+func at(day, hh, mm int) time.Time {
+	return time.Date(2024, 1, 1+day, hh, mm, 0, 0, time.Local)
+}
+
+func TestLocalControlOnlyOnTCPListener(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tr := NewTCPTransport("", "")
+	tr.Limiter = NewRateLimiter(RateLimits{}, nil)
+	tr.Store = index.NewMemoryStore()
+	tr.Scheduler = NewUploadScheduler(1)
+
+	// 本节点 TCP 监听器上的回环连接可执行控制命令
+	if _, err := RateControl(ctx, startNode(t, tr), nil); err != nil {
+		t.Fatalf("RATE on the TCP listener: %v", err)
+	}
+
+	// 非 TCP 监听器（如 UDP、打洞流）上的连接即使对端地址为回环地址也被拒绝
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go tr.ServeListener(ctx, struct{ net.Listener }{ln})
+	if _, err := RateControl(ctx, ln.Addr().String(), nil); err == nil || !strings.Contains(err.Error(), "only allowed from localhost") {
+		t.Errorf("RATE on a stream listener: err = %v, want rejection", err)
+	}
+	if err := NewRemoteStore(ln.Addr().String()).Ping(); err == nil || !strings.Contains(err.Error(), "only allowed from localhost") {
+		t.Errorf("STORE on a stream listener: err = %v, want rejection", err)
+	}
+	rep := NewReceivedReporter(ln.Addr().String())
+	rep.Received("10.0.0.7", 1)
+	if err := rep.Flush(ctx); err == nil || !strings.Contains(err.Error(), "only allowed from localhost") {
+		t.Errorf("RECV on a stream listener: err = %v, want rejection", err)
+	}
+
+	// QUIC 流与中继会话经 handle 进入，同样拒绝
+	a, b := tcpPair(t)
+	go tr.handle(a)
+	if _, err := exchange(b, "RATE\n"); err == nil || !strings.Contains(err.Error(), "only allowed from localhost") {
+		t.Errorf("RATE via handle: err = %v, want rejection", err)
+	}
+}
+
+func TestParseRateRule(t *testing.T) {
+	tests := []struct {
+		spec       string
+		days       string // 按 time.Weekday 下标，1 表示生效
+		start, end int
+		limits     RateLimits
+	}{
+		{"mon-fri 09:00-18:00 up=1M peer-up=256K", "0111110", 9 * 60, 18 * 60, RateLimits{Upload: 1 << 20, PeerUpload: 256 << 10}},
+		{"fri-mon 22:00-06:30 down=512KiB", "1100011", 22 * 60, 6*60 + 30, RateLimits{Download: 512 << 10}},
+		{"SAT,sun  00:00-24:00  peer-down=1.5M", "1000001", 0, 24 * 60, RateLimits{PeerDownload: 3 << 19}},
+		{"* 01:00-02:00 up=0", "1111111", 60, 120, RateLimits{}},
+		{"daily 23:00-01:00 up=100", "1111111", 23 * 60, 60, RateLimits{Upload: 100}},
+	}
+	for _, tt := range tests {
+		r, err := ParseRateRule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseRateRule(%q): %v", tt.spec, err)
+			continue
+		}
+		var days string
+		for _, on := range r.Days {
+			days += map[bool]string{false: "0", true: "1"}[on]
+		}
+		if days != tt.days || r.Start != tt.start || r.End != tt.end || r.Limits != tt.limits {
+			t.Errorf("ParseRateRule(%q) = days %s %d-%d %+v, want days %s %d-%d %+v",
+				tt.spec, days, r.Start, r.End, r.Limits, tt.days, tt.start, tt.end, tt.limits)
+		}
+		if want := strings.Join(strings.Fields(tt.spec), " "); r.String() != want {
+			t.Errorf("String() = %q, want %q", r.String(), want)
+		}
+	}
+
+	for _, spec := range []string{
+		"mon-fri 09:00-18:00",
+		"mon-fri 09:00 up=1M",
+		"funday 09:00-18:00 up=1M",
+		"mon-xyz 09:00-18:00 up=1M",
+		"mon 09:00-09:00 up=1M",
+		"mon 25:00-26:00 up=1M",
+		"mon 24:30-01:00 up=1M",
+		"mon 09:60-10:00 up=1M",
+		"mon 09:00-18:00 up",
+		"mon 09:00-18:00 up=fast",
+		"mon 09:00-18:00 up=-1",
+		"mon 09:00-18:00 sideways=1M",
+	} {
+		if _, err := ParseRateRule(spec); err == nil {
+			t.Errorf("ParseRateRule(%q) accepted", spec)
+		}
+	}
+}
+
+func TestRateRuleMatches(t *testing.T) {
+	rule := func(spec string) RateRule {
+		r, err := ParseRateRule(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	tests := []struct {
+		rule RateRule
+		at   time.Time
+		want bool
+	}{
+		{rule("mon-fri 09:00-18:00 up=1M"), at(0, 9, 0), true},
+		{rule("mon-fri 09:00-18:00 up=1M"), at(4, 17, 59), true},
+		{rule("mon-fri 09:00-18:00 up=1M"), at(0, 18, 0), false},
+		{rule("mon-fri 09:00-18:00 up=1M"), at(5, 12, 0), false},
+		// 跨越午夜的时段按开始当天的星期判断
+		{rule("mon 22:00-02:00 up=1M"), at(0, 23, 0), true},
+		{rule("mon 22:00-02:00 up=1M"), at(1, 1, 59), true},
+		{rule("mon 22:00-02:00 up=1M"), at(1, 2, 0), false},
+		{rule("mon 22:00-02:00 up=1M"), at(0, 1, 0), false},
+		{rule("mon 22:00-02:00 up=1M"), at(1, 23, 0), false},
+		// 跨越周日的星期区间
+		{rule("fri-mon 22:00-06:00 up=1M"), at(6, 23, 0), true},
+		{rule("fri-mon 22:00-06:00 up=1M"), at(1, 5, 0), true},
+		{rule("fri-mon 22:00-06:00 up=1M"), at(2, 5, 0), false},
+		{rule("fri-mon 22:00-06:00 up=1M"), at(3, 23, 0), false},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.matches(tt.at); got != tt.want {
+			t.Errorf("%q matches %s = %v, want %v", tt.rule, tt.at.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestByteLimiterPacing(t *testing.T) {
+	clk := &fakeClock{now: at(0, 12, 0)}
+	l := &byteLimiter{last: clk.Now(), clock: clk}
+	l.setRate(1000)
+
+	// 初始突发量为 1 秒的速率，之后按速率等待，透支在下次调用时补偿
+	l.wait(1000)
+	l.wait(500)
+	if d := clk.takeSlept(); d != 500*time.Millisecond {
+		t.Fatalf("slept %v after 1500 bytes at 1000 B/s, want 500ms", d)
+	}
+	l.wait(2000)
+	if d := clk.takeSlept(); d != 2*time.Second {
+		t.Fatalf("slept %v for 2000 more bytes, want 2s", d)
+	}
+
+	// 空闲期间积累的令牌不超过 1 秒的量
+	clk.advance(time.Minute)
+	l.wait(1000)
+	l.wait(100)
+	if d := clk.takeSlept(); d != 100*time.Millisecond {
+		t.Fatalf("slept %v after idling, want 100ms", d)
+	}
+
+	// 改为不限速后不再等待，恢复限速时重新从满桶开始
+	l.setRate(0)
+	l.wait(1 << 20)
+	l.setRate(2000)
+	l.wait(2000)
+	if d := clk.takeSlept(); d != 0 {
+		t.Fatalf("slept %v, want none", d)
+	}
+	l.wait(1000)
+	if d := clk.takeSlept(); d != 500*time.Millisecond {
+		t.Fatalf("slept %v at 2000 B/s, want 500ms", d)
+	}
+}
+
+func TestRateLimiterFollowsSchedule(t *testing.T) {
+	clk := &fakeClock{now: at(0, 8, 0)}
+	rule, err := ParseRateRule("mon-fri 09:00-18:00 up=1K peer-down=2K")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRateLimiter(RateLimits{Download: 4 << 10}, []RateRule{rule}, clk)
+	if r.Current() != (RateLimits{Download: 4 << 10}) {
+		t.Fatalf("current before the rule = %+v", r.Current())
+	}
+	var buf bytes.Buffer
+	if w := r.UploadWriter("peer", &buf); w != &buf {
+		t.Fatal("upload limited outside the rule")
+	}
+
+	clk.advance(time.Hour)
+	r.apply()
+	if r.Current() != rule.Limits {
+		t.Fatalf("current during the rule = %+v, want %+v", r.Current(), rule.Limits)
+	}
+	// 3K 字节：1K 的突发量之后按 1K/s 等待 2 秒
+	w := r.UploadWriter("peer", &buf)
+	if _, err := w.Write(make([]byte, 3<<10)); err != nil {
+		t.Fatal(err)
+	}
+	if d := clk.takeSlept(); d != 2*time.Second || buf.Len() != 3<<10 {
+		t.Fatalf("upload slept %v and wrote %d bytes, want 2s and 3072", d, buf.Len())
+	}
+	// 单个节点的下载限速按节点分别计算
+	for _, peer := range []string{"a", "b"} {
+		if _, err := r.DownloadWriter(peer, &buf).Write(make([]byte, 4<<10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := clk.takeSlept(); d != 2*time.Second {
+		t.Fatalf("downloads slept %v, want 1s per peer", d)
+	}
+
+	clk.advance(9 * time.Hour)
+	r.apply()
+	if r.Current() != (RateLimits{Download: 4 << 10}) {
+		t.Fatalf("current after the rule = %+v", r.Current())
+	}
+}
+
+func TestRateControlRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	clk := &fakeClock{now: at(5, 10, 0)}
+	tr := NewTCPTransport("", "")
+	tr.Limiter = newRateLimiter(RateLimits{}, nil, clk)
+	addr := startNode(t, tr)
+
+	base := RateLimits{Upload: 1 << 20, PeerDownload: 64 << 10}
+	rules := []string{"sat,sun  08:00-20:00 up=128K", "mon-fri 22:00-06:00 down=2M"}
+	st, err := RateControl(ctx, addr, &RateUpdate{Base: &base, Rules: &rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := RateStatus{
+		Base:    base,
+		Current: RateLimits{Upload: 128 << 10},
+		Rules:   []string{"sat,sun 08:00-20:00 up=128K", "mon-fri 22:00-06:00 down=2M"},
+	}
+	if fmt.Sprintf("%+v", st) != fmt.Sprintf("%+v", want) {
+		t.Fatalf("status = %+v, want %+v", st, want)
+	}
+
+	// 只查询时返回同样的状态；只修改基础限速时保留时段
+	if st, err = RateControl(ctx, addr, nil); err != nil || fmt.Sprintf("%+v", st) != fmt.Sprintf("%+v", want) {
+		t.Fatalf("query = %+v, %v", st, err)
+	}
+	base = RateLimits{}
+	if st, err = RateControl(ctx, addr, &RateUpdate{Base: &base}); err != nil || len(st.Rules) != 2 || st.Base != base {
+		t.Fatalf("base update = %+v, %v", st, err)
+	}
+	// 清空时段后恢复基础限速
+	rules = []string{}
+	if st, err = RateControl(ctx, addr, &RateUpdate{Rules: &rules}); err != nil || st.Rules != nil || st.Current != base {
+		t.Fatalf("clearing rules = %+v, %v", st, err)
+	}
+
+	// 无效的时段整体拒绝，原有设置不变
+	rules = []string{"mon 09:00-18:00 up=1M", "someday 09:00-18:00 up=1M"}
+	if _, err := RateControl(ctx, addr, &RateUpdate{Rules: &rules}); err == nil || !strings.Contains(err.Error(), "unknown weekday") {
+		t.Fatalf("bad rule: err = %v", err)
+	}
+	if got := tr.Limiter.Rules(); len(got) != 0 {
+		t.Fatalf("rules after a rejected update = %v", got)
+	}
+}
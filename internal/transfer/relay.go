@@ -0,0 +1,465 @@
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// 中继（relay）：可直连的节点为无法互联的两个节点转发传输流，需在中继节点上显式启用（TCPTransport.Relay）
+// - 被中继节点 -> 中继：RELAY LISTEN <nodeID>\n，应答 OK 32\n 后为 32 字节随机质询；节点回复 <公钥hex> <签名hex>\n，
+//   公钥须与 nodeID 绑定（core.VerifyNodeKey），签名覆盖质询与 nodeID；验证通过后应答 OK 0\n 并保持连接，
+//   中继在其上下发 CONNECT <session>\n
+// - 被中继节点 -> 中继：RELAY ACCEPT <session>\n，应答 OK 0\n 后该连接与请求方配对
+// - 请求方 -> 中继：RELAY CONNECT <nodeID>\n，目标接受后应答 OK 0\n；或 ERR <msg>\n
+// 配对后中继按限速双向转发字节，两端在其上握手加密（见 secure.go），再按普通请求协议通信
+
+const (
+	DefaultRelayRate      = 1 << 20 // 每个会话每个方向默认 1 MiB/s
+	DefaultRelayTotalRate = 8 << 20
+	DefaultRelaySessions  = 16
+	maxRelayListeners     = 1024
+	relayChallengeSize    = 32
+	relayListenContext    = "ripplego relay listen v1"
+	relayIdleTimeout      = 2 * time.Minute // 会话双向都无数据多久后断开
+	relayHandshakeTimeout = 10 * time.Second
+	relayRetryMin         = time.Second
+	relayRetryMax         = 30 * time.Second
+	relayBufferSize       = 32 << 10
+)
+
+// relayAcceptTimeout 等待目标节点接受会话的时间
+var relayAcceptTimeout = 10 * time.Second
+
+// RelayServer 中继服务端，设置到 TCPTransport.Relay 后启用 RELAY 命令
+type RelayServer struct {
+	Rate        int64 // 每个会话每个方向的速率上限（字节/秒），<=0 表示不限
+	TotalRate   int64 // 全部会话合计的速率上限（字节/秒），<=0 表示不限
+	MaxSessions int   // 同时转发的会话数上限
+
+	mu        sync.Mutex
+	listeners map[core.NodeID]*relayListener
+	pending   map[string]*relayPending
+	sessions  int
+	total     *byteLimiter
+}
+
+type relayListener struct {
+	mu   sync.Mutex // 串行化 CONNECT 通知的写入
+	conn net.Conn
+}
+
+// relayHalf 目标节点接受会话的连接，转发结束后关闭 done
+type relayHalf struct {
+	conn net.Conn
+	r    io.Reader
+	done chan struct{}
+}
+
+// relayPending 等待目标节点接受的会话：ch 不带缓冲，只有请求方仍在等待时交接才会成功；
+// 请求方放弃（超时等）后关闭 gone，使迟到的 ACCEPT 不会一直阻塞
+type relayPending struct {
+	ch   chan relayHalf
+	gone chan struct{}
+}
+
+func NewRelayServer() *RelayServer {
+	return &RelayServer{
+		Rate:        DefaultRelayRate,
+		TotalRate:   DefaultRelayTotalRate,
+		MaxSessions: DefaultRelaySessions,
+		listeners:   make(map[core.NodeID]*relayListener),
+		pending:     make(map[string]*relayPending),
+	}
+}
+
+// Sessions 返回正在转发的会话数
+func (s *RelayServer) Sessions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions
+}
+
+func (t *TCPTransport) handleRelay(conn net.Conn, br *bufio.Reader, parts []string) {
+	if t.Relay == nil {
+		fmt.Fprintf(conn, "ERR relay disabled\n")
+		return
+	}
+	switch parts[1] {
+	case "LISTEN":
+		t.Relay.listen(conn, br, core.NodeID(parts[2]))
+	case "CONNECT":
+		t.Relay.connect(conn, br, core.NodeID(parts[2]))
+	case "ACCEPT":
+		t.Relay.accept(conn, br, parts[2])
+	default:
+		fmt.Fprintf(conn, "ERR invalid request\n")
+	}
+}
+
+// listen 要求被中继节点以与其ID绑定的私钥签名质询，验证通过后登记并保持连接直到对端断开；
+// 同一节点ID重新登记（如重连）时取代并关闭旧的登记
+func (s *RelayServer) listen(conn net.Conn, br *bufio.Reader, id core.NodeID) {
+	challenge := make([]byte, relayChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		fmt.Fprintf(conn, "ERR %v\n", err)
+		return
+	}
+	_ = conn.SetDeadline(time.Now().Add(relayHandshakeTimeout))
+	fmt.Fprintf(conn, "OK %d\n", len(challenge))
+	if _, err := conn.Write(challenge); err != nil {
+		return
+	}
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return
+	}
+	if !verifyRelayProof(id, challenge, strings.TrimSpace(line)) {
+		fmt.Fprintf(conn, "ERR node identity not proven\n")
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	l := &relayListener{conn: conn}
+	s.mu.Lock()
+	if s.listeners == nil {
+		s.listeners = make(map[core.NodeID]*relayListener)
+	}
+	old := s.listeners[id]
+	if old == nil && len(s.listeners) >= maxRelayListeners {
+		s.mu.Unlock()
+		fmt.Fprintf(conn, "ERR relay full\n")
+		return
+	}
+	s.listeners[id] = l
+	s.mu.Unlock()
+	if old != nil {
+		old.conn.Close()
+	}
+	defer func() {
+		s.mu.Lock()
+		if s.listeners[id] == l {
+			delete(s.listeners, id)
+		}
+		s.mu.Unlock()
+	}()
+
+	l.mu.Lock()
+	_, err = fmt.Fprintf(conn, "OK 0\n")
+	l.mu.Unlock()
+	if err != nil {
+		return
+	}
+	// 被中继节点不再发送数据，读到 EOF 或出错即视为离开
+	_, _ = io.Copy(io.Discard, br)
+}
+
+// connect 通知目标节点接受会话，配对成功后转发，直到任一方向结束
+func (s *RelayServer) connect(conn net.Conn, br *bufio.Reader, id core.NodeID) {
+	s.mu.Lock()
+	l := s.listeners[id]
+	switch {
+	case l == nil:
+		s.mu.Unlock()
+		fmt.Fprintf(conn, "ERR unknown node\n")
+		return
+	case s.MaxSessions > 0 && s.sessions >= s.MaxSessions:
+		s.mu.Unlock()
+		fmt.Fprintf(conn, "ERR relay busy\n")
+		return
+	}
+	s.sessions++
+	if s.pending == nil {
+		s.pending = make(map[string]*relayPending)
+	}
+	if s.total == nil {
+		s.total = newByteLimiter(s.TotalRate)
+	}
+	token := relayToken()
+	p := &relayPending{ch: make(chan relayHalf), gone: make(chan struct{})}
+	s.pending[token] = p
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, token)
+		s.sessions--
+		s.mu.Unlock()
+		close(p.gone)
+	}()
+
+	l.mu.Lock()
+	_ = l.conn.SetWriteDeadline(time.Now().Add(relayAcceptTimeout))
+	_, err := fmt.Fprintf(l.conn, "CONNECT %s\n", token)
+	_ = l.conn.SetWriteDeadline(time.Time{})
+	l.mu.Unlock()
+	if err != nil {
+		fmt.Fprintf(conn, "ERR target unreachable\n")
+		return
+	}
+	var peer relayHalf
+	select {
+	case peer = <-p.ch:
+	case <-time.After(relayAcceptTimeout):
+		fmt.Fprintf(conn, "ERR target not responding\n")
+		return
+	}
+	defer close(peer.done)
+	if _, err := fmt.Fprintf(peer.conn, "OK 0\n"); err != nil {
+		fmt.Fprintf(conn, "ERR target unreachable\n")
+		return
+	}
+	if _, err := fmt.Fprintf(conn, "OK 0\n"); err != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); s.forward(peer.conn, conn, br) }()
+	go func() { defer wg.Done(); s.forward(conn, peer.conn, peer.r) }()
+	wg.Wait()
+}
+
+// accept 将目标节点的连接交给等待中的会话，并保持到转发结束
+func (s *RelayServer) accept(conn net.Conn, br *bufio.Reader, token string) {
+	s.mu.Lock()
+	p := s.pending[token]
+	delete(s.pending, token)
+	s.mu.Unlock()
+	if p == nil {
+		fmt.Fprintf(conn, "ERR unknown session\n")
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case p.ch <- relayHalf{conn: conn, r: br, done: done}:
+	case <-p.gone:
+		fmt.Fprintf(conn, "ERR session expired\n")
+		return
+	}
+	<-done
+}
+
+// forward 将 src 的数据限速写入 dst；结束时半关闭 dst 的写方向，使对端读到 EOF
+func (s *RelayServer) forward(dst, src net.Conn, r io.Reader) {
+	session := newByteLimiter(s.Rate)
+	buf := make([]byte, relayBufferSize)
+	for {
+		_ = src.SetReadDeadline(time.Now().Add(relayIdleTimeout))
+		n, err := r.Read(buf)
+		if n > 0 {
+			session.wait(n)
+			s.total.wait(n)
+			_ = dst.SetWriteDeadline(time.Now().Add(relayIdleTimeout))
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	} else {
+		_ = dst.Close()
+	}
+}
+
+// relayProof 被中继节点对 LISTEN 质询的应答：<公钥hex> <签名hex>
+func relayProof(key ed25519.PrivateKey, id core.NodeID, challenge []byte) string {
+	sig := ed25519.Sign(key, relayListenMessage(id, challenge))
+	return hex.EncodeToString(key.Public().(ed25519.PublicKey)) + " " + hex.EncodeToString(sig)
+}
+
+func verifyRelayProof(id core.NodeID, challenge []byte, proof string) bool {
+	pubHex, sigHex, ok := strings.Cut(proof, " ")
+	if !ok {
+		return false
+	}
+	pub, err1 := hex.DecodeString(pubHex)
+	sig, err2 := hex.DecodeString(sigHex)
+	if err1 != nil || err2 != nil || !core.VerifyNodeKey(id, pub) || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(pub, relayListenMessage(id, challenge), sig)
+}
+
+func relayListenMessage(id core.NodeID, challenge []byte) []byte {
+	msg := append([]byte(relayListenContext), challenge...)
+	return append(msg, id...)
+}
+
+func relayToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RelayTransport 在 TCPTransport 之上使用中继：直连与其他 Traverser 都失败时，经 Relays 中的节点转发加密的传输流
+// 设置了 NodeID 与 Key 时，Serve 还会向各中继登记并接受经中继转发来的请求
+type RelayTransport struct {
+	*TCPTransport
+	Relays []string // 中继节点的传输服务地址（host:port）
+	// Key 本节点的签名私钥，NodeID 须由其公钥生成（core.KeyNodeID）；用于向中继证明身份和在握手中签名
+	Key ed25519.PrivateKey
+	// OnRelay 向中继登记成功（err 为空）或登记断开时回调（可选）
+	OnRelay func(relay string, err error)
+}
+
+// NewRelayTransport 创建中继传输，并将自身追加为 tr 的 Traverser
+func NewRelayTransport(tr *TCPTransport, relays []string) *RelayTransport {
+	r := &RelayTransport{TCPTransport: tr, Relays: relays}
+	if tr.Traverser == nil {
+		tr.Traverser = r
+	} else {
+		tr.Traverser = Traversers{tr.Traverser, r}
+	}
+	return r
+}
+
+// Serve 启动 TCP 服务并保持在各中继上的登记
+func (r *RelayTransport) Serve(ctx context.Context) error {
+	if r.NodeID != "" && r.Key != nil {
+		for _, addr := range r.Relays {
+			go r.listenRelay(ctx, addr)
+		}
+	}
+	return r.TCPTransport.Serve(ctx)
+}
+
+// Connect 依次经各中继连接节点 id，返回加密后的连接（实现 Traverser）
+func (r *RelayTransport) Connect(ctx context.Context, id core.NodeID) (net.Conn, error) {
+	if len(r.Relays) == 0 {
+		return nil, errors.New("relay: no relay configured")
+	}
+	var errs []error
+	for _, addr := range r.Relays {
+		conn, br, err := r.request(ctx, core.Node{Address: addr}, fmt.Sprintf("RELAY CONNECT %s\n", id))
+		if err == nil {
+			var sc net.Conn
+			if sc, err = handshakeWithin(ctx, conn, br, true, id, nil); err == nil {
+				return sc, nil
+			}
+			conn.Close()
+		}
+		errs = append(errs, fmt.Errorf("relay %s: %w", addr, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// listenRelay 保持在中继 addr 上的登记，断开后按指数退避重连，直到 ctx 结束
+func (r *RelayTransport) listenRelay(ctx context.Context, addr string) {
+	backoff := relayRetryMin
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := r.relaySession(ctx, addr)
+		if ctx.Err() != nil {
+			return
+		}
+		if r.OnRelay != nil {
+			r.OnRelay(addr, err)
+		}
+		if time.Since(start) > relayRetryMax {
+			backoff = relayRetryMin
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, relayRetryMax)
+	}
+}
+
+// relaySession 登记到中继并处理其下发的 CONNECT 通知，连接断开时返回
+func (r *RelayTransport) relaySession(ctx context.Context, addr string) error {
+	conn, br, err := r.request(ctx, core.Node{Address: addr}, fmt.Sprintf("RELAY LISTEN %s\n", r.NodeID))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	challenge := make([]byte, relayChallengeSize)
+	_ = conn.SetDeadline(time.Now().Add(relayHandshakeTimeout))
+	if _, err := io.ReadFull(br, challenge); err != nil {
+		return err
+	}
+	// 中继在验证通过前不会再发送数据，br 中没有未读的缓冲
+	if br, err = exchange(conn, relayProof(r.Key, r.NodeID, challenge)+"\n"); err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if r.OnRelay != nil {
+		r.OnRelay(addr, nil)
+	}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("relay closed the connection")
+			}
+			return err
+		}
+		if token, ok := strings.CutPrefix(strings.TrimSpace(line), "CONNECT "); ok && token != "" {
+			go r.acceptRelayed(ctx, addr, token)
+		}
+	}
+}
+
+// acceptRelayed 接受中继转发来的会话，握手后按普通请求处理
+func (r *RelayTransport) acceptRelayed(ctx context.Context, addr, token string) {
+	conn, br, err := r.request(ctx, core.Node{Address: addr}, fmt.Sprintf("RELAY ACCEPT %s\n", token))
+	if err != nil {
+		return
+	}
+	sc, err := handshakeWithin(ctx, conn, br, false, r.NodeID, r.Key)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	r.handle(relayedConn{Conn: sc, addr: relayAddr(token)})
+}
+
+// relayAddr 经中继转发来的会话的对端地址。中继不透露请求方的地址，以会话令牌区分对端，
+// 使按对端计的连接数、上传限速与上传槽位不会把经同一中继的全部请求方当作一个对端
+type relayAddr string
+
+func (a relayAddr) Network() string { return "relay" }
+func (a relayAddr) String() string  { return "relay-" + string(a) }
+
+// relayedConn 经中继转发来的会话，RemoteAddr 为 relayAddr 而非中继的地址
+type relayedConn struct {
+	net.Conn
+	addr relayAddr
+}
+
+func (c relayedConn) RemoteAddr() net.Addr { return c.addr }
+
+func handshakeWithin(ctx context.Context, conn net.Conn, br *bufio.Reader, initiator bool, target core.NodeID, key ed25519.PrivateKey) (net.Conn, error) {
+	deadline := time.Now().Add(relayHandshakeTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+	sc, err := secureHandshake(conn, br, initiator, target, key)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return sc, nil
+}
@@ -0,0 +1,185 @@
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/index"
+)
+
+func startRelay(t *testing.T) (*RelayServer, string) {
+	t.Helper()
+	tr := NewTCPTransport("", "")
+	tr.Relay = NewRelayServer()
+	return tr.Relay, startNode(t, tr)
+}
+
+// relayListen 以 key 的身份在中继上登记，返回登记连接
+func relayListen(t *testing.T, relay string, id core.NodeID, key ed25519.PrivateKey) (net.Conn, *bufio.Reader, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", relay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	br, err := exchange(conn, fmt.Sprintf("RELAY LISTEN %s\n", id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := make([]byte, relayChallengeSize)
+	if _, err := io.ReadFull(br, challenge); err != nil {
+		t.Fatal(err)
+	}
+	br, err = exchange(conn, relayProof(key, id, challenge)+"\n")
+	return conn, br, err
+}
+
+func TestRelayForwardsAuthenticatedSession(t *testing.T) {
+	rs, relay := startRelay(t)
+	id, key := nodeKey(t, "target")
+	store := index.NewMemoryStore()
+	_, fi, _ := shareFile(t, store, []byte("relayed file content"), 8)
+	target := NewTCPTransport("", "")
+	target.Store, target.NodeID = store, id
+	rt := &RelayTransport{TCPTransport: target, Relays: []string{relay}, Key: key}
+	registered := make(chan error, 1)
+	rt.OnRelay = func(_ string, err error) { registered <- err }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rt.listenRelay(ctx, relay)
+	if err := <-registered; err != nil {
+		t.Fatal(err)
+	}
+
+	req := NewTCPTransport("", "")
+	NewRelayTransport(req, []string{relay})
+	got, chunks, err := req.FetchManifest(ctx, core.Node{ID: id, Address: "127.0.0.1:1"}, fi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != fi.ID || len(chunks) != 3 {
+		t.Fatalf("manifest through relay = %+v, %d chunks", got, len(chunks))
+	}
+	waitFor(t, func() bool { return rs.Sessions() == 0 })
+}
+
+func TestRelayListenRequiresProof(t *testing.T) {
+	rs, relay := startRelay(t)
+	victim, key := nodeKey(t, "victim")
+	_, other := nodeKey(t, "victim")
+
+	first, br, err := relayListen(t, relay, victim, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 其他密钥无法登记（冒充）同一节点ID，原登记不受影响
+	if _, _, err := relayListen(t, relay, victim, other); err == nil || !strings.Contains(err.Error(), "not proven") {
+		t.Fatalf("squatting err = %v, want rejection", err)
+	}
+	rs.mu.Lock()
+	l := rs.listeners[victim]
+	rs.mu.Unlock()
+	if l == nil || l.conn.RemoteAddr().String() != first.LocalAddr().String() {
+		t.Fatal("original registration replaced by an unproven one")
+	}
+
+	// 持有密钥的节点重新登记时取代旧连接
+	if _, _, err := relayListen(t, relay, victim, key); err != nil {
+		t.Fatal(err)
+	}
+	_ = first.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := br.ReadString('\n'); !errors.Is(err, io.EOF) {
+		t.Fatalf("old registration read err = %v, want EOF", err)
+	}
+}
+
+func TestRelayPendingSessionTimesOut(t *testing.T) {
+	old := relayAcceptTimeout
+	relayAcceptTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { relayAcceptTimeout = old })
+	rs, relay := startRelay(t)
+	id, key := nodeKey(t, "target")
+	_, lbr, err := relayListen(t, relay, id, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", relay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := exchange(conn, fmt.Sprintf("RELAY CONNECT %s\n", id))
+		errCh <- err
+	}()
+	line, err := lbr.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, ok := strings.CutPrefix(strings.TrimSpace(line), "CONNECT ")
+	if !ok {
+		t.Fatalf("listener got %q", line)
+	}
+	// 目标节点不接受：请求方超时后会话被清理，迟到的 ACCEPT 被拒绝
+	if err := <-errCh; err == nil || !strings.Contains(err.Error(), "not responding") {
+		t.Fatalf("connect err = %v, want timeout", err)
+	}
+	waitFor(t, func() bool {
+		rs.mu.Lock()
+		defer rs.mu.Unlock()
+		return len(rs.pending) == 0 && rs.sessions == 0
+	})
+	late, err := net.Dial("tcp", relay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer late.Close()
+	if _, err := exchange(late, fmt.Sprintf("RELAY ACCEPT %s\n", token)); err == nil || !strings.Contains(err.Error(), "unknown session") {
+		t.Fatalf("late accept err = %v, want unknown session", err)
+	}
+}
+
+func TestRelayedSessionsAreSeparatePeers(t *testing.T) {
+	_, relay := startRelay(t)
+	id, key := nodeKey(t, "target")
+	target := NewTCPTransport("", "")
+	target.NodeID = id
+	target.SetLimits(ServerLimits{MaxPeerConns: 1})
+	rt := &RelayTransport{TCPTransport: target, Relays: []string{relay}, Key: key}
+	registered := make(chan error, 1)
+	rt.OnRelay = func(_ string, err error) { registered <- err }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rt.listenRelay(ctx, relay)
+	if err := <-registered; err != nil {
+		t.Fatal(err)
+	}
+
+	// 两个会话同时保持：若都以中继的地址计为同一对端，第二个会超出每对端 1 个连接的上限
+	req := &RelayTransport{TCPTransport: NewTCPTransport("", ""), Relays: []string{relay}}
+	var conns []net.Conn
+	for range 2 {
+		c, err := req.Connect(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		conns = append(conns, c)
+	}
+	for i, c := range conns {
+		if _, err := exchange(c, "HELLO\n"); err != nil {
+			t.Fatalf("session %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package transfer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+// 经中继转发的流在两端之间加密，中继只能看到密文：
+// - 握手：双方各发送 "RGE2" + 32 字节 X25519 临时公钥；被连接方随后发送 32 字节 ed25519 公钥 + 64 字节签名，
+//   签名覆盖双方临时公钥与目标节点ID。发起方验证该公钥与目标节点ID绑定（core.VerifyNodeKey）且签名有效，
+//   中继因此无法冒充目标节点或替换临时公钥（中间人）
+// - 以 ECDH 共享密钥、双方临时公钥与目标节点ID派生两个方向的 AES-256-GCM 密钥
+// - 记录：<len:4> <密文>，nonce 为 8 字节递增序号（大端）+ 4 字节 0，每个方向独立
+// 发起方不证明身份（节点向任何请求方提供分片）；分片数据仍按索引中的哈希校验
+
+const (
+	secureMagic      = "RGE2"
+	maxSecureRecord  = 16 << 10
+	secureKeyContext = "ripplego relay v2"
+	secureAuthSize   = ed25519.PublicKeySize + ed25519.SignatureSize
+)
+
+type secureConn struct {
+	net.Conn
+	r io.Reader // 握手前可能已缓冲数据的读取端
+
+	rmu  sync.Mutex
+	rd   cipher.AEAD
+	rseq uint64
+	rbuf []byte
+
+	wmu  sync.Mutex
+	wr   cipher.AEAD
+	wseq uint64
+}
+
+// secureHandshake 在 conn 上完成密钥交换，initiator 为发起连接的一方，target 为被连接节点的ID；
+// 被连接方以 key（其公钥须与 target 绑定）签名证明身份
+func secureHandshake(conn net.Conn, r io.Reader, initiator bool, target core.NodeID, key ed25519.PrivateKey) (net.Conn, error) {
+	if !initiator && (key == nil || !core.VerifyNodeKey(target, key.Public().(ed25519.PublicKey))) {
+		return nil, errors.New("secure handshake: node key does not match node ID")
+	}
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	hello := append([]byte(secureMagic), priv.PublicKey().Bytes()...)
+	errCh := make(chan error, 1)
+	go func() { _, err := conn.Write(hello); errCh <- err }()
+	peerHello := make([]byte, len(hello))
+	if _, err := io.ReadFull(r, peerHello); err != nil {
+		return nil, fmt.Errorf("secure handshake: %w", err)
+	}
+	if err := <-errCh; err != nil {
+		return nil, fmt.Errorf("secure handshake: %w", err)
+	}
+	if string(peerHello[:len(secureMagic)]) != secureMagic {
+		return nil, errors.New("secure handshake: bad magic")
+	}
+	peerPub, err := ecdh.X25519().NewPublicKey(peerHello[len(secureMagic):])
+	if err != nil {
+		return nil, fmt.Errorf("secure handshake: %w", err)
+	}
+	secret, err := priv.ECDH(peerPub)
+	if err != nil {
+		return nil, fmt.Errorf("secure handshake: %w", err)
+	}
+
+	initPub, respPub := hello[len(secureMagic):], peerHello[len(secureMagic):]
+	if !initiator {
+		initPub, respPub = respPub, initPub
+	}
+	transcript := secureTranscript(initPub, respPub, target)
+	if initiator {
+		auth := make([]byte, secureAuthSize)
+		if _, err := io.ReadFull(r, auth); err != nil {
+			return nil, fmt.Errorf("secure handshake: %w", err)
+		}
+		pub := ed25519.PublicKey(auth[:ed25519.PublicKeySize])
+		if !core.VerifyNodeKey(target, pub) || !ed25519.Verify(pub, transcript, auth[ed25519.PublicKeySize:]) {
+			return nil, fmt.Errorf("secure handshake: peer failed to prove identity %s", target)
+		}
+	} else {
+		auth := append([]byte(key.Public().(ed25519.PublicKey)), ed25519.Sign(key, transcript)...)
+		if _, err := conn.Write(auth); err != nil {
+			return nil, fmt.Errorf("secure handshake: %w", err)
+		}
+	}
+	derive := func(dir string) (cipher.AEAD, error) {
+		h := sha256.New()
+		h.Write(secret)
+		h.Write(transcript)
+		h.Write([]byte(dir))
+		block, err := aes.NewCipher(h.Sum(nil))
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	}
+	c2s, err := derive("c2s")
+	if err != nil {
+		return nil, err
+	}
+	s2c, err := derive("s2c")
+	if err != nil {
+		return nil, err
+	}
+	sc := &secureConn{Conn: conn, r: r, rd: s2c, wr: c2s}
+	if !initiator {
+		sc.rd, sc.wr = c2s, s2c
+	}
+	return sc, nil
+}
+
+func (c *secureConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for len(c.rbuf) == 0 {
+		var hdr [4]byte
+		if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+			return 0, err
+		}
+		n := binary.BigEndian.Uint32(hdr[:])
+		if n < uint32(c.rd.Overhead()) || n > maxSecureRecord+uint32(c.rd.Overhead()) {
+			return 0, errors.New("secure: invalid record length")
+		}
+		ct := make([]byte, n)
+		if _, err := io.ReadFull(c.r, ct); err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		pt, err := c.rd.Open(ct[:0], secureNonce(c.rseq), ct, nil)
+		if err != nil {
+			return 0, errors.New("secure: record authentication failed")
+		}
+		c.rseq++
+		c.rbuf = pt
+	}
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+func (c *secureConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), maxSecureRecord)
+		rec := make([]byte, 4, 4+n+c.wr.Overhead())
+		rec = c.wr.Seal(rec, secureNonce(c.wseq), p[:n], nil)
+		binary.BigEndian.PutUint32(rec, uint32(len(rec)-4))
+		if _, err := c.Conn.Write(rec); err != nil {
+			return written, err
+		}
+		c.wseq++
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// secureTranscript 被连接方签名、并参与密钥派生的握手内容
+func secureTranscript(initPub, respPub []byte, target core.NodeID) []byte {
+	msg := append([]byte(secureKeyContext), initPub...)
+	msg = append(msg, respPub...)
+	return append(msg, target...)
+}
+
+func secureNonce(seq uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, seq)
+	return nonce
+}
@@ -0,0 +1,187 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ripplego/ripplego/internal/core"
+)
+
+func nodeKey(t *testing.T, name string) (core.NodeID, ed25519.PrivateKey) {
+	t.Helper()
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return core.KeyNodeID(name, pub), key
+}
+
+// securePair 在 net.Pipe 上完成握手，返回发起方与被连接方的加密连接
+func securePair(t *testing.T) (init, resp *secureConn) {
+	t.Helper()
+	id, key := nodeKey(t, "target")
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		c, err := secureHandshake(b, b, false, id, key)
+		ch <- result{c, err}
+	}()
+	ic, err := secureHandshake(a, a, true, id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-ch
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	return ic.(*secureConn), r.c.(*secureConn)
+}
+
+// readRecords 从 r 读取 n 条原始记录（不含长度头）
+func readRecords(t *testing.T, r io.Reader, n int) [][]byte {
+	t.Helper()
+	var recs [][]byte
+	for range n {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			t.Fatal(err)
+		}
+		rec := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+		if _, err := io.ReadFull(r, rec); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func frame(recs ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, rec := range recs {
+		binary.Write(&buf, binary.BigEndian, uint32(len(rec)))
+		buf.Write(rec)
+	}
+	return buf.Bytes()
+}
+
+func TestSecureConnRecordFramingAndNonces(t *testing.T) {
+	init, resp := securePair(t)
+	payload := bytes.Repeat([]byte("0123456789abcdef"), (2*maxSecureRecord+100)/16)
+	done := make(chan struct{})
+	go func() { init.Write(payload); close(done) }()
+
+	// 超过单条记录上限的数据按 maxSecureRecord 切分，nonce 为递增序号
+	recs := readRecords(t, resp.r, 3)
+	wantSizes := []int{maxSecureRecord, maxSecureRecord, len(payload) - 2*maxSecureRecord}
+	var got []byte
+	for i, rec := range recs {
+		if len(rec) != wantSizes[i]+resp.rd.Overhead() {
+			t.Fatalf("record %d is %d bytes, want %d", i, len(rec), wantSizes[i]+resp.rd.Overhead())
+		}
+		if i > 0 {
+			if _, err := resp.rd.Open(nil, secureNonce(uint64(i-1)), rec, nil); err == nil {
+				t.Fatalf("record %d opened with the nonce of record %d", i, i-1)
+			}
+		}
+		pt, err := resp.rd.Open(nil, secureNonce(uint64(i)), rec, nil)
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		got = append(got, pt...)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatal("decrypted records do not match the payload")
+	}
+	<-done
+	if init.wseq != 3 {
+		t.Errorf("write sequence = %d, want 3", init.wseq)
+	}
+}
+
+func TestSecureConnRoundTrip(t *testing.T) {
+	init, resp := securePair(t)
+	go func() {
+		init.Write([]byte("GET f 0\n"))
+		buf := make([]byte, 5)
+		io.ReadFull(init, buf)
+		init.Write(buf)
+	}()
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(resp, buf); err != nil || string(buf) != "GET f 0\n" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+	if _, err := resp.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf = make([]byte, 5)
+	if _, err := io.ReadFull(resp, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("echo %q, %v", buf, err)
+	}
+}
+
+func TestSecureConnRejectsTamperedRecords(t *testing.T) {
+	init, resp := securePair(t)
+	go init.Write(bytes.Repeat([]byte{7}, maxSecureRecord+10))
+	recs := readRecords(t, resp.r, 2)
+
+	flipped := bytes.Clone(recs[0])
+	flipped[len(flipped)/2] ^= 1
+	short := make([]byte, 4)
+	binary.BigEndian.PutUint32(short, maxSecureRecord+uint32(resp.rd.Overhead())+1)
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"flipped bit", frame(flipped), "authentication failed"},
+		{"reordered", frame(recs[1], recs[0]), "authentication failed"},
+		{"replayed", frame(recs[0], recs[0]), "authentication failed"},
+		{"oversized length", short, "invalid record length"},
+		{"truncated", frame(recs[0])[:100], io.ErrUnexpectedEOF.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &secureConn{r: bytes.NewReader(tt.data), rd: resp.rd}
+			_, err := io.ReadAll(c)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecureHandshakeRejectsImpostor(t *testing.T) {
+	victim, _ := nodeKey(t, "victim")
+	impostor, key := nodeKey(t, "victim")
+
+	// 冒充者无法以自己的密钥作为 victim 签名
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if _, err := secureHandshake(b, b, false, victim, key); err == nil {
+		t.Fatal("responder accepted a key that does not match the node ID")
+	}
+
+	// 中间人以自己的身份完成握手，发起方按目标 ID 验证时拒绝
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := secureHandshake(b, b, false, impostor, key)
+		errCh <- err
+	}()
+	_, err := secureHandshake(a, a, true, victim, nil)
+	if err == nil || !strings.Contains(err.Error(), "prove identity") {
+		t.Fatalf("initiator err = %v, want identity failure", err)
+	}
+	b.Close()
+	<-errCh
+}
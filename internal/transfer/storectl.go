@@ -0,0 +1,231 @@
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/index"
+)
+
+// 索引存储控制（STORE）
+// 运行中的节点独占其索引目录（Badger 目录锁），同一目录上的 share、files、unshare、manifest、get 等命令
+// 经本机的 STORE 命令读写节点的索引；只接受经节点 TCP 监听器从本机回环地址连入的请求
+// 协议：STORE <op> <len>\n 后为 len 字节的 JSON 参数（storeMsg），应答 OK <len>\n 后为 JSON 结果；或 ERR <msg>\n
+
+const (
+	maxStoreMsg         = 64 << 20 // 单个请求或应答的大小上限（大文件的分片列表）
+	storeControlTimeout = 30 * time.Second
+)
+
+// storeMsg STORE 命令的参数与结果，按操作使用其中的字段
+type storeMsg struct {
+	ID         string            `json:"id,omitempty"`
+	File       core.FileInfo     `json:"file"`
+	Files      []core.FileInfo   `json:"files,omitempty"`
+	Chunks     []core.ChunkInfo  `json:"chunks,omitempty"`
+	NodeChunks core.NodeChunkMap `json:"nodeChunks"`
+	Peer       core.PeerInfo     `json:"peer"`
+	Peers      []core.PeerInfo   `json:"peers,omitempty"`
+}
+
+// handleStore 在本节点的索引存储上执行一个操作，只接受本机控制连接（local，见 serve）的请求
+func (t *TCPTransport) handleStore(conn net.Conn, br *bufio.Reader, op, sizeStr string, local bool) {
+	if !local {
+		fmt.Fprintf(conn, "ERR store control only allowed from localhost\n")
+		return
+	}
+	if t.Store == nil {
+		fmt.Fprintf(conn, "ERR index store unavailable\n")
+		return
+	}
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil || size < 0 || size > maxStoreMsg {
+		fmt.Fprintf(conn, "ERR %v: invalid store request size %q\n", ErrBadRequest, sizeStr)
+		return
+	}
+	var req storeMsg
+	if err := json.NewDecoder(io.LimitReader(br, size)).Decode(&req); err != nil {
+		fmt.Fprintf(conn, "ERR %v: %v\n", ErrBadRequest, err)
+		return
+	}
+	resp, err := t.storeOp(op, req)
+	if err != nil {
+		fmt.Fprintf(conn, "ERR %v\n", err)
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		fmt.Fprintf(conn, "ERR %v\n", err)
+		return
+	}
+	fmt.Fprintf(conn, "OK %d\n", len(data))
+	_, _ = conn.Write(data)
+}
+
+func (t *TCPTransport) storeOp(op string, req storeMsg) (resp storeMsg, err error) {
+	s := t.Store
+	ps, _ := s.(index.PeerStore)
+	id := req.ID
+	switch op {
+	case "ping":
+	case "file.save":
+		err = s.SaveFile(req.File)
+	case "file.get":
+		resp.File, err = s.GetFile(core.FileID(id))
+	case "file.list":
+		resp.Files = s.ListFiles()
+	case "file.delete":
+		err = s.DeleteFile(core.FileID(id))
+	case "file.remove":
+		err = index.RemoveFile(s, core.FileID(id))
+	case "chunks.save":
+		err = s.SaveChunks(core.FileID(id), req.Chunks)
+	case "chunks.get":
+		resp.Chunks, err = s.GetChunks(core.FileID(id))
+	case "chunks.delete":
+		err = s.DeleteChunks(core.FileID(id))
+	case "nodechunks.save":
+		err = s.SaveNodeChunks(req.NodeChunks)
+	case "nodechunks.get":
+		resp.NodeChunks, err = s.GetNodeChunks(core.NodeID(id))
+	case "nodechunks.delete":
+		err = s.DeleteNodeChunks(core.NodeID(id))
+	case "peer.save", "peer.get", "peer.list", "peer.delete":
+		if ps == nil {
+			return resp, errors.New("peer cache unavailable")
+		}
+		switch op {
+		case "peer.save":
+			err = ps.SavePeer(req.Peer)
+		case "peer.get":
+			resp.Peer, err = ps.GetPeer(core.NodeID(id))
+		case "peer.list":
+			resp.Peers = ps.ListPeers()
+		default:
+			err = ps.DeletePeer(core.NodeID(id))
+		}
+	default:
+		return resp, fmt.Errorf("%w: unknown store operation %q", ErrBadRequest, op)
+	}
+	return resp, err
+}
+
+// RemoteStore 经本机运行中节点的 STORE 命令访问其索引存储（实现 index.IndexStore 与 index.PeerStore），
+// 用于索引目录被该节点占用时的命令行操作
+type RemoteStore struct {
+	Node string // 本机节点的传输服务地址
+}
+
+func NewRemoteStore(node string) *RemoteStore { return &RemoteStore{Node: node} }
+
+func (s *RemoteStore) call(op string, req storeMsg) (storeMsg, error) {
+	var resp storeMsg
+	data, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeControlTimeout)
+	defer cancel()
+	conn, err := dialNode(ctx, core.Node{Address: s.Node})
+	if err != nil {
+		return resp, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(storeControlTimeout))
+	br, err := exchange(conn, fmt.Sprintf("STORE %s %d\n%s", op, len(data), data))
+	if err != nil {
+		return resp, err
+	}
+	err = json.NewDecoder(io.LimitReader(br, maxStoreMsg)).Decode(&resp)
+	return resp, err
+}
+
+// Ping 检查节点可达且提供索引存储
+func (s *RemoteStore) Ping() error {
+	_, err := s.call("ping", storeMsg{})
+	return err
+}
+
+func (s *RemoteStore) SaveFile(info core.FileInfo) error {
+	_, err := s.call("file.save", storeMsg{File: info})
+	return err
+}
+
+func (s *RemoteStore) GetFile(id core.FileID) (core.FileInfo, error) {
+	resp, err := s.call("file.get", storeMsg{ID: string(id)})
+	return resp.File, err
+}
+
+func (s *RemoteStore) ListFiles() []core.FileInfo {
+	resp, _ := s.call("file.list", storeMsg{})
+	return resp.Files
+}
+
+func (s *RemoteStore) DeleteFile(id core.FileID) error {
+	_, err := s.call("file.delete", storeMsg{ID: string(id)})
+	return err
+}
+
+// RemoveFile 在节点上原子删除文件及其分片（见 index.RemoveFile）
+func (s *RemoteStore) RemoveFile(id core.FileID) error {
+	_, err := s.call("file.remove", storeMsg{ID: string(id)})
+	return err
+}
+
+func (s *RemoteStore) SaveChunks(fileID core.FileID, chunks []core.ChunkInfo) error {
+	_, err := s.call("chunks.save", storeMsg{ID: string(fileID), Chunks: chunks})
+	return err
+}
+
+func (s *RemoteStore) GetChunks(fileID core.FileID) ([]core.ChunkInfo, error) {
+	resp, err := s.call("chunks.get", storeMsg{ID: string(fileID)})
+	return resp.Chunks, err
+}
+
+func (s *RemoteStore) DeleteChunks(fileID core.FileID) error {
+	_, err := s.call("chunks.delete", storeMsg{ID: string(fileID)})
+	return err
+}
+
+func (s *RemoteStore) SaveNodeChunks(m core.NodeChunkMap) error {
+	_, err := s.call("nodechunks.save", storeMsg{NodeChunks: m})
+	return err
+}
+
+func (s *RemoteStore) GetNodeChunks(nodeID core.NodeID) (core.NodeChunkMap, error) {
+	resp, err := s.call("nodechunks.get", storeMsg{ID: string(nodeID)})
+	return resp.NodeChunks, err
+}
+
+func (s *RemoteStore) DeleteNodeChunks(nodeID core.NodeID) error {
+	_, err := s.call("nodechunks.delete", storeMsg{ID: string(nodeID)})
+	return err
+}
+
+func (s *RemoteStore) SavePeer(p core.PeerInfo) error {
+	_, err := s.call("peer.save", storeMsg{Peer: p})
+	return err
+}
+
+func (s *RemoteStore) GetPeer(id core.NodeID) (core.PeerInfo, error) {
+	resp, err := s.call("peer.get", storeMsg{ID: string(id)})
+	return resp.Peer, err
+}
+
+func (s *RemoteStore) ListPeers() []core.PeerInfo {
+	resp, _ := s.call("peer.list", storeMsg{})
+	return resp.Peers
+}
+
+func (s *RemoteStore) DeletePeer(id core.NodeID) error {
+	_, err := s.call("peer.delete", storeMsg{ID: string(id)})
+	return err
+}
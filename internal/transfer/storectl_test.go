@@ -0,0 +1,81 @@
+package transfer
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/index"
+)
+
+// startNode 在回环地址上启动传输服务，返回其地址
+func startNode(t *testing.T, tr *TCPTransport) string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go tr.ServeListener(ctx, ln)
+	t.Cleanup(func() { cancel(); ln.Close() })
+	return ln.Addr().String()
+}
+
+func TestRemoteStoreThroughNode(t *testing.T) {
+	local := index.NewMemoryStore()
+	tr := NewTCPTransport("", "")
+	tr.Store = local
+	rs := NewRemoteStore(startNode(t, tr))
+	if err := rs.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	fi := core.FileInfo{ID: "f1", Name: "a.bin", Size: 8, ChunkSize: 4, ChunkCount: 2, HashAlgo: core.HashBLAKE3}
+	chunks := []core.ChunkInfo{{ID: "c0", FileID: "f1", Size: 4}, {ID: "c1", FileID: "f1", Index: 1, Offset: 4, Size: 4}}
+	if err := rs.SaveFile(fi); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.SaveChunks(fi.ID, chunks); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.SaveNodeChunks(core.NodeChunkMap{NodeID: "n1", ChunkIDs: []core.ChunkID{"c0", "other"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := local.GetFile("f1"); err != nil || got.Name != "a.bin" || got.HashAlgo != core.HashBLAKE3 {
+		t.Fatalf("node store has %+v, %v", got, err)
+	}
+	if got, err := rs.GetChunks("f1"); err != nil || len(got) != 2 || got[1].Offset != 4 {
+		t.Fatalf("GetChunks = %+v, %v", got, err)
+	}
+	if files := rs.ListFiles(); len(files) != 1 || files[0].ID != "f1" {
+		t.Fatalf("ListFiles = %+v", files)
+	}
+	if _, err := rs.GetFile("missing"); err == nil {
+		t.Error("GetFile of a missing file: want error")
+	}
+
+	if err := index.RemoveFile(rs, "f1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.GetFile("f1"); err == nil {
+		t.Error("file left on node after RemoveFile")
+	}
+	if m, _ := local.GetNodeChunks("n1"); len(m.ChunkIDs) != 1 || m.ChunkIDs[0] != "other" {
+		t.Errorf("node chunks after RemoveFile = %+v", m)
+	}
+
+	if err := index.RecordPeerSeen(rs, core.Node{ID: "p1", Address: "10.0.0.1:9001"}); err != nil {
+		t.Fatal(err)
+	}
+	if ps := rs.ListPeers(); len(ps) != 1 || ps[0].Addrs[0] != "10.0.0.1:9001" {
+		t.Errorf("ListPeers = %+v", ps)
+	}
+}
+
+func TestStoreControlRequiresStore(t *testing.T) {
+	rs := NewRemoteStore(startNode(t, NewTCPTransport("", "")))
+	if err := rs.Ping(); err == nil {
+		t.Error("ping to a node without an index store: want error")
+	}
+}
@@ -3,101 +3,433 @@ package transfer
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
-	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/index"
 )
 
 // TCPTransport 提供TCP服务端与客户端下载端
 // 协议：
 // - 客户端 -> 服务端：GET <fileID> <offset> <size>\n
 // - 服务端 -> 客户端：OK <size>\n 后续流式发送字节；或 ERR <msg>\n
+// - 客户端 -> 服务端：META <fileID>\n
+// - 服务端 -> 客户端：OK <len>\n 后续为 len 字节的 JSON 索引 {"file":FileInfo,"chunks":[]ChunkInfo}（不含本地路径）；或 ERR <msg>\n
+// - 客户端 -> 服务端：HELLO\n
+// - 服务端 -> 客户端：OK <len>\n 后续为 len 字节的 JSON 节点信息 {"id","name","addrs"}
+// - PEX 节点交换见 pex.go
+// - RELAY 中继转发见 relay.go
+// - RATE 限速查询与修改见 ratelimit.go
+// - RECV 本机下载端报告的回报见 choke.go
+// - STORE 本机命令行访问索引存储见 storectl.go
 // 简化：不做TLS与鉴权
 
 type TCPTransport struct {
-	Addr     string // 监听地址，示例 ":9001"
+	Addr     string // 监听地址，示例 ":9001"（双栈）；可用逗号分隔多个，如 "0.0.0.0:9001,[::]:9001"
 	RootDir  string // 文件根目录（用于根据 FileInfo.Path 读取文件）
+	Store    index.IndexStore // 索引存储；设置后按 FileID 反查路径并在服务前校验文件
+	NodeID   core.NodeID // 本节点ID，HELLO 应答使用
+	Name     string      // 本节点名称，HELLO 应答使用
+	Advertise []string   // HELLO 应答中额外宣告的服务地址
+	PEX      PeerExchange // 节点交换来源；设置后启用 PEX 命令（见 pex.go）
+	Traverser Traverser   // 直连失败时按节点ID建立连接（可选，如 UDP 打洞、中继）
+	Relay    *RelayServer // 为其他节点提供中继；设置后启用 RELAY 命令（见 relay.go）
+	PortMap  PortMapper   // 为首个监听端口建立网关端口映射（可选），Serve 返回前移除
+	Limiter  *RateLimiter // 上传限速（可选）；设置后启用本机的 RATE 命令（见 ratelimit.go）
+	Limits   ServerLimits // 连接数与超时限制（见 limits.go），运行中用 SetLimits 修改
+	Scheduler *UploadScheduler // 上传槽位调度（可选，见 choke.go），未获得槽位的对端的 GET 应答 ERR choked
+	CacheSize int64        // 热点分片缓存的字节数上限（见 filecache.go），0 表示不缓存
+	Compress bool          // 分片压缩（见 compress.go）：下载时在 GET 中提供 zstd，服务时按请求压缩应答
+	noCompress skipList    // 不识别压缩参数的节点
+	pexLimit pexLimiter
+	conns    connLimiter
+	files    fileCache
+	chunks   chunkCache
 	mu       sync.Mutex
-	ln       net.Listener
+	lns      []net.Listener
+	noDirect map[core.NodeID]time.Time // 直连失败的节点，到期前直接经 Traverser 连接
+	reindexing map[core.FileID]bool    // 正在后台重建索引的文件
 }
 
 func NewTCPTransport(addr, root string) *TCPTransport {
-	return &TCPTransport{Addr: addr, RootDir: root}
+	return &TCPTransport{Addr: addr, RootDir: root, Limits: DefaultServerLimits(), CacheSize: DefaultChunkCacheSize}
 }
 
 func (t *TCPTransport) Serve(ctx context.Context) error {
-	ln, err := net.Listen("tcp", t.Addr)
-	if err != nil { return err }
-	t.mu.Lock(); t.ln = ln; t.mu.Unlock()
-	go func(){ <-ctx.Done(); ln.Close() }()
+	var lns []net.Listener
+	for _, addr := range strings.Split(t.Addr, ",") {
+		ln, err := listenTCP(strings.TrimSpace(addr))
+		if err != nil {
+			for _, l := range lns { l.Close() }
+			return err
+		}
+		lns = append(lns, ln)
+	}
+	t.mu.Lock(); t.lns = lns; t.mu.Unlock()
+	go func(){ <-ctx.Done(); for _, l := range lns { l.Close() } }()
+
+	if t.PortMap != nil {
+		if ta, ok := lns[0].Addr().(*net.TCPAddr); ok {
+			mctx, cancel := context.WithCancel(ctx)
+			done := make(chan struct{})
+			go func() { defer close(done); t.PortMap.Run(mctx, ta.Port) }()
+			defer func() { cancel(); <-done }()
+		}
+	}
+
+	errCh := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func(ln net.Listener) { errCh <- t.ServeListener(ctx, ln) }(ln)
+	}
+	err := <-errCh
+	for _, l := range lns { l.Close() }
+	t.files.closeAll()
+	return err
+}
+
+// SetAdvertise 更新 HELLO 应答中宣告的服务地址（可在服务运行中调用，如端口映射建立后）
+func (t *TCPTransport) SetAdvertise(addrs []string) {
+	t.mu.Lock(); t.Advertise = slices.Clone(addrs); t.mu.Unlock()
+}
+
+// ServeListener 在已有的监听器上提供同样的服务（如打洞得到的 UDP 流），直到 ctx 结束或 ln 被关闭
+func (t *TCPTransport) ServeListener(ctx context.Context, ln net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+	// 只有 TCP 监听器上来自回环地址的连接可执行本机控制命令；UDP 流的对端地址不足以证明来自本机
+	_, tcp := ln.(*net.TCPListener)
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			select { case <-ctx.Done(): return nil; default: }
+			if ctx.Err() != nil { return nil }
 			return err
 		}
-		go t.handle(conn)
+		// 超出连接数限制的连接在此直接拒绝，不为其启动协程
+		release, ok := t.admit(conn)
+		if !ok { continue }
+		go func() { defer release(); t.serve(conn, tcp && isLoopback(conn.RemoteAddr())) }()
+	}
+}
+
+// listenTCP 按地址选择协议族：IPv4/IPv6 字面量只监听对应协议族；
+// 主机名或未指定主机（":9001"）时双栈监听，主机未启用 IPv6 时退回 IPv4
+func listenTCP(addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil { return nil, err }
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil { return net.Listen("tcp4", addr) }
+		return net.Listen("tcp6", addr)
 	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil && host == "" { return net.Listen("tcp4", addr) }
+	return ln, err
 }
 
+// handle 处理一个连接上的请求（QUIC 流、中继会话等由各传输直接调用）
 func (t *TCPTransport) handle(conn net.Conn) {
+	release, ok := t.admit(conn)
+	if !ok { return }
+	defer release()
+	t.serve(conn, false)
+}
+
+// serve 读取请求行并分派，连接名额已由调用方占用；
+// local 表示连接经本节点的 TCP 监听器从本机回环地址连入，可执行 RATE、RECV、STORE 等本机控制命令
+func (t *TCPTransport) serve(conn net.Conn, local bool) {
 	defer conn.Close()
+	lim := t.limits()
+	if lim.RequestTimeout > 0 { _ = conn.SetReadDeadline(time.Now().Add(lim.RequestTimeout)) }
 	br := bufio.NewReader(conn)
 	line, err := br.ReadString('\n')
 	if err != nil { return }
+	_ = conn.SetReadDeadline(time.Time{})
+	if lim.IdleTimeout > 0 { _ = conn.SetWriteDeadline(time.Now().Add(lim.IdleTimeout)) }
 	line = strings.TrimSpace(line)
 	parts := strings.Split(line, " ")
-	if len(parts) != 4 || parts[0] != "GET" {
-		fmt.Fprintf(conn, "ERR invalid request\n"); return
+	switch {
+	case (len(parts) == 4 || len(parts) == 5) && parts[0] == "GET":
+		t.handleGet(conn, parts)
+	case len(parts) == 2 && parts[0] == "META":
+		t.handleMeta(conn, core.FileID(parts[1]))
+	case len(parts) == 1 && parts[0] == "HELLO":
+		t.handleHello(conn)
+	case len(parts) == 4 && parts[0] == "PEX":
+		t.handlePEX(conn, parts)
+	case len(parts) == 3 && parts[0] == "RELAY":
+		_ = conn.SetDeadline(time.Time{}) // 中继连接长期保持，由中继自行设置超时
+		t.handleRelay(conn, br, parts)
+	case len(parts) == 3 && parts[0] == "STORE":
+		t.handleStore(conn, br, parts[1], parts[2], local)
+	case len(parts) == 2 && parts[0] == "RECV":
+		t.handleReceived(conn, parts[1], local)
+	case parts[0] == "RATE":
+		t.handleRate(conn, strings.TrimSpace(strings.TrimPrefix(line, "RATE")), local)
+	default:
+		fmt.Fprintf(conn, "ERR invalid request\n")
 	}
-	fileID := parts[1]
-	offset, _ := strconv.ParseInt(parts[2], 10, 64)
-	size, _ := strconv.ParseInt(parts[3], 10, 64)
+}
 
-	// 这里简单根据 fileID 查找本地路径：假定 fileID 为sha256(filePath:size)
-	// demo实现：要求客户端提供完整绝对路径作为fileID（或外层维护映射）。
-	// 生产逻辑应通过索引存储反查路径，此处简化。
-	path := fileID
-	if !filepath.IsAbs(path) {
-		path = filepath.Join(t.RootDir, path)
-	}
-	f, err := os.Open(path)
+func (t *TCPTransport) handleGet(conn net.Conn, parts []string) {
+	fileID := core.FileID(parts[1])
+	offset, size, err := parseRange(parts[2], parts[3])
 	if err != nil { fmt.Fprintf(conn, "ERR %v\n", err); return }
-	defer f.Close()
-	if _, err := f.Seek(offset, io.SeekStart); err != nil { fmt.Fprintf(conn, "ERR %v\n", err); return }
+
+	path, err := t.resolve(fileID)
+	if err != nil { fmt.Fprintf(conn, "ERR %v\n", err); return }
+	if err := t.checkRange(fileID, path, offset, size); err != nil { fmt.Fprintf(conn, "ERR %v\n", err); return }
+	peer := peerHost(conn.RemoteAddr())
+	if !t.Scheduler.Allow(peer) { fmt.Fprintf(conn, "ERR %v: no upload slot\n", ErrChoked); return }
+	f, err := t.files.open(path)
+	if err != nil { fmt.Fprintf(conn, "ERR %v\n", err); return }
+	defer t.files.put(f)
 
 	fmt.Fprintf(conn, "OK %d\n", size)
-	if _, err := io.CopyN(conn, f, size); err != nil {
-		return
+	var n int64
+	if len(parts) == 5 {
+		n, _ = t.sendFramed(conn, peer, f, offset, size, parts[4])
+	} else {
+		n, _ = t.sendChunk(conn, peer, f, offset, size)
+	}
+	t.Scheduler.Uploaded(peer, n)
+}
+
+func (t *TCPTransport) handleMeta(conn net.Conn, fileID core.FileID) {
+	if t.Store == nil { fmt.Fprintf(conn, "ERR metadata unavailable\n"); return }
+	if _, err := t.resolve(fileID); err != nil { fmt.Fprintf(conn, "ERR %v\n", err); return }
+	fi, err := t.Store.GetFile(fileID)
+	if err != nil { fmt.Fprintf(conn, "ERR file not found\n"); return }
+	chunks, err := t.Store.GetChunks(fileID)
+	if err != nil { fmt.Fprintf(conn, "ERR %v\n", err); return }
+	fi.Path = "" // 不向外暴露本地路径
+	data, err := json.Marshal(manifestMsg{File: fi, Chunks: chunks})
+	if err != nil { fmt.Fprintf(conn, "ERR %v\n", err); return }
+	fmt.Fprintf(conn, "OK %d\n", len(data))
+	_, _ = conn.Write(data)
+}
+
+func (t *TCPTransport) handleHello(conn net.Conn) {
+	if t.NodeID == "" { fmt.Fprintf(conn, "ERR node id unavailable\n"); return }
+	t.mu.Lock(); addrs := t.Advertise; t.mu.Unlock()
+	data, err := json.Marshal(helloMsg{ID: t.NodeID, Name: t.Name, Addrs: addrs})
+	if err != nil { fmt.Fprintf(conn, "ERR %v\n", err); return }
+	fmt.Fprintf(conn, "OK %d\n", len(data))
+	_, _ = conn.Write(data)
+}
+
+// helloMsg HELLO 响应体
+type helloMsg struct {
+	ID    core.NodeID `json:"id"`
+	Name  string      `json:"name"`
+	Addrs []string    `json:"addrs,omitempty"`
+}
+
+// maxHelloSize 限制 HELLO 响应大小
+const maxHelloSize = 64 << 10
+
+// manifestMsg META 响应体
+type manifestMsg struct {
+	File   core.FileInfo    `json:"file"`
+	Chunks []core.ChunkInfo `json:"chunks"`
+}
+
+// maxManifestSize 限制 META 响应大小，防止异常节点耗尽内存
+const maxManifestSize = 64 << 20
+
+// resolve 将 FileID 映射为本地路径
+// 设置 Store 时通过索引反查，并确认文件自建索引后未被修改/移走；否则立即拒绝本次请求，并在后台重建索引或撤回
+// （重建需重新计算整个文件的哈希，不在请求中进行；FileID 不变时重建完成后即可继续提供）
+// 未设置 Store 时沿用简化逻辑：将 fileID 视为（相对 RootDir 的）路径
+func (t *TCPTransport) resolve(fileID core.FileID) (string, error) {
+	if t.Store == nil {
+		path := string(fileID)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(t.RootDir, path)
+		}
+		return path, nil
 	}
+	fi, err := t.Store.GetFile(fileID)
+	if err != nil { return "", errors.New("file not found") }
+	if fi.Withdrawn { return "", errors.New("file withdrawn") }
+	if fi.Path == "" { return "", errors.New("file not available") } // 仅有导入的索引，本地未持有
+	if err := index.CheckFile(fi); err != nil { t.reindex(fileID); return "", err }
+	return fi.Path, nil
+}
+
+// reindex 在后台重新校验文件并重建索引，每个文件同时只有一个任务
+func (t *TCPTransport) reindex(fileID core.FileID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.reindexing[fileID] { return }
+	if t.reindexing == nil { t.reindexing = make(map[core.FileID]bool) }
+	t.reindexing[fileID] = true
+	go func() {
+		_, _ = index.Revalidate(t.Store, fileID)
+		t.mu.Lock()
+		delete(t.reindexing, fileID)
+		t.mu.Unlock()
+	}()
 }
 
 func (t *TCPTransport) Download(ctx context.Context, node core.Node, fileID core.FileID, chunk core.ChunkInfo, w io.Writer) error {
-	addr := node.Address
-	if addr == "" { return errors.New("empty node address") }
-	conn, err := net.Dial("tcp", addr)
-	if err != nil { return err }
+	return t.getChunk(node, fileID, chunk, w, func(req string) (net.Conn, *bufio.Reader, error) { return t.request(ctx, node, req) })
+}
+
+// FetchManifest 从远端节点获取文件索引
+func (t *TCPTransport) FetchManifest(ctx context.Context, node core.Node, fileID core.FileID) (core.FileInfo, []core.ChunkInfo, error) {
+	conn, br, err := t.request(ctx, node, fmt.Sprintf("META %s\n", fileID))
+	if err != nil { return core.FileInfo{}, nil, err }
+	defer conn.Close()
+	return decodeManifest(br, fileID)
+}
+
+// decodeManifest 读取 META 应答体并确认其属于 fileID
+func decodeManifest(r io.Reader, fileID core.FileID) (core.FileInfo, []core.ChunkInfo, error) {
+	var msg manifestMsg
+	if err := json.NewDecoder(io.LimitReader(r, maxManifestSize)).Decode(&msg); err != nil {
+		return core.FileInfo{}, nil, err
+	}
+	if msg.File.ID != fileID { return core.FileInfo{}, nil, fmt.Errorf("manifest file id mismatch: %s", msg.File.ID) }
+	return msg.File, msg.Chunks, nil
+}
+
+// Hello 向节点询问其ID与服务地址，返回的节点以实际连通的地址为首选地址
+func (t *TCPTransport) Hello(ctx context.Context, node core.Node) (core.Node, error) {
+	conn, br, err := t.request(ctx, node, "HELLO\n")
+	if err != nil { return core.Node{}, err }
 	defer conn.Close()
+	var msg helloMsg
+	if err := json.NewDecoder(io.LimitReader(br, maxHelloSize)).Decode(&msg); err != nil {
+		return core.Node{}, err
+	}
+	if msg.ID == "" { return core.Node{}, errors.New("hello: empty node id") }
+	addr := conn.RemoteAddr().String()
+	_, port, _ := net.SplitHostPort(addr)
+	sp, _ := strconv.Atoi(port)
+	out := core.Node{ID: msg.ID, Address: addr, ServicePort: sp, Addrs: []string{addr}, LastSeen: time.Now(), Status: "online"}
+	for _, a := range msg.Addrs {
+		if _, _, err := net.SplitHostPort(a); err == nil && !slices.Contains(out.Addrs, a) {
+			out.Addrs = append(out.Addrs, a)
+		}
+	}
+	return out, nil
+}
+
+// HasFile 通过 META 询问节点是否提供 fileID；节点明确拒绝时返回 false 与 nil 错误
+func (t *TCPTransport) HasFile(ctx context.Context, node core.Node, fileID core.FileID) (bool, error) {
+	conn, _, err := t.request(ctx, node, fmt.Sprintf("META %s\n", fileID))
+	if err != nil {
+		var re *RemoteError
+		if errors.As(err, &re) { return false, nil }
+		return false, err
+	}
+	conn.Close()
+	return true, nil
+}
+
+// request 建立连接、发送请求行并读取响应头，成功时返回连接与已缓冲的读取器
+func (t *TCPTransport) request(ctx context.Context, node core.Node, req string) (net.Conn, *bufio.Reader, error) {
+	conn, err := t.dial(ctx, node)
+	if err != nil { return nil, nil, err }
+	br, err := exchange(conn, req)
+	if err != nil { return nil, nil, err }
+	return conn, br, nil
+}
+
+// exchange 在已建立的连接（TCP 连接或 QUIC 流）上发送请求行并读取响应头，失败时关闭连接
+func exchange(conn net.Conn, req string) (*bufio.Reader, error) {
 	// 发送请求
-	req := fmt.Sprintf("GET %s %d %d\n", fileID, chunk.Offset, chunk.Size)
-	if _, err := io.WriteString(conn, req); err != nil { return err }
+	if _, err := io.WriteString(conn, req); err != nil { conn.Close(); return nil, err }
 	// 读取响应头
 	br := bufio.NewReader(conn)
 	status, err := br.ReadString('\n')
-	if err != nil { return err }
+	if err != nil { conn.Close(); return nil, err }
 	status = strings.TrimSpace(status)
+	if msg, ok := strings.CutPrefix(status, "ERR "); ok {
+		conn.Close()
+		return nil, &RemoteError{Msg: msg}
+	}
 	if !strings.HasPrefix(status, "OK ") {
-		return fmt.Errorf("bad response: %s", status)
+		conn.Close()
+		return nil, fmt.Errorf("bad response: %s", status)
 	}
-	// 读数据
-	_, err = io.CopyN(w, br, chunk.Size)
-	return err
-}
\ No newline at end of file
+	return br, nil
+}
+
+// dial 直连节点；失败且配置了 Traverser 时按节点ID穿透连接，并在一段时间内跳过直连
+func (t *TCPTransport) dial(ctx context.Context, node core.Node) (net.Conn, error) {
+	if t.Traverser == nil || node.ID == "" { return dialNode(ctx, node) }
+	var directErr error
+	if t.directAllowed(node.ID) {
+		dctx, cancel := context.WithTimeout(ctx, directDialTimeout)
+		conn, err := dialNode(dctx, node)
+		cancel()
+		if err == nil { return conn, nil }
+		if ctx.Err() != nil { return nil, err }
+		t.mu.Lock()
+		if t.noDirect == nil { t.noDirect = make(map[core.NodeID]time.Time) }
+		t.noDirect[node.ID] = time.Now().Add(noDirectTTL)
+		t.mu.Unlock()
+		directErr = err
+	}
+	conn, err := t.Traverser.Connect(ctx, node.ID)
+	if err != nil {
+		if directErr != nil { return nil, errors.Join(directErr, err) }
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (t *TCPTransport) directAllowed(id core.NodeID) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	until, ok := t.noDirect[id]
+	if ok && time.Now().After(until) {
+		delete(t.noDirect, id)
+		return true
+	}
+	return !ok
+}
+
+// RemoteError 对端以 ERR 应答的错误
+type RemoteError struct{ Msg string }
+
+func (e *RemoteError) Error() string { return "remote: " + e.Msg }
+
+// dialNode 依次尝试节点的各服务地址（IPv4/IPv6），返回第一个建立的连接
+func dialNode(ctx context.Context, node core.Node) (net.Conn, error) {
+	addrs := node.ServiceAddrs()
+	if len(addrs) == 0 { return nil, errors.New("empty node address") }
+	var d net.Dialer
+	var lastErr error
+	for i, addr := range addrs {
+		dctx, cancel := ctx, context.CancelFunc(func() {})
+		if i < len(addrs)-1 {
+			// 还有备选地址时不必在一个不可达的地址上等待太久
+			dctx, cancel = context.WithTimeout(ctx, dialFallbackDelay)
+		}
+		conn, err := d.DialContext(dctx, "tcp", addr)
+		cancel()
+		if err == nil { return conn, nil }
+		lastErr = err
+		if ctx.Err() != nil { break }
+	}
+	return nil, lastErr
+}
+
+// dialFallbackDelay 存在多个服务地址时，单个地址的连接超时
+const dialFallbackDelay = 3 * time.Second
+
+const (
+	directDialTimeout = 5 * time.Second // 配置了 Traverser 时直连的总超时
+	noDirectTTL       = time.Minute     // 直连失败后改用 Traverser 的时长
+)
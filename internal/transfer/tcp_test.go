@@ -0,0 +1,90 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/index"
+)
+
+// shareFile 写入临时文件并建立索引保存到 store
+func shareFile(t *testing.T, store index.IndexStore, data []byte, chunkSize int64) (string, core.FileInfo, []core.ChunkInfo) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "shared.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fi, chunks, err := index.BuildFileIndex(path, chunkSize, core.HashSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveChunks(fi.ID, chunks); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveFile(fi); err != nil {
+		t.Fatal(err)
+	}
+	return path, fi, chunks
+}
+
+func TestResolveChangedFileRejectsAndReindexesInBackground(t *testing.T) {
+	store := index.NewMemoryStore()
+	tr := NewTCPTransport("", "")
+	tr.Store = store
+	node := core.Node{Address: startNode(t, tr)}
+	ctx := context.Background()
+	path, fi, chunks := shareFile(t, store, bytes.Repeat([]byte("a"), 64), 32)
+
+	// 同样大小的修改：FileID 不变，请求立即被拒绝，后台重建后继续提供新内容
+	later := time.Now().Add(time.Minute)
+	if err := os.WriteFile(path, bytes.Repeat([]byte("b"), 64), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Chtimes(path, later, later)
+	var re *RemoteError
+	if err := tr.Download(ctx, node, fi.ID, chunks[0], &bytes.Buffer{}); !errors.As(err, &re) || !strings.Contains(re.Msg, "changed") {
+		t.Fatalf("GET of a changed file: err = %v, want an immediate changed error", err)
+	}
+	var buf bytes.Buffer
+	waitFor(t, func() bool {
+		buf.Reset()
+		return tr.Download(ctx, node, fi.ID, chunks[0], &buf) == nil
+	})
+	if buf.String() != strings.Repeat("b", 32) {
+		t.Errorf("served %q after reindex", buf.String())
+	}
+
+	// 大小变化：FileID 改变，旧记录连同分片被删除
+	if err := os.WriteFile(path, bytes.Repeat([]byte("c"), 96), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Download(ctx, node, fi.ID, chunks[0], &bytes.Buffer{}); err == nil {
+		t.Fatal("GET of a resized file succeeded")
+	}
+	waitFor(t, func() bool { _, err := store.GetFile(fi.ID); return err != nil })
+	if _, err := store.GetChunks(fi.ID); err == nil {
+		t.Error("chunks of the old file ID left after reindex")
+	}
+	files := store.ListFiles()
+	if len(files) != 1 || files[0].Size != 96 || files[0].Path != path {
+		t.Errorf("files after reindex = %+v", files)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
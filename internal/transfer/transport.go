@@ -2,13 +2,85 @@ package transfer
 
 import (
 	"context"
+	"errors"
 	"io"
+	"net"
+	"sync"
+	"time"
 
 	"github.com/ripplego/ripplego/internal/core"
 )
 
 // Transport 抽象传输接口，默认TCP实现
 type Transport interface {
-	Serve(ctx context.Context) error                  // 启动服务以共享本地分片
+	Serve(ctx context.Context) error // 启动服务以共享本地分片
 	Download(ctx context.Context, node core.Node, fileID core.FileID, chunk core.ChunkInfo, w io.Writer) error
-}
\ No newline at end of file
+}
+
+// ManifestFetcher 可从远端节点获取文件索引（FileInfo 与分片列表）的传输实现
+type ManifestFetcher interface {
+	FetchManifest(ctx context.Context, node core.Node, fileID core.FileID) (core.FileInfo, []core.ChunkInfo, error)
+}
+
+// Traverser 在节点无法直连时（如位于 NAT 之后）按节点ID建立连接，如 UDP 打洞（见 internal/punch）或中继（见 relay.go）
+type Traverser interface {
+	Connect(ctx context.Context, id core.NodeID) (net.Conn, error)
+}
+
+// PortMapper 在网关上为服务端口建立端口映射（如 UPnP-IGD / NAT-PMP，见 internal/portmap），
+// Run 维持映射直到 ctx 结束，返回前移除映射
+type PortMapper interface {
+	Run(ctx context.Context, port int)
+}
+
+// Traversers 依次尝试多个 Traverser，返回第一个建立的连接
+type Traversers []Traverser
+
+func (ts Traversers) Connect(ctx context.Context, id core.NodeID) (net.Conn, error) {
+	var errs []error
+	for _, t := range ts {
+		conn, err := t.Connect(ctx, id)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// skipList 记录某种传输连接失败的节点，到期前直接使用回退传输（见 QUICTransport、UDPTransport）
+type skipList struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func (l *skipList) skipped(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until, ok := l.until[key]
+	if ok && time.Now().After(until) {
+		delete(l.until, key)
+		return false
+	}
+	return ok
+}
+
+func (l *skipList) add(key string, ttl time.Duration) {
+	l.mu.Lock()
+	if l.until == nil {
+		l.until = make(map[string]time.Time)
+	}
+	l.until[key] = time.Now().Add(ttl)
+	l.mu.Unlock()
+}
+
+// peerKey 按节点区分的键：节点ID，未知时为首选地址
+func peerKey(node core.Node) string {
+	if node.ID != "" {
+		return "id:" + string(node.ID)
+	}
+	return "addr:" + node.Address
+}
@@ -0,0 +1,181 @@
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ripplego/ripplego/internal/core"
+	"github.com/ripplego/ripplego/internal/rudp"
+)
+
+// UDP 传输（LEDBAT）
+// - 基于 internal/rudp 的可靠流，每个请求一条流，流上的请求行与应答与 TCP 协议相同
+// - 服务端发送数据时使用 LEDBAT 拥塞控制：按单向延迟估计排队延迟，超过目标（100ms）即减小窗口，
+//   链路上有交互流量时主动让出带宽，适合在开发机等不应占满上行链路的节点上做种
+// - 未启用的节点或连接失败时回退到 Fallback（TCP，及其打洞、中继）
+
+const (
+	udpDialTimeout = 3 * time.Second
+	noUDPTTL       = time.Minute // UDP 连接失败后直接使用 Fallback 的时长
+)
+
+// errNoUDP 无法与节点建立 UDP 流，可回退到其他传输
+var errNoUDP = errors.New("udp transport unavailable")
+
+// UDPTransport 基于 UDP 可靠流与 LEDBAT 拥塞控制的传输，服务端与 TCP 传输共用请求处理（见 TCPTransport.handle）
+type UDPTransport struct {
+	*TCPTransport
+	// UDPAddr UDP 监听地址，为空时使用 TCPTransport.Addr 中的首个地址（同一端口号的 UDP）
+	UDPAddr string
+	// Fallback 不使用 UDP 的节点或 UDP 连接失败时使用的传输，默认为 TCPTransport；可为包装它的 RelayTransport
+	// Serve 同时启动 Fallback 的服务
+	Fallback Transport
+	// Select 判断是否对节点使用 UDP 传输，为空时对全部节点尝试
+	Select func(node core.Node) bool
+
+	noUDP skipList // UDP 连接失败的节点
+
+	umu    sync.Mutex
+	ep     *rudp.Endpoint // 客户端共用的端点，首次请求时创建
+	closed bool
+}
+
+// NewUDPTransport 创建 UDP 传输，fallback 为空时回退到 tr
+func NewUDPTransport(tr *TCPTransport, fallback Transport) *UDPTransport {
+	if fallback == nil {
+		fallback = tr
+	}
+	return &UDPTransport{TCPTransport: tr, Fallback: fallback}
+}
+
+// Serve 在 UDP 上接受流，同时启动 Fallback 的服务，直到 ctx 结束
+func (u *UDPTransport) Serve(ctx context.Context) error {
+	addr := u.UDPAddr
+	if addr == "" {
+		first, _, _ := strings.Cut(u.Addr, ",")
+		addr = strings.TrimSpace(first)
+	}
+	ep, err := rudp.Listen("udp", addr, nil, true)
+	if err != nil {
+		return fmt.Errorf("udp listen: %w", err)
+	}
+	ep.SetLEDBAT(true)
+	uerr := make(chan error, 1)
+	go func() { uerr <- u.ServeListener(ctx, ep) }()
+	err = u.Fallback.Serve(ctx)
+	ep.Close()
+	if e := <-uerr; err == nil && !errors.Is(e, net.ErrClosed) {
+		err = e
+	}
+	return err
+}
+
+func (u *UDPTransport) Download(ctx context.Context, node core.Node, fileID core.FileID, chunk core.ChunkInfo, w io.Writer) error {
+	if !u.useUDP(node) {
+		return u.Fallback.Download(ctx, node, fileID, chunk, w)
+	}
+	err := u.getChunk(node, fileID, chunk, w, func(req string) (net.Conn, *bufio.Reader, error) { return u.request(ctx, node, req) })
+	if errors.Is(err, errNoUDP) {
+		return u.Fallback.Download(ctx, node, fileID, chunk, w)
+	}
+	return err
+}
+
+// FetchManifest 经 UDP 获取文件索引，不可用时回退
+func (u *UDPTransport) FetchManifest(ctx context.Context, node core.Node, fileID core.FileID) (core.FileInfo, []core.ChunkInfo, error) {
+	fallback := func() (core.FileInfo, []core.ChunkInfo, error) {
+		if mf, ok := u.Fallback.(ManifestFetcher); ok {
+			return mf.FetchManifest(ctx, node, fileID)
+		}
+		return u.TCPTransport.FetchManifest(ctx, node, fileID)
+	}
+	if !u.useUDP(node) {
+		return fallback()
+	}
+	conn, br, err := u.request(ctx, node, fmt.Sprintf("META %s\n", fileID))
+	if errors.Is(err, errNoUDP) {
+		return fallback()
+	}
+	if err != nil {
+		return core.FileInfo{}, nil, err
+	}
+	defer conn.Close()
+	return decodeManifest(br, fileID)
+}
+
+// request 依次尝试节点的服务地址（同端口号的 UDP）建立流并发送请求；都无法建立时返回 errNoUDP
+func (u *UDPTransport) request(ctx context.Context, node core.Node, req string) (net.Conn, *bufio.Reader, error) {
+	addrs := node.ServiceAddrs()
+	if len(addrs) == 0 {
+		return nil, nil, errors.New("empty node address")
+	}
+	ep, err := u.endpoint()
+	if err != nil {
+		return nil, nil, err
+	}
+	var lastErr error
+	for _, addr := range addrs {
+		ua, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		dctx, cancel := context.WithTimeout(ctx, udpDialTimeout)
+		s, err := ep.Dial(dctx, ua)
+		cancel()
+		if err == nil {
+			br, err := exchange(s, req)
+			if err != nil {
+				return nil, nil, err
+			}
+			return s, br, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+	}
+	u.noUDP.add(peerKey(node), noUDPTTL)
+	return nil, nil, fmt.Errorf("%w: %v", errNoUDP, lastErr)
+}
+
+// endpoint 返回客户端共用的端点（不接受对端发起的流）
+func (u *UDPTransport) endpoint() (*rudp.Endpoint, error) {
+	u.umu.Lock()
+	defer u.umu.Unlock()
+	if u.closed {
+		return nil, net.ErrClosed
+	}
+	if u.ep == nil {
+		ep, err := rudp.Listen("udp", ":0", nil, false)
+		if err != nil {
+			return nil, err
+		}
+		ep.SetLEDBAT(true) // 客户端只发送请求行，但保持一致
+		u.ep = ep
+	}
+	return u.ep, nil
+}
+
+func (u *UDPTransport) useUDP(node core.Node) bool {
+	return (u.Select == nil || u.Select(node)) && !u.noUDP.skipped(peerKey(node))
+}
+
+// Close 关闭客户端端点与其上的全部流
+func (u *UDPTransport) Close() error {
+	u.umu.Lock()
+	ep := u.ep
+	u.ep, u.closed = nil, true
+	u.umu.Unlock()
+	if ep != nil {
+		return ep.Close()
+	}
+	return nil
+}